@@ -436,6 +436,7 @@ type InternalRequest struct {
 	//	*InternalRequest_PutReq
 	//	*InternalRequest_GetReq
 	//	*InternalRequest_DeleteReq
+	//	*InternalRequest_MergeReq
 	Request              isInternalRequest_Request `protobuf_oneof:"request"`
 	XXX_NoUnkeyedLiteral struct{}                  `json:"-"`
 	XXX_unrecognized     []byte                    `json:"-"`
@@ -490,10 +491,14 @@ type InternalRequest_GetReq struct {
 type InternalRequest_DeleteReq struct {
 	DeleteReq *DeleteReq `protobuf:"bytes,12,opt,name=deleteReq,proto3,oneof"`
 }
+type InternalRequest_MergeReq struct {
+	MergeReq *MergeReq `protobuf:"bytes,13,opt,name=mergeReq,proto3,oneof"`
+}
 
 func (*InternalRequest_PutReq) isInternalRequest_Request()    {}
 func (*InternalRequest_GetReq) isInternalRequest_Request()    {}
 func (*InternalRequest_DeleteReq) isInternalRequest_Request() {}
+func (*InternalRequest_MergeReq) isInternalRequest_Request()  {}
 
 func (m *InternalRequest) GetRequest() isInternalRequest_Request {
 	if m != nil {
@@ -537,12 +542,20 @@ func (m *InternalRequest) GetDeleteReq() *DeleteReq {
 	return nil
 }
 
+func (m *InternalRequest) GetMergeReq() *MergeReq {
+	if x, ok := m.GetRequest().(*InternalRequest_MergeReq); ok {
+		return x.MergeReq
+	}
+	return nil
+}
+
 // XXX_OneofFuncs is for the internal use of the proto package.
 func (*InternalRequest) XXX_OneofFuncs() (func(msg proto.Message, b *proto.Buffer) error, func(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error), func(msg proto.Message) (n int), []interface{}) {
 	return _InternalRequest_OneofMarshaler, _InternalRequest_OneofUnmarshaler, _InternalRequest_OneofSizer, []interface{}{
 		(*InternalRequest_PutReq)(nil),
 		(*InternalRequest_GetReq)(nil),
 		(*InternalRequest_DeleteReq)(nil),
+		(*InternalRequest_MergeReq)(nil),
 	}
 }
 
@@ -565,6 +578,11 @@ func _InternalRequest_OneofMarshaler(msg proto.Message, b *proto.Buffer) error {
 		if err := b.EncodeMessage(x.DeleteReq); err != nil {
 			return err
 		}
+	case *InternalRequest_MergeReq:
+		_ = b.EncodeVarint(13<<3 | proto.WireBytes)
+		if err := b.EncodeMessage(x.MergeReq); err != nil {
+			return err
+		}
 	case nil:
 	default:
 		return fmt.Errorf("InternalRequest.Request has unexpected type %T", x)
@@ -599,6 +617,14 @@ func _InternalRequest_OneofUnmarshaler(msg proto.Message, tag, wire int, b *prot
 		err := b.DecodeMessage(msg)
 		m.Request = &InternalRequest_DeleteReq{msg}
 		return true, err
+	case 13: // request.mergeReq
+		if wire != proto.WireBytes {
+			return true, proto.ErrInternalBadWireType
+		}
+		msg := new(MergeReq)
+		err := b.DecodeMessage(msg)
+		m.Request = &InternalRequest_MergeReq{msg}
+		return true, err
 	default:
 		return false, nil
 	}
@@ -623,6 +649,11 @@ func _InternalRequest_OneofSizer(msg proto.Message) (n int) {
 		n += 1 // tag and wire
 		n += proto.SizeVarint(uint64(s))
 		n += s
+	case *InternalRequest_MergeReq:
+		s := proto.Size(x.MergeReq)
+		n += 1 // tag and wire
+		n += proto.SizeVarint(uint64(s))
+		n += s
 	case nil:
 	default:
 		panic(fmt.Sprintf("proto: unexpected type %T in oneof", x))
@@ -893,6 +924,61 @@ func (m *EmptyResponse) XXX_DiscardUnknown() {
 
 var xxx_messageInfo_EmptyResponse proto.InternalMessageInfo
 
+type MergeReq struct {
+	Key                  []byte   `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Operand              []byte   `protobuf:"bytes,2,opt,name=operand,proto3" json:"operand,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *MergeReq) Reset()         { *m = MergeReq{} }
+func (m *MergeReq) String() string { return proto.CompactTextString(m) }
+func (*MergeReq) ProtoMessage()    {}
+func (*MergeReq) Descriptor() ([]byte, []int) {
+	return fileDescriptor_92ce2a517654848a, []int{11}
+}
+func (m *MergeReq) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *MergeReq) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_MergeReq.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *MergeReq) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MergeReq.Merge(m, src)
+}
+func (m *MergeReq) XXX_Size() int {
+	return m.Size()
+}
+func (m *MergeReq) XXX_DiscardUnknown() {
+	xxx_messageInfo_MergeReq.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MergeReq proto.InternalMessageInfo
+
+func (m *MergeReq) GetKey() []byte {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (m *MergeReq) GetOperand() []byte {
+	if m != nil {
+		return m.Operand
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*RequestHeader)(nil), "internal_request.RequestHeader")
 	proto.RegisterType((*ResponseHeader)(nil), "internal_request.ResponseHeader")
@@ -905,40 +991,44 @@ func init() {
 	proto.RegisterType((*InternalRequest)(nil), "internal_request.InternalRequest")
 	proto.RegisterType((*InternalResponse)(nil), "internal_request.InternalResponse")
 	proto.RegisterType((*EmptyResponse)(nil), "internal_request.EmptyResponse")
+	proto.RegisterType((*MergeReq)(nil), "internal_request.MergeReq")
 }
 
 func init() { proto.RegisterFile("internal_request.proto", fileDescriptor_92ce2a517654848a) }
 
 var fileDescriptor_92ce2a517654848a = []byte{
-	// 445 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x54, 0xdd, 0x8a, 0xd3, 0x40,
-	0x14, 0x4e, 0x66, 0xdd, 0xb8, 0x39, 0xdb, 0x74, 0xc3, 0x20, 0x32, 0x28, 0x64, 0xcb, 0x20, 0xb2,
-	0x57, 0x05, 0x57, 0x70, 0x05, 0xef, 0x4a, 0x17, 0xdb, 0xbb, 0x65, 0x2e, 0xc4, 0x3b, 0xa9, 0xec,
-	0x41, 0x4b, 0x6b, 0x92, 0xce, 0x24, 0x85, 0xbe, 0x82, 0x4f, 0xe0, 0x93, 0xf8, 0x0c, 0x5e, 0xfa,
-	0x08, 0x52, 0x5f, 0x44, 0x32, 0x33, 0xf9, 0x69, 0x93, 0xe0, 0xde, 0xcd, 0x9c, 0xf9, 0xbe, 0x93,
-	0xf3, 0x7d, 0xdf, 0x21, 0xf0, 0x74, 0x19, 0x67, 0x28, 0xe3, 0xc5, 0xfa, 0x93, 0xc4, 0x4d, 0x8e,
-	0x2a, 0x1b, 0xa7, 0x32, 0xc9, 0x12, 0x1a, 0x1e, 0xd7, 0xf9, 0x25, 0x04, 0xc2, 0x1c, 0x67, 0xb8,
-	0xb8, 0x47, 0x49, 0x87, 0x40, 0xe6, 0x53, 0xe6, 0x8e, 0xdc, 0xab, 0x47, 0x82, 0xcc, 0xa7, 0xfc,
-	0x0d, 0x0c, 0x05, 0xaa, 0x34, 0x89, 0x15, 0xd6, 0x88, 0x64, 0xa5, 0x11, 0x67, 0x82, 0x24, 0x2b,
-	0xfa, 0x04, 0x4e, 0x51, 0xca, 0x44, 0x32, 0x32, 0x72, 0xaf, 0x7c, 0x61, 0x2e, 0xfc, 0x23, 0x78,
-	0x77, 0x79, 0x26, 0x70, 0x43, 0x43, 0x38, 0x59, 0xe1, 0x4e, 0x13, 0x06, 0xa2, 0x38, 0x16, 0x8c,
-	0xed, 0x62, 0x9d, 0xa3, 0x66, 0x0c, 0x84, 0xb9, 0xd0, 0x97, 0x30, 0x94, 0x98, 0xe5, 0x32, 0xbe,
-	0x93, 0xb8, 0x5d, 0x26, 0xb9, 0x62, 0x27, 0xfa, 0x1b, 0x47, 0x55, 0x3e, 0xb6, 0x9d, 0x15, 0x7d,
-	0x01, 0x41, 0x6a, 0xab, 0x1f, 0x1a, 0xfd, 0x0e, 0x8b, 0xfc, 0x19, 0x78, 0xef, 0xb1, 0x7b, 0x12,
-	0x1e, 0xd9, 0x37, 0x55, 0xcf, 0xe4, 0x36, 0x66, 0xe2, 0xb7, 0xe0, 0x4f, 0x71, 0x8d, 0x19, 0x76,
-	0x0b, 0x69, 0x8f, 0x4c, 0x3a, 0x47, 0x7e, 0x55, 0xb7, 0x79, 0xe8, 0xd4, 0xdf, 0x09, 0x5c, 0xcc,
-	0x6d, 0x5a, 0x36, 0x21, 0x7a, 0x03, 0xde, 0x57, 0x9d, 0x81, 0x9e, 0xe1, 0xfc, 0xfa, 0x72, 0xdc,
-	0xca, 0xf9, 0x20, 0x4c, 0x61, 0xe1, 0x36, 0x54, 0x52, 0x86, 0x4a, 0xaf, 0xc1, 0x4b, 0x75, 0x38,
-	0x0c, 0x74, 0x23, 0xd6, 0x6e, 0x64, 0xc2, 0x9b, 0x39, 0xc2, 0x22, 0x0b, 0xce, 0x17, 0x6d, 0x23,
-	0x3b, 0xef, 0xe3, 0x18, 0x9b, 0x0b, 0x8e, 0x41, 0xd2, 0x77, 0xe0, 0xdf, 0x97, 0xf6, 0xb1, 0x81,
-	0xa6, 0x3d, 0x6f, 0xd3, 0x2a, 0x87, 0x67, 0x8e, 0xa8, 0xf1, 0x13, 0x1f, 0x1e, 0x97, 0x5b, 0xfa,
-	0x93, 0x40, 0x58, 0x9b, 0x61, 0xb6, 0x91, 0xbe, 0x3d, 0x72, 0x63, 0xd4, 0xe5, 0x46, 0x73, 0x73,
-	0x2b, 0x3b, 0x4a, 0x29, 0xaa, 0x5f, 0xbe, 0xd9, 0x8a, 0x4a, 0x8a, 0xaa, 0x2c, 0x53, 0xfd, 0xf2,
-	0xcd, 0x56, 0x56, 0x96, 0xa9, 0xa6, 0x7c, 0xf5, 0x7f, 0xf9, 0xaa, 0x29, 0x5f, 0xd1, 0x1b, 0x38,
-	0xc5, 0x6f, 0x69, 0xb6, 0x63, 0x41, 0x5f, 0xd6, 0xb7, 0xc5, 0x73, 0x25, 0xd1, 0x11, 0x06, 0x3f,
-	0x01, 0x38, 0x93, 0xb6, 0xc8, 0x2f, 0x20, 0x38, 0x40, 0x4d, 0xc2, 0x5f, 0xfb, 0xc8, 0xfd, 0xbd,
-	0x8f, 0xdc, 0x3f, 0xfb, 0xc8, 0xfd, 0xf1, 0x37, 0x72, 0x3e, 0x7b, 0xfa, 0xd7, 0xf0, 0xfa, 0x5f,
-	0x00, 0x00, 0x00, 0xff, 0xff, 0xc4, 0xf3, 0x8b, 0xd1, 0x34, 0x04, 0x00, 0x00,
+	// 484 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x94, 0xc1, 0x6a, 0xdb, 0x40,
+	0x10, 0x86, 0xa5, 0x4d, 0xa3, 0xd8, 0x63, 0xcb, 0x31, 0x4b, 0x29, 0x4b, 0x0a, 0x8a, 0x59, 0x4a,
+	0xc9, 0xc9, 0xd0, 0x14, 0x92, 0x40, 0x6f, 0xc6, 0x21, 0xf6, 0xa1, 0x10, 0xf6, 0x50, 0x7a, 0x2b,
+	0x2e, 0x1e, 0x92, 0x60, 0x47, 0xab, 0xec, 0x4a, 0x81, 0xbc, 0x49, 0x9f, 0xa4, 0x4f, 0xd0, 0x43,
+	0x8f, 0x7d, 0x84, 0xe2, 0xbe, 0x48, 0xf1, 0x6a, 0xb5, 0x92, 0x2d, 0xc5, 0xed, 0x4d, 0x3b, 0xfa,
+	0xff, 0xf1, 0xec, 0xf7, 0x8f, 0x05, 0xaf, 0xee, 0xe2, 0x14, 0x55, 0x3c, 0x5b, 0x7e, 0x51, 0xf8,
+	0x90, 0xa1, 0x4e, 0x87, 0x89, 0x92, 0xa9, 0xa4, 0xfd, 0xed, 0x3a, 0x3f, 0x86, 0x50, 0xe4, 0x8f,
+	0x13, 0x9c, 0xcd, 0x51, 0xd1, 0x1e, 0x90, 0xe9, 0x98, 0xf9, 0x03, 0xff, 0xe4, 0x85, 0x20, 0xd3,
+	0x31, 0x3f, 0x83, 0x9e, 0x40, 0x9d, 0xc8, 0x58, 0x63, 0xa9, 0x90, 0x0b, 0xa3, 0x68, 0x09, 0x22,
+	0x17, 0xf4, 0x25, 0xec, 0xa3, 0x52, 0x52, 0x31, 0x32, 0xf0, 0x4f, 0xda, 0x22, 0x3f, 0xf0, 0xcf,
+	0x10, 0x5c, 0x67, 0xa9, 0xc0, 0x07, 0xda, 0x87, 0xbd, 0x05, 0x3e, 0x19, 0x43, 0x57, 0xac, 0x1f,
+	0xd7, 0x8e, 0xc7, 0xd9, 0x32, 0x43, 0xe3, 0xe8, 0x8a, 0xfc, 0x40, 0xdf, 0x42, 0x4f, 0x61, 0x9a,
+	0xa9, 0xf8, 0x5a, 0xe1, 0xe3, 0x9d, 0xcc, 0x34, 0xdb, 0x33, 0xbf, 0xb1, 0x55, 0xe5, 0x43, 0xdb,
+	0x59, 0xd3, 0x37, 0x10, 0x26, 0xb6, 0xfa, 0xa9, 0xd2, 0x6f, 0xb3, 0xc8, 0x8f, 0x20, 0xb8, 0xc2,
+	0xe6, 0x49, 0x78, 0x64, 0xdf, 0xe9, 0x72, 0x26, 0xbf, 0x32, 0x13, 0xbf, 0x84, 0xf6, 0x18, 0x97,
+	0x98, 0x62, 0xf3, 0x45, 0xea, 0x23, 0x93, 0xc6, 0x91, 0xdf, 0x95, 0x6d, 0xfe, 0x77, 0xea, 0x1f,
+	0x04, 0x0e, 0xa7, 0x36, 0x2d, 0x9b, 0x10, 0x3d, 0x87, 0xe0, 0xd6, 0x64, 0x60, 0x66, 0xe8, 0x9c,
+	0x1e, 0x0f, 0x6b, 0x39, 0x6f, 0x84, 0x29, 0x82, 0x5b, 0x17, 0xd9, 0x74, 0xcc, 0x48, 0x11, 0x2a,
+	0x3d, 0x85, 0x20, 0x31, 0xe1, 0x30, 0x30, 0x8d, 0x58, 0xbd, 0x51, 0x1e, 0xde, 0xc4, 0x13, 0x56,
+	0xb9, 0xf6, 0xdc, 0x18, 0x8c, 0xac, 0xf3, 0x9c, 0xe7, 0x0a, 0x0b, 0x4f, 0xae, 0xa4, 0x1f, 0xa0,
+	0x3d, 0x2f, 0xf0, 0xb1, 0xae, 0xb1, 0xbd, 0xae, 0xdb, 0x1c, 0xe1, 0x89, 0x27, 0x4a, 0x3d, 0xbd,
+	0x80, 0xd6, 0x3d, 0xaa, 0x1b, 0xe3, 0x0d, 0x8d, 0xf7, 0xa8, 0xee, 0xfd, 0x68, 0x15, 0x13, 0x4f,
+	0x38, 0xf5, 0xa8, 0x0d, 0x07, 0xc5, 0x7e, 0x7f, 0x27, 0xd0, 0x2f, 0x31, 0xe6, 0x7b, 0x4c, 0x2f,
+	0xb6, 0x38, 0x0e, 0x9a, 0x38, 0x56, 0x77, 0xde, 0x81, 0x2c, 0x20, 0x68, 0x06, 0x3b, 0x21, 0x68,
+	0x07, 0x41, 0x3b, 0xd8, 0x9a, 0x75, 0x76, 0xc2, 0xd6, 0x0e, 0xb6, 0xae, 0x82, 0xd3, 0xff, 0x06,
+	0xa7, 0xab, 0xe0, 0x34, 0x3d, 0x87, 0x7d, 0xbc, 0x4f, 0xd2, 0x27, 0x16, 0x3e, 0xb7, 0x25, 0x97,
+	0xeb, 0xd7, 0xee, 0x8a, 0x9e, 0xc8, 0xf5, 0x23, 0x80, 0x96, 0xb2, 0x45, 0x7e, 0x08, 0xe1, 0x86,
+	0x8a, 0x9f, 0x41, 0xab, 0x80, 0xdd, 0xf0, 0x4f, 0x60, 0x70, 0x20, 0x13, 0x54, 0xb3, 0x78, 0x6e,
+	0xd7, 0xb9, 0x38, 0x8e, 0xfa, 0x3f, 0x57, 0x91, 0xff, 0x6b, 0x15, 0xf9, 0xbf, 0x57, 0x91, 0xff,
+	0xed, 0x4f, 0xe4, 0x7d, 0x0d, 0xcc, 0xc7, 0xe8, 0xfd, 0xdf, 0x01, 0x00, 0xee, 0x88, 0x79, 0xcc,
+	0xa6, 0x04, 0x00, 0x00,
 }
 
 func (m *RequestHeader) Marshal() (dAtA []byte, err error) {
@@ -1277,6 +1367,20 @@ func (m *InternalRequest_DeleteReq) MarshalTo(dAtA []byte) (int, error) {
 	}
 	return i, nil
 }
+func (m *InternalRequest_MergeReq) MarshalTo(dAtA []byte) (int, error) {
+	i := 0
+	if m.MergeReq != nil {
+		dAtA[i] = 0x6a
+		i++
+		i = encodeVarintInternalRequest(dAtA, i, uint64(m.MergeReq.Size()))
+		n6, err := m.MergeReq.MarshalTo(dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n6
+	}
+	return i, nil
+}
 func (m *InternalResponse) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
 	dAtA = make([]byte, size)
@@ -1296,18 +1400,18 @@ func (m *InternalResponse) MarshalTo(dAtA []byte) (int, error) {
 		dAtA[i] = 0xa
 		i++
 		i = encodeVarintInternalRequest(dAtA, i, uint64(m.Header.Size()))
-		n6, err := m.Header.MarshalTo(dAtA[i:])
+		n7, err := m.Header.MarshalTo(dAtA[i:])
 		if err != nil {
 			return 0, err
 		}
-		i += n6
+		i += n7
 	}
 	if m.Response != nil {
-		nn7, err := m.Response.MarshalTo(dAtA[i:])
+		nn8, err := m.Response.MarshalTo(dAtA[i:])
 		if err != nil {
 			return 0, err
 		}
-		i += nn7
+		i += nn8
 	}
 	if m.XXX_unrecognized != nil {
 		i += copy(dAtA[i:], m.XXX_unrecognized)
@@ -1321,11 +1425,11 @@ func (m *InternalResponse_GetRes) MarshalTo(dAtA []byte) (int, error) {
 		dAtA[i] = 0x52
 		i++
 		i = encodeVarintInternalRequest(dAtA, i, uint64(m.GetRes.Size()))
-		n8, err := m.GetRes.MarshalTo(dAtA[i:])
+		n9, err := m.GetRes.MarshalTo(dAtA[i:])
 		if err != nil {
 			return 0, err
 		}
-		i += n8
+		i += n9
 	}
 	return i, nil
 }
@@ -1335,11 +1439,11 @@ func (m *InternalResponse_PutRes) MarshalTo(dAtA []byte) (int, error) {
 		dAtA[i] = 0x5a
 		i++
 		i = encodeVarintInternalRequest(dAtA, i, uint64(m.PutRes.Size()))
-		n9, err := m.PutRes.MarshalTo(dAtA[i:])
+		n10, err := m.PutRes.MarshalTo(dAtA[i:])
 		if err != nil {
 			return 0, err
 		}
-		i += n9
+		i += n10
 	}
 	return i, nil
 }
@@ -1349,11 +1453,11 @@ func (m *InternalResponse_DeleteRes) MarshalTo(dAtA []byte) (int, error) {
 		dAtA[i] = 0x62
 		i++
 		i = encodeVarintInternalRequest(dAtA, i, uint64(m.DeleteRes.Size()))
-		n10, err := m.DeleteRes.MarshalTo(dAtA[i:])
+		n11, err := m.DeleteRes.MarshalTo(dAtA[i:])
 		if err != nil {
 			return 0, err
 		}
-		i += n10
+		i += n11
 	}
 	return i, nil
 }
@@ -1363,11 +1467,11 @@ func (m *InternalResponse_Empty) MarshalTo(dAtA []byte) (int, error) {
 		dAtA[i] = 0x6a
 		i++
 		i = encodeVarintInternalRequest(dAtA, i, uint64(m.Empty.Size()))
-		n11, err := m.Empty.MarshalTo(dAtA[i:])
+		n12, err := m.Empty.MarshalTo(dAtA[i:])
 		if err != nil {
 			return 0, err
 		}
-		i += n11
+		i += n12
 	}
 	return i, nil
 }
@@ -1392,6 +1496,39 @@ func (m *EmptyResponse) MarshalTo(dAtA []byte) (int, error) {
 	return i, nil
 }
 
+func (m *MergeReq) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *MergeReq) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Key) > 0 {
+		dAtA[i] = 0xa
+		i++
+		i = encodeVarintInternalRequest(dAtA, i, uint64(len(m.Key)))
+		i += copy(dAtA[i:], m.Key)
+	}
+	if len(m.Operand) > 0 {
+		dAtA[i] = 0x12
+		i++
+		i = encodeVarintInternalRequest(dAtA, i, uint64(len(m.Operand)))
+		i += copy(dAtA[i:], m.Operand)
+	}
+	if m.XXX_unrecognized != nil {
+		i += copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	return i, nil
+}
+
 func encodeVarintInternalRequest(dAtA []byte, offset int, v uint64) int {
 	for v >= 1<<7 {
 		dAtA[offset] = uint8(v&0x7f | 0x80)
@@ -1599,6 +1736,18 @@ func (m *InternalRequest_DeleteReq) Size() (n int) {
 	}
 	return n
 }
+func (m *InternalRequest_MergeReq) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.MergeReq != nil {
+		l = m.MergeReq.Size()
+		n += 1 + l + sovInternalRequest(uint64(l))
+	}
+	return n
+}
 func (m *InternalResponse) Size() (n int) {
 	if m == nil {
 		return 0
@@ -1678,6 +1827,26 @@ func (m *EmptyResponse) Size() (n int) {
 	return n
 }
 
+func (m *MergeReq) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Key)
+	if l > 0 {
+		n += 1 + l + sovInternalRequest(uint64(l))
+	}
+	l = len(m.Operand)
+	if l > 0 {
+		n += 1 + l + sovInternalRequest(uint64(l))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
+	return n
+}
+
 func sovInternalRequest(x uint64) (n int) {
 	for {
 		n++
@@ -2601,6 +2770,38 @@ func (m *InternalRequest) Unmarshal(dAtA []byte) error {
 			}
 			m.Request = &InternalRequest_DeleteReq{v}
 			iNdEx = postIndex
+		case 13:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MergeReq", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternalRequest
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthInternalRequest
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			v := &MergeReq{}
+			if err := v.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			m.Request = &InternalRequest_MergeReq{v}
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipInternalRequest(dAtA[iNdEx:])
@@ -2886,6 +3087,119 @@ func (m *EmptyResponse) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
+func (m *MergeReq) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowInternalRequest
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: MergeReq: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: MergeReq: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Key", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternalRequest
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthInternalRequest
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Key = append(m.Key[:0], dAtA[iNdEx:postIndex]...)
+			if m.Key == nil {
+				m.Key = []byte{}
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Operand", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowInternalRequest
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthInternalRequest
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Operand = append(m.Operand[:0], dAtA[iNdEx:postIndex]...)
+			if m.Operand == nil {
+				m.Operand = []byte{}
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipInternalRequest(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthInternalRequest
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
 func skipInternalRequest(dAtA []byte) (n int, err error) {
 	l := len(dAtA)
 	iNdEx := 0
@@ -166,6 +166,12 @@ func (st *ReplicatedKvStore) ProposeDelete(ctx context.Context, key []byte) (
 	return st.handleRequest(ctx, deleteReq)
 }
 
+func (st *ReplicatedKvStore) ProposeMerge(ctx context.Context, key []byte, operand []byte) (
+	*internal_request.InternalResponse, error) {
+	mergeReq := st.reqBuilder.NewMergeRequest(key, operand)
+	return st.handleRequest(ctx, mergeReq)
+}
+
 func (st *ReplicatedKvStore) Get(ctx context.Context, key string, options GetOptions) ([]byte, error) {
 	switch options.ReadConsistencyLevel {
 	case Serializable:
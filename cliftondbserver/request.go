@@ -64,6 +64,15 @@ func (b *RequestBuilder) NewDeleteRequest(key []byte) *internal_request.Internal
 	return req
 }
 
+func (b *RequestBuilder) NewMergeRequest(key []byte, operand []byte) *internal_request.InternalRequest {
+	nextId := b.idGenerator.NextId()
+	req := &internal_request.InternalRequest{
+		Request: &internal_request.InternalRequest_MergeReq{&internal_request.MergeReq{Key: key, Operand: operand}},
+		Header:  &internal_request.RequestHeader{ID: nextId},
+	}
+	return req
+}
+
 func IdAsBytes(id uint64, buffer []byte) {
 	if len(buffer) < int(unsafe.Sizeof(id)) {
 		panic(errors.New("buffer too small"))
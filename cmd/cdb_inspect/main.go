@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+// Offline inspection of the sstable and wal files of a stopped partition.
+// Files are opened for reading only.
+
+var rootCmd = &cobra.Command{
+	Use:   "cdb_inspect",
+	Short: "Inspect SSTable and WAL files of a CliftonDB partition",
+	Long: "Inspect SSTable and WAL files of a CliftonDB partition offline, " +
+		"reports structural inconsistencies and exits with status 2 when any are found",
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+// inconsistent is set once a command reports a problem
+var inconsistent = false
+
+func reportProblems(name string, problems []string) {
+	for _, problem := range problems {
+		fmt.Printf("PROBLEM %s: %s\n", name, problem)
+	}
+
+	if len(problems) > 0 {
+		inconsistent = true
+	}
+}
+
+func main() {
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if inconsistent {
+		os.Exit(2)
+	}
+}
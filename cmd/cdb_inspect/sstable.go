@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/zl14917/MastersProject/kvstore/sstable"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	indexFileSuffix = "_index"
+	dataFileSuffix  = "_data"
+)
+
+func init() {
+	rootCmd.AddCommand(sstableCmd)
+	sstableCmd.AddCommand(headersCmd, keysCmd, lookupCmd)
+	keysCmd.Flags().Bool("values", false, "print values of live keys")
+}
+
+var sstableCmd = &cobra.Command{
+	Use:   "sstable",
+	Short: "Inspect the index and data files of one table",
+	Long: "Inspect the index and data files of one table, " +
+		"either file of a table names it, e.g. sstables/sstable_12_index",
+}
+
+var headersCmd = &cobra.Command{
+	Use:   "headers <table-file>",
+	Short: "Print the file headers and check blocks and entries against them",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		indexFilePath, dataFilePath := tableFiles(args[0])
+
+		info, err := sstable.InspectTable(indexFilePath, dataFilePath)
+		if err != nil {
+			return err
+		}
+
+		index, data := info.IndexHeader, info.DataHeader
+
+		fmt.Printf("index file %s, %d bytes\n", info.IndexFilePath, info.IndexFileSize)
+		fmt.Printf("  magic 0x%08x flags 0x%x version %d restart interval %d\n",
+			index.Magic, index.Flags, index.Version, index.RestartInterval)
+		fmt.Printf("  key count %d block size %d block count %d filter block count %d max key size %d\n",
+			index.KeyCount, index.BlockSize, index.BlockCount, index.FilterBlockCount, index.MaxKeySize)
+
+		fmt.Printf("data file %s, %d bytes\n", info.DataFilePath, info.DataFileSize)
+		fmt.Printf("  magic 0x%08x flags 0x%x codec %s\n", data.Magic, data.Flags, data.Codec)
+		fmt.Printf("  values count %d block size %d block count %d\n", data.ValuesCount, data.BlockSize, data.BlockCount)
+
+		fmt.Printf("entries %d, tombstones %d\n", info.Entries, info.Tombstones)
+		reportProblems(args[0], info.Problems)
+		return nil
+	},
+}
+
+var keysCmd = &cobra.Command{
+	Use:   "keys <table-file>",
+	Short: "List every version of every key, tombstones included",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		values, err := cmd.Flags().GetBool("values")
+		if err != nil {
+			return err
+		}
+
+		return readVersions(args[0], nil, func(record sstable.Record) {
+			printRecord(record, values)
+		})
+	},
+}
+
+var lookupCmd = &cobra.Command{
+	Use:   "get <table-file> <key>",
+	Short: "Print every version of a key",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		found := false
+
+		err := readVersions(args[0], []byte(args[1]), func(record sstable.Record) {
+			printRecord(record, true)
+			found = true
+		})
+
+		if err == nil && !found {
+			fmt.Printf("key %q not found\n", args[1])
+		}
+		return err
+	},
+}
+
+// tableFiles returns the index and data file of the table either file belongs to.
+func tableFiles(filePath string) (indexFilePath string, dataFilePath string) {
+	base := strings.TrimSuffix(strings.TrimSuffix(filePath, indexFileSuffix), dataFileSuffix)
+	return base + indexFileSuffix, base + dataFileSuffix
+}
+
+// readVersions calls onRecord with the versions of key in the table,
+// or with every version of every key if key is nil.
+func readVersions(filePath string, key []byte, onRecord func(record sstable.Record)) error {
+	table, err := sstable.OpenTableFiles(tableFiles(filePath))
+	if err != nil {
+		return err
+	}
+	defer table.Close()
+
+	reader, err := table.NewReader()
+	if err != nil {
+		return err
+	}
+
+	err = reader.Seek(key)
+	if err != nil {
+		return err
+	}
+
+	for {
+		record, err := reader.ReadNext()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if key != nil && string(record.Key) != string(key) {
+			return nil
+		}
+
+		onRecord(record)
+	}
+}
+
+func printRecord(record sstable.Record, withValue bool) {
+	line := fmt.Sprintf("%q seq %d", record.Key, record.Seq)
+
+	if record.Deleted {
+		line += " tombstone"
+	} else if withValue && record.MergeOperand {
+		line += fmt.Sprintf(" merge operand %q", record.Value)
+	} else if withValue {
+		line += fmt.Sprintf(" value %q", record.Value)
+	} else if record.MergeOperand {
+		line += " merge operand"
+	}
+
+	if record.ExpiresAt != 0 {
+		line += " expires " + time.Unix(0, record.ExpiresAt).UTC().Format(time.RFC3339Nano)
+	}
+
+	fmt.Println(line)
+}
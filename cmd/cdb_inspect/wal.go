@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/zl14917/MastersProject/kvstore/wal"
+	"os"
+	"path/filepath"
+)
+
+func init() {
+	rootCmd.AddCommand(walCmd)
+	walCmd.Flags().Bool("records", true, "print the header of every record")
+}
+
+var walCmd = &cobra.Command{
+	Use:   "wal <wal-dir | segment-file>",
+	Short: "Walk wal segments, verify record checksums and the chain of segments",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		printRecords, err := cmd.Flags().GetBool("records")
+		if err != nil {
+			return err
+		}
+
+		onRecord := func(filePath string, record wal.InspectedRecord) {
+			if !printRecords {
+				return
+			}
+
+			status := "ok"
+			if !record.Valid() {
+				status = fmt.Sprintf("BAD computed 0x%08x", record.ComputedCRC)
+			}
+
+			fmt.Printf("%s offset %d index %d type %d length %d crc 0x%08x %s\n",
+				filepath.Base(filePath), record.Offset, record.Index, record.EventType, record.DataLen, record.CRC, status)
+		}
+
+		stat, err := os.Stat(args[0])
+		if err != nil {
+			return err
+		}
+
+		var segments []wal.SegmentInfo
+
+		if stat.IsDir() {
+			segments, err = wal.InspectSegments(args[0], onRecord)
+		} else {
+			var info wal.SegmentInfo
+			info, err = wal.InspectSegment(args[0], func(record wal.InspectedRecord) {
+				onRecord(args[0], record)
+			})
+			segments = append(segments, info)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		for _, seg := range segments {
+			fmt.Printf("segment %s id %d prev %d flags 0x%x records %d from index %d to %d\n",
+				filepath.Base(seg.FilePath), seg.SegId, seg.PrevSegId, seg.Flags, seg.Records, seg.StartRecordIndex, seg.NextIndex)
+			reportProblems(filepath.Base(seg.FilePath), seg.Problems)
+		}
+
+		return nil
+	},
+}
@@ -1,28 +1,35 @@
 package maps
 
-
-
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	skipListMaxLevel  = 12
+	skipListBranching = 4
+)
+
+// SkipListMap keeps keys in ascending order. Writers are serialized by a mutex,
+// readers follow next pointers with atomic loads and never wait for writers.
+// Iterators see a weakly consistent view of writes made while they iterate.
 type SkipListMap struct {
+	writeLock    sync.Mutex
 	headSentinel *slMapNode
-	head         *slMapNode
-
-	size     int
-	maxLevel int
-}
-
-func (m *SkipListMap) Remove(key Key) (value Value, ok bool) {
-	panic("implement me")
-}
 
-func (m *SkipListMap) Len() int {
-	panic("implement me")
+	size   int64
+	height int32
+	random *rand.Rand
 }
 
-
 type slMapNode struct {
 	Key   Key
-	Value Value
-	Next  []*slMapNode
+	value unsafe.Pointer // *Value
+	next  []unsafe.Pointer
+	// set once the node is unlinked, readers that reached it before see it removed
+	removed int32
 }
 
 type SkipListIterator struct {
@@ -32,69 +39,153 @@ type SkipListIterator struct {
 func makeMapNode(key Key, value Value, height int) *slMapNode {
 	return &slMapNode{
 		Key:   key,
-		Value: value,
-		Next:  make([]*slMapNode, 1, height),
+		value: unsafe.Pointer(&value),
+		next:  make([]unsafe.Pointer, height),
 	}
 }
 
+func (n *slMapNode) Next(level int) *slMapNode {
+	return (*slMapNode)(atomic.LoadPointer(&n.next[level]))
+}
+
+func (n *slMapNode) setNext(level int, next *slMapNode) {
+	atomic.StorePointer(&n.next[level], unsafe.Pointer(next))
+}
+
+func (n *slMapNode) Value() Value {
+	return *(*Value)(atomic.LoadPointer(&n.value))
+}
+
+func (n *slMapNode) isRemoved() bool {
+	return atomic.LoadInt32(&n.removed) != 0
+}
+
 func NewSkipListMap() Map {
-	maxLevel := 6
-	sentinel := makeMapNode("", nil, maxLevel)
 	return &SkipListMap{
-		size:         0,
-		headSentinel: sentinel,
-		head:         sentinel,
-		maxLevel:     maxLevel,
+		headSentinel: makeMapNode("", nil, skipListMaxLevel),
+		height:       1,
+		random:       rand.New(rand.NewSource(0xdecafbad)),
 	}
 }
 
 func (m *SkipListMap) Get(key Key) (value Value, ok bool) {
-	node, exists := m.searchNode(key)
-	if ! exists {
+	node, exists := m.searchNode(key, nil)
+	if !exists {
 		return nil, false
 	}
-	return node.Value, true
+	return node.Value(), true
 }
 
-func (m *SkipListMap) Contains(key Key) (bool) {
-	_, exists := m.searchNode(key)
+func (m *SkipListMap) Contains(key Key) bool {
+	_, exists := m.searchNode(key, nil)
 	return exists
 }
 
-func (m *SkipListMap) searchNode(key Key) (node *slMapNode, exists bool) {
-	return
+func (m *SkipListMap) Len() int {
+	return int(atomic.LoadInt64(&m.size))
 }
 
-func (m *SkipListMap) linkNode(previous *slMapNode, newNode *slMapNode) {
+// searchNode returns the first node with a key >= key, exists is true if its key is key.
+// If previous is not nil it is filled with the last node before key on every level.
+func (m *SkipListMap) searchNode(key Key, previous []*slMapNode) (node *slMapNode, exists bool) {
+	current := m.headSentinel
+
+	for level := int(atomic.LoadInt32(&m.height)) - 1; level >= 0; level-- {
+		for {
+			node = current.Next(level)
+			if node == nil || node.Key >= key {
+				break
+			}
+			current = node
+		}
+
+		if previous != nil {
+			previous[level] = current
+		}
+	}
 
+	return node, node != nil && node.Key == key && !node.isRemoved()
 }
-func (m *SkipListMap) Put(key Key, value Value) (err error) {
-	if m.head == nil {
-		m.head = makeMapNode(key, value, m.maxLevel)
-		return
+
+func (m *SkipListMap) randomHeight() int {
+	height := 1
+	for height < skipListMaxLevel && m.random.Intn(skipListBranching) == 0 {
+		height++
 	}
-	node, exists := m.searchNode(key)
+	return height
+}
+
+func (m *SkipListMap) Put(key Key, value Value) (err error) {
+	m.writeLock.Lock()
+	defer m.writeLock.Unlock()
+
+	var previous [skipListMaxLevel]*slMapNode
+
+	node, exists := m.searchNode(key, previous[:])
 	if exists {
-		node.Value = value
-		return
+		atomic.StorePointer(&node.value, unsafe.Pointer(&value))
+		return nil
+	}
+
+	height := m.randomHeight()
+	currentHeight := int(atomic.LoadInt32(&m.height))
+
+	for level := currentHeight; level < height; level++ {
+		previous[level] = m.headSentinel
+	}
+
+	newNode := makeMapNode(key, value, height)
+
+	// link bottom up, a reader that finds the node on a level finds it below too
+	for level := 0; level < height; level++ {
+		newNode.setNext(level, previous[level].Next(level))
+		previous[level].setNext(level, newNode)
+	}
+
+	if height > currentHeight {
+		atomic.StoreInt32(&m.height, int32(height))
 	}
 
-	previous := node
-	newNode := makeMapNode(key, value, m.maxLevel)
-	m.linkNode(previous, newNode)
+	atomic.AddInt64(&m.size, 1)
 	return nil
 }
 
+func (m *SkipListMap) Remove(key Key) (value Value, ok bool) {
+	m.writeLock.Lock()
+	defer m.writeLock.Unlock()
+
+	var previous [skipListMaxLevel]*slMapNode
+
+	node, exists := m.searchNode(key, previous[:])
+	if !exists {
+		return nil, false
+	}
+
+	atomic.StoreInt32(&node.removed, 1)
+
+	// unlink top down, the next pointers of the node are kept
+	// so that readers standing on it can move on
+	for level := len(node.next) - 1; level >= 0; level-- {
+		previous[level].setNext(level, node.Next(level))
+	}
+
+	atomic.AddInt64(&m.size, -1)
+	return node.Value(), true
+}
+
+// Iterator returns keys in ascending order.
 func (m *SkipListMap) Iterator() MapIterator {
 	return &SkipListIterator{
-		current: m.head,
+		current: m.headSentinel,
 	}
 }
 
 func (i *SkipListIterator) Next() bool {
-	if len(i.current.Next) > 0 && i.current.Next[0] != nil {
-		i.current = i.current.Next[0]
-		return true
+	for next := i.current.Next(0); next != nil; next = next.Next(0) {
+		i.current = next
+		if !next.isRemoved() {
+			return true
+		}
 	}
 	return false
 }
@@ -103,5 +194,5 @@ func (i *SkipListIterator) Current() (key Key, value Value) {
 	if i.current == nil {
 		return
 	}
-	return i.current.Key, i.current.Value
+	return i.current.Key, i.current.Value()
 }
@@ -1,6 +1,11 @@
 package maps
 
-import "testing"
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
 
 func TestSkipListMap(t *testing.T){
 
@@ -17,4 +22,91 @@ func TestSkipListMap(t *testing.T){
 		t.Errorf("inserting 'hello', should contain 'hello'")
 	}
 
-}
\ No newline at end of file
+}
+
+func TestSkipListMap_Ordered(t *testing.T) {
+	m := NewSkipListMap()
+	keys := []Key{"pear", "apple", "fig", "banana", "cherry", "date"}
+
+	for _, key := range keys {
+		if err := m.Put(key, Value(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_ = m.Put("fig", Value("fig2"))
+
+	if value, ok := m.Remove("cherry"); !ok || string(value) != "cherry" {
+		t.Errorf("removing cherry should return its value, got %s, %v", value, ok)
+	}
+
+	if _, ok := m.Remove("cherry"); ok {
+		t.Errorf("cherry is removed twice")
+	}
+
+	if m.Len() != 5 {
+		t.Errorf("expect 5 keys, got %d", m.Len())
+	}
+
+	var scanned []string
+	for iterator := m.Iterator(); iterator.Next(); {
+		key, value := iterator.Current()
+		scanned = append(scanned, string(key)+"="+string(value))
+	}
+
+	expect := "apple=apple,banana=banana,date=date,fig=fig2,pear=pear"
+	if strings.Join(scanned, ",") != expect {
+		t.Errorf("expect %s, got %s", expect, strings.Join(scanned, ","))
+	}
+}
+
+func TestSkipListMap_ConcurrentReaders(t *testing.T) {
+	m := NewSkipListMap()
+
+	var (
+		wg   sync.WaitGroup
+		done = make(chan struct{})
+	)
+
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				var last Key
+				for iterator := m.Iterator(); iterator.Next(); {
+					key, _ := iterator.Current()
+					if key <= last {
+						t.Errorf("keys out of order: %s after %s", key, last)
+						return
+					}
+					last = key
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 2000; i++ {
+		key := Key(fmt.Sprintf("key_%04d", (i*7919)%2000))
+		_ = m.Put(key, Value(key))
+		if i%3 == 0 {
+			m.Remove(key)
+		}
+	}
+
+	close(done)
+	wg.Wait()
+
+	for i := 0; i < 2000; i++ {
+		key := Key(fmt.Sprintf("key_%04d", (i*7919)%2000))
+		if m.Contains(key) == (i%3 == 0) {
+			t.Errorf("key %s contained: %v", key, m.Contains(key))
+		}
+	}
+}
@@ -59,6 +59,9 @@ func (m *ThreadsafeMap) Remove(key Key) (value Value, ok bool) {
 func (m *ThreadsafeMap) Put(key Key, value Value) (err error) {
 	m.Lock()
 	defer m.Unlock()
+	if m.Map == nil {
+		m.Map = make(map[Key]mapValue)
+	}
 	m.Map[key] = mapValue{
 		Data:   value,
 		Delete: false,
@@ -69,8 +72,8 @@ func (m *ThreadsafeMap) Put(key Key, value Value) (err error) {
 func (m *ThreadsafeMap) Contains(key Key) (ok bool) {
 	m.RLock()
 	defer m.RUnlock()
-	_, ok = m.Map[key]
-	return false
+	value, ok := m.Map[key]
+	return ok && !value.Delete
 }
 
 func (m *ThreadsafeMap) Len() int {
@@ -0,0 +1,229 @@
+package kvstore
+
+import (
+	"fmt"
+	"github.com/zl14917/MastersProject/kvstore/sstable"
+	"github.com/zl14917/MastersProject/kvstore/tables"
+	"github.com/zl14917/MastersProject/kvstore/types"
+	"github.com/zl14917/MastersProject/kvstore/wal"
+)
+
+// WriteBatch collects puts, deletes and merges that are committed together by Write,
+// as one wal record. They are written to the default column family, or to the family
+// given to PutCF, DeleteCF and MergeCF. Keys and values are copied, callers may reuse
+// their buffers.
+type WriteBatch struct {
+	entries []wal.BatchEntry
+	size    int
+}
+
+func NewWriteBatch() *WriteBatch {
+	return &WriteBatch{}
+}
+
+func (b *WriteBatch) Put(key types.KeyType, value types.ValueType) {
+	b.add(0, wal.PutKey, key, value)
+}
+
+func (b *WriteBatch) Delete(key types.KeyType) {
+	b.add(0, wal.DeleteKey, key, nil)
+}
+
+// Merge adds an operand of the store's merge operator.
+func (b *WriteBatch) Merge(key types.KeyType, operand types.ValueType) {
+	b.add(0, wal.MergeKey, key, operand)
+}
+
+func (b *WriteBatch) PutCF(family *ColumnFamily, key types.KeyType, value types.ValueType) {
+	b.add(family.family.id, wal.PutKey, key, value)
+}
+
+func (b *WriteBatch) DeleteCF(family *ColumnFamily, key types.KeyType) {
+	b.add(family.family.id, wal.DeleteKey, key, nil)
+}
+
+// MergeCF adds an operand of the merge operator of family.
+func (b *WriteBatch) MergeCF(family *ColumnFamily, key types.KeyType, operand types.ValueType) {
+	b.add(family.family.id, wal.MergeKey, key, operand)
+}
+
+func (b *WriteBatch) add(columnFamily uint32, eventType wal.WALEventType, key []byte, value []byte) {
+	b.entries = append(b.entries, wal.BatchEntry{
+		ColumnFamily: columnFamily,
+		EventType:    eventType,
+		Key:          copyBytes(key),
+		Value:        copyBytes(value),
+	})
+	b.size += len(key) + len(value)
+}
+
+// Len is the number of puts, deletes and merges in the batch.
+func (b *WriteBatch) Len() int {
+	return len(b.entries)
+}
+
+// Size is the number of key and value bytes in the batch.
+func (b *WriteBatch) Size() int {
+	return b.size
+}
+
+func (b *WriteBatch) Reset() {
+	b.entries = nil
+	b.size = 0
+}
+
+// hasMergeOperands is true if entries hold merge operands.
+func hasMergeOperands(entries []tables.BatchEntry) bool {
+	for _, entry := range entries {
+		if entry.MergeOperand {
+			return true
+		}
+	}
+	return false
+}
+
+// memTableEntries converts the entries of a batch record by column family id,
+// copyData copies keys and values out of a record buffer that is reused.
+func memTableEntries(entries []wal.BatchEntry, copyData bool) (map[uint32][]tables.BatchEntry, error) {
+	result := make(map[uint32][]tables.BatchEntry)
+
+	for _, entry := range entries {
+		key, value := entry.Key, entry.Value
+		if copyData {
+			key, value = copyBytes(key), copyBytes(value)
+		}
+
+		var converted tables.BatchEntry
+
+		switch entry.EventType {
+		case wal.PutKey:
+			converted = tables.BatchEntry{Key: key, Value: value}
+		case wal.DeleteKey:
+			converted = tables.BatchEntry{Key: key, Deleted: true}
+		case wal.MergeKey:
+			converted = tables.BatchEntry{Key: key, Value: value, MergeOperand: true}
+		default:
+			return nil, fmt.Errorf("unknown batch event type %d", entry.EventType)
+		}
+
+		result[entry.ColumnFamily] = append(result[entry.ColumnFamily], converted)
+	}
+
+	return result, nil
+}
+
+// foldBatchEntries leaves one entry per key. Entries of a batch share a sequence number,
+// the version of a later entry would overwrite the one of an earlier entry of its key.
+// A put or delete replaces the earlier entries, a merge operand is combined with them
+// by operator.
+func foldBatchEntries(entries []tables.BatchEntry, operator sstable.MergeOperator) ([]tables.BatchEntry, error) {
+	if len(entries) < 2 {
+		return entries, nil
+	}
+
+	var (
+		folded    = make([]tables.BatchEntry, 0, len(entries))
+		positions = make(map[string]int, len(entries))
+	)
+
+	for _, entry := range entries {
+		i, ok := positions[string(entry.Key)]
+		if !ok {
+			positions[string(entry.Key)] = len(folded)
+			folded = append(folded, entry)
+			continue
+		}
+
+		older := folded[i]
+
+		switch {
+		case !entry.MergeOperand:
+			folded[i] = entry
+		case operator == nil:
+			return nil, NoMergeOperatorErr
+		case older.Deleted:
+			// the operand is the first value of the key after the delete
+			folded[i] = tables.BatchEntry{Key: entry.Key, Value: entry.Value}
+		default:
+			// merged into a put the result is a value, into an operand it is an operand
+			folded[i] = tables.BatchEntry{
+				Key:          entry.Key,
+				Value:        operator(entry.Key, older.Value, entry.Value),
+				MergeOperand: older.MergeOperand,
+			}
+		}
+	}
+
+	return folded, nil
+}
+
+// Write commits all puts, deletes and merges of batch as one wal record, at a single
+// sequence number, whichever column families they write to. After a crash either
+// the whole batch or none of it is recovered. Entries of a key are applied in the
+// order they were added.
+func (s *CliftonDBKVStore) Write(batch *WriteBatch) error {
+	if batch.Len() == 0 {
+		return nil
+	}
+
+	entries, err := memTableEntries(batch.entries, false)
+	if err != nil {
+		return err
+	}
+
+	families := make([]*columnFamily, 0, len(entries))
+
+	for id, familyEntries := range entries {
+		family := s.columnFamilyById(id)
+		if family == nil {
+			return UnknownColumnFamilyErr
+		}
+
+		if family.options.MergeOperator == nil && hasMergeOperands(familyEntries) {
+			return NoMergeOperatorErr
+		}
+
+		entries[id], err = foldBatchEntries(familyEntries, family.options.MergeOperator)
+		if err != nil {
+			return err
+		}
+
+		families = append(families, family)
+	}
+
+	record := &wal.WALRecord{}
+	record.SetBatchPayload(batch.entries)
+
+	err = s.beginWrite(families...)
+	if err != nil {
+		return err
+	}
+	defer s.endWrite(families...)
+
+	seq, err := s.appendRecord(record)
+	if err != nil {
+		return err
+	}
+
+	// entries of a family that failed are never shown, nor those of the others
+	commits := make([]func(), 0, len(families))
+
+	for _, family := range families {
+		var commit func()
+
+		commit, err = family.memTables().active.PrepareBatch(entries[family.id], seq)
+		if err != nil {
+			break
+		}
+		commits = append(commits, commit)
+	}
+
+	if err == nil {
+		for _, commit := range commits {
+			commit()
+		}
+	}
+
+	s.visible.Publish(seq)
+	return err
+}
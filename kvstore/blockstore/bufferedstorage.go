@@ -34,6 +34,9 @@ type BufferedBlockStorage struct {
 	currentReadBufferBlock  int
 	currentReadBufferSize   int
 	currentWriteBufferBlock int
+
+	readBufferLoaded bool
+	writeBufferDirty bool
 }
 
 func (b *BufferedBlockStorage) WriteWithCallback(data []byte, callback WriteEventCallback) (n int, err error) {
@@ -68,6 +71,8 @@ type BufferedBlockStorageOptions struct {
 	AutoSync   bool
 	SyncFileIO bool
 	BlockSize  int
+	// existing files are opened for reading only
+	ReadOnly bool
 }
 
 var defaultOptions = BufferedBlockStorageOptions{
@@ -94,6 +99,12 @@ func WithAutoSync() BufferedBlockStorageOption {
 	}
 }
 
+func WithReadOnly() BufferedBlockStorageOption {
+	return func(opts *BufferedBlockStorageOptions) {
+		opts.ReadOnly = true
+	}
+}
+
 func (b *BufferedBlockStorage) blockStorage() {}
 
 func applyOptions(opts *BufferedBlockStorageOptions, storageOptions ...BufferedBlockStorageOption) {
@@ -116,7 +127,8 @@ func newBufferedStorageWithOptions(path string, storageOptions ...BufferedBlockS
 		autoSync: !options.SyncFileIO && options.AutoSync,
 		syncMode: options.SyncFileIO,
 
-		blockLen: 0,
+		blockSize: options.BlockSize,
+		blockLen:  0,
 
 		readBuffer:              make(blockBuffer, options.BlockSize, options.BlockSize),
 		writeBuffer:             bytes.NewBuffer(nil),
@@ -158,6 +170,9 @@ func NewBlockFile(path string, storageOptions ...BufferedBlockStorageOption) (Bl
 func OpenBlockFile(path string, storageOptions ...BufferedBlockStorageOption) (BlockStorage, error) {
 	var err error
 	var flags int = os.O_RDWR
+	var options = defaultOptions
+
+	applyOptions(&options, storageOptions...)
 
 	storage := newBufferedStorageWithOptions(path, storageOptions...)
 
@@ -165,23 +180,32 @@ func OpenBlockFile(path string, storageOptions ...BufferedBlockStorageOption) (B
 		flags |= os.O_SYNC
 	}
 
+	if options.ReadOnly {
+		flags = os.O_RDONLY
+	}
+
 	storage.file, err = os.OpenFile(
 		storage.FilePath,
 		flags,
 		0644,
 	)
 
+	if err != nil {
+		return nil, err
+	}
+
 	fileInfo, err := storage.file.Stat()
+	if err != nil {
+		_ = storage.file.Close()
+		return nil, err
+	}
+
 	storage.blockLen = int(fileInfo.Size() / int64(storage.blockSize))
 
 	if fileInfo.Size()%int64(storage.blockSize) > 0 {
 		storage.blockLen += 1
 	}
 
-	if err != nil {
-		return nil, err
-	}
-
 	return storage, nil
 }
 
@@ -190,13 +214,19 @@ func (s *BufferedBlockStorage) flushReadBuffer() error {
 	clearBytes(s.readBuffer, 0x0, s.blockSize)
 
 	offset := int64(s.blockSize) * int64(s.currentReadBufferBlock)
-	// Read in a loop
 	s.currentReadBufferSize, err = s.file.ReadAt(s.readBuffer, offset)
 
+	// the last block of a file may be short
+	if err == io.EOF && s.currentReadBufferSize > 0 {
+		err = nil
+	}
+
 	if err != nil {
+		s.readBufferLoaded = false
 		return err
 	}
 
+	s.readBufferLoaded = true
 	return nil
 }
 
@@ -210,24 +240,35 @@ func (s *BufferedBlockStorage) Read(data []byte) (n int, err error) {
 	remaining := s.blockSize - s.seqReadOffset
 
 	// if reading bytes larger than space remaining in current block,
-	// read the next block from disk into buffer.
+	// move on to the next block.
 	if bytesToRead > remaining {
 		s.seqReadOffset = 0
 		s.seqReadBlock++
-		remaining = s.blockSize
-		s.currentReadBufferBlock = s.seqReadBlock
+	}
 
-		err = s.flushReadBuffer()
+	if s.blockLen <= s.seqReadBlock {
+		return 0, io.EOF
+	}
 
-		if err == io.EOF && s.currentReadBufferSize == 0 {
+	// reads see data still held in the write buffer
+	if s.writeBufferDirty && s.currentWriteBufferBlock == s.seqReadBlock {
+		err = s.Flush()
+		if err != nil {
 			return 0, err
 		}
+	}
 
+	if !s.readBufferLoaded || s.currentReadBufferBlock != s.seqReadBlock {
 		s.currentReadBufferBlock = s.seqReadBlock
-	}
 
-	if s.blockLen <= s.seqReadBlock {
-		return 0, io.EOF
+		err = s.flushReadBuffer()
+		if err == io.EOF {
+			return 0, err
+		}
+
+		if err != nil {
+			return 0, err
+		}
 	}
 
 	transferSize := bytesToRead
@@ -236,7 +277,12 @@ func (s *BufferedBlockStorage) Read(data []byte) (n int, err error) {
 		transferSize = s.currentReadBufferSize - s.seqReadOffset
 	}
 
+	if transferSize <= 0 {
+		return 0, io.EOF
+	}
+
 	copy(data, s.readBuffer[s.seqReadOffset:s.seqReadOffset+transferSize])
+	s.seqReadOffset += transferSize
 
 	return transferSize, nil
 }
@@ -248,20 +294,19 @@ func (s *BufferedBlockStorage) Write(data []byte) (n int, err error) {
 		return 0, SizeExceedBlockSize
 	}
 
-	remaining := s.blockSize - s.seqWriteBlock
+	remaining := s.blockSize - s.seqWriteOffset
 
 	// if data larger than space remaining in current block,
 	// flush buffer to disk, increase cursor position,
 	// and reset buffer.
 	if bytesToWrite > remaining {
-		s.seqWriteBlock++
-		s.seqWriteOffset = 0
 		err = s.Flush()
 		if err != nil {
 			return 0, err
 		}
 
-		clearBuffer(s.writeBuffer, 0x0, s.blockLen)
+		s.seqWriteBlock++
+		s.seqWriteOffset = 0
 		s.writeBuffer.Reset()
 
 		s.currentWriteBufferBlock = s.seqWriteBlock
@@ -269,26 +314,40 @@ func (s *BufferedBlockStorage) Write(data []byte) (n int, err error) {
 
 	// if don't have enough space in file, we grow as needed.
 	if s.blockLen < s.seqWriteBlock+1 {
-		oldLen := s.blockLen
-		_, err = s.Allocate(s.seqWriteBlock + 10)
+		_, err = s.Allocate(s.seqWriteBlock + 1)
 		if err != nil {
-			s.blockLen = oldLen
 			return 0, err
 		}
 	}
 
 	s.writeBuffer.Write(data)
 	s.seqWriteOffset += bytesToWrite
+	s.writeBufferDirty = true
+
+	if s.currentReadBufferBlock == s.currentWriteBufferBlock {
+		s.readBufferLoaded = false
+	}
 
 	return bytesToWrite, nil
 }
 
 func (s *BufferedBlockStorage) Sync() error {
+	err := s.Flush()
+	if err != nil {
+		return err
+	}
+
 	return s.file.Sync()
 }
 
 func (s *BufferedBlockStorage) Close() error {
-	return s.file.Close()
+	err := s.Flush()
+	closeErr := s.file.Close()
+
+	if err != nil {
+		return err
+	}
+	return closeErr
 }
 func (s *BufferedBlockStorage) Allocate(nblocks int) (nAllocated int, err error) {
 	if s.blockLen >= nblocks {
@@ -308,10 +367,21 @@ func (s *BufferedBlockStorage) Allocate(nblocks int) (nAllocated int, err error)
 	return growth, nil
 }
 
+// Flush writes the partially filled block of sequential writes.
 func (s *BufferedBlockStorage) Flush() error {
+	if !s.writeBufferDirty {
+		return nil
+	}
+
 	offset := int64(s.currentWriteBufferBlock) * int64(s.blockSize)
-	_, err := s.file.WriteAt(s.writeBuffer.Bytes()[:s.blockSize], offset)
-	return err
+	_, err := s.file.WriteAt(s.writeBuffer.Bytes(), offset)
+
+	if err != nil {
+		return err
+	}
+
+	s.writeBufferDirty = false
+	return nil
 }
 
 func (s *BufferedBlockStorage) NumBlocks() int {
@@ -328,11 +398,12 @@ func (s *BufferedBlockStorage) WriteBlock(index uint, buffer *bytes.Buffer) (n i
 		bytesToWrite = buffer.Len()
 	}
 
+	if int(index) >= s.blockLen {
+		return 0, io.EOF
+	}
+
 	offset := int64(s.blockSize) * int64(index)
 
-	if bytesToWrite > buffer.Len() {
-		bytesToWrite = buffer.Len()
-	}
 	bufMem := buffer.Bytes()[0:bytesToWrite]
 
 	n, err = s.file.WriteAt(bufMem, offset)
@@ -341,6 +412,10 @@ func (s *BufferedBlockStorage) WriteBlock(index uint, buffer *bytes.Buffer) (n i
 		return 0, err
 	}
 
+	if s.readBufferLoaded && s.currentReadBufferBlock == int(index) {
+		s.readBufferLoaded = false
+	}
+
 	if s.autoSync {
 		err = s.Sync()
 	}
@@ -354,16 +429,30 @@ func (s *BufferedBlockStorage) ReadBlock(index uint, buffer *bytes.Buffer) (n in
 		offset int64 = int64(s.blockSize) * int64(index)
 	)
 
+	if int(index) >= s.blockLen {
+		return 0, io.EOF
+	}
+
+	if s.writeBufferDirty && s.currentWriteBufferBlock == int(index) {
+		err = s.Flush()
+		if err != nil {
+			return 0, err
+		}
+	}
+
 	len := buffer.Len()
-	requiredCapacity := len + s.blockSize
+	buffer.Grow(s.blockSize)
 
-	bufMem := buffer.Bytes()[len:requiredCapacity]
+	// spare capacity of the buffer after Grow
+	bufMem := buffer.Bytes()[len : len+s.blockSize]
 
-	if buffer.Cap() < requiredCapacity {
-		buffer.Grow(requiredCapacity - buffer.Cap())
+	n, err = s.file.ReadAt(bufMem, offset)
+
+	// the last block of a file may be short
+	if err == io.EOF && n > 0 {
+		err = nil
 	}
 
-	n, err = s.file.ReadAt(bufMem, offset)
 	if err != nil {
 		return 0, err
 	}
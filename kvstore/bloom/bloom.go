@@ -0,0 +1,123 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+)
+
+const (
+	minFilterBits = 64
+	maxHashCount  = 30
+
+	filterHeaderSize = 8
+)
+
+var FilterTooShortErr = errors.New("bloom filter data too short")
+
+// Filter is a bloom filter over keys, probes are derived from one 64-bit hash
+// by double hashing.
+//
+// Marshalled as | hash count, 4 bytes | bit count, 4 bytes | bits |
+type Filter struct {
+	hashCount uint32
+	bitCount  uint32
+	bits      []byte
+}
+
+// NewFilter sizes a filter for keyCount keys at bitsPerKey bits each,
+// 10 bits per key gives about 1% false positives.
+func NewFilter(keyCount int, bitsPerKey int) *Filter {
+	bitCount := keyCount * bitsPerKey
+	if bitCount < minFilterBits {
+		bitCount = minFilterBits
+	}
+
+	byteCount := (bitCount + 7) / 8
+	bitCount = byteCount * 8
+
+	// ln(2) * bits per key minimises false positives
+	hashCount := uint32(math.Round(float64(bitsPerKey) * math.Ln2))
+	if hashCount < 1 {
+		hashCount = 1
+	}
+	if hashCount > maxHashCount {
+		hashCount = maxHashCount
+	}
+
+	return &Filter{
+		hashCount: hashCount,
+		bitCount:  uint32(bitCount),
+		bits:      make([]byte, byteCount),
+	}
+}
+
+func Hash(key []byte) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write(key)
+	return h.Sum64()
+}
+
+func (f *Filter) Add(key []byte) {
+	f.AddHash(Hash(key))
+}
+
+func (f *Filter) AddHash(hash uint64) {
+	h1, h2 := uint32(hash), uint32(hash>>32)
+
+	for i := uint32(0); i < f.hashCount; i++ {
+		bit := (h1 + i*h2) % f.bitCount
+		f.bits[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// MayContain returns false only if key was never added.
+func (f *Filter) MayContain(key []byte) bool {
+	return f.MayContainHash(Hash(key))
+}
+
+func (f *Filter) MayContainHash(hash uint64) bool {
+	h1, h2 := uint32(hash), uint32(hash>>32)
+
+	for i := uint32(0); i < f.hashCount; i++ {
+		bit := (h1 + i*h2) % f.bitCount
+		if f.bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *Filter) MarshalledSize() int {
+	return filterHeaderSize + len(f.bits)
+}
+
+func (f *Filter) Marshall() []byte {
+	data := make([]byte, f.MarshalledSize())
+	binary.BigEndian.PutUint32(data[0:4], f.hashCount)
+	binary.BigEndian.PutUint32(data[4:8], f.bitCount)
+	copy(data[filterHeaderSize:], f.bits)
+	return data
+}
+
+func UnMarshall(data []byte) (*Filter, error) {
+	if len(data) < filterHeaderSize {
+		return nil, FilterTooShortErr
+	}
+
+	f := &Filter{
+		hashCount: binary.BigEndian.Uint32(data[0:4]),
+		bitCount:  binary.BigEndian.Uint32(data[4:8]),
+	}
+
+	byteCount := int(f.bitCount+7) / 8
+
+	if f.bitCount == 0 || f.hashCount == 0 || len(data) < filterHeaderSize+byteCount {
+		return nil, FilterTooShortErr
+	}
+
+	f.bits = make([]byte, byteCount)
+	copy(f.bits, data[filterHeaderSize:])
+	return f, nil
+}
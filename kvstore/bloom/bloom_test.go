@@ -0,0 +1,40 @@
+package bloom
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	const keys = 10000
+	filter := NewFilter(keys, 10)
+
+	for i := 0; i < keys; i++ {
+		filter.Add([]byte(fmt.Sprintf("key_%d", i)))
+	}
+
+	decoded, err := UnMarshall(filter.Marshall())
+	if err != nil {
+		t.Error("error unmarshalling filter", err)
+		return
+	}
+
+	for i := 0; i < keys; i++ {
+		if !decoded.MayContain([]byte(fmt.Sprintf("key_%d", i))) {
+			t.Errorf("filter must contain key_%d", i)
+			return
+		}
+	}
+
+	falsePositives := 0
+	for i := 0; i < keys; i++ {
+		if decoded.MayContain([]byte(fmt.Sprintf("missing_%d", i))) {
+			falsePositives++
+		}
+	}
+
+	// about 1% expected at 10 bits per key
+	if falsePositives > keys/50 {
+		t.Errorf("too many false positives: %d of %d", falsePositives, keys)
+	}
+}
@@ -0,0 +1,72 @@
+package kvstore
+
+import (
+	"fmt"
+	"os"
+	"path"
+)
+
+// Checkpoint writes a copy of the store into dirPath, which must not exist yet,
+// that NewCliftonDBKVStore opens as a standalone store. The memtables of every column
+// family are flushed first, sstables are hard-linked and only the wal records after
+// the flush are copied.
+// Writes wait while the wal is copied, reads go on.
+func (s *CliftonDBKVStore) Checkpoint(dirPath string) error {
+	_, err := os.Stat(dirPath)
+
+	if err == nil {
+		return fmt.Errorf("checkpoint directory %s already exists", dirPath)
+	}
+
+	if !os.IsNotExist(err) {
+		return err
+	}
+
+	err = s.Flush()
+	if err != nil {
+		return err
+	}
+
+	// the flush index and the wal segments after it stay as they are
+	s.flushLock.Lock()
+	defer s.flushLock.Unlock()
+
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	checkpoint := &CliftonDBKVStore{
+		KVStoreRoot:         dirPath,
+		SSTablesRoot:        path.Join(dirPath, sstablePath),
+		WALRoot:             path.Join(dirPath, walPath),
+		KVStoreLockFilePath: path.Join(dirPath, lockFileName),
+		logger:              s.logger,
+	}
+
+	err = checkpoint.EnsureDirsExist()
+	if err != nil {
+		return err
+	}
+
+	for _, family := range s.families {
+		tablesPath := columnFamilyPath(dirPath, family.name)
+
+		err = os.MkdirAll(tablesPath, os.ModePerm)
+		if err != nil {
+			return err
+		}
+
+		err = family.fileTable.Checkpoint(tablesPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	data := s.lockFileData
+
+	err = s.wal.Checkpoint(checkpoint.WALRoot, data.WALFlushIndex+1)
+	if err != nil {
+		return err
+	}
+
+	return checkpoint.WriteLockFile(data)
+}
@@ -0,0 +1,236 @@
+package kvstore
+
+import (
+	"errors"
+	"github.com/zl14917/MastersProject/kvstore/compactor"
+	"github.com/zl14917/MastersProject/kvstore/sstable"
+	"github.com/zl14917/MastersProject/kvstore/tables"
+	"github.com/zl14917/MastersProject/kvstore/types"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultColumnFamily is the family read and written by the methods of the store itself.
+const DefaultColumnFamily = "default"
+
+var UnknownColumnFamilyErr = errors.New("kv-store has no such column family")
+var InvalidColumnFamilyNameErr = errors.New("column family name must be a file name other than default")
+
+// columnFamily is a partition of the store with memtables, sstables and options of
+// its own. Families share the wal and its sequence numbers, so a write to several
+// families is a single wal record.
+type columnFamily struct {
+	name string
+	// wal records refer to the family by id, the default family is 0
+	id      uint32
+	options KVStoreOptions
+
+	fileTable         *tables.LevelFileTable
+	compactor         compactor.Compactor
+	compactionStopped <-chan struct{}
+
+	// *memTableSet, the active memtable and the ones waiting to be flushed
+	memtables        atomic.Value
+	memtablesLock    sync.Mutex
+	memtablesChanged chan struct{}
+}
+
+// ColumnFamily reads and writes one column family of a store,
+// WriteBatch writes to several families atomically.
+type ColumnFamily struct {
+	store  *CliftonDBKVStore
+	family *columnFamily
+}
+
+// The store has a column family called name, its sstables, memtables, compactions
+// and merge operator are configured by familyOptions applied over the store's options.
+// WAL options are the store's. A family is created the first time the store is opened
+// with it, later opens without it open the family with the store's options.
+func WithColumnFamily(name string, familyOptions ...KVStoreOpenOptions) KVStoreOpenOptions {
+	return kvStoreOptionsFunc(func(options *KVStoreOptions) {
+		families := make(map[string][]KVStoreOpenOptions, len(options.ColumnFamilies)+1)
+		for familyName, opts := range options.ColumnFamilies {
+			families[familyName] = opts
+		}
+
+		families[name] = familyOptions
+		options.ColumnFamilies = families
+	})
+}
+
+func validColumnFamilyName(name string) bool {
+	return name != "" && name != "." && name != ".." && name != DefaultColumnFamily &&
+		!strings.ContainsAny(name, `/\`)
+}
+
+// columnFamilyPath is the directory of the sstables of a family of the store in dirPath.
+func columnFamilyPath(dirPath string, name string) string {
+	if name == DefaultColumnFamily {
+		return path.Join(dirPath, sstablePath)
+	}
+	return path.Join(dirPath, columnFamiliesPath, name)
+}
+
+// newColumnFamily configures the tables of a family, they are read once opened.
+func (s *CliftonDBKVStore) newColumnFamily(name string, id uint32, options KVStoreOptions) *columnFamily {
+	fileTable := tables.NewSStableFileTable(columnFamilyPath(s.KVStoreRoot, name), s.WALRoot)
+	fileTable.Options.DataBlockSize = options.DataBlockSize
+	fileTable.Options.IndexBlockSize = options.IndexBlockSize
+	fileTable.Options.BloomBitsPerKey = options.BloomBitsPerKey
+	fileTable.Options.DataBlockCodec = options.DataBlockCodec
+	fileTable.Options.BlockCache = options.BlockCache
+
+	if fileTable.Options.BlockCache == nil && options.BlockCacheSize > 0 {
+		fileTable.Options.BlockCache = sstable.NewBlockCache(options.BlockCacheSize)
+	}
+
+	if options.CompactionFilter != nil {
+		fileTable.CompactionFilter = s.countFilterDecisions(options.CompactionFilter)
+	}
+	fileTable.MergeOperator = options.MergeOperator
+
+	return &columnFamily{
+		name:      name,
+		id:        id,
+		options:   options,
+		fileTable: fileTable,
+	}
+}
+
+// addColumnFamilies adds the families of the lock file and of the options after the
+// default one. New families take the next unused ids, recorded in data.
+func (s *CliftonDBKVStore) addColumnFamilies(data *KVStoreLockFileData, options KVStoreOptions) error {
+	var (
+		ids    = make(map[string]uint32, len(data.ColumnFamilies)+len(options.ColumnFamilies))
+		names  []string
+		nextId uint32 = 1
+	)
+
+	for name, id := range data.ColumnFamilies {
+		ids[name] = id
+		if id >= nextId {
+			nextId = id + 1
+		}
+	}
+
+	for name := range options.ColumnFamilies {
+		if !validColumnFamilyName(name) {
+			return InvalidColumnFamilyNameErr
+		}
+
+		if _, ok := ids[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	// ids do not depend on the order options are applied in
+	sort.Strings(names)
+	for _, name := range names {
+		ids[name] = nextId
+		nextId++
+	}
+
+	names = names[:0]
+	for name := range ids {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		familyOptions := options
+		familyOptions.ColumnFamilies = nil
+
+		for _, opt := range options.ColumnFamilies[name] {
+			opt.Apply(&familyOptions)
+		}
+
+		s.families = append(s.families, s.newColumnFamily(name, ids[name], familyOptions))
+	}
+
+	if len(ids) > 0 {
+		data.ColumnFamilies = ids
+	}
+	return nil
+}
+
+// ColumnFamily returns the family called name, DefaultColumnFamily included.
+func (s *CliftonDBKVStore) ColumnFamily(name string) (*ColumnFamily, error) {
+	for _, family := range s.families {
+		if family.name == name {
+			return &ColumnFamily{store: s, family: family}, nil
+		}
+	}
+	return nil, UnknownColumnFamilyErr
+}
+
+// ColumnFamilies lists the names of the families of the store, the default one first.
+func (s *CliftonDBKVStore) ColumnFamilies() []string {
+	names := make([]string, 0, len(s.families))
+	for _, family := range s.families {
+		names = append(names, family.name)
+	}
+	return names
+}
+
+func (s *CliftonDBKVStore) columnFamilyById(id uint32) *columnFamily {
+	for _, family := range s.families {
+		if family.id == id {
+			return family
+		}
+	}
+	return nil
+}
+
+func (cf *ColumnFamily) Name() string {
+	return cf.family.name
+}
+
+func (cf *ColumnFamily) Get(key types.KeyType) (data types.ValueType, ok bool, err error) {
+	return cf.family.get(key, cf.store.visible.Load(), cf.store.fileTable.Clock.Now())
+}
+
+func (cf *ColumnFamily) Exists(key types.KeyType) (ok bool, err error) {
+	_, ok, err = cf.Get(key)
+	return
+}
+
+// Put, Delete and Merge of a family are logged as batches of one entry.
+func (cf *ColumnFamily) Put(key types.KeyType, data types.ValueType) error {
+	batch := NewWriteBatch()
+	batch.PutCF(cf, key, data)
+	return cf.store.Write(batch)
+}
+
+// Delete writes a tombstone that hides every older version of key in the family,
+// ok is true if the key was live before.
+func (cf *ColumnFamily) Delete(key types.KeyType) (ok bool, err error) {
+	_, ok, err = cf.Get(key)
+	if err != nil {
+		return
+	}
+
+	batch := NewWriteBatch()
+	batch.DeleteCF(cf, key)
+	err = cf.store.Write(batch)
+	return
+}
+
+// Merge writes an operand of the family's merge operator.
+func (cf *ColumnFamily) Merge(key types.KeyType, operand types.ValueType) error {
+	batch := NewWriteBatch()
+	batch.MergeCF(cf, key, operand)
+	return cf.store.Write(batch)
+}
+
+// Scan iterates keys in [start, end) of the family.
+func (cf *ColumnFamily) Scan(start types.KeyType, end types.KeyType) (*Iterator, error) {
+	return cf.family.scan(start, end, cf.store.visible.Load(), cf.store.fileTable.Clock.Now())
+}
+
+// Metadata lists the index files of live tables of the family per level.
+func (cf *ColumnFamily) Metadata() KVStoreMetadata {
+	return cf.family.metadata()
+}
@@ -1,7 +1,38 @@
 package compactor
 
-import "context"
+import (
+	"context"
+	"fmt"
+)
 
 type Compactor interface {
+	// Compact runs compactions in the background until ctx is done,
+	// the returned channel is closed once it stopped.
 	Compact(ctx context.Context) <-chan struct{}
+	// Schedule asks for the tables to be checked for work, it never blocks.
+	Schedule()
+	// CompactOnce runs a single compaction if one is due.
+	CompactOnce() (compacted bool, err error)
+}
+
+type ErrorHandler func(err error)
+
+// Strategy names a compaction policy a store can be configured with.
+type Strategy string
+
+const (
+	LeveledStrategy    Strategy = "leveled"
+	SizeTieredStrategy Strategy = "size-tiered"
+)
+
+// ParseStrategy defaults to leveled compaction for an empty name.
+func ParseStrategy(name string) (Strategy, error) {
+	switch Strategy(name) {
+	case "", LeveledStrategy:
+		return LeveledStrategy, nil
+	case SizeTieredStrategy:
+		return SizeTieredStrategy, nil
+	default:
+		return "", fmt.Errorf("unknown compaction strategy %q", name)
+	}
 }
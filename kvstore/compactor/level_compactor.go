@@ -1 +1,229 @@
 package compactor
+
+import (
+	"bytes"
+	"context"
+	"github.com/zl14917/MastersProject/kvstore/sstable"
+	"github.com/zl14917/MastersProject/kvstore/tables"
+	"github.com/zl14917/MastersProject/kvstore/types"
+	"sync"
+	"time"
+)
+
+// LevelTrigger starts a compaction of a level into the next one
+// when either limit is reached, a zero limit is not checked.
+type LevelTrigger struct {
+	MaxFiles int
+	MaxBytes int64
+}
+
+type LeveledOptions struct {
+	// triggers of every level but the bottom one
+	Triggers [tables.NumLevels - 1]LevelTrigger
+	// output tables are split at about this size
+	TargetTableBytes int64
+	// levels are checked this often even when no compaction is scheduled
+	CheckInterval time.Duration
+	OnError       ErrorHandler
+}
+
+var DefaultLeveledOptions = LeveledOptions{
+	Triggers: [tables.NumLevels - 1]LevelTrigger{
+		{MaxFiles: 4},
+		{MaxBytes: 64 * 1024 * 1024},
+	},
+	TargetTableBytes: 4 * 1024 * 1024,
+	CheckInterval:    10 * time.Second,
+}
+
+// LeveledCompactor merges all level 0 tables into the overlapping tables of level 1,
+// and one table of a deeper level at a time into the overlapping tables below it.
+type LeveledCompactor struct {
+	// one compaction at a time
+	sync.Mutex
+
+	fileTable *tables.LevelFileTable
+	options   LeveledOptions
+	schedule  chan struct{}
+
+	// last key compacted out of each level, the next compaction starts after it
+	compactPointer [tables.NumLevels]types.KeyType
+}
+
+var _ Compactor = &LeveledCompactor{}
+
+func NewLeveledCompactor(fileTable *tables.LevelFileTable, options LeveledOptions) *LeveledCompactor {
+	if options.CheckInterval <= 0 {
+		options.CheckInterval = DefaultLeveledOptions.CheckInterval
+	}
+
+	return &LeveledCompactor{
+		fileTable: fileTable,
+		options:   options,
+		schedule:  make(chan struct{}, 1),
+	}
+}
+
+func (c *LeveledCompactor) Compact(ctx context.Context) <-chan struct{} {
+	return runLoop(ctx, c.schedule, c.options.CheckInterval, c.CompactOnce, c.options.OnError)
+}
+
+func (c *LeveledCompactor) Schedule() {
+	select {
+	case c.schedule <- struct{}{}:
+	default:
+	}
+}
+
+func (c *LeveledCompactor) CompactOnce() (compacted bool, err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	level, err := c.pickLevel()
+	if err != nil {
+		return false, err
+	}
+
+	if level < 0 {
+		return rewriteRangeDeleted(c.fileTable)
+	}
+
+	return true, c.compactLevel(level)
+}
+
+// pickLevel returns the level furthest over its limits, or -1.
+func (c *LeveledCompactor) pickLevel() (int, error) {
+	var (
+		bestLevel = -1
+		bestScore = 1.0
+	)
+
+	for level, trigger := range c.options.Triggers {
+		refs := c.fileTable.Tables(level)
+		score := 0.0
+
+		if trigger.MaxFiles > 0 {
+			score = float64(len(refs)) / float64(trigger.MaxFiles)
+		}
+
+		if trigger.MaxBytes > 0 {
+			size, err := totalSize(refs)
+			if err != nil {
+				return -1, err
+			}
+
+			if sizeScore := float64(size) / float64(trigger.MaxBytes); sizeScore > score {
+				score = sizeScore
+			}
+		}
+
+		if score >= bestScore && len(refs) > 0 {
+			bestLevel = level
+			bestScore = score
+		}
+	}
+
+	return bestLevel, nil
+}
+
+// pickInputs returns the tables of level to compact, newest first.
+func (c *LeveledCompactor) pickInputs(level int) ([]*tables.SStableRef, error) {
+	refs := c.fileTable.Tables(level)
+
+	if level == 0 {
+		inputs := make([]*tables.SStableRef, 0, len(refs))
+		for i := len(refs) - 1; i >= 0; i-- {
+			inputs = append(inputs, refs[i])
+		}
+		return inputs, nil
+	}
+
+	pointer := c.compactPointer[level]
+
+	for _, ref := range refs {
+		minKey, _, err := ref.KeyRange()
+		if err != nil {
+			return nil, err
+		}
+
+		if pointer == nil || bytes.Compare(minKey, pointer) > 0 {
+			return []*tables.SStableRef{ref}, nil
+		}
+	}
+
+	// wrap around to the start of the key space
+	return refs[0:1], nil
+}
+
+func (c *LeveledCompactor) compactLevel(level int) error {
+	outputLevel := level + 1
+
+	inputs, err := c.pickInputs(level)
+	if err != nil {
+		return err
+	}
+
+	minKey, maxKey, err := keyRange(inputs)
+	if err != nil {
+		return err
+	}
+
+	overlaps, err := overlapping(c.fileTable.Tables(outputLevel), minKey, maxKey)
+	if err != nil {
+		return err
+	}
+
+	c.compactPointer[level] = maxKey
+
+	edit := tables.VersionEdit{
+		Removed: append(tableEdits(level, inputs), tableEdits(outputLevel, overlaps)...),
+	}
+
+	// a single table with nothing to merge with moves down as it is
+	if level > 0 && len(inputs) == 1 && len(overlaps) == 0 {
+		edit.Added = tableEdits(outputLevel, inputs)
+		return c.fileTable.Install(edit)
+	}
+
+	dropTombstones, err := c.isBottomFor(outputLevel, minKey, maxKey)
+	if err != nil {
+		return err
+	}
+
+	newTable := func() *tables.SStableRef {
+		return c.fileTable.NewTable(outputLevel)
+	}
+
+	var (
+		snapshots       = c.fileTable.Snapshots.Sequences()
+		rangeTombstones = c.fileTable.RangeTombstones()
+	)
+
+	outputs, err := mergeInto(
+		append(inputs, overlaps...),
+		newTable,
+		sstable.MergeOptions{
+			DropTombstones:  dropTombstones,
+			MaxTableBytes:   c.options.TargetTableBytes,
+			Snapshots:       snapshots,
+			Now:             c.fileTable.Snapshots.ExpiryTime(c.fileTable.Clock.Now()),
+			RangeTombstones: rangeTombstones,
+			Filter:          c.fileTable.CompactionFilter,
+			MergeOperator:   c.fileTable.MergeOperator,
+		},
+	)
+
+	if err != nil {
+		return err
+	}
+
+	edit.Added = tableEdits(outputLevel, outputs)
+	edit.AppliedRangeTombstones = appliedRangeTombstones(rangeTombstones, snapshots)
+	return c.fileTable.Install(edit)
+}
+
+// isBottomFor is true when no level below outputLevel holds keys in the range,
+// so tombstones have nothing left to shadow.
+func (c *LeveledCompactor) isBottomFor(outputLevel int, minKey types.KeyType, maxKey types.KeyType) (bool, error) {
+	return noDeeperOverlap(c.fileTable, outputLevel, minKey, maxKey)
+}
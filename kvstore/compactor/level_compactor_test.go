@@ -0,0 +1,192 @@
+package compactor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/zl14917/MastersProject/kvstore/tables"
+	"github.com/zl14917/MastersProject/kvstore/types"
+)
+
+const tmpDir = "/tmp/"
+
+type testWithFileIO func(t *testing.T, dirPath string)
+
+func WithTempDir(t *testing.T, io testWithFileIO) {
+
+	name, err := ioutil.TempDir(tmpDir, "cliftondbtests")
+
+	if err != nil {
+		t.Fatal("can't create test directory", err)
+	}
+
+	io(t, name)
+
+	_ = os.RemoveAll(name)
+}
+
+// writeTable adds a level 0 table holding keys [from, to),
+// keys divisible by deleteEvery are written as tombstones.
+func writeTable(t *testing.T, fileTable *tables.LevelFileTable, from int, to int, version string, deleteEvery int) {
+	ref := fileTable.NewTable(0)
+
+	writer, err := ref.NewWriter()
+	if err != nil {
+		t.Fatal("error creating sstable", err)
+	}
+
+	for i := from; i < to; i++ {
+		key := fmt.Sprintf("key_%04d", i)
+		deleted := deleteEvery > 0 && i%deleteEvery == 0
+
+		err = writer.Write([]byte(key), []byte(version+"_"+key), deleted)
+		if err != nil {
+			t.Fatalf("error writing key %s: %v", key, err)
+		}
+	}
+
+	err = writer.Commit()
+	if err != nil {
+		t.Fatal("error committing sstable", err)
+	}
+
+	err = fileTable.AddSSTable(0, ref)
+	if err != nil {
+		t.Fatal("error adding sstable", err)
+	}
+}
+
+func expectValue(t *testing.T, fileTable *tables.LevelFileTable, i int, version string) {
+	key := fmt.Sprintf("key_%04d", i)
+	value, deleted, ok, err := fileTable.Get([]byte(key), types.MaxSequenceNumber, 0)
+
+	if err != nil || deleted || !ok {
+		t.Errorf("key %s should be found, ok: %v, deleted: %v, err: %v", key, ok, deleted, err)
+		return
+	}
+
+	if string(value) != version+"_"+key {
+		t.Errorf("key %s expect value %s, got %s", key, version+"_"+key, value)
+	}
+}
+
+func expectAbsent(t *testing.T, fileTable *tables.LevelFileTable, i int) {
+	key := fmt.Sprintf("key_%04d", i)
+	_, deleted, ok, err := fileTable.Get([]byte(key), types.MaxSequenceNumber, 0)
+
+	if err != nil || (ok && !deleted) {
+		t.Errorf("key %s should not be found, ok: %v, err: %v", key, ok, err)
+	}
+}
+
+func countFiles(t *testing.T, dirPath string) int {
+	files, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(files)
+}
+
+func TestLeveledCompactor_Level0(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		fileTable := tables.NewSStableFileTable(dirPath, dirPath)
+
+		options := DefaultLeveledOptions
+		options.TargetTableBytes = 4 * 1024
+		compactor := NewLeveledCompactor(fileTable, options)
+
+		writeTable(t, fileTable, 0, 300, "v1", 0)
+		writeTable(t, fileTable, 100, 200, "v2", 0)
+		writeTable(t, fileTable, 150, 250, "v3", 10)
+
+		compacted, err := compactor.CompactOnce()
+		if compacted || err != nil {
+			t.Errorf("three level 0 tables should not trigger compaction: %v", err)
+		}
+
+		writeTable(t, fileTable, 290, 400, "v4", 0)
+
+		compacted, err = compactor.CompactOnce()
+		if !compacted || err != nil {
+			t.Errorf("four level 0 tables should be compacted: %v", err)
+			return
+		}
+
+		if len(fileTable.Tables(0)) != 0 || len(fileTable.Tables(1)) < 2 {
+			t.Errorf(
+				"level 0 should be merged into several level 1 tables, got %d and %d",
+				len(fileTable.Tables(0)),
+				len(fileTable.Tables(1)),
+			)
+		}
+
+		for i := 0; i < 400; i++ {
+			switch {
+			case i >= 290:
+				expectValue(t, fileTable, i, "v4")
+			case i >= 150 && i < 250 && i%10 == 0:
+				expectAbsent(t, fileTable, i)
+			case i >= 150 && i < 250:
+				expectValue(t, fileTable, i, "v3")
+			case i >= 100 && i < 200:
+				expectValue(t, fileTable, i, "v2")
+			default:
+				expectValue(t, fileTable, i, "v1")
+			}
+		}
+
+		// two files for each table, no inputs left behind
+		if files := countFiles(t, dirPath); files != 2*len(fileTable.Tables(1)) {
+			t.Errorf("expect only files of level 1 tables, got %d files", files)
+		}
+	})
+}
+
+func TestLeveledCompactor_DeeperLevels(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		fileTable := tables.NewSStableFileTable(dirPath, dirPath)
+
+		options := DefaultLeveledOptions
+		options.Triggers[0] = LevelTrigger{MaxFiles: 1}
+		options.Triggers[1] = LevelTrigger{MaxBytes: 1}
+		compactor := NewLeveledCompactor(fileTable, options)
+
+		writeTable(t, fileTable, 0, 100, "v1", 0)
+		for compacted, err := compactor.CompactOnce(); compacted || err != nil; compacted, err = compactor.CompactOnce() {
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+
+		if len(fileTable.Tables(2)) != 1 {
+			t.Errorf("table should move down to level 2, got %d", len(fileTable.Tables(2)))
+			return
+		}
+
+		writeTable(t, fileTable, 50, 150, "v2", 5)
+		for compacted, err := compactor.CompactOnce(); compacted || err != nil; compacted, err = compactor.CompactOnce() {
+			if err != nil {
+				t.Error(err)
+				return
+			}
+		}
+
+		if len(fileTable.Tables(0)) != 0 || len(fileTable.Tables(1)) != 0 {
+			t.Error("level 0 and 1 should be empty")
+		}
+
+		for i := 0; i < 150; i++ {
+			switch {
+			case i >= 50 && i%5 == 0:
+				expectAbsent(t, fileTable, i)
+			case i >= 50:
+				expectValue(t, fileTable, i, "v2")
+			default:
+				expectValue(t, fileTable, i, "v1")
+			}
+		}
+	})
+}
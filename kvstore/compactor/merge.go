@@ -0,0 +1,178 @@
+package compactor
+
+import (
+	"bytes"
+	"context"
+	"github.com/zl14917/MastersProject/kvstore/sstable"
+	"github.com/zl14917/MastersProject/kvstore/tables"
+	"github.com/zl14917/MastersProject/kvstore/types"
+	"time"
+)
+
+// mergeInto merges inputs, ordered newest first, into tables created by newTable.
+// Inputs are not changed, the caller installs the outputs.
+func mergeInto(
+	inputs []*tables.SStableRef,
+	newTable func() *tables.SStableRef,
+	options sstable.MergeOptions,
+) ([]*tables.SStableRef, error) {
+	readers := make([]sstable.SSTableReader, 0, len(inputs))
+
+	for _, input := range inputs {
+		reader, err := input.NewMergeReader()
+		if err != nil {
+			return nil, err
+		}
+		readers = append(readers, reader)
+	}
+
+	var outputs []*tables.SStableRef
+
+	_, err := sstable.MergeTables(readers, func() (*sstable.SSTable, error) {
+		ref := newTable()
+		outputs = append(outputs, ref)
+		return &ref.SSTable, nil
+	}, options)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return outputs, nil
+}
+
+// keyRange is the smallest range covering all tables.
+func keyRange(refs []*tables.SStableRef) (minKey types.KeyType, maxKey types.KeyType, err error) {
+	for _, ref := range refs {
+		tableMin, tableMax, err := ref.KeyRange()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if minKey == nil || bytes.Compare(tableMin, minKey) < 0 {
+			minKey = tableMin
+		}
+
+		if maxKey == nil || bytes.Compare(tableMax, maxKey) > 0 {
+			maxKey = tableMax
+		}
+	}
+
+	return minKey, maxKey, nil
+}
+
+func overlapping(refs []*tables.SStableRef, minKey types.KeyType, maxKey types.KeyType) ([]*tables.SStableRef, error) {
+	var result []*tables.SStableRef
+
+	for _, ref := range refs {
+		overlaps, err := ref.Overlaps(minKey, maxKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if overlaps {
+			result = append(result, ref)
+		}
+	}
+
+	return result, nil
+}
+
+// noDeeperOverlap is true when no level below level holds keys in the range.
+func noDeeperOverlap(fileTable *tables.LevelFileTable, level int, minKey types.KeyType, maxKey types.KeyType) (bool, error) {
+	for deeper := level + 1; deeper < tables.NumLevels; deeper++ {
+		refs, err := overlapping(fileTable.Tables(deeper), minKey, maxKey)
+		if err != nil {
+			return false, err
+		}
+
+		if len(refs) > 0 {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func totalSize(refs []*tables.SStableRef) (int64, error) {
+	var size int64
+
+	for _, ref := range refs {
+		tableSize, err := ref.Size()
+		if err != nil {
+			return 0, err
+		}
+		size += tableSize
+	}
+
+	return size, nil
+}
+
+func tableEdits(level int, refs []*tables.SStableRef) []tables.TableEdit {
+	edits := make([]tables.TableEdit, 0, len(refs))
+
+	for _, ref := range refs {
+		edits = append(edits, tables.TableEdit{Level: level, Table: ref})
+	}
+
+	return edits
+}
+
+// appliedRangeTombstones are the tombstones a merge leaves no version they delete behind for,
+// those no snapshot is older than.
+func appliedRangeTombstones(tombstones []sstable.RangeTombstone, snapshots []uint64) []uint64 {
+	var applied []uint64
+
+	for _, tombstone := range tombstones {
+		if len(snapshots) == 0 || snapshots[0] >= tombstone.Seq {
+			applied = append(applied, tombstone.Seq)
+		}
+	}
+
+	return applied
+}
+
+// runLoop calls compactOnce until there is no more work whenever it is
+// scheduled or the interval passed, until ctx is done.
+func runLoop(
+	ctx context.Context,
+	schedule <-chan struct{},
+	interval time.Duration,
+	compactOnce func() (bool, error),
+	onError ErrorHandler,
+) <-chan struct{} {
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-schedule:
+			case <-ticker.C:
+			}
+
+			for ctx.Err() == nil {
+				compacted, err := compactOnce()
+
+				if err != nil {
+					if onError != nil {
+						onError(err)
+					}
+					break
+				}
+
+				if !compacted {
+					break
+				}
+			}
+		}
+	}()
+
+	return stopped
+}
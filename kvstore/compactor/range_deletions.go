@@ -0,0 +1,52 @@
+package compactor
+
+import (
+	"github.com/zl14917/MastersProject/kvstore/sstable"
+	"github.com/zl14917/MastersProject/kvstore/tables"
+)
+
+// rewriteRangeDeleted rewrites one table holding versions a range tombstone deletes,
+// once no snapshot reads them, in place and without them. Deleted ranges free their
+// space and their tombstones are dropped without waiting for the table to be merged,
+// a table holding only deleted versions is removed.
+func rewriteRangeDeleted(fileTable *tables.LevelFileTable) (rewritten bool, err error) {
+	var (
+		deletions       = fileTable.RangeDeletions()
+		snapshots       = fileTable.Snapshots.Sequences()
+		rangeTombstones = make([]sstable.RangeTombstone, 0, len(deletions))
+	)
+
+	for _, deletion := range deletions {
+		rangeTombstones = append(rangeTombstones, deletion.RangeTombstone)
+	}
+
+	applied := appliedRangeTombstones(rangeTombstones, snapshots)
+	if len(applied) == 0 {
+		return false, nil
+	}
+
+	// the oldest tombstones go first, applied ones are a prefix of them
+	table := deletions[0].Tables[0]
+	level, timestamp := table.Level, table.Timestamp
+
+	newTable := func() *tables.SStableRef {
+		return fileTable.NewTableAt(level, timestamp)
+	}
+
+	outputs, err := mergeInto([]*tables.SStableRef{table}, newTable, sstable.MergeOptions{
+		Snapshots:       snapshots,
+		Now:             fileTable.Snapshots.ExpiryTime(fileTable.Clock.Now()),
+		RangeTombstones: rangeTombstones,
+		Filter:          fileTable.CompactionFilter,
+		MergeOperator:   fileTable.MergeOperator,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return true, fileTable.Install(tables.VersionEdit{
+		Added:                  tableEdits(level, outputs),
+		Removed:                tableEdits(level, []*tables.SStableRef{table}),
+		AppliedRangeTombstones: applied,
+	})
+}
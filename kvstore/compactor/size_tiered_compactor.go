@@ -0,0 +1,222 @@
+package compactor
+
+import (
+	"context"
+	"github.com/zl14917/MastersProject/kvstore/sstable"
+	"github.com/zl14917/MastersProject/kvstore/tables"
+	"sync"
+	"time"
+)
+
+type SizeTieredOptions struct {
+	// a bucket is merged once it holds this many tables
+	MinThreshold int
+	// at most this many tables are merged at once
+	MaxThreshold int
+	// tables join a bucket when their size is within
+	// [BucketLow, BucketHigh] times the bucket's average size
+	BucketLow  float64
+	BucketHigh float64
+	// tables smaller than this all fall into the same bucket
+	MinTableBytes int64
+	// tables are checked this often even when no compaction is scheduled
+	CheckInterval time.Duration
+	OnError       ErrorHandler
+}
+
+var DefaultSizeTieredOptions = SizeTieredOptions{
+	MinThreshold:  4,
+	MaxThreshold:  32,
+	BucketLow:     0.5,
+	BucketHigh:    1.5,
+	MinTableBytes: 1024 * 1024,
+	CheckInterval: 10 * time.Second,
+}
+
+// SizeTieredCompactor keeps all tables in level 0 and merges
+// tables of similar size into one larger table.
+//
+// Sequence numbers order versions of a key, table age only breaks ties
+// between versions of the same sequence number. Buckets are runs of tables
+// adjacent in age, so the merged table takes the place of its inputs.
+type SizeTieredCompactor struct {
+	sync.Mutex
+
+	fileTable *tables.LevelFileTable
+	options   SizeTieredOptions
+	schedule  chan struct{}
+}
+
+var _ Compactor = &SizeTieredCompactor{}
+
+func NewSizeTieredCompactor(fileTable *tables.LevelFileTable, options SizeTieredOptions) *SizeTieredCompactor {
+	if options.CheckInterval <= 0 {
+		options.CheckInterval = DefaultSizeTieredOptions.CheckInterval
+	}
+
+	if options.MinThreshold < 2 {
+		options.MinThreshold = 2
+	}
+
+	if options.MaxThreshold < options.MinThreshold {
+		options.MaxThreshold = options.MinThreshold
+	}
+
+	return &SizeTieredCompactor{
+		fileTable: fileTable,
+		options:   options,
+		schedule:  make(chan struct{}, 1),
+	}
+}
+
+func (c *SizeTieredCompactor) Compact(ctx context.Context) <-chan struct{} {
+	return runLoop(ctx, c.schedule, c.options.CheckInterval, c.CompactOnce, c.options.OnError)
+}
+
+func (c *SizeTieredCompactor) Schedule() {
+	select {
+	case c.schedule <- struct{}{}:
+	default:
+	}
+}
+
+type tableBucket struct {
+	// position of the oldest table in level 0
+	start int
+	refs  []*tables.SStableRef
+	size  int64
+}
+
+func (b *tableBucket) average() float64 {
+	return float64(b.size) / float64(len(b.refs))
+}
+
+func (c *SizeTieredCompactor) fits(bucket *tableBucket, size int64) bool {
+	if size < c.options.MinTableBytes && bucket.average() < float64(c.options.MinTableBytes) {
+		return true
+	}
+
+	average := bucket.average()
+	return float64(size) >= average*c.options.BucketLow && float64(size) <= average*c.options.BucketHigh
+}
+
+// buckets groups level 0 tables, oldest first, into runs of similar size.
+func (c *SizeTieredCompactor) buckets(refs []*tables.SStableRef) ([]*tableBucket, error) {
+	var (
+		buckets []*tableBucket
+		current *tableBucket
+	)
+
+	for i, ref := range refs {
+		size, err := ref.Size()
+		if err != nil {
+			return nil, err
+		}
+
+		if current == nil || !c.fits(current, size) {
+			current = &tableBucket{start: i}
+			buckets = append(buckets, current)
+		}
+
+		current.refs = append(current.refs, ref)
+		current.size += size
+	}
+
+	return buckets, nil
+}
+
+// pickBucket returns the bucket with most tables over the threshold,
+// preferring smaller tables, which are cheaper to merge.
+func (c *SizeTieredCompactor) pickBucket(buckets []*tableBucket) *tableBucket {
+	var best *tableBucket
+
+	for _, bucket := range buckets {
+		if len(bucket.refs) < c.options.MinThreshold {
+			continue
+		}
+
+		if best == nil ||
+			len(bucket.refs) > len(best.refs) ||
+			(len(bucket.refs) == len(best.refs) && bucket.average() < best.average()) {
+			best = bucket
+		}
+	}
+
+	if best != nil && len(best.refs) > c.options.MaxThreshold {
+		best.refs = best.refs[0:c.options.MaxThreshold]
+	}
+
+	return best
+}
+
+func (c *SizeTieredCompactor) CompactOnce() (compacted bool, err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	refs := c.fileTable.Tables(0)
+
+	buckets, err := c.buckets(refs)
+	if err != nil {
+		return false, err
+	}
+
+	bucket := c.pickBucket(buckets)
+	if bucket == nil {
+		return rewriteRangeDeleted(c.fileTable)
+	}
+
+	return true, c.compactBucket(refs, bucket)
+}
+
+func (c *SizeTieredCompactor) compactBucket(refs []*tables.SStableRef, bucket *tableBucket) error {
+	minKey, maxKey, err := keyRange(bucket.refs)
+	if err != nil {
+		return err
+	}
+
+	// tombstones are kept while an older table may hold the key
+	olderOverlaps, err := overlapping(refs[0:bucket.start], minKey, maxKey)
+	if err != nil {
+		return err
+	}
+
+	dropTombstones, err := noDeeperOverlap(c.fileTable, 0, minKey, maxKey)
+	if err != nil {
+		return err
+	}
+
+	dropTombstones = dropTombstones && len(olderOverlaps) == 0
+
+	inputs := make([]*tables.SStableRef, 0, len(bucket.refs))
+	for i := len(bucket.refs) - 1; i >= 0; i-- {
+		inputs = append(inputs, bucket.refs[i])
+	}
+
+	newest := inputs[0].Timestamp
+	newTable := func() *tables.SStableRef {
+		return c.fileTable.NewTableAt(0, newest)
+	}
+
+	var (
+		snapshots       = c.fileTable.Snapshots.Sequences()
+		rangeTombstones = c.fileTable.RangeTombstones()
+	)
+
+	outputs, err := mergeInto(inputs, newTable, sstable.MergeOptions{
+		DropTombstones:  dropTombstones,
+		Snapshots:       snapshots,
+		Now:             c.fileTable.Snapshots.ExpiryTime(c.fileTable.Clock.Now()),
+		RangeTombstones: rangeTombstones,
+		Filter:          c.fileTable.CompactionFilter,
+		MergeOperator:   c.fileTable.MergeOperator,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.fileTable.Install(tables.VersionEdit{
+		Added:                  tableEdits(0, outputs),
+		Removed:                tableEdits(0, bucket.refs),
+		AppliedRangeTombstones: appliedRangeTombstones(rangeTombstones, snapshots),
+	})
+}
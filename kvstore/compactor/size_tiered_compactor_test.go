@@ -0,0 +1,82 @@
+package compactor
+
+import (
+	"testing"
+
+	"github.com/zl14917/MastersProject/kvstore/tables"
+)
+
+func TestSizeTieredCompactor(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		fileTable := tables.NewSStableFileTable(dirPath, dirPath)
+
+		options := DefaultSizeTieredOptions
+		options.MinTableBytes = 0
+		compactor := NewSizeTieredCompactor(fileTable, options)
+
+		writeTable(t, fileTable, 0, 3000, "v0", 0)
+		writeTable(t, fileTable, 0, 50, "v1", 0)
+		writeTable(t, fileTable, 25, 75, "v2", 0)
+		writeTable(t, fileTable, 50, 100, "v3", 0)
+
+		compacted, err := compactor.CompactOnce()
+		if compacted || err != nil {
+			t.Errorf("three similar tables should not be merged: %v", err)
+		}
+
+		writeTable(t, fileTable, 75, 125, "v4", 5)
+
+		compacted, err = compactor.CompactOnce()
+		if !compacted || err != nil {
+			t.Errorf("four similar tables should be merged: %v", err)
+			return
+		}
+
+		refs := fileTable.Tables(0)
+		if len(refs) != 2 {
+			t.Errorf("expect the large table and one merged table, got %d tables", len(refs))
+			return
+		}
+
+		size, _ := refs[1].Size()
+		largeSize, _ := refs[0].Size()
+		if size >= largeSize {
+			t.Error("large table should stay oldest and not be merged")
+		}
+
+		for i := 0; i < 200; i++ {
+			switch {
+			case i >= 75 && i < 125 && i%5 == 0:
+				// the tombstone still shadows the older large table
+				expectAbsent(t, fileTable, i)
+			case i >= 75 && i < 125:
+				expectValue(t, fileTable, i, "v4")
+			case i >= 50 && i < 100:
+				expectValue(t, fileTable, i, "v3")
+			case i >= 25 && i < 75:
+				expectValue(t, fileTable, i, "v2")
+			case i < 25:
+				expectValue(t, fileTable, i, "v1")
+			default:
+				expectValue(t, fileTable, i, "v0")
+			}
+		}
+
+		// newer tables keep shadowing the merged one
+		writeTable(t, fileTable, 0, 10, "v5", 0)
+		expectValue(t, fileTable, 5, "v5")
+	})
+}
+
+func TestParseStrategy(t *testing.T) {
+	for _, strategy := range []Strategy{LeveledStrategy, SizeTieredStrategy} {
+		parsed, err := ParseStrategy(string(strategy))
+		if err != nil || parsed != strategy {
+			t.Errorf("strategy %s parsed as %s: %v", strategy, parsed, err)
+		}
+	}
+
+	if _, err := ParseStrategy("unknown"); err == nil {
+		t.Error("unknown strategy should fail to parse")
+	}
+}
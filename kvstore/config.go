@@ -1,17 +1,110 @@
 // Configuration values for KV Store
 package kvstore
 
+import (
+	"github.com/zl14917/MastersProject/kvstore/compactor"
+	"github.com/zl14917/MastersProject/kvstore/sstable"
+	"time"
+)
+
 type SSTableConfig struct {
 	IndexBlockSize int `yaml:"index-block-size"`
 	DataBlockSize  int `yaml:"data-block-size"`
+	// nil keeps the default, 0 disables bloom filters
+	BloomBitsPerKey *int `yaml:"bloom-bits-per-key"`
+	// data block codec of new tables, "none" or "flate"
+	Compression string `yaml:"compression"`
 }
 
 type LogStorageConfig struct {
-	SegmentSize string `yaml:"segment-size"`
+	SegmentSize         string        `yaml:"segment-size"`
+	GroupCommitMaxBatch string        `yaml:"group-commit-max-batch"`
+	GroupCommitMaxDelay time.Duration `yaml:"group-commit-max-delay"`
+	CompressArchived    bool          `yaml:"compress-archived"`
+}
+
+func (c *LogStorageConfig) SegmentSizeBytes() (int64, error) {
+	if c.SegmentSize == "" {
+		return int64(defaultKVStoreOptions.WALSegmentSizeBytes), nil
+	}
+
+	size, err := ParseSize(c.SegmentSize)
+	return int64(size), err
+}
+
+type CompactionConfig struct {
+	// "leveled" or "size-tiered", empty is leveled
+	Strategy string `yaml:"strategy"`
+	// size-tiered bucket thresholds, zero keeps the defaults
+	MinThreshold int `yaml:"min-threshold"`
+	MaxThreshold int `yaml:"max-threshold"`
 }
 
 type KVStoreConfig struct {
 	Log         LogStorageConfig `yaml:"wal-log"`
 	SSTable     SSTableConfig    `yaml:"sstable"`
+	Compaction  CompactionConfig `yaml:"compaction"`
 	DataDirPath string           `yaml:"data-dir"`
 }
+
+// OpenOptions turns configured values into options for NewCliftonDBKVStore,
+// values left empty keep their defaults.
+func (c *KVStoreConfig) OpenOptions() (KVStoreOpenOptions, error) {
+	segmentSize, err := c.Log.SegmentSizeBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var maxBatch uint64
+	if c.Log.GroupCommitMaxBatch != "" {
+		maxBatch, err = ParseSize(c.Log.GroupCommitMaxBatch)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	codec, err := sstable.ParseBlockCodec(c.SSTable.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	strategy, err := compactor.ParseStrategy(c.Compaction.Strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	return kvStoreOptionsFunc(func(options *KVStoreOptions) {
+		options.DataBlockCodec = codec
+		options.CompactionStrategy = strategy
+
+		if c.Compaction.MinThreshold > 0 {
+			options.SizeTieredCompaction.MinThreshold = c.Compaction.MinThreshold
+		}
+
+		if c.Compaction.MaxThreshold > 0 {
+			options.SizeTieredCompaction.MaxThreshold = c.Compaction.MaxThreshold
+		}
+		options.WALSegmentSizeBytes = int(segmentSize)
+		options.WALCompressArchived = c.Log.CompressArchived
+
+		if maxBatch > 0 {
+			options.WALGroupCommitMaxBatchBytes = int(maxBatch)
+		}
+
+		if c.Log.GroupCommitMaxDelay > 0 {
+			options.WALGroupCommitMaxDelay = c.Log.GroupCommitMaxDelay
+		}
+
+		if c.SSTable.DataBlockSize > 0 {
+			options.DataBlockSize = c.SSTable.DataBlockSize
+		}
+
+		if c.SSTable.IndexBlockSize > 0 {
+			options.IndexBlockSize = c.SSTable.IndexBlockSize
+		}
+
+		if c.SSTable.BloomBitsPerKey != nil {
+			options.BloomBitsPerKey = *c.SSTable.BloomBitsPerKey
+		}
+	}), nil
+}
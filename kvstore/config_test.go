@@ -0,0 +1,35 @@
+package kvstore
+
+import (
+	"testing"
+
+	"github.com/zl14917/MastersProject/kvstore/compactor"
+)
+
+func TestKVStoreConfig_CompactionStrategy(t *testing.T) {
+	config := KVStoreConfig{
+		Compaction: CompactionConfig{Strategy: "size-tiered", MinThreshold: 6},
+	}
+
+	openOptions, err := config.OpenOptions()
+	if err != nil {
+		t.Error("error converting config", err)
+		return
+	}
+
+	options := defaultKVStoreOptions
+	openOptions.Apply(&options)
+
+	if options.CompactionStrategy != compactor.SizeTieredStrategy {
+		t.Errorf("expect size-tiered strategy, got %s", options.CompactionStrategy)
+	}
+
+	if options.SizeTieredCompaction.MinThreshold != 6 {
+		t.Errorf("expect min threshold 6, got %d", options.SizeTieredCompaction.MinThreshold)
+	}
+
+	config.Compaction.Strategy = "unknown"
+	if _, err = config.OpenOptions(); err == nil {
+		t.Error("unknown strategy should fail")
+	}
+}
@@ -1,5 +1,13 @@
 package crc
 
+import (
+	"fmt"
+	"hash/crc32"
+)
+
+// CRC-32C (Castagnoli), the polynomial has hardware support on most platforms.
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
 type CRC interface {
 	Update(data []byte)
 	GetValue() uint32
@@ -16,9 +24,36 @@ func NewCRC() CRC {
 }
 
 func (c *CRCStruct) Update(data []byte) {
-
+	c.value = crc32.Update(c.value, castagnoliTable, data)
 }
 
 func (c *CRCStruct) GetValue() uint32 {
-	return 0
+	return c.value
+}
+
+func (c *CRCStruct) Reset() {
+	c.value = 0
+}
+
+// Checksum computes CRC-32C of data in one call.
+func Checksum(data []byte) uint32 {
+	return crc32.Checksum(data, castagnoliTable)
+}
+
+// CorruptionError reports stored data that does not match its checksum.
+// Block is -1 for files that are not block structured.
+type CorruptionError struct {
+	FilePath string
+	Block    int
+	Offset   int64
+
+	Stored   uint32
+	Computed uint32
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf(
+		"checksum mismatch in %s, block %d, offset %d: stored 0x%08x, computed 0x%08x",
+		e.FilePath, e.Block, e.Offset, e.Stored, e.Computed,
+	)
 }
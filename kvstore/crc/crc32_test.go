@@ -0,0 +1,18 @@
+package crc
+
+import "testing"
+
+func TestChecksum(t *testing.T) {
+	// check value of CRC-32C from the iSCSI specification
+	if value := Checksum([]byte("123456789")); value != 0xe3069283 {
+		t.Errorf("crc32c of '123456789' should be 0xe3069283, got 0x%08x", value)
+	}
+
+	c := NewCRC()
+	c.Update([]byte("1234"))
+	c.Update([]byte("56789"))
+
+	if c.GetValue() != 0xe3069283 {
+		t.Errorf("incremental update should equal one-shot checksum, got 0x%08x", c.GetValue())
+	}
+}
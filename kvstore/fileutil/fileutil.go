@@ -0,0 +1,85 @@
+package fileutil
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// CopyFile copies source to a new file at target and syncs it,
+// target must not exist.
+func CopyFile(source string, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+
+	if err == nil {
+		err = out.Sync()
+	}
+
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// SyncDir makes files created, renamed or removed in dirPath durable.
+func SyncDir(dirPath string) error {
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return err
+	}
+
+	err = dir.Sync()
+	closeErr := dir.Close()
+
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// ReplaceFile atomically replaces the content of filePath with data, a crash leaves
+// either the old or the new content. data is written and synced to a temporary file
+// renamed over filePath, then the directory is synced.
+func ReplaceFile(filePath string, data []byte) error {
+	tempPath := filePath + ".tmp"
+
+	temp, err := os.OpenFile(tempPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = temp.Write(data)
+
+	if err == nil {
+		err = temp.Sync()
+	}
+
+	closeErr := temp.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(tempPath, filePath)
+	}
+
+	if err != nil {
+		_ = os.Remove(tempPath)
+		return err
+	}
+
+	return SyncDir(filepath.Dir(filePath))
+}
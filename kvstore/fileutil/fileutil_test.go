@@ -0,0 +1,71 @@
+package fileutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestCopyFile(t *testing.T) {
+	dirPath, err := ioutil.TempDir("", "cliftondbtests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dirPath)
+
+	source := path.Join(dirPath, "source")
+	target := path.Join(dirPath, "target")
+
+	err = ioutil.WriteFile(source, []byte("content"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = CopyFile(source, target)
+	if err != nil {
+		t.Fatal("error copying file", err)
+	}
+
+	data, err := ioutil.ReadFile(target)
+	if err != nil || string(data) != "content" {
+		t.Errorf("expect copy to hold 'content', got '%s', %v", data, err)
+	}
+
+	err = CopyFile(source, target)
+	if !os.IsExist(err) {
+		t.Error("copying over an existing file should fail, got", err)
+	}
+
+	err = SyncDir(dirPath)
+	if err != nil {
+		t.Error("error syncing directory", err)
+	}
+}
+
+func TestReplaceFile(t *testing.T) {
+	dirPath, err := ioutil.TempDir("", "cliftondbtests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dirPath)
+
+	filePath := path.Join(dirPath, "file")
+
+	for _, content := range []string{"first version", "second"} {
+		err = ReplaceFile(filePath, []byte(content))
+		if err != nil {
+			t.Fatal("error replacing file", err)
+		}
+
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil || string(data) != content {
+			t.Errorf("expect file to hold '%s', got '%s', %v", content, data, err)
+		}
+	}
+
+	files, _ := ioutil.ReadDir(dirPath)
+	if len(files) != 1 {
+		t.Errorf("expect temporary file to be renamed, got %d files", len(files))
+	}
+}
@@ -0,0 +1,333 @@
+package kvstore
+
+import (
+	"errors"
+	"github.com/zl14917/MastersProject/kvstore/compactor"
+	"github.com/zl14917/MastersProject/kvstore/tables"
+	"go.uber.org/zap"
+	"sync/atomic"
+	"time"
+)
+
+var StoreClosedErr = errors.New("kv-store is closed")
+
+const flushRetryDelay = time.Second
+
+// WriteStallOptions hold writes back while flushing or level 0 compaction
+// falls behind, so that memory and read amplification stay bounded.
+// Level 0 limits apply to leveled compaction only, size-tiered compaction keeps
+// every table in level 0 and leaves tables of different sizes unmerged.
+type WriteStallOptions struct {
+	// writes wait while this many full memtables wait to be flushed
+	MaxImmutableMemTables int
+	// every write is delayed by SlowdownDelay once level 0 has this many tables
+	Level0SlowdownTables int
+	// writes wait while level 0 has this many tables, 0 never waits
+	Level0StopTables int
+	SlowdownDelay    time.Duration
+}
+
+var DefaultWriteStallOptions = WriteStallOptions{
+	MaxImmutableMemTables: 4,
+	Level0SlowdownTables:  8,
+	Level0StopTables:      12,
+	SlowdownDelay:         time.Millisecond,
+}
+
+type KVStoreStats struct {
+	// memtables written to level 0
+	Flushes uint64
+	// writes delayed because level 0 reached its slowdown threshold
+	WriteSlowdowns uint64
+	// writes that waited for a flush or a level 0 compaction
+	WriteStalls uint64
+	// decisions of the compaction filter
+	FilterKeeps    uint64
+	FilterDrops    uint64
+	FilterReplaces uint64
+}
+
+// immutableMemTable is a full memtable waiting to be flushed,
+// flushIndex is the index of the last wal record in it.
+type immutableMemTable struct {
+	tables.MemTable
+	flushIndex uint64
+}
+
+// memTableSet is replaced as a whole when memtables rotate or are flushed,
+// readers load it once and search a consistent set of memtables.
+type memTableSet struct {
+	active tables.MemTable
+	// oldest first
+	immutable []immutableMemTable
+}
+
+func (f *columnFamily) memTables() *memTableSet {
+	return f.memtables.Load().(*memTableSet)
+}
+
+// setMemTables publishes set and wakes writers waiting for a flush,
+// callers hold memtablesLock.
+func (f *columnFamily) setMemTables(set *memTableSet) {
+	f.memtables.Store(set)
+
+	if f.memtablesChanged != nil {
+		close(f.memtablesChanged)
+	}
+	f.memtablesChanged = make(chan struct{})
+}
+
+func (f *columnFamily) newMemTable() tables.MemTable {
+	return tables.NewSkipListMemTable(1000, 1000)
+}
+
+func (s *CliftonDBKVStore) Stats() KVStoreStats {
+	return KVStoreStats{
+		Flushes:        atomic.LoadUint64(&s.stats.Flushes),
+		WriteSlowdowns: atomic.LoadUint64(&s.stats.WriteSlowdowns),
+		WriteStalls:    atomic.LoadUint64(&s.stats.WriteStalls),
+		FilterKeeps:    atomic.LoadUint64(&s.stats.FilterKeeps),
+		FilterDrops:    atomic.LoadUint64(&s.stats.FilterDrops),
+		FilterReplaces: atomic.LoadUint64(&s.stats.FilterReplaces),
+	}
+}
+
+// beginWrite holds off rotation of memtables until endWrite, so every record
+// logged before a rotation is in the memtable that is flushed. Writes apply
+// their records to the active memtables of families in between.
+func (s *CliftonDBKVStore) beginWrite(families ...*columnFamily) error {
+	for _, family := range families {
+		err := s.throttleWrites(family)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.writeLock.RLock()
+	return nil
+}
+
+func (s *CliftonDBKVStore) endWrite(families ...*columnFamily) {
+	s.writeLock.RUnlock()
+
+	for _, family := range families {
+		if family.memTables().active.SizeBytes() >= family.options.MemTableSizeBytes {
+			s.rotateMemTable(family, false)
+		}
+	}
+}
+
+// rotateMemTable queues the active memtable of family for flushing once it is full,
+// or whenever it holds data if force is set.
+func (s *CliftonDBKVStore) rotateMemTable(family *columnFamily, force bool) {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	family.memtablesLock.Lock()
+	defer family.memtablesLock.Unlock()
+
+	set := family.memTables()
+	size := set.active.SizeBytes()
+
+	// a concurrent write rotated it already
+	if size == 0 || (!force && size < family.options.MemTableSizeBytes) {
+		return
+	}
+
+	immutable := make([]immutableMemTable, len(set.immutable), len(set.immutable)+1)
+	copy(immutable, set.immutable)
+
+	family.setMemTables(&memTableSet{
+		active: family.newMemTable(),
+		immutable: append(immutable, immutableMemTable{
+			MemTable:   set.active,
+			flushIndex: s.wal.Index - 1,
+		}),
+	})
+
+	s.requestFlush()
+}
+
+func (s *CliftonDBKVStore) requestFlush() {
+	select {
+	case s.flushRequests <- struct{}{}:
+	default:
+	}
+}
+
+func (s *CliftonDBKVStore) startFlushing() {
+	stopped := make(chan struct{})
+	s.flushRequests = make(chan struct{}, 1)
+	s.flushStopped = stopped
+
+	go func() {
+		defer close(stopped)
+
+		for {
+			select {
+			case <-s.backgroundCtx.Done():
+				return
+			case <-s.flushRequests:
+			}
+
+			err := s.flushImmutable()
+
+			if err != nil {
+				s.logger.Error("flushing memtable failed", zap.Error(err))
+				time.AfterFunc(flushRetryDelay, s.requestFlush)
+			}
+		}
+	}()
+}
+
+// flushImmutable writes queued memtables of every column family to level 0.
+func (s *CliftonDBKVStore) flushImmutable() error {
+	s.flushLock.Lock()
+	defer s.flushLock.Unlock()
+
+	for _, family := range s.families {
+		err := s.flushColumnFamily(family)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flushColumnFamily writes queued memtables of family to level 0, oldest first.
+// A memtable is read until its table is installed, then the wal records every
+// family holds in sstables are dropped.
+func (s *CliftonDBKVStore) flushColumnFamily(family *columnFamily) error {
+	for {
+		set := family.memTables()
+		if len(set.immutable) == 0 {
+			return nil
+		}
+
+		oldest := set.immutable[0]
+		s.logger.Info("flushing memtable",
+			zap.String("column-family", family.name),
+			zap.Int64("size-bytes", oldest.SizeBytes()),
+			zap.Uint64("flush-index", oldest.flushIndex),
+		)
+
+		err := family.fileTable.Flush(oldest.MemTable, oldest.flushIndex)
+		if err != nil {
+			return err
+		}
+
+		family.memtablesLock.Lock()
+		set = family.memTables()
+		family.setMemTables(&memTableSet{
+			active:    set.active,
+			immutable: append([]immutableMemTable(nil), set.immutable[1:]...),
+		})
+		family.memtablesLock.Unlock()
+
+		atomic.AddUint64(&s.stats.Flushes, 1)
+		family.scheduleCompaction(0)
+
+		err = s.truncateWAL(s.flushedIndex(oldest.flushIndex))
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// flushedIndex lowers flushIndex, the last record a family just flushed, to the last
+// record every family holds in sstables. All records of a family with empty memtables
+// are in its sstables, the records of the others are after their flush index.
+func (s *CliftonDBKVStore) flushedIndex(flushIndex uint64) uint64 {
+	for _, family := range s.families {
+		set := family.memTables()
+		if len(set.immutable) == 0 && set.active.SizeBytes() == 0 {
+			continue
+		}
+
+		if index := family.fileTable.FlushIndex(); index < flushIndex {
+			flushIndex = index
+		}
+	}
+	return flushIndex
+}
+
+// truncateWAL records that records up to flushIndex are in sstables,
+// and removes the wal segments holding only those records.
+func (s *CliftonDBKVStore) truncateWAL(flushIndex uint64) error {
+	// a family that had no records to flush before may be behind the others
+	if flushIndex <= s.lockFileData.WALFlushIndex {
+		return nil
+	}
+
+	s.lockFileData.WALFlushIndex = flushIndex
+
+	err := s.WriteLockFile(s.lockFileData)
+	if err != nil {
+		return err
+	}
+
+	removed, err := s.wal.RemoveSegmentsBefore(flushIndex + 1)
+
+	if removed > 0 {
+		s.logger.Info("removed flushed wal segments", zap.Int("segments", removed))
+	}
+
+	return err
+}
+
+// Flush writes the memtables of every column family and any memtables waiting
+// to be flushed to level 0, and returns once they are installed.
+func (s *CliftonDBKVStore) Flush() error {
+	for _, family := range s.families {
+		s.rotateMemTable(family, true)
+	}
+	return s.flushImmutable()
+}
+
+// throttleWrites holds a write to family back while too many of its memtables wait
+// to be flushed or its level 0 is full, and delays it once when level 0 is about to be.
+func (s *CliftonDBKVStore) throttleWrites(family *columnFamily) error {
+	var (
+		options    = family.options.WriteStalls
+		stalled    = false
+		slowedDown = false
+	)
+
+	for {
+		family.memtablesLock.Lock()
+		set, memTablesChanged := family.memTables(), family.memtablesChanged
+		family.memtablesLock.Unlock()
+
+		tablesChanged := family.fileTable.Changed()
+		level0 := 0
+		if family.options.CompactionStrategy != compactor.SizeTieredStrategy {
+			level0 = len(family.fileTable.Tables(0))
+		}
+
+		if (options.MaxImmutableMemTables > 0 && len(set.immutable) >= options.MaxImmutableMemTables) ||
+			(options.Level0StopTables > 0 && level0 >= options.Level0StopTables) {
+
+			if !stalled {
+				stalled = true
+				atomic.AddUint64(&s.stats.WriteStalls, 1)
+			}
+
+			select {
+			case <-memTablesChanged:
+			case <-tablesChanged:
+			case <-s.backgroundCtx.Done():
+				return StoreClosedErr
+			}
+			continue
+		}
+
+		if !slowedDown && options.Level0SlowdownTables > 0 && level0 >= options.Level0SlowdownTables {
+			slowedDown = true
+			atomic.AddUint64(&s.stats.WriteSlowdowns, 1)
+			time.Sleep(options.SlowdownDelay)
+			continue
+		}
+
+		return nil
+	}
+}
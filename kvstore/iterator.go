@@ -0,0 +1,82 @@
+package kvstore
+
+import (
+	"github.com/zl14917/MastersProject/kvstore/tables"
+	"github.com/zl14917/MastersProject/kvstore/types"
+)
+
+// Iterator returns live keys of a scan in order, deleted keys are skipped.
+// Next and Prev move it forward or backward, Seek positions Next at the
+// first key >= key and SeekForPrev positions Prev at the last key <= key.
+// It pins the tables it reads, callers must Close it.
+type Iterator struct {
+	scanner tables.Scanner
+	// reads a key at the sequence number of the scan, merge operands are folded by it
+	get func(key types.KeyType) (types.ValueType, bool, error)
+
+	key   types.KeyType
+	value types.ValueType
+	err   error
+}
+
+func (i *Iterator) Next() bool {
+	return i.move(i.scanner.Next)
+}
+
+func (i *Iterator) Prev() bool {
+	return i.move(i.scanner.Prev)
+}
+
+func (i *Iterator) move(step func() bool) bool {
+	for i.err == nil && step() {
+		key, value, deleted := i.scanner.Current()
+		if deleted {
+			continue
+		}
+
+		if i.scanner.MergeOperand() {
+			var ok bool
+
+			value, ok, i.err = i.get(key)
+			if !ok {
+				continue
+			}
+		}
+
+		i.key, i.value = key, value
+		return true
+	}
+
+	i.key, i.value = nil, nil
+	return false
+}
+
+// Seek with a nil key goes back to the start of the scanned range.
+func (i *Iterator) Seek(key types.KeyType) {
+	i.scanner.Seek(key)
+}
+
+// SeekForPrev with a nil key goes to the end of the scanned range.
+func (i *Iterator) SeekForPrev(key types.KeyType) {
+	i.scanner.SeekForPrev(key)
+}
+
+func (i *Iterator) Key() types.KeyType {
+	return i.key
+}
+
+func (i *Iterator) Value() types.ValueType {
+	return i.value
+}
+
+// Err is the error that stopped the iteration, if any.
+func (i *Iterator) Err() error {
+	if i.err != nil {
+		return i.err
+	}
+	return i.scanner.Err()
+}
+
+func (i *Iterator) Close() error {
+	return i.scanner.Close()
+}
@@ -1,45 +1,61 @@
 package kvstore
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"github.com/zl14917/MastersProject/kvstore/compactor"
+	"github.com/zl14917/MastersProject/kvstore/fileutil"
+	"github.com/zl14917/MastersProject/kvstore/sstable"
 	"github.com/zl14917/MastersProject/kvstore/tables"
 	"github.com/zl14917/MastersProject/kvstore/types"
 	"github.com/zl14917/MastersProject/kvstore/wal"
 	"go.uber.org/zap"
 	"gopkg.in/yaml.v2"
+	"io"
 	"os"
 	"path"
+	"sync"
 	"sync/atomic"
 	"time"
-	"unsafe"
 )
 
 const (
-	sstablePath  = "sstables/"
-	walPath      = "wal/"
-	logFileName  = "kvstore-%d.log"
-	logPrefix    = "[kvstore-%d]"
-	lockFileName = "store.lock.file"
+	sstablePath        = "sstables/"
+	columnFamiliesPath = "column-families/"
+	walPath            = "wal/"
+	logFileName        = "kvstore-%d.log"
+	logPrefix          = "[kvstore-%d]"
+	lockFileName       = "store.lock.file"
 )
 
 type KVStoreLockFileData struct {
 	PartitionId    uint32 `yaml:"partition-id"`
 	WALCommitIndex uint64 `yaml:"wal-commit-index"`
 	WALApplyIndex  uint64 `yaml:"wal-apply-index"`
+	// records up to and including this index are persisted in sstables,
+	// only records after it are replayed into the memtable on recovery.
+	WALFlushIndex uint64 `yaml:"wal-flush-index"`
+	// ids of the column families other than the default one, by name
+	ColumnFamilies map[string]uint32 `yaml:"column-families,omitempty"`
 }
 
 var defaultKVStoreLockFileData = KVStoreLockFileData{
 	PartitionId:    0,
 	WALCommitIndex: 0,
 	WALApplyIndex:  0,
+	WALFlushIndex:  0,
 }
 
+var InvalidRangeErr = errors.New("range start must be before its end")
+
 type KVStore interface {
 	Get(keyType types.KeyType) (data types.ValueType, ok bool, err error)
-	Put(key types.KeyType, data types.ValueType) (err error)
+	Put(key types.KeyType, data types.ValueType, options ...PutOption) (err error)
 	Remove(key types.KeyType) (ok bool, err error)
 	Exists(key types.KeyType) (ok bool, err error)
+	Write(batch *WriteBatch) error
 }
 
 type KVStoreOpenOptions interface {
@@ -50,12 +66,201 @@ type KVStoreOptions struct {
 	WALSegmentSizeBytes int
 	DataBlockSize       int
 	IndexBlockSize      int
+	BloomBitsPerKey     int
+	DataBlockCodec      sstable.BlockCodec
+	// bytes of sstable blocks cached when BlockCache is nil, 0 disables caching
+	BlockCacheSize int
+	// cache shared with other stores
+	BlockCache *sstable.BlockCache
+	// the memtable is flushed once it holds this many bytes
+	MemTableSizeBytes int64
+	WriteStalls       WriteStallOptions
+
+	WALGroupCommitMaxBatchBytes int
+	WALGroupCommitMaxDelay      time.Duration
+	WALCompressArchived         bool
+
+	CompactionStrategy   compactor.Strategy
+	LeveledCompaction    compactor.LeveledOptions
+	SizeTieredCompaction compactor.SizeTieredOptions
+	// applied to live records by every compaction, nil keeps them all
+	CompactionFilter sstable.CompactionFilter
+	// folds operands written by Merge, a store without one rejects merges
+	MergeOperator sstable.MergeOperator
+	// options of the column families other than the default one, by name
+	ColumnFamilies map[string][]KVStoreOpenOptions
 }
 
 var defaultKVStoreOptions = KVStoreOptions{
 	WALSegmentSizeBytes: 1024 * 1024 * 16,
 	DataBlockSize:       1024 * 16,
 	IndexBlockSize:      1024 * 4,
+	BloomBitsPerKey:     sstable.DefaultBloomBitsPerKey,
+	BlockCacheSize:      1024 * 1024 * 8,
+	MemTableSizeBytes:   1024 * 1024 * 4,
+	WriteStalls:         DefaultWriteStallOptions,
+
+	WALGroupCommitMaxBatchBytes: wal.DefaultGroupCommitOptions.MaxBatchBytes,
+	WALGroupCommitMaxDelay:      wal.DefaultGroupCommitOptions.MaxBatchDelay,
+
+	CompactionStrategy:   compactor.LeveledStrategy,
+	LeveledCompaction:    compactor.DefaultLeveledOptions,
+	SizeTieredCompaction: compactor.DefaultSizeTieredOptions,
+}
+
+type kvStoreOptionsFunc func(options *KVStoreOptions)
+
+func (f kvStoreOptionsFunc) Apply(options *KVStoreOptions) {
+	f(options)
+}
+
+func WithWALSegmentSize(size int) KVStoreOpenOptions {
+	return kvStoreOptionsFunc(func(options *KVStoreOptions) {
+		options.WALSegmentSizeBytes = size
+	})
+}
+
+// SSTables are written with a bloom filter of bitsPerKey bits per key,
+// 0 disables filters.
+func WithBloomFilter(bitsPerKey int) KVStoreOpenOptions {
+	return kvStoreOptionsFunc(func(options *KVStoreOptions) {
+		options.BloomBitsPerKey = bitsPerKey
+	})
+}
+
+// Data blocks of new SSTables hold about size bytes, larger blocks
+// suit large values read whole.
+func WithDataBlockSize(size int) KVStoreOpenOptions {
+	return kvStoreOptionsFunc(func(options *KVStoreOptions) {
+		options.DataBlockSize = size
+	})
+}
+
+// Data blocks of new SSTables are compressed with codec.
+func WithDataBlockCodec(codec sstable.BlockCodec) KVStoreOpenOptions {
+	return kvStoreOptionsFunc(func(options *KVStoreOptions) {
+		options.DataBlockCodec = codec
+	})
+}
+
+// Blocks read from sstables are cached in a cache of capacity bytes
+// owned by the store, 0 disables caching.
+func WithBlockCacheSize(capacity int) KVStoreOpenOptions {
+	return kvStoreOptionsFunc(func(options *KVStoreOptions) {
+		options.BlockCacheSize = capacity
+		options.BlockCache = nil
+	})
+}
+
+// Blocks read from sstables are cached in cache, which can be shared
+// by all stores of a server to bound their memory together.
+func WithBlockCache(cache *sstable.BlockCache) KVStoreOpenOptions {
+	return kvStoreOptionsFunc(func(options *KVStoreOptions) {
+		options.BlockCache = cache
+	})
+}
+
+// The memtable is queued for flushing to level 0 once it holds size bytes.
+func WithMemTableSize(size int64) KVStoreOpenOptions {
+	return kvStoreOptionsFunc(func(options *KVStoreOptions) {
+		options.MemTableSizeBytes = size
+	})
+}
+
+func WithWriteStalls(stalls WriteStallOptions) KVStoreOpenOptions {
+	return kvStoreOptionsFunc(func(options *KVStoreOptions) {
+		options.WriteStalls = stalls
+	})
+}
+
+func WithLeveledCompaction(options compactor.LeveledOptions) KVStoreOpenOptions {
+	return kvStoreOptionsFunc(func(kvOptions *KVStoreOptions) {
+		kvOptions.CompactionStrategy = compactor.LeveledStrategy
+		kvOptions.LeveledCompaction = options
+	})
+}
+
+// Size-tiered compaction suits append-mostly data,
+// it rewrites data less often than leveled compaction.
+func WithSizeTieredCompaction(options compactor.SizeTieredOptions) KVStoreOpenOptions {
+	return kvStoreOptionsFunc(func(kvOptions *KVStoreOptions) {
+		kvOptions.CompactionStrategy = compactor.SizeTieredStrategy
+		kvOptions.SizeTieredCompaction = options
+	})
+}
+
+// Compactions keep, drop or rewrite records as filter decides, for example
+// to purge a retired key prefix without deleting its keys one by one.
+// Records are only filtered once no snapshot reads them.
+func WithCompactionFilter(filter sstable.CompactionFilter) KVStoreOpenOptions {
+	return kvStoreOptionsFunc(func(options *KVStoreOptions) {
+		options.CompactionFilter = filter
+	})
+}
+
+// Merge writes operands that operator folds into the value of their key, on reads
+// and in compactions. A store must be reopened with the same operator.
+func WithMergeOperator(operator sstable.MergeOperator) KVStoreOpenOptions {
+	return kvStoreOptionsFunc(func(options *KVStoreOptions) {
+		options.MergeOperator = operator
+	})
+}
+
+// Rolled over wal segments are kept compressed.
+func WithWALCompression() KVStoreOpenOptions {
+	return kvStoreOptionsFunc(func(options *KVStoreOptions) {
+		options.WALCompressArchived = true
+	})
+}
+
+// Concurrent writes are batched into one wal write and fsync,
+// until maxBatchBytes are queued or the oldest write waited maxDelay.
+func WithWALGroupCommit(maxBatchBytes int, maxDelay time.Duration) KVStoreOpenOptions {
+	return kvStoreOptionsFunc(func(options *KVStoreOptions) {
+		options.WALGroupCommitMaxBatchBytes = maxBatchBytes
+		options.WALGroupCommitMaxDelay = maxDelay
+	})
+}
+
+// PutOptions give a put an expiry time. Expiry is decided against the timestamps
+// of writes in the log, a key expires once a write at or after its expiry time
+// is applied, so that replicas applying the same log agree on it.
+type PutOptions struct {
+	// unix nanoseconds the put is logged at, the current time when 0
+	Timestamp int64
+	// unix nanoseconds the key expires at, 0 never expires
+	ExpiresAt int64
+	// expires the key this long after Timestamp, unless ExpiresAt is set
+	TTL time.Duration
+}
+
+type PutOption interface {
+	Apply(options *PutOptions)
+}
+
+type putOptionsFunc func(options *PutOptions)
+
+func (f putOptionsFunc) Apply(options *PutOptions) {
+	f(options)
+}
+
+func WithTTL(ttl time.Duration) PutOption {
+	return putOptionsFunc(func(options *PutOptions) {
+		options.TTL = ttl
+	})
+}
+
+func WithExpiry(expiresAt time.Time) PutOption {
+	return putOptionsFunc(func(options *PutOptions) {
+		options.ExpiresAt = expiresAt.UnixNano()
+	})
+}
+
+// Replicated stores log every put with the timestamp of the leader's log entry.
+func WithTimestamp(timestamp time.Time) PutOption {
+	return putOptionsFunc(func(options *PutOptions) {
+		options.Timestamp = timestamp.UnixNano()
+	})
 }
 
 type KVStoreMetadata struct {
@@ -66,13 +271,25 @@ type KVStoreMetadata struct {
 }
 
 type CliftonDBKVStore struct {
-	fileTable tables.FileTable
-	memtable  tables.MemTable
-	wal       *wal.WAL
-
-	logger        *zap.Logger
-	backgroundCtx context.Context
-	prevMemtable  tables.MemTable
+	// the default column family, read and written by the methods of the store
+	*columnFamily
+	// the default family first
+	families []*columnFamily
+	wal      *wal.WAL
+
+	// writes hold it shared, rotating a memtable exclusively
+	writeLock     sync.RWMutex
+	flushLock     sync.Mutex
+	flushRequests chan struct{}
+	flushStopped  <-chan struct{}
+
+	logger         *zap.Logger
+	backgroundCtx  context.Context
+	stopBackground context.CancelFunc
+	visible        *visibleSequence
+	lockFileData   KVStoreLockFileData
+	options        KVStoreOptions
+	stats          KVStoreStats
 
 	KVStoreRoot         string
 	SSTablesRoot        string
@@ -80,35 +297,99 @@ type CliftonDBKVStore struct {
 	KVStoreLockFilePath string
 }
 
+// Metadata lists the index files of live tables of the default column family per level.
+func (s *CliftonDBKVStore) Metadata() KVStoreMetadata {
+	return s.metadata()
+}
+
+func (f *columnFamily) metadata() KVStoreMetadata {
+	names := func(level int) []string {
+		var files []string
+		for _, table := range f.fileTable.Tables(level) {
+			files = append(files, path.Base(table.IndexFilePath))
+		}
+		return files
+	}
+
+	return KVStoreMetadata{
+		SStableLevel0: names(0),
+		SStableLevel1: names(1),
+		SStableLevel2: names(2),
+	}
+}
+
+// BlockCacheStats reports hits and misses of the block cache,
+// counting reads of every store sharing it.
+func (s *CliftonDBKVStore) BlockCacheStats() sstable.BlockCacheStats {
+	if s.fileTable.Options.BlockCache == nil {
+		return sstable.BlockCacheStats{}
+	}
+	return s.fileTable.Options.BlockCache.Stats()
+}
+
+// Remove is Delete.
 func (s *CliftonDBKVStore) Remove(key types.KeyType) (ok bool, err error) {
-	panic("implement me")
+	return s.Delete(key)
 }
 
-func NewCliftonDBKVStore(dirPath string, logPath string) (*CliftonDBKVStore, error) {
-	var err error
+func NewCliftonDBKVStore(dirPath string, logPath string, openOptions ...KVStoreOpenOptions) (*CliftonDBKVStore, error) {
+	var (
+		err     error
+		options = defaultKVStoreOptions
+	)
+
+	for _, opt := range openOptions {
+		opt.Apply(&options)
+	}
 
 	walRootPath := path.Join(dirPath, walPath)
+	walOptions := []wal.WALOptions{
+		wal.WithSegmentSize(int64(options.WALSegmentSizeBytes)),
+		wal.WithGroupCommit(wal.GroupCommitOptions{
+			MaxBatchBytes: options.WALGroupCommitMaxBatchBytes,
+			MaxBatchDelay: options.WALGroupCommitMaxDelay,
+		}),
+	}
+
+	if options.WALCompressArchived {
+		walOptions = append(walOptions, wal.WithCompressedArchive())
+	}
 
 	store := &CliftonDBKVStore{
-		fileTable:    nil,
-		memtable:     tables.NewMapMemTable(1000, 1000),
-		wal:          wal.NewWAL(walRootPath),
+		wal:          wal.NewWAL(walRootPath, walOptions...),
+		options:      options,
 		KVStoreRoot:  dirPath,
 		SSTablesRoot: path.Join(dirPath, sstablePath),
 
 		WALRoot:             walRootPath,
-		KVStoreLockFilePath: path.Join(dirPath, walRootPath, lockFileName),
+		KVStoreLockFilePath: path.Join(dirPath, lockFileName),
 
 		logger: nil,
 	}
 
+	store.columnFamily = store.newColumnFamily(DefaultColumnFamily, 0, options)
+	store.families = []*columnFamily{store.columnFamily}
+
 	data, err := store.ReadLockFile()
 
-	storeLogFilePath := path.Join(logPath, fmt.Sprintf(logFileName, data.PartitionId))
+	if os.IsNotExist(err) {
+		data, err = defaultKVStoreLockFileData, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// families share the block cache unless their options give them their own
+	options.BlockCache = store.fileTable.Options.BlockCache
+	err = store.addColumnFamilies(&data, options)
 
 	if err != nil {
 		return nil, err
 	}
+
+	storeLogFilePath := path.Join(logPath, fmt.Sprintf(logFileName, data.PartitionId))
+
 	err = store.EnsureDirsExist()
 
 	if err != nil {
@@ -117,22 +398,54 @@ func NewCliftonDBKVStore(dirPath string, logPath string) (*CliftonDBKVStore, err
 
 	config := zap.NewDevelopmentConfig()
 	config.OutputPaths = []string{storeLogFilePath}
-	store.logger, _ = config.Build()
+	store.logger, err = config.Build()
 
 	if err != nil {
 		store.logger = zap.NewExample()
 	}
+
+	flushIndex := types.MaxSequenceNumber
+
+	for _, family := range store.families {
+		err = family.fileTable.Open()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if index := family.fileTable.FlushIndex(); index < flushIndex {
+			flushIndex = index
+		}
+
+		family.setMemTables(&memTableSet{active: family.newMemTable()})
+	}
+
+	// tables of every family may have been flushed after the lock file was written
+	if flushIndex > data.WALFlushIndex {
+		data.WALFlushIndex = flushIndex
+	}
+
+	store.lockFileData = data
 	err = store.walCheckForRecovery()
 
 	if err != nil {
 		return nil, err
 	}
-	err = store.WriteLockFile(data)
+	err = store.WriteLockFile(store.lockFileData)
 
 	if err != nil {
 		return nil, err
 	}
 
+	store.visible = newVisibleSequence(store.wal.Index - 1)
+	store.startCompaction()
+	store.startFlushing()
+
+	// the replayed wal may have filled the memtables
+	for _, family := range store.families {
+		store.rotateMemTable(family, false)
+	}
+
 	return store, nil
 }
 
@@ -147,30 +460,27 @@ func (s *CliftonDBKVStore) ReadLockFile() (data KVStoreLockFileData, err error)
 
 	err = yaml.NewDecoder(file).Decode(&data)
 	if err != nil {
-		return defaultKVStoreLockFileData, err
+		return defaultKVStoreLockFileData, fmt.Errorf("error reading kv-store lock file %s: %v", s.KVStoreLockFilePath, err)
 	}
 	return
 }
 
+// WriteLockFile replaces the lock file atomically, a crash while writing it
+// leaves the previous content.
 func (s *CliftonDBKVStore) WriteLockFile(data KVStoreLockFileData) error {
-	file, err := os.OpenFile(s.KVStoreLockFilePath, os.O_CREATE|os.O_WRONLY, 0644)
-
+	content, err := yaml.Marshal(data)
 	if err != nil {
-		s.logger.Error(
-			"error openinng kv-store lock file",
-			zap.String("file-path", s.KVStoreLockFilePath),
-		)
 		return err
 	}
 
-	err = yaml.NewEncoder(file).Encode(data)
-	if err != nil {
-		_ = file.Close()
-		return err
-	}
+	err = fileutil.ReplaceFile(s.KVStoreLockFilePath, content)
 
-	err = file.Close()
 	if err != nil {
+		s.logger.Error(
+			"error writing kv-store lock file",
+			zap.String("file-path", s.KVStoreLockFilePath),
+			zap.Error(err),
+		)
 		return err
 	}
 
@@ -188,6 +498,13 @@ func (s *CliftonDBKVStore) EnsureDirsExist() error {
 		return err
 	}
 
+	for _, family := range s.families {
+		err = os.MkdirAll(family.fileTable.TableRootDir, os.ModePerm)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -195,59 +512,491 @@ func BootstrapFromDir(dirPath string) *KVStore {
 	return nil
 }
 
+// walCheckForRecovery looks for segments left by a previous run and
+// replays them on top of the flushed sstables.
 func (s *CliftonDBKVStore) walCheckForRecovery() error {
+	err := s.wal.LoadSegments()
+
+	if err != nil {
+		return err
+	}
+
+	nextIndex := s.lockFileData.WALFlushIndex + 1
+
+	if len(s.wal.Segments) > 0 {
+		s.logger.Info("recovering from wal",
+			zap.Int("segments", len(s.wal.Segments)),
+			zap.Uint64("flush-index", s.lockFileData.WALFlushIndex),
+		)
+
+		err = s.rebuildMemTableFromWAL()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.wal.Index < nextIndex {
+		s.wal.Index = nextIndex
+	}
+
 	return nil
 }
 
+// rebuildMemTableFromWAL replays records after the flush index into fresh memtables
+// of every column family.
 func (s *CliftonDBKVStore) rebuildMemTableFromWAL() error {
+	var (
+		replay = &walReplay{
+			memtables:      make(map[uint32]tables.MemTable, len(s.families)),
+			flushIndexes:   make(map[uint32]uint64, len(s.families)),
+			mergeOperators: make(map[uint32]sstable.MergeOperator, len(s.families)),
+			clock:          &s.fileTable.Clock,
+		}
+		record   = &wal.WALRecord{}
+		replayed = 0
+	)
+
+	for _, family := range s.families {
+		replay.memtables[family.id] = family.newMemTable()
+		replay.flushIndexes[family.id] = family.fileTable.FlushIndex()
+		replay.mergeOperators[family.id] = family.options.MergeOperator
+	}
+
+	reader := s.wal.NewReader()
+	defer reader.Close()
+
+	err := reader.SetIndex(s.lockFileData.WALFlushIndex + 1)
+
+	if err != nil {
+		return err
+	}
+
+	for {
+		err = reader.ReadNext(record)
+		if err != nil {
+			break
+		}
+
+		err = replay.apply(record)
+		if err != nil {
+			break
+		}
+		replayed++
+	}
+
+	if err != io.EOF {
+		return fmt.Errorf("error replaying wal at index %d: %v", reader.ReadIndex(), err)
+	}
+
+	s.logger.Info("replayed wal records", zap.Int("records", replayed), zap.Int("next-index", reader.ReadIndex()))
 
+	for _, family := range s.families {
+		family.memtablesLock.Lock()
+		family.setMemTables(&memTableSet{active: replay.memtables[family.id]})
+		family.memtablesLock.Unlock()
+	}
 	return nil
 }
 
-func (s *CliftonDBKVStore) flushMemTable() error {
-	s.logger.Info("starting to flush memtable")
+// walReplay applies wal records to the memtables of the column families,
+// a family skips records up to the last one its sstables hold.
+type walReplay struct {
+	memtables      map[uint32]tables.MemTable
+	flushIndexes   map[uint32]uint64
+	mergeOperators map[uint32]sstable.MergeOperator
+	clock          *tables.ExpiryClock
+}
+
+// memtable is nil if the family flushed the record at index already.
+func (r *walReplay) memtable(columnFamily uint32, index uint64) (tables.MemTable, error) {
+	memtable, ok := r.memtables[columnFamily]
+	if !ok {
+		return nil, fmt.Errorf("unknown column family %d at index %d", columnFamily, index)
+	}
 
-	newMemTable := tables.NewMapMemTable(4000, 4000)
-	s.prevMemtable = s.memtable
+	if index <= r.flushIndexes[columnFamily] {
+		return nil, nil
+	}
+	return memtable, nil
+}
 
-	for !atomic.CompareAndSwapPointer(
-		(*unsafe.Pointer)(unsafe.Pointer(&s.memtable)),
-		unsafe.Pointer(&s.memtable),
-		unsafe.Pointer(&newMemTable),
-	) {
+func (r *walReplay) apply(record *wal.WALRecord) error {
+	if record.EventType == wal.WriteBatch || record.EventType == wal.ColumnFamilyBatch {
+		batch, err := record.BatchPayload()
+		if err != nil {
+			return err
+		}
+
+		entries, err := memTableEntries(batch, true)
+		if err != nil {
+			return fmt.Errorf("error replaying batch at index %d: %v", record.Index, err)
+		}
+
+		for columnFamily, familyEntries := range entries {
+			memtable, err := r.memtable(columnFamily, record.Index)
+			if err != nil {
+				return err
+			}
+
+			if memtable == nil {
+				continue
+			}
+
+			familyEntries, err = foldBatchEntries(familyEntries, r.mergeOperators[columnFamily])
+			if err != nil {
+				return fmt.Errorf("error replaying batch at index %d: %v", record.Index, err)
+			}
+
+			err = memtable.ApplyBatch(familyEntries, record.Index)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 
+	memtable, err := r.memtable(0, record.Index)
+	if err != nil {
+		return err
 	}
 
-	time.Sleep(100 * time.Millisecond)
+	if record.EventType == wal.PutKeyWithExpiry {
+		key, value, timestamp, expiresAt, err := record.ExpiringPayload()
+		if err != nil {
+			return err
+		}
+
+		if memtable != nil {
+			err = memtable.PutWithExpiry(copyBytes(key), copyBytes(value), record.Index, expiresAt)
+		}
+		r.clock.Advance(timestamp)
+		return err
+	}
 
-	s.fileTable.BeginFlushing(s.prevMemtable, nil)
+	key, value, err := record.Payload()
 
-	return nil
+	if err != nil || memtable == nil {
+		return err
+	}
+
+	switch record.EventType {
+	case wal.PutKey:
+		return memtable.Put(copyBytes(key), copyBytes(value), record.Index)
+	case wal.DeleteKey:
+		_, err = memtable.Remove(key, record.Index)
+		return err
+	case wal.DeleteRange:
+		return memtable.DeleteRange(key, value, record.Index)
+	case wal.MergeKey:
+		return memtable.Merge(copyBytes(key), copyBytes(value), record.Index)
+	default:
+		return fmt.Errorf("unknown wal event type %d at index %d", record.EventType, record.Index)
+	}
 }
 
-func (s *CliftonDBKVStore) scheduleCompaction(deadline time.Duration) {
+func copyBytes(b []byte) []byte {
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
+
+// appendToWAL returns the index of the record, it is the sequence number of the write.
+func (s *CliftonDBKVStore) appendToWAL(eventType wal.WALEventType, key types.KeyType, value types.ValueType) (uint64, error) {
+	record := &wal.WALRecord{}
+	record.SetPayload(eventType, key, value)
+	return s.appendRecord(record)
+}
 
+// A record whose sync failed used up its index without being published, the wal
+// accepts no record after it so no later write is hidden behind the gap.
+func (s *CliftonDBKVStore) appendRecord(record *wal.WALRecord) (uint64, error) {
+	err := s.wal.Append(record)
+	return record.Index, err
+}
+
+// startCompaction starts a compactor for the tables of every column family,
+// with the strategy of the family.
+func (s *CliftonDBKVStore) startCompaction() {
+	s.backgroundCtx, s.stopBackground = context.WithCancel(context.Background())
+
+	for _, family := range s.families {
+		family := family
+		onError := func(err error) {
+			s.logger.Error("compaction failed", zap.String("column-family", family.name), zap.Error(err))
+		}
+
+		switch family.options.CompactionStrategy {
+		case compactor.SizeTieredStrategy:
+			options := family.options.SizeTieredCompaction
+			options.OnError = onError
+			family.compactor = compactor.NewSizeTieredCompactor(family.fileTable, options)
+		default:
+			options := family.options.LeveledCompaction
+			options.OnError = onError
+			family.compactor = compactor.NewLeveledCompactor(family.fileTable, options)
+		}
+
+		family.compactionStopped = family.compactor.Compact(s.backgroundCtx)
+	}
+}
+
+// countFilterDecisions counts the decisions of filter in the store stats.
+func (s *CliftonDBKVStore) countFilterDecisions(filter sstable.CompactionFilter) sstable.CompactionFilter {
+	return func(key types.KeyType, value types.ValueType) (sstable.FilterDecision, types.ValueType) {
+		decision, newValue := filter(key, value)
+
+		switch decision {
+		case sstable.FilterDrop:
+			atomic.AddUint64(&s.stats.FilterDrops, 1)
+		case sstable.FilterReplace:
+			atomic.AddUint64(&s.stats.FilterReplaces, 1)
+		default:
+			atomic.AddUint64(&s.stats.FilterKeeps, 1)
+		}
+
+		return decision, newValue
+	}
+}
+
+func (s *CliftonDBKVStore) Close() error {
+	if s.stopBackground != nil {
+		s.stopBackground()
+		for _, family := range s.families {
+			<-family.compactionStopped
+		}
+		<-s.flushStopped
+	}
+
+	for _, family := range s.families {
+		err := family.fileTable.Close()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	err := s.wal.Close()
+
+	if err != nil {
+		return err
+	}
+
+	return s.logger.Sync()
+}
+
+// scheduleCompaction asks the compactor of the family to check the levels after deadline.
+func (f *columnFamily) scheduleCompaction(deadline time.Duration) {
+	if deadline <= 0 {
+		f.compactor.Schedule()
+		return
+	}
+
+	time.AfterFunc(deadline, f.compactor.Schedule)
 }
 
 func (s *CliftonDBKVStore) Get(key types.KeyType) (data types.ValueType, ok bool, err error) {
-	data, ok, err = s.memtable.Get(key)
-	if ok {
+	return s.get(key, s.visible.Load(), s.fileTable.Clock.Now())
+}
+
+// get returns the newest version of key at or before seq, unless it expired at now.
+// Merge operands are folded into the versions older than them.
+func (f *columnFamily) get(key types.KeyType, seq uint64, now int64) (data types.ValueType, ok bool, err error) {
+	var values []types.ValueType
+
+	for {
+		record, ok, err := f.getVersion(key, seq, now)
+		if err != nil {
+			return nil, false, err
+		}
+
+		live := ok && !record.Deleted
+		if live {
+			values = append(values, record.Value)
+		}
+
+		if !live || !record.MergeOperand {
+			break
+		}
+
+		// the operand is merged into the version before it
+		seq = record.Seq - 1
+	}
+
+	if len(values) == 0 {
+		return nil, false, nil
+	}
+
+	return f.fold(key, values)
+}
+
+// getVersion returns the newest version of key at or before seq, as a tombstone if
+// it is deleted or expired at now. Memtables are searched newest first, a tombstone
+// in a memtable hides older versions in older memtables and the sstables.
+func (f *columnFamily) getVersion(key types.KeyType, seq uint64, now int64) (record sstable.Record, ok bool, err error) {
+	set := f.memTables()
+
+	for i := len(set.immutable); i >= 0; i-- {
+		memtable := set.active
+		if i < len(set.immutable) {
+			memtable = set.immutable[i]
+		}
+
+		record, ok, err = memtable.GetVersion(key, seq, now)
+		if err != nil || ok {
+			return
+		}
+	}
+
+	return f.fileTable.GetVersion(key, seq, now)
+}
+
+// Scan iterates keys in [start, end) of the memtables and all sstable levels,
+// a nil start or end leaves that side of the range open.
+func (s *CliftonDBKVStore) Scan(start types.KeyType, end types.KeyType) (*Iterator, error) {
+	return s.scan(start, end, s.visible.Load(), s.fileTable.Clock.Now())
+}
+
+// Range tombstones of a memtable delete keys of older memtables and the sstables
+// even where the memtable has no version of the key to shadow them with.
+func (f *columnFamily) scan(start types.KeyType, end types.KeyType, seq uint64, now int64) (*Iterator, error) {
+	var (
+		set             = f.memTables()
+		sources         []tables.Scanner
+		rangeTombstones []sstable.RangeTombstone
+	)
+
+	for i := len(set.immutable); i >= 0; i-- {
+		memtable := set.active
+		if i < len(set.immutable) {
+			memtable = set.immutable[i]
+		}
+
+		scanner := tables.NewMemTableScanner(memtable, start, end, seq, now)
+		sources = append(sources, tables.NewRangeDeletedScanner(scanner, rangeTombstones, seq))
+		rangeTombstones = append(rangeTombstones, memtable.RangeTombstones()...)
+	}
+
+	fileScanner, err := f.fileTable.NewScanner(start, end, seq, now)
+	if err != nil {
+		return nil, err
+	}
+
+	sources = append(sources, tables.NewRangeDeletedScanner(fileScanner, rangeTombstones, seq))
+
+	return &Iterator{
+		scanner: tables.NewMergedScanner(sources...),
+		get: func(key types.KeyType) (types.ValueType, bool, error) {
+			return f.get(key, seq, now)
+		},
+	}, nil
+}
+
+func (s *CliftonDBKVStore) Put(key types.KeyType, data types.ValueType, options ...PutOption) (err error) {
+	if len(options) > 0 {
+		return s.putWithOptions(key, data, options)
+	}
+
+	err = s.beginWrite(s.columnFamily)
+	if err != nil {
 		return
 	}
+	defer s.endWrite(s.columnFamily)
+
+	seq, err := s.appendToWAL(wal.PutKey, key, data)
+	if err != nil {
+		return
+	}
+
+	err = s.memTables().active.Put(key, data, seq)
+	s.visible.Publish(seq)
 	return
 }
 
-func (s *CliftonDBKVStore) Put(key types.KeyType, data types.ValueType) (err error) {
-	err = s.memtable.Put(key, data)
-	return
+func (s *CliftonDBKVStore) putWithOptions(key types.KeyType, data types.ValueType, options []PutOption) error {
+	var putOptions PutOptions
+
+	for _, opt := range options {
+		opt.Apply(&putOptions)
+	}
+
+	if putOptions.Timestamp == 0 {
+		putOptions.Timestamp = time.Now().UnixNano()
+	}
+
+	if putOptions.ExpiresAt == 0 && putOptions.TTL > 0 {
+		putOptions.ExpiresAt = putOptions.Timestamp + int64(putOptions.TTL)
+	}
+
+	record := &wal.WALRecord{}
+	record.SetExpiringPayload(key, data, putOptions.Timestamp, putOptions.ExpiresAt)
+
+	err := s.beginWrite(s.columnFamily)
+	if err != nil {
+		return err
+	}
+	defer s.endWrite(s.columnFamily)
+
+	seq, err := s.appendRecord(record)
+	if err != nil {
+		return err
+	}
+
+	err = s.memTables().active.PutWithExpiry(key, data, seq, putOptions.ExpiresAt)
+	s.fileTable.Clock.Advance(putOptions.Timestamp)
+	s.visible.Publish(seq)
+	return err
 }
 
+// Delete writes a tombstone that hides every older version of key,
+// ok is true if the key was live before.
 func (s *CliftonDBKVStore) Delete(key types.KeyType) (ok bool, err error) {
-	ok, err = s.memtable.Remove(key)
+	_, ok, err = s.Get(key)
+	if err != nil {
+		return
+	}
+
+	err = s.beginWrite(s.columnFamily)
+	if err != nil {
+		return
+	}
+	defer s.endWrite(s.columnFamily)
+
+	seq, err := s.appendToWAL(wal.DeleteKey, key, nil)
+	if err != nil {
+		return
+	}
+
+	_, err = s.memTables().active.Remove(key, seq)
+	s.visible.Publish(seq)
 	return
 }
 
+// DeleteRange deletes every key in [start, end) with a single range tombstone,
+// it takes the same time however many keys are in the range. Compaction drops
+// the deleted versions and then the tombstone.
+func (s *CliftonDBKVStore) DeleteRange(start types.KeyType, end types.KeyType) error {
+	if bytes.Compare(start, end) >= 0 {
+		return InvalidRangeErr
+	}
+
+	err := s.beginWrite(s.columnFamily)
+	if err != nil {
+		return err
+	}
+	defer s.endWrite(s.columnFamily)
+
+	seq, err := s.appendToWAL(wal.DeleteRange, start, types.ValueType(end))
+	if err != nil {
+		return err
+	}
+
+	err = s.memTables().active.DeleteRange(start, end, seq)
+	s.visible.Publish(seq)
+	return err
+}
+
 func (s *CliftonDBKVStore) Exists(key types.KeyType) (ok bool, err error) {
-	ok, err = s.memtable.Exists(key)
+	_, ok, err = s.Get(key)
 	return
 }
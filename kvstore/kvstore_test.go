@@ -0,0 +1,1364 @@
+package kvstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zl14917/MastersProject/kvstore/compactor"
+	"github.com/zl14917/MastersProject/kvstore/sstable"
+	"github.com/zl14917/MastersProject/kvstore/tables"
+	"github.com/zl14917/MastersProject/kvstore/types"
+	"github.com/zl14917/MastersProject/kvstore/wal"
+)
+
+const tmpDir = "/tmp/"
+
+type testWithFileIO func(t *testing.T, dirPath string)
+
+func WithTempDir(t *testing.T, io testWithFileIO) {
+
+	name, err := ioutil.TempDir(tmpDir, "cliftondbtests")
+
+	if err != nil {
+		t.Fatal("can't create test directory", err)
+	}
+
+	io(t, name)
+
+	_ = os.RemoveAll(name)
+}
+
+func expectValue(t *testing.T, store *CliftonDBKVStore, key string, expect string) {
+	value, ok, err := store.Get([]byte(key))
+	if err != nil {
+		t.Errorf("error reading key %s: %v", key, err)
+		return
+	}
+
+	if !ok {
+		t.Errorf("key %s should exist", key)
+		return
+	}
+
+	if !bytes.Equal(value, []byte(expect)) {
+		t.Errorf("key %s expect value %s, got %s", key, expect, string(value))
+	}
+}
+
+func expectMissing(t *testing.T, store *CliftonDBKVStore, key string) {
+	_, ok, err := store.Get([]byte(key))
+	if err != nil {
+		t.Errorf("error reading key %s: %v", key, err)
+		return
+	}
+
+	if ok {
+		t.Errorf("key %s should not exist", key)
+	}
+}
+
+func TestCliftonDBKVStore_RecoverFromWAL(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		store, err := NewCliftonDBKVStore(dirPath, dirPath)
+		if err != nil {
+			t.Error("error creating store", err)
+			return
+		}
+
+		_ = store.Put([]byte("hello"), []byte("world"))
+		_ = store.Put([]byte("greetings"), []byte("message"))
+		_ = store.Put([]byte("hello"), []byte("world2"))
+		_, _ = store.Delete([]byte("greetings"))
+
+		err = store.Close()
+		if err != nil {
+			t.Error("error closing store", err)
+		}
+
+		store, err = NewCliftonDBKVStore(dirPath, dirPath)
+		if err != nil {
+			t.Error("error reopening store", err)
+			return
+		}
+
+		expectValue(t, store, "hello", "world2")
+		expectMissing(t, store, "greetings")
+
+		_ = store.Put([]byte("after"), []byte("recovery"))
+		_ = store.Close()
+
+		store, err = NewCliftonDBKVStore(dirPath, dirPath)
+		if err != nil {
+			t.Error("error reopening store twice", err)
+			return
+		}
+
+		expectValue(t, store, "hello", "world2")
+		expectValue(t, store, "after", "recovery")
+		_ = store.Close()
+	})
+}
+
+func TestCliftonDBKVStore_RecoverTornTail(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		store, err := NewCliftonDBKVStore(dirPath, dirPath)
+		if err != nil {
+			t.Error("error creating store", err)
+			return
+		}
+
+		_ = store.Put([]byte("hello"), []byte("world"))
+		_ = store.Close()
+
+		segments, err := wal.FindSegments(path.Join(dirPath, walPath))
+		if err != nil || len(segments) != 1 {
+			t.Errorf("expect one wal segment, got %d: %v", len(segments), err)
+			return
+		}
+
+		file, err := os.OpenFile(segments[0].FilePath, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		// half of a record header
+		_, _ = file.Write([]byte{0, 0, 0, 0, 0, 0, 0, 2, 0, 0})
+		_ = file.Close()
+		_ = segments[0].Close()
+
+		store, err = NewCliftonDBKVStore(dirPath, dirPath)
+		if err != nil {
+			t.Error("torn tail should not fail recovery", err)
+			return
+		}
+
+		expectValue(t, store, "hello", "world")
+		_ = store.Put([]byte("next"), []byte("value"))
+		_ = store.Close()
+
+		store, err = NewCliftonDBKVStore(dirPath, dirPath)
+		if err != nil {
+			t.Error("error reopening store after torn tail", err)
+			return
+		}
+
+		expectValue(t, store, "hello", "world")
+		expectValue(t, store, "next", "value")
+		_ = store.Close()
+	})
+}
+
+func TestCliftonDBKVStore_LockFile(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		store, err := NewCliftonDBKVStore(dirPath, dirPath)
+		if err != nil {
+			t.Error("error creating store", err)
+			return
+		}
+
+		for i := 0; i < 3; i++ {
+			_ = store.Put([]byte(fmt.Sprintf("key_%d", i)), []byte("value"))
+			if err = store.Flush(); err != nil {
+				t.Error("error flushing store", err)
+			}
+		}
+
+		flushIndex := store.lockFileData.WALFlushIndex
+		_ = store.Close()
+
+		if _, err = os.Stat(store.KVStoreLockFilePath + ".tmp"); !os.IsNotExist(err) {
+			t.Error("expect temporary lock file to be renamed, got", err)
+		}
+
+		data, err := store.ReadLockFile()
+		if err != nil || data.WALFlushIndex != flushIndex {
+			t.Errorf("expect lock file with flush index %d, got %d: %v", flushIndex, data.WALFlushIndex, err)
+		}
+
+		err = ioutil.WriteFile(store.KVStoreLockFilePath, nil, 0644)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		_, err = NewCliftonDBKVStore(dirPath, dirPath)
+		if err == nil || !strings.Contains(err.Error(), store.KVStoreLockFilePath) {
+			t.Error("expect error naming the empty lock file, got", err)
+		}
+	})
+}
+
+func TestCliftonDBKVStore_Scan(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		store, err := NewCliftonDBKVStore(dirPath, dirPath)
+		if err != nil {
+			t.Error("error creating store", err)
+			return
+		}
+		defer store.Close()
+
+		flushed := tables.NewMapMemTable(100, 100)
+		_ = flushed.Put([]byte("apple"), []byte("old"), 0)
+		_ = flushed.Put([]byte("cherry"), []byte("flushed"), 0)
+		_ = flushed.Put([]byte("damson"), []byte("flushed"), 0)
+
+		var flushErr error
+		store.fileTable.BeginFlushing(flushed, func(ok bool, err error) {
+			flushErr = err
+		})
+
+		if flushErr != nil {
+			t.Error("error flushing memtable", flushErr)
+			return
+		}
+
+		_ = store.Put([]byte("apple"), []byte("new"))
+		_ = store.Put([]byte("banana"), []byte("new"))
+		_ = store.Put([]byte("elder"), []byte("new"))
+		_ = store.Put([]byte("cherry"), []byte("new"))
+		_, _ = store.Delete([]byte("cherry"))
+
+		iterator, err := store.Scan(nil, []byte("elder"))
+		if err != nil {
+			t.Error("error scanning store", err)
+			return
+		}
+		defer iterator.Close()
+
+		var scanned []string
+		for iterator.Next() {
+			scanned = append(scanned, string(iterator.Key())+"="+string(iterator.Value()))
+		}
+
+		if iterator.Err() != nil {
+			t.Error("error during scan", iterator.Err())
+		}
+
+		expect := []string{"apple=new", "banana=new", "damson=flushed"}
+		if strings.Join(scanned, ",") != strings.Join(expect, ",") {
+			t.Errorf("expect scan %v, got %v", expect, scanned)
+		}
+	})
+}
+
+func TestCliftonDBKVStore_ScanBackwards(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		store, err := NewCliftonDBKVStore(dirPath, dirPath)
+		if err != nil {
+			t.Error("error creating store", err)
+			return
+		}
+		defer store.Close()
+
+		flushed := tables.NewMapMemTable(100, 100)
+		for _, key := range []string{"a1", "a3", "a5", "a7"} {
+			_ = flushed.Put([]byte(key), []byte("flushed"), 0)
+		}
+		store.fileTable.BeginFlushing(flushed, nil)
+
+		for _, key := range []string{"a2", "a4", "a6"} {
+			_ = store.Put([]byte(key), []byte("new"))
+		}
+		_ = store.Put([]byte("a5"), []byte("new"))
+		_, _ = store.Delete([]byte("a5"))
+
+		iterator, err := store.Scan(nil, nil)
+		if err != nil {
+			t.Error("error scanning store", err)
+			return
+		}
+		defer iterator.Close()
+
+		// latest 3 keys before a6
+		var scanned []string
+		iterator.SeekForPrev([]byte("a6"))
+		for len(scanned) < 3 && iterator.Prev() {
+			scanned = append(scanned, string(iterator.Key()))
+		}
+
+		expect := []string{"a6", "a4", "a3"}
+		if strings.Join(scanned, ",") != strings.Join(expect, ",") {
+			t.Errorf("expect %v, got %v", expect, scanned)
+		}
+
+		iterator.Seek([]byte("a45"))
+		if !iterator.Next() || string(iterator.Key()) != "a6" {
+			t.Errorf("expect a6 after a45, got %s", iterator.Key())
+		}
+	})
+}
+
+func TestCliftonDBKVStore_SharedBlockCache(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		cache := sstable.NewBlockCache(1024 * 1024)
+
+		var stores []*CliftonDBKVStore
+		for _, name := range []string{"first", "second"} {
+			store, err := NewCliftonDBKVStore(path.Join(dirPath, name), dirPath, WithBlockCache(cache))
+			if err != nil {
+				t.Error("error creating store", err)
+				return
+			}
+			defer store.Close()
+
+			flushed := tables.NewMapMemTable(100, 100)
+			_ = flushed.Put([]byte("key"), []byte(name), 0)
+
+			var flushErr error
+			store.fileTable.BeginFlushing(flushed, func(ok bool, err error) {
+				flushErr = err
+			})
+
+			if flushErr != nil {
+				t.Error("error flushing memtable", flushErr)
+				return
+			}
+			stores = append(stores, store)
+		}
+
+		expectValue(t, stores[0], "key", "first")
+		expectValue(t, stores[1], "key", "second")
+		misses := cache.Stats().Misses
+
+		expectValue(t, stores[0], "key", "first")
+		expectValue(t, stores[1], "key", "second")
+		stats := stores[0].BlockCacheStats()
+
+		if stats.Misses != misses || stats.Hits == 0 {
+			t.Errorf("repeated reads should hit the shared cache, misses before %d, got %+v", misses, stats)
+		}
+
+		if stats != stores[1].BlockCacheStats() {
+			t.Errorf("stores sharing a cache should report the same stats")
+		}
+	})
+}
+
+func expectSnapshotScan(t *testing.T, snapshot *Snapshot, expect []string) {
+	iterator, err := snapshot.Scan(nil, nil)
+	if err != nil {
+		t.Error("error scanning snapshot", err)
+		return
+	}
+	defer iterator.Close()
+
+	var scanned []string
+	for iterator.Next() {
+		scanned = append(scanned, string(iterator.Key())+"="+string(iterator.Value()))
+	}
+
+	if strings.Join(scanned, ",") != strings.Join(expect, ",") {
+		t.Errorf("expect snapshot scan %v, got %v", expect, scanned)
+	}
+}
+
+func TestCliftonDBKVStore_Snapshot(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		store, err := NewCliftonDBKVStore(dirPath, dirPath)
+		if err != nil {
+			t.Error("error creating store", err)
+			return
+		}
+		defer store.Close()
+
+		_ = store.Put([]byte("a"), []byte("v1"))
+		_ = store.Put([]byte("b"), []byte("v1"))
+
+		snapshot := store.Snapshot()
+
+		_ = store.Put([]byte("a"), []byte("v2"))
+		_, _ = store.Delete([]byte("b"))
+		_ = store.Put([]byte("c"), []byte("v1"))
+
+		expectValue(t, store, "a", "v2")
+		expectMissing(t, store, "b")
+		expectValue(t, store, "c", "v1")
+
+		expectSnapshot := func() {
+			for key, expect := range map[string]string{"a": "v1", "b": "v1", "c": ""} {
+				value, ok, err := snapshot.Get([]byte(key))
+				if err != nil || ok != (expect != "") || string(value) != expect {
+					t.Errorf("snapshot key %s expect %q, got %q, ok: %v, err: %v", key, expect, value, ok, err)
+				}
+			}
+			expectSnapshotScan(t, snapshot, []string{"a=v1", "b=v1"})
+		}
+		expectSnapshot()
+
+		// versions read by the snapshot survive flushing and compaction
+		flush := func() {
+			if err := store.Flush(); err != nil {
+				t.Error("error flushing memtable", err)
+			}
+		}
+
+		flush()
+		_ = store.Put([]byte("a"), []byte("v3"))
+		flush()
+
+		options := compactor.DefaultLeveledOptions
+		options.Triggers[0].MaxFiles = 1
+
+		compacted, err := compactor.NewLeveledCompactor(store.fileTable, options).CompactOnce()
+		if err != nil || !compacted {
+			t.Errorf("level 0 should be compacted, compacted: %v, err: %v", compacted, err)
+		}
+
+		expectSnapshot()
+		expectValue(t, store, "a", "v3")
+		expectMissing(t, store, "b")
+
+		snapshot.Release()
+		snapshot.Release()
+
+		if seqs := store.fileTable.Snapshots.Sequences(); len(seqs) != 0 {
+			t.Errorf("released snapshot should not be tracked, got %v", seqs)
+		}
+	})
+}
+
+func TestCliftonDBKVStore_WriteBatch(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		store, err := NewCliftonDBKVStore(dirPath, dirPath)
+		if err != nil {
+			t.Error("error creating store", err)
+			return
+		}
+
+		_ = store.Put([]byte("a"), []byte("old"))
+		_ = store.Put([]byte("c"), []byte("old"))
+		snapshot := store.Snapshot()
+
+		key := []byte("b")
+		batch := NewWriteBatch()
+		batch.Put([]byte("a"), []byte("new"))
+		batch.Put(key, []byte("new"))
+		batch.Delete([]byte("c"))
+		// the batch keeps its own copy
+		key[0] = 'x'
+
+		if err = store.Write(batch); err != nil {
+			t.Error("error writing batch", err)
+			return
+		}
+
+		expectValue(t, store, "a", "new")
+		expectValue(t, store, "b", "new")
+		expectMissing(t, store, "c")
+		expectMissing(t, store, "x")
+
+		// a snapshot sees none of a batch written after it
+		expectSnapshotScan(t, snapshot, []string{"a=old", "c=old"})
+		snapshot.Release()
+
+		_ = store.Close()
+
+		store, err = NewCliftonDBKVStore(dirPath, dirPath)
+		if err != nil {
+			t.Error("error reopening store", err)
+			return
+		}
+
+		expectValue(t, store, "a", "new")
+		expectValue(t, store, "b", "new")
+		expectMissing(t, store, "c")
+
+		batch.Reset()
+		batch.Put([]byte("d"), []byte("torn"))
+		batch.Delete([]byte("a"))
+		_ = store.Write(batch)
+		_ = store.Close()
+
+		segments, err := wal.FindSegments(path.Join(dirPath, walPath))
+		if err != nil || len(segments) != 1 {
+			t.Errorf("expect one wal segment, got %d: %v", len(segments), err)
+			return
+		}
+		_ = segments[0].Close()
+
+		// crash in the middle of writing the last batch
+		info, err := os.Stat(segments[0].FilePath)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		_ = os.Truncate(segments[0].FilePath, info.Size()-3)
+
+		store, err = NewCliftonDBKVStore(dirPath, dirPath)
+		if err != nil {
+			t.Error("torn batch should not fail recovery", err)
+			return
+		}
+		defer store.Close()
+
+		expectValue(t, store, "a", "new")
+		expectMissing(t, store, "d")
+	})
+}
+
+func TestCliftonDBKVStore_TTL(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		store, err := NewCliftonDBKVStore(dirPath, dirPath)
+		if err != nil {
+			t.Error("error creating store", err)
+			return
+		}
+
+		start := time.Unix(1000, 0)
+
+		_ = store.Put([]byte("forever"), []byte("value"))
+		_ = store.Put([]byte("session"), []byte("data"), WithTimestamp(start), WithTTL(time.Minute))
+		_ = store.Put([]byte("short"), []byte("data"), WithTimestamp(start), WithExpiry(start.Add(time.Second)))
+
+		expectValue(t, store, "session", "data")
+		expectValue(t, store, "short", "data")
+
+		snapshot := store.Snapshot()
+		defer snapshot.Release()
+
+		// expiry follows the timestamps of logged writes, not the wall clock
+		_ = store.Put([]byte("tick"), []byte("1"), WithTimestamp(start.Add(2*time.Second)))
+
+		expectValue(t, store, "session", "data")
+		expectMissing(t, store, "short")
+
+		if value, ok, _ := snapshot.Get([]byte("short")); !ok || string(value) != "data" {
+			t.Errorf("snapshot should read short before it expired, got %q", value)
+		}
+
+		_ = store.Put([]byte("tick"), []byte("2"), WithTimestamp(start.Add(2*time.Minute)))
+		expectMissing(t, store, "session")
+
+		iterator, err := store.Scan(nil, nil)
+		if err != nil {
+			t.Error("error scanning store", err)
+			return
+		}
+
+		var scanned []string
+		for iterator.Next() {
+			scanned = append(scanned, string(iterator.Key()))
+		}
+		_ = iterator.Close()
+
+		if strings.Join(scanned, ",") != "forever,tick" {
+			t.Errorf("scan should skip expired keys, got %v", scanned)
+		}
+
+		snapshot.Release()
+		_ = store.Close()
+
+		store, err = NewCliftonDBKVStore(dirPath, dirPath)
+		if err != nil {
+			t.Error("error reopening store", err)
+			return
+		}
+		defer store.Close()
+
+		expectMissing(t, store, "session")
+		expectMissing(t, store, "short")
+		expectValue(t, store, "forever", "value")
+
+		// compaction drops expired keys from the tables
+		if err = store.Flush(); err != nil {
+			t.Error("error flushing memtable", err)
+		}
+
+		options := compactor.DefaultLeveledOptions
+		options.Triggers[0].MaxFiles = 1
+
+		if _, err = compactor.NewLeveledCompactor(store.fileTable, options).CompactOnce(); err != nil {
+			t.Error("error compacting", err)
+		}
+
+		for _, key := range []string{"session", "short"} {
+			if _, _, ok, err := store.fileTable.Get([]byte(key), types.MaxSequenceNumber, 0); ok || err != nil {
+				t.Errorf("expired key %s should be dropped, ok: %v, err: %v", key, ok, err)
+			}
+		}
+
+		expectValue(t, store, "forever", "value")
+	})
+}
+
+func TestCliftonDBKVStore_BackgroundFlush(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		options := []KVStoreOpenOptions{WithMemTableSize(16 * 1024), WithWALSegmentSize(4 * 1024)}
+
+		store, err := NewCliftonDBKVStore(dirPath, dirPath, options...)
+		if err != nil {
+			t.Error("error creating store", err)
+			return
+		}
+
+		value := strings.Repeat("v", 100)
+		for i := 0; i < 500; i++ {
+			key := fmt.Sprintf("key_%04d", i)
+			if err = store.Put([]byte(key), []byte(value)); err != nil {
+				t.Error("error writing key", key, err)
+				break
+			}
+
+			// reads see the key whether or not its memtable is flushed yet
+			if i%100 == 0 {
+				expectValue(t, store, fmt.Sprintf("key_%04d", i/2), value)
+			}
+		}
+
+		for deadline := time.Now().Add(5 * time.Second); len(store.memTables().immutable) > 0; {
+			if time.Now().After(deadline) {
+				t.Error("memtables were not flushed in time")
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if store.Stats().Flushes == 0 || len(store.fileTable.Tables(0))+len(store.fileTable.Tables(1)) == 0 {
+			t.Errorf("full memtables should be flushed, stats: %+v", store.Stats())
+		}
+
+		if start := store.wal.Segments[0].StartRecordIndex; start <= 1 {
+			t.Error("wal segments of flushed memtables should be removed")
+		}
+
+		_ = store.Close()
+
+		store, err = NewCliftonDBKVStore(dirPath, dirPath, options...)
+		if err != nil {
+			t.Error("error reopening store", err)
+			return
+		}
+		defer store.Close()
+
+		for i := 0; i < 500; i++ {
+			expectValue(t, store, fmt.Sprintf("key_%04d", i), value)
+		}
+	})
+}
+
+func TestCliftonDBKVStore_WriteStall(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		stalls := DefaultWriteStallOptions
+		stalls.MaxImmutableMemTables = 1
+
+		store, err := NewCliftonDBKVStore(dirPath, dirPath, WithMemTableSize(1024), WithWriteStalls(stalls))
+		if err != nil {
+			t.Error("error creating store", err)
+			return
+		}
+		defer store.Close()
+
+		// holds the flusher back until the write stalls
+		store.flushLock.Lock()
+
+		value := strings.Repeat("v", 100)
+		for i := 0; len(store.memTables().immutable) == 0; i++ {
+			_ = store.Put([]byte(fmt.Sprintf("key_%04d", i)), []byte(value))
+		}
+
+		written := make(chan error)
+		go func() {
+			written <- store.Put([]byte("stalled"), []byte(value))
+		}()
+
+		select {
+		case <-written:
+			t.Error("write should wait for the full memtable to be flushed")
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		if store.Stats().WriteStalls != 1 {
+			t.Errorf("expect 1 stalled write, got %+v", store.Stats())
+		}
+
+		store.flushLock.Unlock()
+
+		if err = <-written; err != nil {
+			t.Error("error writing after flush", err)
+		}
+
+		expectValue(t, store, "stalled", value)
+		expectValue(t, store, "key_0000", value)
+	})
+}
+
+// size-tiered compaction may never merge the tables of level 0,
+// writes must not wait for it
+func TestCliftonDBKVStore_SizeTieredWriteStall(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		stalls := DefaultWriteStallOptions
+		stalls.Level0SlowdownTables = 2
+		stalls.Level0StopTables = 3
+
+		// tables never form a bucket large enough to be merged
+		options := compactor.DefaultSizeTieredOptions
+		options.MinThreshold = 100
+		options.MaxThreshold = 100
+
+		store, err := NewCliftonDBKVStore(dirPath, dirPath, WithWriteStalls(stalls), WithSizeTieredCompaction(options))
+		if err != nil {
+			t.Error("error creating store", err)
+			return
+		}
+		defer store.Close()
+
+		for i := 0; i < 3; i++ {
+			_ = store.Put([]byte(fmt.Sprintf("key_%d", i)), []byte("value"))
+			if err = store.Flush(); err != nil {
+				t.Error("error flushing store", err)
+			}
+		}
+
+		if tables := len(store.fileTable.Tables(0)); tables < stalls.Level0StopTables {
+			t.Errorf("expect at least %d tables in level 0, got %d", stalls.Level0StopTables, tables)
+		}
+
+		written := make(chan error)
+		go func() {
+			written <- store.Put([]byte("next"), []byte("value"))
+		}()
+
+		select {
+		case err = <-written:
+			if err != nil {
+				t.Error("error writing", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("write waited for level 0 of a size-tiered store")
+		}
+
+		if stats := store.Stats(); stats.WriteStalls != 0 || stats.WriteSlowdowns != 0 {
+			t.Errorf("expect no stalled or slowed down writes, got %+v", stats)
+		}
+	})
+}
+
+func TestCliftonDBKVStore_Checkpoint(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		storePath, checkpointPath := path.Join(dirPath, "store"), path.Join(dirPath, "checkpoint")
+
+		store, err := NewCliftonDBKVStore(storePath, dirPath)
+		if err != nil {
+			t.Error("error creating store", err)
+			return
+		}
+		defer store.Close()
+
+		_ = store.Put([]byte("flushed"), []byte("v1"))
+		_ = store.Put([]byte("deleted"), []byte("v1"))
+		if err = store.Flush(); err != nil {
+			t.Error("error flushing memtable", err)
+		}
+		_ = store.Put([]byte("memtable"), []byte("v1"))
+
+		if err = store.Checkpoint(checkpointPath); err != nil {
+			t.Error("error writing checkpoint", err)
+			return
+		}
+
+		if err = store.Checkpoint(checkpointPath); err == nil {
+			t.Error("checkpoint should not overwrite an existing directory")
+		}
+
+		// later writes and compactions of the store leave the checkpoint as it was
+		_ = store.Put([]byte("flushed"), []byte("v2"))
+		_ = store.Put([]byte("after"), []byte("v2"))
+		_, _ = store.Delete([]byte("deleted"))
+		_ = store.Flush()
+
+		options := compactor.DefaultLeveledOptions
+		options.Triggers[0].MaxFiles = 1
+
+		if _, err = compactor.NewLeveledCompactor(store.fileTable, options).CompactOnce(); err != nil {
+			t.Error("error compacting", err)
+		}
+
+		checkpoint, err := NewCliftonDBKVStore(checkpointPath, dirPath)
+		if err != nil {
+			t.Error("error opening checkpoint", err)
+			return
+		}
+
+		expectValue(t, checkpoint, "flushed", "v1")
+		expectValue(t, checkpoint, "memtable", "v1")
+		expectValue(t, checkpoint, "deleted", "v1")
+		expectMissing(t, checkpoint, "after")
+
+		_ = checkpoint.Put([]byte("checkpoint"), []byte("v1"))
+		_ = checkpoint.Close()
+
+		checkpoint, err = NewCliftonDBKVStore(checkpointPath, dirPath)
+		if err != nil {
+			t.Error("error reopening checkpoint", err)
+			return
+		}
+		defer checkpoint.Close()
+
+		expectValue(t, checkpoint, "checkpoint", "v1")
+		expectValue(t, checkpoint, "memtable", "v1")
+
+		expectValue(t, store, "flushed", "v2")
+		expectMissing(t, store, "deleted")
+		expectMissing(t, store, "checkpoint")
+	})
+}
+
+func TestCliftonDBKVStore_DeleteRange(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		store, err := NewCliftonDBKVStore(dirPath, dirPath)
+		if err != nil {
+			t.Error("error creating store", err)
+			return
+		}
+
+		for _, tenant := range []string{"a", "b", "c"} {
+			for i := 0; i < 10; i++ {
+				_ = store.Put([]byte(fmt.Sprintf("%s/%d", tenant, i)), []byte("v1"))
+			}
+		}
+
+		if err = store.Flush(); err != nil {
+			t.Error("error flushing memtable", err)
+		}
+
+		_ = store.Put([]byte("b/5"), []byte("v2"))
+		snapshot := store.Snapshot()
+
+		// the tombstone hides versions in the memtable and in sstables
+		if err = store.DeleteRange([]byte("b/"), []byte("b0")); err != nil {
+			t.Error("error deleting range", err)
+		}
+
+		if err = store.DeleteRange([]byte("b0"), []byte("b/")); err != InvalidRangeErr {
+			t.Errorf("expect %v for an empty range, got %v", InvalidRangeErr, err)
+		}
+
+		// a point delete of a flushed key
+		if ok, err := store.Delete([]byte("c/0")); !ok || err != nil {
+			t.Errorf("deleting flushed key should return ok, ok: %v, err: %v", ok, err)
+		}
+
+		_ = store.Put([]byte("b/7"), []byte("v3"))
+
+		expectKeys := func(store *CliftonDBKVStore) {
+			expectValue(t, store, "a/5", "v1")
+			expectMissing(t, store, "b/5")
+			expectMissing(t, store, "c/0")
+			expectValue(t, store, "b/7", "v3")
+
+			iterator, err := store.Scan([]byte("a/8"), []byte("c/2"))
+			if err != nil {
+				t.Error("error scanning", err)
+				return
+			}
+			defer iterator.Close()
+
+			var keys []string
+			for iterator.Next() {
+				keys = append(keys, string(iterator.Key()))
+			}
+
+			if expect := []string{"a/8", "a/9", "b/7", "c/1"}; fmt.Sprint(keys) != fmt.Sprint(expect) {
+				t.Errorf("expect scanned keys %v, got %v", expect, keys)
+			}
+		}
+
+		expectKeys(store)
+
+		if value, ok, _ := snapshot.Get([]byte("b/5")); !ok || string(value) != "v2" {
+			t.Errorf("snapshot should read b/5 before the tombstone, got %s", value)
+		}
+		snapshot.Release()
+
+		// recovered from the wal
+		_ = store.Close()
+		store, err = NewCliftonDBKVStore(dirPath, dirPath)
+		if err != nil {
+			t.Error("error reopening store", err)
+			return
+		}
+		defer store.Close()
+
+		expectKeys(store)
+
+		// compaction drops the deleted versions, then the tombstone
+		if err = store.Flush(); err != nil {
+			t.Error("error flushing memtable", err)
+		}
+
+		if len(store.fileTable.RangeDeletions()) != 1 {
+			t.Errorf("expect a range tombstone after flushing, got %v", store.fileTable.RangeDeletions())
+		}
+
+		for {
+			compacted, err := store.compactor.CompactOnce()
+			if err != nil {
+				t.Error("error compacting", err)
+				return
+			}
+
+			if !compacted {
+				break
+			}
+		}
+
+		if deletions := store.fileTable.RangeDeletions(); len(deletions) != 0 {
+			t.Errorf("expect range tombstones to be dropped by compaction, got %v", deletions)
+		}
+
+		expectKeys(store)
+	})
+}
+
+func TestCliftonDBKVStore_CompactionFilter(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		filter := func(key types.KeyType, value types.ValueType) (sstable.FilterDecision, types.ValueType) {
+			switch {
+			case bytes.HasPrefix(key, []byte("retired/")):
+				return sstable.FilterDrop, nil
+			case bytes.HasPrefix(value, []byte("v1:")):
+				return sstable.FilterReplace, append([]byte("v2:"), value[3:]...)
+			default:
+				return sstable.FilterKeep, nil
+			}
+		}
+
+		options := compactor.DefaultLeveledOptions
+		options.Triggers[0].MaxFiles = 1
+
+		store, err := NewCliftonDBKVStore(dirPath, dirPath, WithCompactionFilter(filter), WithLeveledCompaction(options))
+		if err != nil {
+			t.Error("error creating store", err)
+			return
+		}
+		defer store.Close()
+
+		for i := 0; i < 5; i++ {
+			_ = store.Put([]byte(fmt.Sprintf("retired/%d", i)), []byte("value"))
+			_ = store.Put([]byte(fmt.Sprintf("live/%d", i)), []byte(fmt.Sprintf("v1:%d", i)))
+		}
+		_ = store.Put([]byte("other"), []byte("kept"))
+
+		if err = store.Flush(); err != nil {
+			t.Error("error flushing memtable", err)
+		}
+
+		// the flushed table is compacted into level 1, in the background or here
+		for {
+			compacted, err := store.compactor.CompactOnce()
+			if err != nil {
+				t.Error("error compacting", err)
+				return
+			}
+
+			if !compacted {
+				break
+			}
+		}
+
+		for i := 0; i < 5; i++ {
+			expectMissing(t, store, fmt.Sprintf("retired/%d", i))
+			expectValue(t, store, fmt.Sprintf("live/%d", i), fmt.Sprintf("v2:%d", i))
+		}
+		expectValue(t, store, "other", "kept")
+
+		stats := store.Stats()
+		if stats.FilterDrops != 5 || stats.FilterReplaces != 5 || stats.FilterKeeps != 1 {
+			t.Errorf("expect 5 drops, 5 replaces and 1 keep, got %+v", stats)
+		}
+	})
+}
+
+func TestCliftonDBKVStore_Merge(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		add := func(key types.KeyType, older types.ValueType, newer types.ValueType) types.ValueType {
+			var a, b int
+			_, _ = fmt.Sscan(string(older), &a)
+			_, _ = fmt.Sscan(string(newer), &b)
+			return types.ValueType(fmt.Sprint(a + b))
+		}
+
+		options := compactor.DefaultLeveledOptions
+		options.Triggers[0].MaxFiles = 1
+
+		open := func() *CliftonDBKVStore {
+			store, err := NewCliftonDBKVStore(dirPath, dirPath, WithMergeOperator(add), WithLeveledCompaction(options))
+			if err != nil {
+				t.Fatal("error opening store", err)
+			}
+			return store
+		}
+
+		store := open()
+
+		_ = store.Put([]byte("hits"), []byte("10"))
+		_ = store.Put([]byte("reset"), []byte("100"))
+
+		for i := 0; i < 3; i++ {
+			if err := store.Merge([]byte("hits"), []byte("1")); err != nil {
+				t.Error("error merging", err)
+			}
+		}
+
+		snapshot := store.Snapshot()
+
+		// operands of a key with no value fold into each other
+		_ = store.Merge([]byte("new"), []byte("5"))
+
+		if err := store.Flush(); err != nil {
+			t.Error("error flushing memtable", err)
+		}
+
+		batch := NewWriteBatch()
+		batch.Merge([]byte("new"), []byte("5"))
+		batch.Merge([]byte("hits"), []byte("7"))
+		if err := store.Write(batch); err != nil {
+			t.Error("error writing batch", err)
+		}
+
+		// operands after a delete do not see the deleted value
+		_, _ = store.Delete([]byte("reset"))
+		_ = store.Merge([]byte("reset"), []byte("2"))
+
+		expectKeys := func(store *CliftonDBKVStore) {
+			expectValue(t, store, "hits", "20")
+			expectValue(t, store, "new", "10")
+			expectValue(t, store, "reset", "2")
+
+			iterator, err := store.Scan(nil, nil)
+			if err != nil {
+				t.Error("error scanning", err)
+				return
+			}
+			defer iterator.Close()
+
+			var scanned []string
+			for iterator.Next() {
+				scanned = append(scanned, fmt.Sprintf("%s=%s", iterator.Key(), iterator.Value()))
+			}
+
+			if expect := []string{"hits=20", "new=10", "reset=2"}; fmt.Sprint(scanned) != fmt.Sprint(expect) {
+				t.Errorf("expect scanned %v, got %v", expect, scanned)
+			}
+		}
+
+		expectKeys(store)
+
+		if value, ok, _ := snapshot.Get([]byte("hits")); !ok || string(value) != "13" {
+			t.Errorf("snapshot should read hits before the batch as 13, got %s", value)
+		}
+		snapshot.Release()
+
+		// folded by compaction
+		if err := store.Flush(); err != nil {
+			t.Error("error flushing memtable", err)
+		}
+
+		for {
+			compacted, err := store.compactor.CompactOnce()
+			if err != nil {
+				t.Error("error compacting", err)
+				return
+			}
+
+			if !compacted {
+				break
+			}
+		}
+
+		expectKeys(store)
+
+		// recovered from the wal
+		_ = store.Merge([]byte("hits"), []byte("1"))
+		_ = store.Close()
+
+		store = open()
+		defer store.Close()
+
+		expectValue(t, store, "hits", "21")
+	})
+}
+
+// entries of a batch share a sequence number, entries of one key must not overwrite
+// each other
+func TestCliftonDBKVStore_MergeInBatch(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		add := func(key types.KeyType, older types.ValueType, newer types.ValueType) types.ValueType {
+			var a, b int
+			_, _ = fmt.Sscan(string(older), &a)
+			_, _ = fmt.Sscan(string(newer), &b)
+			return types.ValueType(fmt.Sprint(a + b))
+		}
+
+		open := func() *CliftonDBKVStore {
+			store, err := NewCliftonDBKVStore(dirPath, dirPath, WithMergeOperator(add))
+			if err != nil {
+				t.Fatal("error opening store", err)
+			}
+			return store
+		}
+
+		store := open()
+
+		_ = store.Put([]byte("hits"), []byte("10"))
+		_ = store.Put([]byte("reset"), []byte("100"))
+
+		batch := NewWriteBatch()
+		batch.Merge([]byte("hits"), []byte("1"))
+		batch.Merge([]byte("hits"), []byte("1"))
+		batch.Put([]byte("total"), []byte("10"))
+		batch.Merge([]byte("total"), []byte("5"))
+		batch.Merge([]byte("new"), []byte("1"))
+		batch.Merge([]byte("new"), []byte("2"))
+		batch.Delete([]byte("reset"))
+		batch.Merge([]byte("reset"), []byte("4"))
+		batch.Merge([]byte("replaced"), []byte("1"))
+		batch.Put([]byte("replaced"), []byte("7"))
+		batch.Put([]byte("removed"), []byte("1"))
+		batch.Delete([]byte("removed"))
+
+		if err := store.Write(batch); err != nil {
+			t.Error("error writing batch", err)
+		}
+
+		expectKeys := func(store *CliftonDBKVStore) {
+			expectValue(t, store, "hits", "12")
+			expectValue(t, store, "total", "15")
+			expectValue(t, store, "new", "3")
+			expectValue(t, store, "reset", "4")
+			expectValue(t, store, "replaced", "7")
+			expectMissing(t, store, "removed")
+		}
+
+		expectKeys(store)
+		_ = store.Close()
+
+		// replayed from the wal
+		store = open()
+		defer store.Close()
+
+		expectKeys(store)
+	})
+}
+
+func TestCliftonDBKVStore_MergeWithoutOperator(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		store, err := NewCliftonDBKVStore(dirPath, dirPath)
+		if err != nil {
+			t.Error("error creating store", err)
+			return
+		}
+		defer store.Close()
+
+		if err = store.Merge([]byte("hits"), []byte("1")); err != NoMergeOperatorErr {
+			t.Errorf("expect %v, got %v", NoMergeOperatorErr, err)
+		}
+
+		batch := NewWriteBatch()
+		batch.Put([]byte("a"), []byte("1"))
+		batch.Merge([]byte("hits"), []byte("1"))
+
+		if err = store.Write(batch); err != NoMergeOperatorErr {
+			t.Errorf("expect %v for a batch with merges, got %v", NoMergeOperatorErr, err)
+		}
+
+		expectMissing(t, store, "a")
+	})
+}
+
+func TestCliftonDBKVStore_ColumnFamilies(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		add := func(key types.KeyType, older types.ValueType, newer types.ValueType) types.ValueType {
+			var a, b int
+			_, _ = fmt.Sscan(string(older), &a)
+			_, _ = fmt.Sscan(string(newer), &b)
+			return types.ValueType(fmt.Sprint(a + b))
+		}
+
+		open := func() (*CliftonDBKVStore, *ColumnFamily, *ColumnFamily) {
+			store, err := NewCliftonDBKVStore(dirPath, dirPath,
+				WithColumnFamily("blobs", WithDataBlockSize(64*1024), WithSizeTieredCompaction(compactor.DefaultSizeTieredOptions)),
+				WithColumnFamily("counters", WithMergeOperator(add)),
+			)
+			if err != nil {
+				t.Fatal("error opening store", err)
+			}
+
+			blobs, err := store.ColumnFamily("blobs")
+			if err != nil {
+				t.Fatal("error opening column family", err)
+			}
+
+			counters, err := store.ColumnFamily("counters")
+			if err != nil {
+				t.Fatal("error opening column family", err)
+			}
+			return store, blobs, counters
+		}
+
+		expectFamilyValue := func(family *ColumnFamily, key string, expect string) {
+			value, ok, err := family.Get([]byte(key))
+			if err != nil || !ok || string(value) != expect {
+				t.Errorf("%s: key %s expect %s, got %s, ok: %v, err: %v", family.Name(), key, expect, value, ok, err)
+			}
+		}
+
+		store, blobs, counters := open()
+
+		if names := store.ColumnFamilies(); fmt.Sprint(names) != "[default blobs counters]" {
+			t.Errorf("expect families [default blobs counters], got %v", names)
+		}
+
+		if _, err := store.ColumnFamily("missing"); err != UnknownColumnFamilyErr {
+			t.Errorf("expect %v, got %v", UnknownColumnFamilyErr, err)
+		}
+
+		if blobs.family.fileTable.Options.DataBlockSize != 64*1024 || store.fileTable.Options.DataBlockSize == 64*1024 {
+			t.Error("only blobs should write 64KB data blocks")
+		}
+
+		// the same key in every family
+		_ = store.Put([]byte("key"), []byte("default"))
+		_ = blobs.Put([]byte("key"), []byte("blob"))
+
+		if err := blobs.Merge([]byte("hits"), []byte("1")); err != NoMergeOperatorErr {
+			t.Errorf("blobs has no merge operator, expect %v, got %v", NoMergeOperatorErr, err)
+		}
+
+		batch := NewWriteBatch()
+		batch.Put([]byte("meta"), []byte("v1"))
+		batch.PutCF(blobs, []byte("blob/1"), []byte("data"))
+		batch.MergeCF(counters, []byte("hits"), []byte("1"))
+
+		if err := store.Write(batch); err != nil {
+			t.Error("error writing batch", err)
+		}
+		batchSeq := store.visible.Load()
+
+		expectValue(t, store, "key", "default")
+		expectValue(t, store, "meta", "v1")
+		expectMissing(t, store, "blob/1")
+		expectFamilyValue(blobs, "key", "blob")
+		expectFamilyValue(blobs, "blob/1", "data")
+		expectFamilyValue(counters, "hits", "1")
+
+		// counters is flushed alone, the wal is kept for the other families
+		store.rotateMemTable(counters.family, true)
+		if err := store.flushImmutable(); err != nil {
+			t.Error("error flushing counters", err)
+		}
+
+		if len(counters.Metadata().SStableLevel0) != 1 || len(store.Metadata().SStableLevel0) != 0 {
+			t.Errorf("expect a level 0 table of counters only, got %v and %v", counters.Metadata(), store.Metadata())
+		}
+
+		_ = counters.Merge([]byte("hits"), []byte("2"))
+		_, _ = blobs.Delete([]byte("key"))
+
+		iterator, err := blobs.Scan(nil, nil)
+		if err != nil {
+			t.Fatal("error scanning", err)
+		}
+
+		var scanned []string
+		for iterator.Next() {
+			scanned = append(scanned, fmt.Sprintf("%s=%s", iterator.Key(), iterator.Value()))
+		}
+		_ = iterator.Close()
+
+		if fmt.Sprint(scanned) != "[blob/1=data]" {
+			t.Errorf("expect blobs to scan [blob/1=data], got %v", scanned)
+		}
+
+		_ = store.Close()
+
+		// the batch is replayed into the families that did not flush it
+		store, blobs, counters = open()
+
+		if _, ok, _ := counters.family.memTables().active.GetVersion([]byte("hits"), batchSeq, 0); ok {
+			t.Error("the batch should not be replayed into counters, it flushed it")
+		}
+
+		expectValue(t, store, "key", "default")
+		expectFamilyValue(counters, "hits", "3")
+		expectFamilyValue(blobs, "blob/1", "data")
+
+		if ok, _ := blobs.Exists([]byte("key")); ok {
+			t.Error("key should be deleted from blobs")
+		}
+
+		if err = store.Flush(); err != nil {
+			t.Error("error flushing", err)
+		}
+		_ = store.Close()
+
+		// families keep their ids without their options
+		store, err = NewCliftonDBKVStore(dirPath, dirPath)
+		if err != nil {
+			t.Fatal("error opening store", err)
+		}
+		defer store.Close()
+
+		blobs, _ = store.ColumnFamily("blobs")
+		expectFamilyValue(blobs, "blob/1", "data")
+		expectValue(t, store, "meta", "v1")
+
+		if _, err = NewCliftonDBKVStore(path.Join(dirPath, "other"), dirPath, WithColumnFamily(DefaultColumnFamily)); err != InvalidColumnFamilyNameErr {
+			t.Errorf("expect %v, got %v", InvalidColumnFamilyNameErr, err)
+		}
+	})
+}
+
+var prepareBatchFailedErr = errors.New("prepare batch failed")
+
+// failingMemTable fails every batch written to it
+type failingMemTable struct {
+	tables.MemTable
+}
+
+func (m *failingMemTable) PrepareBatch(entries []tables.BatchEntry, seq uint64) (func(), error) {
+	return nil, prepareBatchFailedErr
+}
+
+// a batch is shown in every family it writes to or in none of them
+func TestCliftonDBKVStore_WriteBatchFamilyFails(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		store, err := NewCliftonDBKVStore(dirPath, dirPath, WithColumnFamily("failing"))
+		if err != nil {
+			t.Error("error creating store", err)
+			return
+		}
+		defer store.Close()
+
+		failing, err := store.ColumnFamily("failing")
+		if err != nil {
+			t.Fatal("error opening column family", err)
+		}
+
+		family := failing.family
+		family.memtablesLock.Lock()
+		family.setMemTables(&memTableSet{active: &failingMemTable{family.memTables().active}})
+		family.memtablesLock.Unlock()
+
+		batch := NewWriteBatch()
+		batch.Put([]byte("applied"), []byte("value"))
+		batch.PutCF(failing, []byte("failed"), []byte("value"))
+
+		if err = store.Write(batch); err != prepareBatchFailedErr {
+			t.Errorf("expect %v, got %v", prepareBatchFailedErr, err)
+		}
+
+		expectMissing(t, store, "applied")
+
+		// later writes are visible
+		_ = store.Put([]byte("next"), []byte("value"))
+		expectValue(t, store, "next", "value")
+	})
+}
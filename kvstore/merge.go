@@ -0,0 +1,47 @@
+package kvstore
+
+import (
+	"errors"
+	"github.com/zl14917/MastersProject/kvstore/types"
+	"github.com/zl14917/MastersProject/kvstore/wal"
+)
+
+var NoMergeOperatorErr = errors.New("kv-store has no merge operator")
+
+// Merge writes operand as a version of key of its own, reads fold it into the older
+// versions with the store's merge operator. Read-modify-write updates such as counters
+// take a single write without reading the current value.
+func (s *CliftonDBKVStore) Merge(key types.KeyType, operand types.ValueType) error {
+	if s.options.MergeOperator == nil {
+		return NoMergeOperatorErr
+	}
+
+	err := s.beginWrite(s.columnFamily)
+	if err != nil {
+		return err
+	}
+	defer s.endWrite(s.columnFamily)
+
+	seq, err := s.appendToWAL(wal.MergeKey, key, operand)
+	if err != nil {
+		return err
+	}
+
+	err = s.memTables().active.Merge(key, operand, seq)
+	s.visible.Publish(seq)
+	return err
+}
+
+// fold combines the versions of key read, newest first, with the merge operator of
+// the family. A single version is a value, or an operand that had no value to merge into.
+func (f *columnFamily) fold(key types.KeyType, values []types.ValueType) (types.ValueType, bool, error) {
+	if len(values) == 1 {
+		return values[0], true, nil
+	}
+
+	if f.options.MergeOperator == nil {
+		return nil, false, NoMergeOperatorErr
+	}
+
+	return f.options.MergeOperator.Fold(key, values), true, nil
+}
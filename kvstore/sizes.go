@@ -1,5 +1,11 @@
 package kvstore
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 const (
 	B uint64 = 1 << (iota * 10)
 	KB
@@ -9,3 +15,38 @@ const (
 	PB
 	EB
 )
+
+var sizeUnits = []struct {
+	suffix string
+	size   uint64
+}{
+	{"EB", EB},
+	{"PB", PB},
+	{"TB", TB},
+	{"GB", GB},
+	{"MB", MB},
+	{"KB", KB},
+	{"B", B},
+}
+
+// ParseSize reads sizes written in configuration files, such as "16MB" or "512KB".
+// A plain number is taken as bytes.
+func ParseSize(size string) (uint64, error) {
+	str := strings.ToUpper(strings.TrimSpace(size))
+	unit := B
+
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(str, u.suffix) {
+			str = strings.TrimSpace(strings.TrimSuffix(str, u.suffix))
+			unit = u.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseUint(str, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %v", size, err)
+	}
+
+	return n * unit, nil
+}
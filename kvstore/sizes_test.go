@@ -0,0 +1,25 @@
+package kvstore
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	input := []string{"0", "512", "4KB", "16MB", "1gb", " 2 GB "}
+	expect := []uint64{0, 512, 4 * KB, 16 * MB, GB, 2 * GB}
+
+	for i, str := range input {
+		size, err := ParseSize(str)
+		if err != nil {
+			t.Errorf("ParseSize(%q) returned error: %v", str, err)
+			continue
+		}
+
+		if size != expect[i] {
+			t.Errorf("ParseSize(%q) expect %d got %d", str, expect[i], size)
+		}
+	}
+
+	_, err := ParseSize("16XB")
+	if err == nil {
+		t.Error("unknown unit should return error")
+	}
+}
@@ -0,0 +1,88 @@
+package kvstore
+
+import (
+	"github.com/zl14917/MastersProject/kvstore/types"
+	"sync"
+	"sync/atomic"
+)
+
+// Writes take the index of their wal record as sequence number. Concurrent
+// writes reach the memtable out of order, visibleSequence only moves past a
+// sequence number once every write up to it is applied, so reads never see
+// a write without the ones committed before it.
+type visibleSequence struct {
+	sync.Mutex
+	visible uint64
+	applied map[uint64]bool
+}
+
+func newVisibleSequence(visible uint64) *visibleSequence {
+	return &visibleSequence{
+		visible: visible,
+		applied: make(map[uint64]bool),
+	}
+}
+
+func (v *visibleSequence) Load() uint64 {
+	v.Lock()
+	defer v.Unlock()
+	return v.visible
+}
+
+// Publish marks seq applied to the memtable.
+func (v *visibleSequence) Publish(seq uint64) {
+	v.Lock()
+	defer v.Unlock()
+
+	if seq <= v.visible {
+		return
+	}
+
+	v.applied[seq] = true
+
+	for v.applied[v.visible+1] {
+		delete(v.applied, v.visible+1)
+		v.visible++
+	}
+}
+
+// Snapshot reads the store as it was when the snapshot was taken,
+// later writes are not visible through it. Compaction keeps the versions
+// a snapshot reads until it is released.
+type Snapshot struct {
+	store    *CliftonDBKVStore
+	seq      uint64
+	now      int64
+	released int32
+}
+
+// Snapshot pins the current state of the store, callers must Release it.
+// Keys that expire later are still read through it.
+func (s *CliftonDBKVStore) Snapshot() *Snapshot {
+	seq, now := s.visible.Load(), s.fileTable.Clock.Now()
+	s.fileTable.Snapshots.Acquire(seq, now)
+
+	return &Snapshot{store: s, seq: seq, now: now}
+}
+
+// Sequence is the sequence number of the newest write the snapshot sees.
+func (snapshot *Snapshot) Sequence() uint64 {
+	return snapshot.seq
+}
+
+func (snapshot *Snapshot) Get(key types.KeyType) (data types.ValueType, ok bool, err error) {
+	return snapshot.store.get(key, snapshot.seq, snapshot.now)
+}
+
+// Scan iterates keys in [start, end) as of the snapshot.
+func (snapshot *Snapshot) Scan(start types.KeyType, end types.KeyType) (*Iterator, error) {
+	return snapshot.store.scan(start, end, snapshot.seq, snapshot.now)
+}
+
+// Release lets compaction drop versions only the snapshot reads,
+// iterators opened from it stay valid until closed.
+func (snapshot *Snapshot) Release() {
+	if atomic.CompareAndSwapInt32(&snapshot.released, 0, 1) {
+		snapshot.store.fileTable.Snapshots.Release(snapshot.seq, snapshot.now)
+	}
+}
@@ -0,0 +1,171 @@
+package sstable
+
+import (
+	"bytes"
+	"container/list"
+	"github.com/zl14917/MastersProject/kvstore/blockstore"
+	"sync"
+	"sync/atomic"
+)
+
+// BlockCache keeps verified block payloads of index and data files in memory,
+// least recently used blocks are evicted once capacity bytes are cached.
+// One cache can be shared by every table of a store, or by all stores of a server.
+type BlockCache struct {
+	sync.Mutex
+
+	capacity int
+	size     int
+	lru      *list.List
+	blocks   map[blockCacheKey]*list.Element
+
+	nextID    uint64
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+// blocks are keyed by the file they were read from and their index in it,
+// every opened file takes a new id so paths of removed tables are never confused.
+type blockCacheKey struct {
+	file  uint64
+	block uint
+}
+
+type blockCacheEntry struct {
+	key  blockCacheKey
+	data []byte
+}
+
+type BlockCacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	// bytes of block payloads cached
+	Size     int
+	Capacity int
+}
+
+func NewBlockCache(capacity int) *BlockCache {
+	return &BlockCache{
+		capacity: capacity,
+		lru:      list.New(),
+		blocks:   make(map[blockCacheKey]*list.Element),
+	}
+}
+
+// NewID returns an id for a file whose blocks are cached.
+func (c *BlockCache) NewID() uint64 {
+	return atomic.AddUint64(&c.nextID, 1)
+}
+
+// Get returns the cached payload of block in file, callers must not modify it.
+func (c *BlockCache) Get(file uint64, block uint) ([]byte, bool) {
+	c.Lock()
+	element, ok := c.blocks[blockCacheKey{file, block}]
+	if ok {
+		c.lru.MoveToFront(element)
+	}
+	c.Unlock()
+
+	if !ok {
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false
+	}
+
+	atomic.AddUint64(&c.hits, 1)
+	return element.Value.(*blockCacheEntry).data, true
+}
+
+// Insert caches a copy of the payload of block in file.
+func (c *BlockCache) Insert(file uint64, block uint, payload []byte) {
+	if len(payload) > c.capacity {
+		return
+	}
+
+	key := blockCacheKey{file, block}
+	data := make([]byte, len(payload))
+	copy(data, payload)
+
+	c.Lock()
+	defer c.Unlock()
+
+	if element, ok := c.blocks[key]; ok {
+		entry := element.Value.(*blockCacheEntry)
+		c.size += len(data) - len(entry.data)
+		entry.data = data
+		c.lru.MoveToFront(element)
+	} else {
+		c.blocks[key] = c.lru.PushFront(&blockCacheEntry{key: key, data: data})
+		c.size += len(data)
+	}
+
+	for c.size > c.capacity {
+		c.evict(c.lru.Back())
+	}
+}
+
+// EraseFile drops every cached block of file.
+func (c *BlockCache) EraseFile(file uint64) {
+	c.Lock()
+	defer c.Unlock()
+
+	for key, element := range c.blocks {
+		if key.file == file {
+			c.remove(element)
+		}
+	}
+}
+
+func (c *BlockCache) evict(element *list.Element) {
+	c.remove(element)
+	atomic.AddUint64(&c.evictions, 1)
+}
+
+func (c *BlockCache) remove(element *list.Element) {
+	entry := element.Value.(*blockCacheEntry)
+	c.lru.Remove(element)
+	delete(c.blocks, entry.key)
+	c.size -= len(entry.data)
+}
+
+func (c *BlockCache) Stats() BlockCacheStats {
+	c.Lock()
+	size := c.size
+	c.Unlock()
+
+	return BlockCacheStats{
+		Hits:      atomic.LoadUint64(&c.hits),
+		Misses:    atomic.LoadUint64(&c.misses),
+		Evictions: atomic.LoadUint64(&c.evictions),
+		Size:      size,
+		Capacity:  c.capacity,
+	}
+}
+
+// cachedBlockReader reads checksummed blocks of one file through cache,
+// a nil cache reads every block from storage.
+type cachedBlockReader struct {
+	cache  *BlockCache
+	fileID uint64
+}
+
+func (r cachedBlockReader) readBlock(storage blockstore.BlockStorage, filePath string, index uint, buffer *bytes.Buffer) error {
+	if r.cache == nil {
+		return readChecksummedBlock(storage, filePath, index, buffer)
+	}
+
+	if payload, ok := r.cache.Get(r.fileID, index); ok {
+		buffer.Reset()
+		buffer.Write(payload)
+		return nil
+	}
+
+	err := readChecksummedBlock(storage, filePath, index, buffer)
+	if err != nil {
+		return err
+	}
+
+	r.cache.Insert(r.fileID, index, buffer.Bytes())
+	return nil
+}
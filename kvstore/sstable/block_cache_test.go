@@ -0,0 +1,123 @@
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestBlockCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewBlockCache(30)
+	file := cache.NewID()
+
+	for i := uint(0); i < 3; i++ {
+		cache.Insert(file, i, bytes.Repeat([]byte{byte(i)}, 10))
+	}
+
+	// block 0 becomes the most recently used, block 1 is evicted next
+	if data, ok := cache.Get(file, 0); !ok || !bytes.Equal(data, bytes.Repeat([]byte{0}, 10)) {
+		t.Fatalf("block 0 should be cached, got %v, %v", data, ok)
+	}
+
+	cache.Insert(file, 3, bytes.Repeat([]byte{3}, 10))
+
+	if _, ok := cache.Get(file, 1); ok {
+		t.Error("block 1 should be evicted")
+	}
+
+	for _, block := range []uint{0, 2, 3} {
+		if _, ok := cache.Get(file, block); !ok {
+			t.Errorf("block %d should be cached", block)
+		}
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 4 || stats.Misses != 1 || stats.Evictions != 1 || stats.Size != 30 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	cache.EraseFile(file)
+	if stats = cache.Stats(); stats.Size != 0 {
+		t.Errorf("erased file should free its blocks, got %+v", stats)
+	}
+}
+
+func TestBlockCache_KeyedByFile(t *testing.T) {
+	cache := NewBlockCache(1024)
+	first, second := cache.NewID(), cache.NewID()
+
+	cache.Insert(first, 0, []byte("first"))
+	cache.Insert(second, 0, []byte("second"))
+
+	if data, _ := cache.Get(first, 0); string(data) != "first" {
+		t.Errorf("expect first, got %s", data)
+	}
+
+	if data, _ := cache.Get(second, 0); string(data) != "second" {
+		t.Errorf("expect second, got %s", data)
+	}
+}
+
+func TestSSTable_ReadsThroughBlockCache(t *testing.T) {
+	var options = defaultSSTableOpenOptions
+	options.InMemStore = true
+	options.IndexBlockSize = 256
+	options.DataBlockSize = 256
+	options.BlockCache = NewBlockCache(64 * 1024)
+
+	sstable := NewSSTable("", &options)
+
+	writer, err := sstable.NewWriter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key_%03d", i)
+		if err = writer.Write([]byte(key), []byte("value_"+key), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = writer.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	find := func() {
+		reader, err := sstable.NewReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("key_%03d", i)
+			value, _, ok, err := reader.FindRecord([]byte(key))
+
+			if err != nil || !ok || string(value) != "value_"+key {
+				t.Errorf("key %s expect value %s, got %s, ok: %v, err: %v", key, "value_"+key, value, ok, err)
+			}
+		}
+	}
+
+	find()
+	misses := options.BlockCache.Stats().Misses
+
+	find()
+	stats := options.BlockCache.Stats()
+
+	if stats.Misses != misses {
+		t.Errorf("second pass should read every block from the cache, misses went from %d to %d", misses, stats.Misses)
+	}
+
+	if stats.Hits == 0 {
+		t.Errorf("expect cache hits, got %+v", stats)
+	}
+
+	if err = sstable.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if stats = options.BlockCache.Stats(); stats.Size != 0 {
+		t.Errorf("closed table should leave no cached blocks, got %+v", stats)
+	}
+}
@@ -0,0 +1,34 @@
+package sstable
+
+import (
+	"github.com/zl14917/MastersProject/kvstore/types"
+)
+
+type FilterDecision int
+
+const (
+	FilterKeep FilterDecision = iota
+	FilterDrop
+	// the record is kept with the value returned by the filter
+	FilterReplace
+)
+
+// CompactionFilter decides what compaction does with the newest version of a key.
+// It is called only for live versions no snapshot reads, and must not retain
+// key or value. Filters run on compaction goroutines concurrently with reads.
+type CompactionFilter func(key types.KeyType, value types.ValueType) (decision FilterDecision, newValue types.ValueType)
+
+// filterRecord applies filter to a live record. A dropped record becomes a tombstone,
+// older versions of its key in tables not merged must not read again.
+func filterRecord(filter CompactionFilter, record Record) Record {
+	decision, value := filter(record.Key, record.Value)
+
+	switch decision {
+	case FilterDrop:
+		return Record{Key: record.Key, Seq: record.Seq, Deleted: true}
+	case FilterReplace:
+		record.Value = value
+	}
+
+	return record
+}
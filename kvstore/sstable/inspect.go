@@ -0,0 +1,240 @@
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/zl14917/MastersProject/kvstore/blockstore"
+	"io"
+	"os"
+)
+
+// file headers fit in this many bytes, blocks of a valid table are larger
+const minInspectedBlockSize = 64
+
+// TableInfo describes the files of a table as they are on disk.
+type TableInfo struct {
+	IndexFilePath string
+	DataFilePath  string
+	IndexHeader   SSTableIndexFileHeader
+	DataHeader    SSTableDataFileHeader
+	IndexFileSize int64
+	DataFileSize  int64
+
+	// index entries found, tombstones among them
+	Entries    int
+	Tombstones int
+
+	// inconsistencies between the headers, the file sizes and the blocks,
+	// empty for a table that reads back as written
+	Problems []string
+}
+
+func (info *TableInfo) problemf(format string, args ...interface{}) {
+	info.Problems = append(info.Problems, fmt.Sprintf(format, args...))
+}
+
+// OpenTableFiles opens a table by the paths of its files for reading only,
+// block sizes are taken from the file headers.
+func OpenTableFiles(indexFilePath string, dataFilePath string) (*SSTable, error) {
+	var (
+		indexHeader SSTableIndexFileHeader
+		dataHeader  SSTableDataFileHeader
+	)
+
+	err := readHeaderBytes(indexFilePath, indexHeader.UnMarshall)
+	if err != nil {
+		return nil, fmt.Errorf("error reading index file header: %v", err)
+	}
+
+	err = readHeaderBytes(dataFilePath, dataHeader.UnMarshall)
+	if err != nil {
+		return nil, fmt.Errorf("error reading data file header: %v", err)
+	}
+
+	for _, blockSize := range []uint32{indexHeader.BlockSize, dataHeader.BlockSize} {
+		if blockSize < minInspectedBlockSize || blockSize == HeaderUninitialized {
+			return nil, fmt.Errorf("invalid block size %d in file header", blockSize)
+		}
+	}
+
+	table := &SSTable{
+		IndexFilePath:         indexFilePath,
+		DataFilePath:          dataFilePath,
+		MaxKeySize:            int(indexHeader.MaxKeySize),
+		IndexStorageBlockSize: int(indexHeader.BlockSize),
+		DataStoreBlockSize:    int(dataHeader.BlockSize),
+		loadExisting:          true,
+	}
+
+	table.indexStorage, err = blockstore.OpenBlockFile(
+		indexFilePath,
+		blockstore.WithBlockSize(table.IndexStorageBlockSize),
+		blockstore.WithReadOnly(),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	table.dataStorage, err = blockstore.OpenBlockFile(
+		dataFilePath,
+		blockstore.WithBlockSize(table.DataStoreBlockSize),
+		blockstore.WithReadOnly(),
+	)
+
+	if err != nil {
+		_ = table.Close()
+		return nil, err
+	}
+
+	return table, nil
+}
+
+// readHeaderBytes decodes the header at the start of the first block,
+// before the block size needed to verify the block is known.
+func readHeaderBytes(filePath string, unMarshall func(r io.Reader) error) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var buffer [minInspectedBlockSize]byte
+
+	_, err = io.ReadFull(file, buffer[:])
+	if err != nil {
+		return err
+	}
+
+	return unMarshall(bytes.NewReader(buffer[:]))
+}
+
+// InspectTable checks a table file by file and entry by entry. It returns an error
+// only if the tables can't be opened, everything found wrong is in Problems.
+func InspectTable(indexFilePath string, dataFilePath string) (info TableInfo, err error) {
+	info.IndexFilePath, info.DataFilePath = indexFilePath, dataFilePath
+
+	table, err := OpenTableFiles(indexFilePath, dataFilePath)
+	if err != nil {
+		return info, err
+	}
+	defer table.Close()
+
+	tableReader, err := table.NewReader()
+	if err != nil {
+		return info, err
+	}
+
+	reader := tableReader.(*sstableReaderStruct)
+	info.IndexHeader = reader.indexReader.header
+	info.DataHeader = reader.dataReader.header
+
+	info.IndexFileSize, err = fileSize(indexFilePath)
+	if err != nil {
+		return info, err
+	}
+
+	info.DataFileSize, err = fileSize(dataFilePath)
+	if err != nil {
+		return info, err
+	}
+
+	indexHeader, dataHeader := info.IndexHeader, info.DataHeader
+
+	info.checkBlocks("index", table.indexStorage, indexFilePath, info.IndexFileSize,
+		1+int64(indexHeader.BlockCount)+int64(indexHeader.FilterBlockCount))
+	info.checkBlocks("data", table.dataStorage, dataFilePath, info.DataFileSize, int64(dataHeader.BlockCount))
+
+	if indexHeader.Version > IndexFormatPrefixCompressed {
+		info.problemf("index: unknown format version %d", indexHeader.Version)
+	}
+
+	if indexHeader.Version == IndexFormatPrefixCompressed && indexHeader.RestartInterval == 0 {
+		info.problemf("index: prefix compressed without a restart interval")
+	}
+
+	if indexHeader.Flags&IndexFileHasFilter != 0 && indexHeader.FilterBlockCount == 0 {
+		info.problemf("index: has filter flag set but no filter blocks")
+	}
+
+	info.checkEntries(reader)
+
+	if info.Entries != int(indexHeader.KeyCount) {
+		info.problemf("index: header key count %d, found %d entries", indexHeader.KeyCount, info.Entries)
+	}
+
+	if values := info.Entries - info.Tombstones; values != int(dataHeader.ValuesCount) {
+		info.problemf("data: header values count %d, index refers to %d values", dataHeader.ValuesCount, values)
+	}
+
+	return info, nil
+}
+
+func fileSize(filePath string) (int64, error) {
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return 0, err
+	}
+	return stat.Size(), nil
+}
+
+// checkBlocks compares the blocks of a file to the count in its header and verifies their checksums.
+func (info *TableInfo) checkBlocks(name string, storage blockstore.BlockStorage, filePath string, size int64, expected int64) {
+	blockSize := int64(storage.BlockSize())
+
+	if size%blockSize != 0 {
+		info.problemf("%s: file size %d is not a multiple of block size %d", name, size, blockSize)
+	}
+
+	// a partial block at the end counts as one
+	blocks := (size + blockSize - 1) / blockSize
+
+	if blocks != expected {
+		info.problemf("%s: header accounts for %d blocks, file holds %d", name, expected, blocks)
+	}
+
+	buffer := bytes.NewBuffer(nil)
+
+	for block := uint(0); block < uint(blocks); block++ {
+		err := readChecksummedBlock(storage, filePath, block, buffer)
+		if err != nil {
+			info.problemf("%s: block %d: %v", name, block, err)
+		}
+	}
+}
+
+// checkEntries reads every index entry and the value it refers to,
+// versions must be ordered by key, then by descending sequence number.
+func (info *TableInfo) checkEntries(reader *sstableReaderStruct) {
+	var prev *SSTableIndexEntry
+
+	for block := uint(1); block <= uint(reader.indexReader.header.BlockCount); block++ {
+		entries, err := reader.indexReader.readEntries(block)
+		if err != nil {
+			info.problemf("index: block %d: %v", block, err)
+			continue
+		}
+
+		for i := range entries {
+			entry := &entries[i]
+			info.Entries++
+
+			if entry.Deleted() {
+				info.Tombstones++
+			}
+
+			if prev != nil {
+				cmp := bytes.Compare(prev.LargeKey, entry.LargeKey)
+				if cmp > 0 || (cmp == 0 && prev.Seq <= entry.Seq) {
+					info.problemf("index: block %d: key %q at %d is out of order after %q at %d",
+						block, entry.LargeKey, entry.Seq, prev.LargeKey, prev.Seq)
+				}
+			}
+			prev = entry
+
+			if _, err = reader.readRecord(entry); err != nil {
+				info.problemf("data: value of key %q at %d: %v", entry.LargeKey, entry.Seq, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package sstable
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestInspectTable(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		var options = defaultSSTableOpenOptions
+		options.Timestamp = 1
+		options.IndexBlockSize = 256
+		options.DataBlockSize = 256
+
+		table := NewSSTable(dirPath, &options)
+
+		writer, err := table.NewWriter()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 100; i++ {
+			key := []byte(fmt.Sprintf("key_%03d", i))
+			_ = writer.WriteVersion(key, []byte("value"), uint64(i+1), i%10 == 0)
+		}
+
+		if err = writer.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		_ = table.Close()
+
+		info, err := InspectTable(table.IndexFilePath, table.DataFilePath)
+		if err != nil {
+			t.Fatal("error inspecting table", err)
+		}
+
+		if len(info.Problems) > 0 || info.Entries != 100 || info.Tombstones != 10 {
+			t.Errorf("expect 100 entries, 10 tombstones and no problems, got %+v", info)
+		}
+
+		// a torn block at the end of the data file and a flipped byte in the index
+		data, err := os.OpenFile(table.DataFilePath, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = data.Write([]byte("torn"))
+		_ = data.Close()
+
+		index, err := os.OpenFile(table.IndexFilePath, os.O_RDWR, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = index.WriteAt([]byte{0xff}, int64(options.IndexBlockSize)+64)
+		_ = index.Close()
+
+		info, err = InspectTable(table.IndexFilePath, table.DataFilePath)
+		if err != nil {
+			t.Fatal("error inspecting damaged table", err)
+		}
+
+		problems := strings.Join(info.Problems, "\n")
+
+		for _, expect := range []string{"not a multiple of block size", "file holds", "index: block 1"} {
+			if !strings.Contains(problems, expect) {
+				t.Errorf("expect a problem with %q, got:\n%s", expect, problems)
+			}
+		}
+	})
+}
@@ -0,0 +1,23 @@
+package sstable
+
+import (
+	"github.com/zl14917/MastersProject/kvstore/types"
+)
+
+// MergeOperator combines two values of a key, the older one first. The older one is
+// an operand or the value operands are merged into, the newer one is an operand.
+// It must be associative: reads and compactions combine operands in whatever groups
+// they find them in. It must not retain or modify its arguments.
+type MergeOperator func(key types.KeyType, older types.ValueType, newer types.ValueType) types.ValueType
+
+// Fold combines values of key, newest first. The oldest one is the value the operands
+// are merged into, or the oldest operand if the key had no value before them.
+func (m MergeOperator) Fold(key types.KeyType, values []types.ValueType) types.ValueType {
+	folded := values[len(values)-1]
+
+	for i := len(values) - 2; i >= 0; i-- {
+		folded = m(key, folded, values[i])
+	}
+
+	return folded
+}
@@ -1,7 +1,353 @@
 package sstable
 
-type MergeCallback func(success bool, err error)
+import (
+	"bytes"
+	"container/heap"
+	"github.com/zl14917/MastersProject/kvstore/types"
+	"io"
+	"sort"
+)
 
-func MergeTables(source []SSTable, destination *SSTable, callback MergeCallback) {
+type MergeOptions struct {
+	// tombstones are only dropped when no older table below
+	// the merged ones can hold the key
+	DropTombstones bool
+	// a new destination table is started once one holds about this many bytes,
+	// 0 writes a single table. Versions of a key are never split over tables.
+	MaxTableBytes int64
+	// sequence numbers of live snapshots in ascending order, the newest
+	// version of a key each of them reads is kept
+	Snapshots []uint64
+	// unix nanoseconds, records expired by then are compacted like tombstones.
+	// It must not be later than the time any live snapshot reads at.
+	Now int64
+	// versions a tombstone deletes are dropped unless a snapshot
+	// older than the tombstone reads them
+	RangeTombstones []RangeTombstone
+	// applied to the newest version of every key no snapshot reads, nil keeps them all
+	Filter CompactionFilter
+	// folds merge operands into the version they are merged into, without it
+	// operands and every version older than them are kept
+	MergeOperator MergeOperator
+}
+
+// NewTableFunc creates an empty destination table for MergeTables.
+type NewTableFunc func() (*SSTable, error)
+
+// approximate per record overhead of index and data entries
+const mergeRecordOverhead = int64(indexEntryHeaderSize + SSTableDataRecordHeaderSize)
+
+type mergeSource struct {
+	reader SSTableReader
+	// position in the sources, lower is newer
+	age int
+
+	Record
+}
+
+func (s *mergeSource) next() (ok bool, err error) {
+	s.Record, err = s.reader.ReadNext()
+	if err == io.EOF {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+type mergeHeap []*mergeSource
+
+func (h mergeHeap) Len() int { return len(h) }
+
+func (h mergeHeap) Less(i, j int) bool {
+	cmp := bytes.Compare(h[i].Key, h[j].Key)
+	if cmp != 0 {
+		return cmp < 0
+	}
+
+	if h[i].Seq != h[j].Seq {
+		return h[i].Seq > h[j].Seq
+	}
+	return h[i].age < h[j].age
+}
+
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *mergeHeap) Push(x interface{}) {
+	*h = append(*h, x.(*mergeSource))
+}
+
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	source := old[n-1]
+	*h = old[0 : n-1]
+	return source
+}
+
+type mergeOutput struct {
+	newTable NewTableFunc
+	options  MergeOptions
+
+	tables  []*SSTable
+	writer  SSTableWriter
+	written int64
+	lastKey types.KeyType
+}
+
+func (o *mergeOutput) write(record Record) error {
+	full := o.options.MaxTableBytes > 0 && o.written >= o.options.MaxTableBytes
+
+	if full && !bytes.Equal(record.Key, o.lastKey) {
+		err := o.finishTable()
+		if err != nil {
+			return err
+		}
+	}
+
+	if o.writer == nil {
+		table, err := o.newTable()
+		if err != nil {
+			return err
+		}
+
+		o.tables = append(o.tables, table)
+
+		o.writer, err = table.NewWriter()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := o.writer.WriteRecord(record)
+	if err != nil {
+		return err
+	}
+
+	o.written += int64(len(record.Key)+len(record.Value)) + mergeRecordOverhead
+	o.lastKey = record.Key
+
+	return nil
+}
+
+// writeNewest writes the newest version of a key in a snapshot stripe,
+// unless no read needs it any more.
+func (o *mergeOutput) writeNewest(record Record, stripe int) error {
+	options := &o.options
+
+	// an expired record still hides older versions of its key
+	if record.Expired(options.Now) {
+		record = Record{Key: record.Key, Seq: record.Seq, Deleted: true}
+	}
+
+	rangeDropped := rangeDeleted(options, &record, stripe)
+
+	if options.Filter != nil && !record.Deleted && !record.MergeOperand && !rangeDropped && stripe == len(options.Snapshots) {
+		record = filterRecord(options.Filter, record)
+	}
+
+	// a tombstone only hides older versions once no snapshot reads them
+	if rangeDropped || (record.Deleted && options.DropTombstones && stripe == 0) {
+		return nil
+	}
+
+	return o.write(record)
+}
+
+// mergeFold collects the operands of a key in one snapshot stripe, newest first,
+// until the version they are merged into is found.
+type mergeFold struct {
+	key    types.KeyType
+	seq    uint64
+	stripe int
+	values []types.ValueType
+}
+
+func newMergeFold(record *Record, stripe int) *mergeFold {
+	fold := &mergeFold{key: copyBytes(record.Key), seq: record.Seq, stripe: stripe}
+	fold.add(record.Value)
+	return fold
+}
+
+func (f *mergeFold) add(value types.ValueType) {
+	f.values = append(f.values, copyBytes(value))
+}
+
+// writeFold writes the folded operands as one record. A complete fold found the
+// version the operands are merged into, or the key has no older versions, and
+// is written as a value. Otherwise the result is an operand itself.
+func (o *mergeOutput) writeFold(fold *mergeFold, complete bool) error {
+	record := Record{
+		Key:          fold.key,
+		Value:        o.options.MergeOperator.Fold(fold.key, fold.values),
+		Seq:          fold.seq,
+		MergeOperand: !complete,
+	}
+
+	if complete && o.options.Filter != nil && fold.stripe == len(o.options.Snapshots) {
+		record = filterRecord(o.options.Filter, record)
+	}
+
+	if record.Deleted && o.options.DropTombstones && fold.stripe == 0 {
+		return nil
+	}
+
+	return o.write(record)
+}
+
+func copyBytes(b []byte) []byte {
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
+
+func (o *mergeOutput) finishTable() error {
+	if o.writer == nil {
+		return nil
+	}
+
+	err := o.writer.Commit()
+	o.writer = nil
+	o.written = 0
+	return err
+}
+
+func (o *mergeOutput) removeAll() {
+	for _, table := range o.tables {
+		_ = table.PermanentlyRemove()
+	}
+	o.tables = nil
+}
+
+// snapshotStripe is the number of snapshots older than seq. Of the versions
+// of a key in the same stripe, every snapshot reads the newest or a later one.
+func snapshotStripe(snapshots []uint64, seq uint64) int {
+	return sort.Search(len(snapshots), func(i int) bool {
+		return snapshots[i] >= seq
+	})
+}
+
+// rangeDeleted is true if a tombstone deletes record and no snapshot reads the record
+// without the tombstone, that is both are in the same snapshot stripe.
+func rangeDeleted(options *MergeOptions, record *Record, stripe int) bool {
+	for i := range options.RangeTombstones {
+		tombstone := &options.RangeTombstones[i]
+
+		if tombstone.Covers(record.Key, record.Seq) && snapshotStripe(options.Snapshots, tombstone.Seq) == stripe {
+			return true
+		}
+	}
+	return false
+}
+
+// MergeTables merges sorted sources, ordered newest first, into new tables.
+// The newest version of every key is kept, with older versions still read
+// by live snapshots. Merge operands are folded into the versions they are
+// merged into, or into one operand if those are not merged. Outputs are committed and open for reading, on error
+// the ones already written are removed.
+func MergeTables(sources []SSTableReader, newTable NewTableFunc, options MergeOptions) ([]*SSTable, error) {
+	var (
+		h      = make(mergeHeap, 0, len(sources))
+		output = &mergeOutput{newTable: newTable, options: options}
+	)
+
+	for age, reader := range sources {
+		source := &mergeSource{reader: reader, age: age}
+
+		ok, err := source.next()
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			h = append(h, source)
+		}
+	}
+
+	heap.Init(&h)
+
+	var (
+		lastKey    types.KeyType
+		lastStripe int
+		// operands of lastKey in lastStripe waiting for the version they merge into
+		fold *mergeFold
+	)
+
+	for h.Len() > 0 {
+		source := h[0]
+		record := source.Record
+		stripe := snapshotStripe(options.Snapshots, record.Seq)
+
+		var err error
+
+		if fold != nil && bytes.Equal(record.Key, lastKey) && stripe == lastStripe {
+			live := !record.Deleted && !record.Expired(options.Now) && !rangeDeleted(&options, &record, stripe)
+
+			if live {
+				fold.add(record.Value)
+			}
+
+			// older versions of the stripe are shadowed by the folded record
+			if !live || !record.MergeOperand {
+				err = output.writeFold(fold, true)
+				fold = nil
+			}
+		} else {
+			if fold != nil {
+				// older versions of the key are in older stripes or in tables not merged,
+				// there are none once the key ended if tombstones can be dropped
+				err = output.writeFold(fold, options.DropTombstones && !bytes.Equal(record.Key, lastKey))
+				fold = nil
+			}
+
+			// older versions of the key that no snapshot reads
+			shadowed := lastKey != nil && bytes.Equal(record.Key, lastKey) && stripe == lastStripe
+
+			if err == nil && !shadowed {
+				lastKey, lastStripe = record.Key, stripe
+
+				if record.MergeOperand && options.MergeOperator != nil && !rangeDeleted(&options, &record, stripe) {
+					fold = newMergeFold(&record, stripe)
+				} else {
+					err = output.writeNewest(record, stripe)
+				}
+
+				// without an operator older versions are still read through the operand
+				if record.MergeOperand && options.MergeOperator == nil {
+					lastKey = nil
+				}
+			}
+		}
+
+		if err != nil {
+			output.removeAll()
+			return nil, err
+		}
+
+		ok, err := source.next()
+		if err != nil {
+			output.removeAll()
+			return nil, err
+		}
+
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+
+	if fold != nil {
+		err := output.writeFold(fold, options.DropTombstones)
+		if err != nil {
+			output.removeAll()
+			return nil, err
+		}
+	}
+
+	err := output.finishTable()
+	if err != nil {
+		output.removeAll()
+		return nil, err
+	}
 
+	return output.tables, nil
 }
@@ -0,0 +1,497 @@
+package sstable
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/zl14917/MastersProject/kvstore/types"
+	"io"
+	"testing"
+)
+
+type testRecord struct {
+	key     string
+	value   string
+	deleted bool
+}
+
+func newInMemTable(t *testing.T, records []testRecord) *SSTable {
+	var options = defaultSSTableOpenOptions
+	options.InMemStore = true
+	options.IndexBlockSize = 256
+	options.DataBlockSize = 256
+
+	table := NewSSTable("", &options)
+
+	if records == nil {
+		return table
+	}
+
+	writer, err := table.NewWriter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, record := range records {
+		err = writer.Write([]byte(record.key), []byte(record.value), record.deleted)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = writer.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return table
+}
+
+func readAll(t *testing.T, table *SSTable) []testRecord {
+	reader, err := table.NewReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var records []testRecord
+	for {
+		record, err := reader.ReadNext()
+		if err == io.EOF {
+			return records
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		records = append(records, testRecord{string(record.Key), string(record.Value), record.Deleted})
+	}
+}
+
+func mergeTestTables(t *testing.T, options MergeOptions) []*SSTable {
+	var newer, older []testRecord
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key_%03d", i)
+
+		if i%2 == 0 {
+			newer = append(newer, testRecord{key, "new_" + key, i%10 == 0})
+		}
+		older = append(older, testRecord{key, "old_" + key, false})
+	}
+
+	var sources []SSTableReader
+	for _, records := range [][]testRecord{newer, older} {
+		reader, err := newInMemTable(t, records).NewReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources = append(sources, reader)
+	}
+
+	outputs, err := MergeTables(sources, func() (*SSTable, error) {
+		return newInMemTable(t, nil), nil
+	}, options)
+
+	if err != nil {
+		t.Fatal("error merging tables", err)
+	}
+
+	return outputs
+}
+
+func TestMergeTables(t *testing.T) {
+	outputs := mergeTestTables(t, MergeOptions{MaxTableBytes: 1024})
+
+	if len(outputs) < 2 {
+		t.Errorf("merge output should be split by size, got %d tables", len(outputs))
+	}
+
+	var records []testRecord
+	for _, output := range outputs {
+		records = append(records, readAll(t, output)...)
+	}
+
+	if len(records) != 100 {
+		t.Errorf("expect 100 merged records, got %d", len(records))
+		return
+	}
+
+	for i, record := range records {
+		key := fmt.Sprintf("key_%03d", i)
+		expect := testRecord{key, "old_" + key, false}
+
+		if i%10 == 0 {
+			expect = testRecord{key, "", true}
+		} else if i%2 == 0 {
+			expect.value = "new_" + key
+		}
+
+		if record != expect {
+			t.Errorf("record %d expect %v, got %v", i, expect, record)
+		}
+	}
+}
+
+func TestMergeTables_DropTombstones(t *testing.T) {
+	outputs := mergeTestTables(t, MergeOptions{DropTombstones: true})
+
+	if len(outputs) != 1 {
+		t.Errorf("expect a single output table, got %d", len(outputs))
+		return
+	}
+
+	records := readAll(t, outputs[0])
+	if len(records) != 90 {
+		t.Errorf("expect 90 records without tombstones, got %d", len(records))
+	}
+
+	for _, record := range records {
+		if record.deleted {
+			t.Errorf("tombstone of %s should be dropped", record.key)
+		}
+	}
+}
+
+type testVersion struct {
+	key     string
+	seq     uint64
+	deleted bool
+}
+
+func TestMergeTables_KeepsSnapshotVersions(t *testing.T) {
+	tables := [][]testVersion{
+		{{"a", 20, false}, {"b", 25, true}, {"d", 30, false}},
+		{{"a", 10, false}, {"c", 8, true}, {"d", 25, false}},
+		{{"b", 5, false}, {"c", 4, false}},
+	}
+
+	var sources []SSTableReader
+	for _, versions := range tables {
+		table := newInMemTable(t, nil)
+
+		writer, err := table.NewWriter()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, version := range versions {
+			err = writer.WriteVersion([]byte(version.key), []byte(fmt.Sprint(version.seq)), version.seq, version.deleted)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err = writer.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		reader, err := table.NewReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources = append(sources, reader)
+	}
+
+	outputs, err := MergeTables(sources, func() (*SSTable, error) {
+		return newInMemTable(t, nil), nil
+	}, MergeOptions{DropTombstones: true, Snapshots: []uint64{12}})
+
+	if err != nil {
+		t.Fatal("error merging tables", err)
+	}
+
+	reader, err := outputs[0].NewReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var merged []testVersion
+	for {
+		record, err := reader.ReadNext()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		merged = append(merged, testVersion{string(record.Key), record.Seq, record.Deleted})
+	}
+
+	// the snapshot at 12 reads a@10 and b@5, c is deleted before it and
+	// only the newest version of d is read by anyone
+	expect := []testVersion{{"a", 20, false}, {"a", 10, false}, {"b", 25, true}, {"b", 5, false}, {"d", 30, false}}
+
+	if fmt.Sprint(merged) != fmt.Sprint(expect) {
+		t.Errorf("expect merged versions %v, got %v", expect, merged)
+	}
+}
+
+func TestMergeTables_DropsExpired(t *testing.T) {
+	table := newInMemTable(t, nil)
+
+	writer, err := table.NewWriter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, record := range []Record{
+		{Key: []byte("a"), Value: []byte("live"), Seq: 3, ExpiresAt: 200},
+		{Key: []byte("b"), Value: []byte("expired"), Seq: 5, ExpiresAt: 100},
+		// an expired version still hides older ones
+		{Key: []byte("b"), Value: []byte("old"), Seq: 1},
+		{Key: []byte("c"), Value: []byte("forever"), Seq: 2},
+	} {
+		if err = writer.WriteRecord(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = writer.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := table.NewReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outputs, err := MergeTables([]SSTableReader{reader}, func() (*SSTable, error) {
+		return newInMemTable(t, nil), nil
+	}, MergeOptions{DropTombstones: true, Now: 150})
+
+	if err != nil {
+		t.Fatal("error merging tables", err)
+	}
+
+	reader, err = outputs[0].NewReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var merged []string
+	for {
+		record, err := reader.ReadNext()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		merged = append(merged, fmt.Sprintf("%s=%s@%d", record.Key, record.Value, record.ExpiresAt))
+	}
+
+	expect := []string{"a=live@200", "c=forever@0"}
+	if fmt.Sprint(merged) != fmt.Sprint(expect) {
+		t.Errorf("expect merged records %v, got %v", expect, merged)
+	}
+}
+
+func TestMergeTables_DropsRangeDeleted(t *testing.T) {
+	table := newInMemTable(t, nil)
+
+	writer, err := table.NewWriter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, record := range []Record{
+		{Key: []byte("a"), Value: []byte("a@2"), Seq: 2},
+		{Key: []byte("b"), Value: []byte("b@12"), Seq: 12},
+		{Key: []byte("b"), Value: []byte("b@6"), Seq: 6},
+		{Key: []byte("b"), Value: []byte("b@3"), Seq: 3},
+		{Key: []byte("c"), Value: []byte("c@4"), Seq: 4},
+		{Key: []byte("d"), Value: []byte("d@1"), Seq: 1},
+	} {
+		if err = writer.WriteRecord(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = writer.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := table.NewReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outputs, err := MergeTables([]SSTableReader{reader}, func() (*SSTable, error) {
+		return newInMemTable(t, nil), nil
+	}, MergeOptions{
+		Snapshots:       []uint64{5},
+		RangeTombstones: []RangeTombstone{{Start: []byte("b"), End: []byte("d"), Seq: 10}},
+	})
+
+	if err != nil {
+		t.Fatal("error merging tables", err)
+	}
+
+	var merged []string
+	for _, record := range readAll(t, outputs[0]) {
+		merged = append(merged, record.value)
+	}
+
+	// b@12 is written after the tombstone, the snapshot at 5 reads b@3 and c@4
+	// without it, d is past the end of the range
+	expect := []string{"a@2", "b@12", "b@3", "c@4", "d@1"}
+	if fmt.Sprint(merged) != fmt.Sprint(expect) {
+		t.Errorf("expect merged records %v, got %v", expect, merged)
+	}
+}
+
+func TestMergeTables_CompactionFilter(t *testing.T) {
+	table := newInMemTable(t, nil)
+
+	writer, err := table.NewWriter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, record := range []Record{
+		{Key: []byte("a"), Value: []byte("v1:a@8"), Seq: 8},
+		{Key: []byte("a"), Value: []byte("v1:a@3"), Seq: 3},
+		{Key: []byte("retired/b"), Value: []byte("b@9"), Seq: 9},
+		{Key: []byte("retired/b"), Value: []byte("b@2"), Seq: 2},
+		{Key: []byte("retired/c"), Value: []byte("c@4"), Seq: 4},
+		{Key: []byte("z"), Value: []byte("z@7"), Seq: 7},
+	} {
+		if err = writer.WriteRecord(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = writer.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := table.NewReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filter := func(key types.KeyType, value types.ValueType) (FilterDecision, types.ValueType) {
+		switch {
+		case bytes.HasPrefix(key, []byte("retired/")):
+			return FilterDrop, nil
+		case bytes.HasPrefix(value, []byte("v1:")):
+			return FilterReplace, append([]byte("v2:"), value[3:]...)
+		default:
+			return FilterKeep, nil
+		}
+	}
+
+	outputs, err := MergeTables([]SSTableReader{reader}, func() (*SSTable, error) {
+		return newInMemTable(t, nil), nil
+	}, MergeOptions{
+		Snapshots: []uint64{5},
+		Filter:    filter,
+	})
+
+	if err != nil {
+		t.Fatal("error merging tables", err)
+	}
+
+	var merged []string
+	for _, record := range readAll(t, outputs[0]) {
+		if record.deleted {
+			merged = append(merged, record.key+"=deleted")
+		} else {
+			merged = append(merged, record.key+"="+record.value)
+		}
+	}
+
+	// the snapshot at 5 reads a@3, b@2 and c@4 as they were written,
+	// the dropped b@9 still hides b@2 from reads after the snapshot
+	expect := []string{"a=v2:a@8", "a=v1:a@3", "retired/b=deleted", "retired/b=b@2", "retired/c=c@4", "z=z@7"}
+	if fmt.Sprint(merged) != fmt.Sprint(expect) {
+		t.Errorf("expect merged records %v, got %v", expect, merged)
+	}
+}
+
+func TestMergeTables_FoldsMergeOperands(t *testing.T) {
+	concat := func(key types.KeyType, older types.ValueType, newer types.ValueType) types.ValueType {
+		return types.ValueType(string(older) + "," + string(newer))
+	}
+
+	for _, dropTombstones := range []bool{false, true} {
+		table := newInMemTable(t, nil)
+
+		writer, err := table.NewWriter()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, record := range []Record{
+			{Key: []byte("a"), Value: []byte("3"), Seq: 9, MergeOperand: true},
+			{Key: []byte("a"), Value: []byte("2"), Seq: 8, MergeOperand: true},
+			{Key: []byte("a"), Value: []byte("1"), Seq: 4},
+			{Key: []byte("b"), Value: []byte("y"), Seq: 7, MergeOperand: true},
+			{Key: []byte("b"), Value: []byte("x"), Seq: 6},
+			{Key: []byte("b"), Value: []byte("old"), Seq: 2},
+			{Key: []byte("c"), Value: []byte("q"), Seq: 8, MergeOperand: true},
+			{Key: []byte("c"), Seq: 7, Deleted: true},
+			{Key: []byte("d"), Value: []byte("m"), Seq: 9, MergeOperand: true},
+			{Key: []byte("d"), Value: []byte("n"), Seq: 6, MergeOperand: true},
+		} {
+			if err = writer.WriteRecord(record); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err = writer.Commit(); err != nil {
+			t.Fatal(err)
+		}
+
+		reader, err := table.NewReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		outputs, err := MergeTables([]SSTableReader{reader}, func() (*SSTable, error) {
+			return newInMemTable(t, nil), nil
+		}, MergeOptions{
+			DropTombstones: dropTombstones,
+			Snapshots:      []uint64{5},
+			MergeOperator:  concat,
+		})
+
+		if err != nil {
+			t.Fatal("error merging tables", err)
+		}
+
+		outputReader, err := outputs[0].NewReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var merged []string
+		for {
+			record, err := outputReader.ReadNext()
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			merged = append(merged, fmt.Sprintf("%s@%d=%s/%v", record.Key, record.Seq, record.Value, record.MergeOperand))
+		}
+
+		// the snapshot at 5 reads a@4 and b@2 as they are, so a's operands are
+		// folded into one. d has no value, unless no older table can hold one.
+		expect := []string{"a@9=2,3/true", "a@4=1/false", "b@7=x,y/false", "b@2=old/false", "c@8=q/false", "d@9=n,m/true"}
+		if dropTombstones {
+			expect[len(expect)-1] = "d@9=n,m/false"
+		}
+
+		if fmt.Sprint(merged) != fmt.Sprint(expect) {
+			t.Errorf("drop tombstones %v: expect merged records %v, got %v", dropTombstones, expect, merged)
+		}
+	}
+}
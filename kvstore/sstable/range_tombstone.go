@@ -0,0 +1,39 @@
+package sstable
+
+import (
+	"bytes"
+	"github.com/zl14917/MastersProject/kvstore/types"
+)
+
+// RangeTombstone deletes every version of the keys in [Start, End)
+// written before Seq, the sequence number of the delete.
+type RangeTombstone struct {
+	Start types.KeyType
+	End   types.KeyType
+	Seq   uint64
+}
+
+func (t *RangeTombstone) Contains(key types.KeyType) bool {
+	return bytes.Compare(key, t.Start) >= 0 && bytes.Compare(key, t.End) < 0
+}
+
+// Covers is true if the version of key at seq is deleted by the tombstone.
+func (t *RangeTombstone) Covers(key types.KeyType, seq uint64) bool {
+	return seq < t.Seq && t.Contains(key)
+}
+
+// Overlaps is true if the tombstone contains a key in [minKey, maxKey].
+func (t *RangeTombstone) Overlaps(minKey types.KeyType, maxKey types.KeyType) bool {
+	return bytes.Compare(maxKey, t.Start) >= 0 && bytes.Compare(minKey, t.End) < 0
+}
+
+// RangeDeleted is true if a tombstone a read at readSeq sees deletes the version of key at seq.
+// A key without a version is passed with seq 0, it is deleted by any tombstone containing it.
+func RangeDeleted(tombstones []RangeTombstone, key types.KeyType, seq uint64, readSeq uint64) bool {
+	for i := range tombstones {
+		if tombstones[i].Seq <= readSeq && tombstones[i].Covers(key, seq) {
+			return true
+		}
+	}
+	return false
+}
@@ -6,10 +6,12 @@ import (
 	"errors"
 	"fmt"
 	"github.com/zl14917/MastersProject/kvstore/blockstore"
+	"github.com/zl14917/MastersProject/kvstore/bloom"
 	"github.com/zl14917/MastersProject/kvstore/types"
 	"io"
 	"os"
 	"path"
+	"sort"
 	"strconv"
 )
 
@@ -39,10 +41,18 @@ type SSTable struct {
 	InMem                 bool
 	IndexStorageBlockSize int
 	DataStoreBlockSize    int
+	BloomBitsPerKey       int
+	DataBlockCodec        BlockCodec
+	BlockCache            *BlockCache
+	IndexRestartInterval  int
 
 	dataStorage  blockstore.BlockStorage
 	indexStorage blockstore.BlockStorage
 
+	// ids the blocks of the open storages are cached under
+	indexCacheID uint64
+	dataCacheID  uint64
+
 	CreatedTimestamp uint64
 
 	loadExisting bool
@@ -65,14 +75,29 @@ type SSTableOpenOptions struct {
 	InMemStore     bool
 	LoadExisting   bool
 	Timestamp      int64
+	// bits of bloom filter per key written at commit, 0 writes no filter
+	BloomBitsPerKey int
+	// codec data blocks of new tables are compressed with,
+	// existing tables are read with the codec in their header
+	DataBlockCodec BlockCodec
+	// blocks read by readers are cached in BlockCache, nil reads every block from storage
+	BlockCache *BlockCache
+	// index keys are prefix compressed with a full key every IndexRestartInterval
+	// entries, 0 writes full keys in the format of files before compression
+	IndexRestartInterval int
 }
 
+const DefaultBloomBitsPerKey = 10
+
 var defaultSSTableOpenOptions = SSTableOpenOptions{
-	Prefix:         "level_0_",
-	MaxKeySize:     4 * 1024,
-	MaxValueSize:   16 * 1024,
-	IndexBlockSize: 4 * 1024,
-	DataBlockSize:  16 * 1024,
+	Prefix:          "level_0_",
+	MaxKeySize:      4 * 1024,
+	MaxValueSize:    16 * 1024,
+	IndexBlockSize:  4 * 1024,
+	DataBlockSize:   16 * 1024,
+	BloomBitsPerKey: DefaultBloomBitsPerKey,
+
+	IndexRestartInterval: DefaultIndexRestartInterval,
 }
 
 func NewSSTable(dirPath string, options *SSTableOpenOptions) (*SSTable) {
@@ -87,6 +112,10 @@ func NewSSTable(dirPath string, options *SSTableOpenOptions) (*SSTable) {
 
 		IndexStorageBlockSize: options.IndexBlockSize,
 		DataStoreBlockSize:    options.DataBlockSize,
+		BloomBitsPerKey:       options.BloomBitsPerKey,
+		DataBlockCodec:        options.DataBlockCodec,
+		BlockCache:            options.BlockCache,
+		IndexRestartInterval:  options.IndexRestartInterval,
 
 		indexStorage: nil,
 		dataStorage:  nil,
@@ -135,6 +164,7 @@ func (s *SSTable) createOrOpenIndexStorage() error {
 			s.indexStorage = nil
 			return err
 		}
+		s.indexCacheID = s.newCacheID()
 	}
 
 	return nil
@@ -148,11 +178,25 @@ func (s *SSTable) createOrOpenDataStorage() error {
 			s.dataStorage = nil
 			return err
 		}
+		s.dataCacheID = s.newCacheID()
 	}
 
 	return nil
 }
 
+func (s *SSTable) newCacheID() uint64 {
+	if s.BlockCache == nil {
+		return 0
+	}
+	return s.BlockCache.NewID()
+}
+
+func (s *SSTable) eraseCachedBlocks(id uint64) {
+	if s.BlockCache != nil {
+		s.BlockCache.EraseFile(id)
+	}
+}
+
 func LoadSSTableFrom(dirPath string, options *SSTableOpenOptions) *SSTable {
 	options.LoadExisting = true
 	return NewSSTable(dirPath, options)
@@ -175,17 +219,55 @@ func (s *SSTable) NewReader() (SSTableReader, error) {
 		dataReader:  newSSTableDataReader(s.dataStorage),
 	}
 
+	reader.indexReader.filePath = s.IndexFilePath
+	reader.dataReader.filePath = s.DataFilePath
+	reader.indexReader.blocks = cachedBlockReader{cache: s.BlockCache, fileID: s.indexCacheID}
+	reader.dataReader.blocks = cachedBlockReader{cache: s.BlockCache, fileID: s.dataCacheID}
+
+	err = reader.indexReader.ReadHeader()
+	if err != nil {
+		return nil, fmt.Errorf("error reading index file header: %v", err)
+	}
+
+	err = reader.dataReader.ReaderHeader()
+	if err != nil {
+		return nil, fmt.Errorf("error reading data file header: %v", err)
+	}
+
+	reader.filter, err = reader.indexReader.ReadFilter()
+	if err != nil {
+		return nil, fmt.Errorf("error reading bloom filter: %v", err)
+	}
+
 	return reader, nil
 }
 
 func (s *SSTable) NewWriter() (SSTableWriter, error) {
+	err := s.createOrOpenDataStorage()
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.createOrOpenIndexStorage()
+
+	if err != nil {
+		return nil, err
+	}
 
 	writer := &sstableWriterStruct{
 		sstableDataWriter:       newSStableDataWriter(s.dataStorage),
 		sstableBlockIndexWriter: newSSTableIndexWriter(s.indexStorage),
 	}
 
-	err := writer.sstableDataWriter.WriteHeader()
+	writer.sstableBlockIndexWriter.bloomBitsPerKey = s.BloomBitsPerKey
+	writer.sstableBlockIndexWriter.restartInterval = s.IndexRestartInterval
+
+	err = writer.sstableDataWriter.setCodec(s.DataBlockCodec)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writer.sstableDataWriter.WriteHeader()
 	if err != nil {
 		return nil, fmt.Errorf("error writing data file header: %v", err)
 	}
@@ -198,6 +280,21 @@ func (s *SSTable) NewWriter() (SSTableWriter, error) {
 	return writer, nil
 }
 
+// SizeBytes is the size of the index and data files of an opened table.
+func (s *SSTable) SizeBytes() int64 {
+	var size int64
+
+	if s.indexStorage != nil {
+		size += int64(s.indexStorage.NumBlocks()) * int64(s.indexStorage.BlockSize())
+	}
+
+	if s.dataStorage != nil {
+		size += int64(s.dataStorage.NumBlocks()) * int64(s.dataStorage.BlockSize())
+	}
+
+	return size
+}
+
 func (s *SSTable) Close() error {
 	var errIndex, errData error
 	if s.indexStorage != nil {
@@ -205,12 +302,14 @@ func (s *SSTable) Close() error {
 
 		if errIndex == nil {
 			s.indexStorage = nil
+			s.eraseCachedBlocks(s.indexCacheID)
 		}
 	}
 	if s.dataStorage != nil {
 		errData = s.dataStorage.Close()
 		if errData == nil {
 			s.dataStorage = nil
+			s.eraseCachedBlocks(s.dataCacheID)
 		}
 	}
 
@@ -257,6 +356,17 @@ type sstableBlockIndexWriter struct {
 
 	entryMarshallBuffer *bytes.Buffer
 	blockBuffer         *bytes.Buffer
+	scratchBuffer       *bytes.Buffer
+
+	// hashes of written keys, the filter is sized from them at commit
+	bloomBitsPerKey int
+	keyHashes       []uint64
+
+	// blockBuffer holds only the entries of prefix compressed blocks,
+	// the key count and restarts are put before them when flushing
+	restartInterval int
+	restarts        []uint32
+	lastKey         []byte
 
 	header SSTableIndexFileHeader
 }
@@ -265,36 +375,25 @@ func newSSTableIndexWriter(storage blockstore.BlockStorage) sstableBlockIndexWri
 	return sstableBlockIndexWriter{
 		Storage:             storage,
 		BlockSize:           storage.BlockSize(),
-		MaxKeySize:          MaxKeySizeFitInBlocK(storage.BlockSize()),
+		MaxKeySize:          MaxKeySizeFitInBlocK(blockPayloadSize(storage.BlockSize())),
 		blockBuffer:         bytes.NewBuffer(nil),
 		entryMarshallBuffer: bytes.NewBuffer(nil),
+		scratchBuffer:       bytes.NewBuffer(nil),
 
+		header:            UnitialzedSSTableIndexFileHeader,
 		currentBlockIndex: 1,
 	}
 }
 
 func (w *sstableBlockIndexWriter) WriteHeader() error {
-	var err error
-
-	_, err = w.Storage.Allocate(1)
-	if err != nil {
-		return err
-	}
-
 	buffer := bytes.NewBuffer(nil)
-	err = w.header.Marshall(buffer)
-
-	if err != nil {
-		return err
-	}
-
-	_, err = w.Storage.WriteBlock(0, buffer)
+	err := w.header.Marshall(buffer)
 
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return writeChecksummedBlock(w.Storage, 0, buffer.Bytes(), w.scratchBuffer)
 }
 
 func (w *sstableBlockIndexWriter) Commit() error {
@@ -309,6 +408,24 @@ func (w *sstableBlockIndexWriter) Commit() error {
 	w.header.Flags = IndexFileFlags(0)
 	w.header.BlockCount = uint32(w.currentBlockIndex - 1)
 	w.header.KeyCount = uint32(w.keyCount)
+	w.header.FilterBlockCount = 0
+	w.header.Version = IndexFormatFullKeys
+	w.header.RestartInterval = 0
+
+	if w.restartInterval > 0 {
+		w.header.Version = IndexFormatPrefixCompressed
+		w.header.RestartInterval = uint32(w.restartInterval)
+	}
+
+	if w.bloomBitsPerKey > 0 {
+		filterBlocks, err := w.writeFilter()
+		if err != nil {
+			return err
+		}
+
+		w.header.Flags |= IndexFileHasFilter
+		w.header.FilterBlockCount = uint32(filterBlocks)
+	}
 
 	err = w.WriteHeader()
 
@@ -320,7 +437,38 @@ func (w *sstableBlockIndexWriter) Commit() error {
 	return err
 }
 
-func (writer *sstableBlockIndexWriter) WriteIndex(key types.KeyType, deleted bool, position blockstore.Position) error {
+// writeFilter builds the bloom filter over all written keys
+// and splits it over the blocks after the last index block.
+func (w *sstableBlockIndexWriter) writeFilter() (blocks int, err error) {
+	filter := bloom.NewFilter(len(w.keyHashes), w.bloomBitsPerKey)
+
+	for _, hash := range w.keyHashes {
+		filter.AddHash(hash)
+	}
+
+	data := filter.Marshall()
+	payloadSize := blockPayloadSize(w.BlockSize)
+
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > payloadSize {
+			chunk = chunk[0:payloadSize]
+		}
+
+		err = writeChecksummedBlock(w.Storage, w.currentBlockIndex, chunk, w.scratchBuffer)
+		if err != nil {
+			return blocks, err
+		}
+
+		data = data[len(chunk):]
+		w.currentBlockIndex++
+		blocks++
+	}
+
+	return blocks, nil
+}
+
+func (writer *sstableBlockIndexWriter) WriteIndex(key types.KeyType, seq uint64, expiresAt int64, deleted bool, mergeOperand bool, position blockstore.Position) error {
 
 	if len(key) > writer.MaxKeySize {
 		return fmt.Errorf("can't write key: %v, Key Size : %d", KeyTooLarge, len(key))
@@ -333,22 +481,32 @@ func (writer *sstableBlockIndexWriter) WriteIndex(key types.KeyType, deleted boo
 		position = blockstore.UninitializedPosition
 	}
 
+	if mergeOperand {
+		flags |= SSTableIndexKeyMerge
+	}
+
+	if seq != 0 {
+		flags |= SSTableIndexKeyHasSeq
+	}
+
+	if expiresAt != 0 {
+		flags |= SSTableIndexKeyHasExpiry
+	}
+
 	entry := SSTableIndexEntry{
 		Flags:          flags,
 		KeyLen:         uint32(len(key)),
 		DataFileOffSet: position.EncodeUint64(),
+		Seq:            seq,
+		ExpiresAt:      expiresAt,
 		LargeKey:       key,
 	}
 
-	fmt.Printf("%v", entry)
-	writer.entryMarshallBuffer.Reset()
-	if writer.blockKeyCount == 0 {
-		writer.entryMarshallBuffer.WriteByte(0x7f)
-		writer.entryMarshallBuffer.WriteByte(0x7f)
-		writer.entryMarshallBuffer.WriteByte(0x7f)
-		writer.entryMarshallBuffer.WriteByte(0x7f)
+	if writer.restartInterval > 0 {
+		return writer.writePrefixedEntry(&entry)
 	}
 
+	writer.entryMarshallBuffer.Reset()
 	_, err := entry.Marshall(writer.entryMarshallBuffer)
 
 	if err != nil {
@@ -356,28 +514,102 @@ func (writer *sstableBlockIndexWriter) WriteIndex(key types.KeyType, deleted boo
 	}
 
 	serializedBytes := writer.entryMarshallBuffer.Bytes()
+	payloadSize := blockPayloadSize(writer.BlockSize)
 
-	if writer.blockBuffer.Len()+len(serializedBytes) >= writer.BlockSize {
+	if writer.blockKeyCount > 0 && writer.blockBuffer.Len()+len(serializedBytes) > payloadSize {
 		err = writer.FlushCurrentBlock()
 		if err != nil {
 			return err
 		}
 	}
 
+	// placeholder for key count of the block, filled in when flushing
+	if writer.blockKeyCount == 0 {
+		writer.blockBuffer.Write([]byte{0x7f, 0x7f, 0x7f, 0x7f})
+	}
+
 	_, err = writer.blockBuffer.Write(serializedBytes)
 
 	if err != nil {
 		return err
 	}
+
+	writer.addedEntry(key)
+	return nil
+}
+
+func (writer *sstableBlockIndexWriter) addedEntry(key types.KeyType) {
 	writer.blockKeyCount++
 	writer.keyCount++
 
+	if writer.bloomBitsPerKey > 0 {
+		writer.keyHashes = append(writer.keyHashes, bloom.Hash(key))
+	}
+}
+
+// marshallPrefixed encodes entry as the next entry of the current block.
+func (writer *sstableBlockIndexWriter) marshallPrefixed(entry *SSTableIndexEntry) (serialized []byte, restart bool, err error) {
+	restart = writer.blockKeyCount%uint(writer.restartInterval) == 0
+	shared := 0
+
+	if !restart {
+		shared = sharedPrefixLen(writer.lastKey, entry.LargeKey)
+	}
+
+	writer.entryMarshallBuffer.Reset()
+	_, err = entry.MarshallPrefixed(writer.entryMarshallBuffer, shared)
+
+	return writer.entryMarshallBuffer.Bytes(), restart, err
+}
+
+func (writer *sstableBlockIndexWriter) writePrefixedEntry(entry *SSTableIndexEntry) error {
+	serialized, restart, err := writer.marshallPrefixed(entry)
+	if err != nil {
+		return err
+	}
+
+	blockSize := func() int {
+		size := 4 + 4 + 4*len(writer.restarts) + writer.blockBuffer.Len() + len(serialized)
+		if restart {
+			size += 4
+		}
+		return size
+	}
+
+	if writer.blockKeyCount > 0 && blockSize() > blockPayloadSize(writer.BlockSize) {
+		err = writer.FlushCurrentBlock()
+		if err != nil {
+			return err
+		}
+
+		// the first entry of a block is a restart point
+		serialized, restart, err = writer.marshallPrefixed(entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	if restart {
+		writer.restarts = append(writer.restarts, uint32(writer.blockBuffer.Len()))
+	}
+
+	_, err = writer.blockBuffer.Write(serialized)
+	if err != nil {
+		return err
+	}
+
+	writer.lastKey = append(writer.lastKey[:0], entry.LargeKey...)
+	writer.addedEntry(entry.LargeKey)
 	return nil
 }
 
 func (writer *sstableBlockIndexWriter) FlushCurrentBlock() error {
 	var err error
 
+	if writer.restartInterval > 0 {
+		return writer.flushPrefixedBlock()
+	}
+
 	bs := writer.blockBuffer.Bytes()
 
 	if len(bs) < 1 {
@@ -385,33 +617,93 @@ func (writer *sstableBlockIndexWriter) FlushCurrentBlock() error {
 	}
 
 	binary.BigEndian.PutUint32(bs[0:4], uint32(writer.blockKeyCount))
-	_, err = writer.Storage.Allocate(int(writer.currentBlockIndex + 1))
+
+	err = writeChecksummedBlock(writer.Storage, writer.currentBlockIndex, bs, writer.scratchBuffer)
 
 	if err != nil {
 		return err
 	}
 
-	_, err = writer.Storage.WriteBlock(writer.currentBlockIndex, writer.blockBuffer)
+	writer.blockKeyCount = 0
+	writer.blockBuffer.Reset()
+	writer.currentBlockIndex++
+
+	return nil
+}
+
+func (writer *sstableBlockIndexWriter) flushPrefixedBlock() error {
+	if writer.blockKeyCount == 0 {
+		return nil
+	}
+
+	var uint32buffer [4]byte
+
+	block := writer.entryMarshallBuffer
+	block.Reset()
 
+	for _, field := range []uint32{uint32(writer.blockKeyCount), uint32(len(writer.restarts))} {
+		binary.BigEndian.PutUint32(uint32buffer[:], field)
+		block.Write(uint32buffer[:])
+	}
+
+	for _, offset := range writer.restarts {
+		binary.BigEndian.PutUint32(uint32buffer[:], offset)
+		block.Write(uint32buffer[:])
+	}
+
+	block.Write(writer.blockBuffer.Bytes())
+
+	err := writeChecksummedBlock(writer.Storage, writer.currentBlockIndex, block.Bytes(), writer.scratchBuffer)
 	if err != nil {
 		return err
 	}
 
 	writer.blockKeyCount = 0
 	writer.blockBuffer.Reset()
+	writer.restarts = writer.restarts[:0]
 	writer.currentBlockIndex++
 
 	return nil
 }
 
+// Values are packed into data blocks, a value never spans two blocks.
+// Compressed data blocks hold several storage blocks worth of values
+// and are written over as many storage blocks as they compress to.
 type sstableDataWriter struct {
 	Storage      blockstore.BlockStorage
 	BlockSize    int
 	MaxValueSize int
 	recordCount  int
 
+	currentBlockIndex uint
+	blockBuffer       *bytes.Buffer
+	scratchBuffer     *bytes.Buffer
 	recordWriteBuffer *bytes.Buffer
 	header            SSTableDataFileHeader
+
+	codec            BlockCodec
+	compressor       blockCompressor
+	compressedBuffer *bytes.Buffer
+}
+
+func (writer *sstableDataWriter) setCodec(codec BlockCodec) error {
+	compressor, err := newCompressor(codec)
+	if err != nil {
+		return err
+	}
+
+	writer.codec = codec
+	writer.compressor = compressor
+	writer.compressedBuffer = bytes.NewBuffer(nil)
+	return nil
+}
+
+// blockCapacity is the number of value bytes a data block holds before compression.
+func (writer *sstableDataWriter) blockCapacity() int {
+	if writer.compressor == nil {
+		return blockPayloadSize(writer.BlockSize)
+	}
+	return compressedBlockFactor * blockPayloadSize(writer.BlockSize)
 }
 
 func (writer *sstableDataWriter) WriteValue(value types.ValueType) (index blockstore.Position, err error) {
@@ -419,7 +711,6 @@ func (writer *sstableDataWriter) WriteValue(value types.ValueType) (index blocks
 	if len(value) > writer.MaxValueSize {
 		return blockstore.UninitializedPosition, ValueTooLarge
 	}
-	beforePosition := writer.Storage.WritePosition()
 
 	writer.recordWriteBuffer.Reset()
 	record := SSTableDataRecord{
@@ -429,50 +720,104 @@ func (writer *sstableDataWriter) WriteValue(value types.ValueType) (index blocks
 
 	err = record.Marshall(writer.recordWriteBuffer)
 	if err != nil {
-		return
+		return blockstore.UninitializedPosition, err
 	}
 
-	_, err = writer.Storage.Write(value)
+	recordBytes := writer.recordWriteBuffer.Bytes()
 
-	if err != nil {
-		return blockstore.UninitializedPosition, err
+	if writer.blockBuffer.Len()+len(recordBytes) > writer.blockCapacity() {
+		err = writer.FlushCurrentBlock()
+		if err != nil {
+			return blockstore.UninitializedPosition, err
+		}
 	}
-	afterPosition := writer.Storage.WritePosition()
 
-	if beforePosition.Block == afterPosition.Block {
-		return beforePosition, nil
+	position := blockstore.Position{
+		Block:  int(writer.currentBlockIndex),
+		Offset: writer.blockBuffer.Len(),
 	}
+
+	writer.blockBuffer.Write(recordBytes)
 	writer.recordCount++
 
-	return blockstore.Position{
-		Block:  afterPosition.Block,
-		Offset: 0,
-	}, nil
+	return position, nil
+}
+
+func (writer *sstableDataWriter) FlushCurrentBlock() error {
+	if writer.blockBuffer.Len() < 1 {
+		return nil
+	}
+
+	if writer.compressor != nil {
+		return writer.flushCompressedBlock()
+	}
+
+	err := writeChecksummedBlock(
+		writer.Storage,
+		writer.currentBlockIndex,
+		writer.blockBuffer.Bytes(),
+		writer.scratchBuffer,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	writer.blockBuffer.Reset()
+	writer.currentBlockIndex++
+	return nil
+}
+
+func (writer *sstableDataWriter) flushCompressedBlock() error {
+	var frameHeader [compressedFrameHeaderSize]byte
+
+	writer.compressedBuffer.Reset()
+	writer.compressedBuffer.Write(frameHeader[:])
+
+	err := writer.compressor.Compress(writer.compressedBuffer, writer.blockBuffer.Bytes())
+	if err != nil {
+		return err
+	}
+
+	frame := writer.compressedBuffer.Bytes()
+	putCompressedFrameHeader(frame, len(frame)-compressedFrameHeaderSize, writer.blockBuffer.Len())
+
+	payloadSize := blockPayloadSize(writer.BlockSize)
+
+	for len(frame) > 0 {
+		chunk := frame
+		if len(chunk) > payloadSize {
+			chunk = chunk[0:payloadSize]
+		}
+
+		err = writeChecksummedBlock(writer.Storage, writer.currentBlockIndex, chunk, writer.scratchBuffer)
+		if err != nil {
+			return err
+		}
+
+		frame = frame[len(chunk):]
+		writer.currentBlockIndex++
+	}
+
+	writer.blockBuffer.Reset()
+	return nil
 }
+
 func newSStableDataWriter(storage blockstore.BlockStorage) sstableDataWriter {
 	blockSize := storage.BlockSize()
 
 	writer := sstableDataWriter{
 		Storage:      storage,
 		BlockSize:    blockSize,
-		MaxValueSize: MaxValueSizeFitInBlock(blockSize),
+		MaxValueSize: MaxValueSizeFitInBlock(blockPayloadSize(blockSize)),
 
+		currentBlockIndex: 1,
+		blockBuffer:       bytes.NewBuffer(nil),
+		scratchBuffer:     bytes.NewBuffer(nil),
 		recordWriteBuffer: bytes.NewBuffer(nil),
 		header:            UnitializedSSTableDataFileHeader,
 	}
 
-	position := writer.Storage.WritePosition()
-
-	if position.Block == 0 {
-		padHeaderBlock := writer.Storage.BlockSize() - position.Offset
-		zeros := make([]byte, padHeaderBlock, padHeaderBlock)
-		_, err := writer.Storage.Write(zeros)
-
-		if err != nil {
-			_, _ = fmt.Fprintln(os.Stderr, err)
-		}
-	}
-
 	return writer
 }
 
@@ -483,20 +828,29 @@ func (w *sstableDataWriter) WriteHeader() error {
 	if err != nil {
 		return err
 	}
-	_, err = w.Storage.WriteBlock(0, w.recordWriteBuffer)
 
-	return err
+	return writeChecksummedBlock(w.Storage, 0, w.recordWriteBuffer.Bytes(), w.scratchBuffer)
 }
 
 func (w *sstableDataWriter) Commit() error {
+	err := w.FlushCurrentBlock()
+	if err != nil {
+		return err
+	}
 
 	header := &w.header
 
-	header.BlockCount = uint32(w.Storage.NumBlocks())
+	header.BlockCount = uint32(w.currentBlockIndex)
 	header.BlockSize = uint32(w.BlockSize)
 	header.ValuesCount = uint32(w.recordCount)
+	header.Flags = DataFileFlags(0)
+	header.Codec = w.codec
+
+	if w.codec != CodecNone {
+		header.Flags |= DataFileCompressed
+	}
 
-	err := w.WriteHeader()
+	err = w.WriteHeader()
 	if err != nil {
 		return err
 	}
@@ -519,9 +873,18 @@ func (w *sstableWriterStruct) MaxValueSize() int {
 }
 
 func (w *sstableWriterStruct) Write(key types.KeyType, value types.ValueType, deleted bool) error {
+	return w.WriteVersion(key, value, 0, deleted)
+}
+
+func (w *sstableWriterStruct) WriteVersion(key types.KeyType, value types.ValueType, seq uint64, deleted bool) error {
+	return w.WriteRecord(Record{Key: key, Value: value, Seq: seq, Deleted: deleted})
+}
+
+func (w *sstableWriterStruct) WriteRecord(record Record) error {
 	var (
+		key      = record.Key
 		keyLen   = len(key)
-		valueLen = len(value)
+		valueLen = len(record.Value)
 
 		position = blockstore.UninitializedPosition
 		err      error
@@ -535,15 +898,15 @@ func (w *sstableWriterStruct) Write(key types.KeyType, value types.ValueType, de
 		return ValueTooLarge
 	}
 
-	if !deleted {
-		position, err = w.WriteValue(value)
+	if !record.Deleted {
+		position, err = w.WriteValue(record.Value)
 	}
 
 	if err != nil {
 		return err
 	}
 
-	err = w.WriteIndex(key, deleted, position)
+	err = w.WriteIndex(key, record.Seq, record.ExpiresAt, record.Deleted, record.MergeOperand, position)
 
 	if err != nil {
 		return err
@@ -553,29 +916,26 @@ func (w *sstableWriterStruct) Write(key types.KeyType, value types.ValueType, de
 }
 
 func (w *sstableWriterStruct) Commit() error {
-	err := w.sstableBlockIndexWriter.FlushCurrentBlock()
-
-	if err != nil {
-		return err
-	}
-
-	err = w.sstableBlockIndexWriter.Commit()
+	err := w.sstableBlockIndexWriter.Commit()
 
 	if err != nil {
 		return err
 	}
 
-	err = w.sstableDataWriter.Commit()
-
-	return nil
+	return w.sstableDataWriter.Commit()
 }
 
 type sstableIndexReader struct {
 	blockFirstKeyCache map[uint]*SSTableIndexEntry
 
+	filePath     string
 	indexStorage blockstore.BlockStorage
+	blocks       cachedBlockReader
 	header       SSTableIndexFileHeader
 	buffer       *bytes.Buffer
+
+	// restart offsets of the last prefix compressed block read
+	restarts []uint32
 }
 
 func newSSTableIndexReader(storage blockstore.BlockStorage) sstableIndexReader {
@@ -589,8 +949,7 @@ func newSSTableIndexReader(storage blockstore.BlockStorage) sstableIndexReader {
 }
 
 func (r *sstableIndexReader) ReadHeader() error {
-	r.buffer.Reset()
-	_, err := r.indexStorage.ReadBlock(0, r.buffer)
+	err := r.blocks.readBlock(r.indexStorage, r.filePath, 0, r.buffer)
 
 	if err != nil {
 		return err
@@ -600,16 +959,61 @@ func (r *sstableIndexReader) ReadHeader() error {
 	return err
 }
 
-func (r *sstableIndexReader) readFirstEntryOfBlock(n uint) (entry *SSTableIndexEntry, err error) {
-	r.buffer.Reset()
-	_, err = r.indexStorage.ReadBlock(n, r.buffer)
+// ReadFilter returns nil for tables written without a bloom filter.
+func (r *sstableIndexReader) ReadFilter() (*bloom.Filter, error) {
+	if r.header.Flags&IndexFileHasFilter == 0 || r.header.FilterBlockCount == 0 {
+		return nil, nil
+	}
+
+	var (
+		first = uint(r.header.BlockCount) + 1
+		last  = first + uint(r.header.FilterBlockCount)
+		data  []byte
+	)
+
+	for n := first; n < last; n++ {
+		err := r.blocks.readBlock(r.indexStorage, r.filePath, n, r.buffer)
+		if err != nil {
+			return nil, err
+		}
+
+		data = append(data, r.buffer.Bytes()...)
+	}
+
+	return bloom.UnMarshall(data)
+}
+
+func (r *sstableIndexReader) readBlock(n uint) (indexBlock SSTableIndexBlock, err error) {
+	err = r.blocks.readBlock(r.indexStorage, r.filePath, n, r.buffer)
 
 	if err != nil {
-		return nil, err
+		return indexBlock, err
 	}
 
-	indexBlock := SSTableIndexBlock{}
 	err = indexBlock.UnMarshall(r.buffer)
+	if err != nil || !r.prefixCompressed() {
+		return indexBlock, err
+	}
+
+	r.restarts, err = readRestarts(r.buffer, r.restarts)
+	return indexBlock, err
+}
+
+func (r *sstableIndexReader) prefixCompressed() bool {
+	return r.header.Version == IndexFormatPrefixCompressed
+}
+
+// readEntry reads the next entry of the block in r.buffer,
+// prevKey is the key of the entry before it.
+func (r *sstableIndexReader) readEntry(entry *SSTableIndexEntry, prevKey []byte) error {
+	if r.prefixCompressed() {
+		return entry.UnMarshallPrefixed(r.buffer, prevKey)
+	}
+	return entry.UnMarshall(r.buffer)
+}
+
+func (r *sstableIndexReader) readFirstEntryOfBlock(n uint) (entry *SSTableIndexEntry, err error) {
+	indexBlock, err := r.readBlock(n)
 
 	if err != nil {
 		return nil, err
@@ -621,7 +1025,7 @@ func (r *sstableIndexReader) readFirstEntryOfBlock(n uint) (entry *SSTableIndexE
 
 	entry = &SSTableIndexEntry{}
 
-	err = entry.UnMarshall(r.buffer)
+	err = r.readEntry(entry, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -643,8 +1047,10 @@ func (r *sstableIndexReader) getFirstEntryOfBlock(n uint) (entry *SSTableIndexEn
 	return entry, nil
 }
 
-func (r *sstableIndexReader) FindIndexForKey(key types.KeyType) (entry *SSTableIndexEntry, ok bool, err error) {
-	// binary search
+// blockForKey is the last block with first key <= key, or < key unless inclusive.
+// It is block 1 if there is no such block. Versions of a key can span blocks,
+// the newest of them may be before the last block starting with the key.
+func (r *sstableIndexReader) blockForKey(key types.KeyType, inclusive bool) (block uint, err error) {
 	var (
 		mid   uint
 		left  uint = 1
@@ -652,94 +1058,192 @@ func (r *sstableIndexReader) FindIndexForKey(key types.KeyType) (entry *SSTableI
 	)
 
 	for left < right {
-		mid = left + (right-left)/2
-		entry, err = r.getFirstEntryOfBlock(mid)
+		mid = left + (right-left+1)/2
+		entry, err := r.getFirstEntryOfBlock(mid)
 
 		if err != nil {
-			return nil, false, err
+			return 0, err
 		}
 
 		cmp := bytes.Compare(key, entry.LargeKey)
 
-		if cmp == 0 {
-			return entry, true, nil
-		} else if cmp < 0 {
-			right = mid
+		if cmp < 0 || (cmp == 0 && !inclusive) {
+			right = mid - 1
 		} else {
-			left = mid + 1
+			left = mid
 		}
 	}
 
-	if left == right {
-		entry, err = r.getFirstEntryOfBlock(left)
-		if err != nil {
-			return nil, false, err
-		}
+	return left, nil
+}
 
-		if bytes.Compare(entry.LargeKey, key) <= 0 {
-			return r.searchForKeyInBlock(key, left)
-		} else {
-			return nil, false, nil
+// FindIndexForKey returns the entry of the newest version of key at or before seq.
+func (r *sstableIndexReader) FindIndexForKey(key types.KeyType, seq uint64) (entry *SSTableIndexEntry, ok bool, err error) {
+	if r.header.BlockCount < 1 {
+		return nil, false, nil
+	}
+
+	block, err := r.blockForKey(key, false)
+	if err != nil {
+		return nil, false, err
+	}
+
+	entry, err = r.getFirstEntryOfBlock(block)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if bytes.Compare(entry.LargeKey, key) > 0 {
+		return nil, false, nil
+	}
+
+	for ; block <= uint(r.header.BlockCount); block++ {
+		entry, ok, more, err := r.searchForKeyInBlock(key, seq, block)
+
+		if err != nil || ok || !more {
+			return entry, ok, err
 		}
 	}
 
 	return nil, false, nil
 }
 
-func (r *sstableIndexReader) searchForKeyInBlock(key types.KeyType, block uint) (entry *SSTableIndexEntry, ok bool, err error) {
-	r.buffer.Reset()
-	_, err = r.indexStorage.ReadBlock(block, r.buffer)
+// searchForKeyInBlock is done unless more is true, when the versions of key
+// may continue in the next block.
+func (r *sstableIndexReader) searchForKeyInBlock(key types.KeyType, seq uint64, block uint) (entry *SSTableIndexEntry, ok bool, more bool, err error) {
+	indexBlock, err := r.readBlock(block)
 
 	if err != nil {
-		return nil, false, err
+		return nil, false, false, err
 	}
 
-	indexBlock := SSTableIndexBlock{}
-	err = indexBlock.UnMarshall(r.buffer)
+	var (
+		i       uint32 = 0
+		prevKey []byte
+	)
 
-	if err != nil {
-		return nil, false, err
+	if r.prefixCompressed() {
+		i, err = r.seekRestart(key, indexBlock)
+		if err != nil {
+			return nil, false, false, err
+		}
 	}
-	entry = &SSTableIndexEntry{}
 
-	var i uint32 = 0
 	for ; i < indexBlock.KeyCount; i++ {
-		err = entry.UnMarshall(r.buffer)
+		entry = &SSTableIndexEntry{}
+
+		err = r.readEntry(entry, prevKey)
 		if err == io.EOF {
-			return nil, false, nil
+			return nil, false, false, nil
 		}
 
 		if err != nil {
-			return nil, false, err
+			return nil, false, false, err
 		}
 
 		cmp := bytes.Compare(entry.LargeKey, key)
-		if cmp == 0 {
-			return entry, true, nil
+		if cmp == 0 && entry.Seq <= seq {
+			return entry, true, false, nil
 		} else if cmp > 0 {
-			return nil, false, nil
+			return nil, false, false, nil
 		}
+		prevKey = entry.LargeKey
 	}
-	return nil, false, nil
+	return nil, false, true, nil
+}
+
+// seekRestart binary-searches the restart points of the block in r.buffer for
+// the last one with a key before key, like blockForKey versions of key may start
+// before a restart point with key. r.buffer is left at that restart point and
+// the number of entries before it is returned.
+func (r *sstableIndexReader) seekRestart(key types.KeyType, indexBlock SSTableIndexBlock) (uint32, error) {
+	var (
+		entries  = r.buffer.Bytes()
+		interval = r.header.RestartInterval
+		left     = 0
+		right    = len(r.restarts) - 1
+		entry    SSTableIndexEntry
+	)
+
+	if interval == 0 || uint32(len(r.restarts)) != (indexBlock.KeyCount+interval-1)/interval {
+		return 0, fmt.Errorf("index block of %d keys has %d restarts in %s", indexBlock.KeyCount, len(r.restarts), r.filePath)
+	}
+
+	for left < right {
+		mid := left + (right-left+1)/2
+		offset := r.restarts[mid]
+
+		if int(offset) >= len(entries) {
+			return 0, fmt.Errorf("index block restart offset %d overruns the block in %s", offset, r.filePath)
+		}
+
+		err := entry.UnMarshallPrefixed(bytes.NewBuffer(entries[offset:]), nil)
+		if err != nil {
+			return 0, err
+		}
+
+		if bytes.Compare(key, entry.LargeKey) <= 0 {
+			right = mid - 1
+		} else {
+			left = mid
+		}
+	}
+
+	r.buffer.Next(int(r.restarts[left]))
+	return uint32(left) * interval, nil
+}
+
+// readEntries decodes all entries of index block n, so that they can be walked in both directions.
+func (r *sstableIndexReader) readEntries(n uint) ([]SSTableIndexEntry, error) {
+	indexBlock, err := r.readBlock(n)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		entries = make([]SSTableIndexEntry, indexBlock.KeyCount)
+		prevKey []byte
+	)
+
+	for i := range entries {
+		err = r.readEntry(&entries[i], prevKey)
+		if err != nil {
+			return nil, err
+		}
+		prevKey = entries[i].LargeKey
+	}
+
+	return entries, nil
 }
 
 type sstableDataReader struct {
-	storage blockstore.BlockStorage
-	buffer  *bytes.Buffer
+	filePath string
+	storage  blockstore.BlockStorage
+	blocks   cachedBlockReader
+	buffer   *bytes.Buffer
 
 	header SSTableDataFileHeader
+
+	// last decompressed data block, values are often read in order
+	compressor       blockCompressor
+	compressedBuffer *bytes.Buffer
+	blockData        *bytes.Buffer
+	blockDataIndex   int
 }
 
 func newSSTableDataReader(storage blockstore.BlockStorage) sstableDataReader {
 	return sstableDataReader{
-		buffer:  bytes.NewBuffer(nil),
-		storage: storage,
+		buffer:           bytes.NewBuffer(nil),
+		storage:          storage,
+		compressedBuffer: bytes.NewBuffer(nil),
+		blockData:        bytes.NewBuffer(nil),
+		blockDataIndex:   -1,
 	}
 }
 
 func (r *sstableDataReader) ReaderHeader() error {
-	r.buffer.Reset()
-	_, err := r.storage.ReadBlock(0, r.buffer)
+	err := r.blocks.readBlock(r.storage, r.filePath, 0, r.buffer)
 	if err != nil {
 		return err
 	}
@@ -749,18 +1253,75 @@ func (r *sstableDataReader) ReaderHeader() error {
 		return err
 	}
 
-	return nil
+	r.compressor, err = newCompressor(r.header.Codec)
+	r.blockDataIndex = -1
+	return err
+}
+
+// readCompressedBlock decompresses the data block starting at storage block index.
+func (r *sstableDataReader) readCompressedBlock(index uint) ([]byte, error) {
+	if r.blockDataIndex == int(index) {
+		return r.blockData.Bytes(), nil
+	}
+
+	err := r.blocks.readBlock(r.storage, r.filePath, index, r.buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.buffer.Len() < compressedFrameHeaderSize {
+		return nil, fmt.Errorf("short compressed block header at block %d in %s", index, r.filePath)
+	}
+
+	compressedLen, uncompressedLen := compressedFrameHeader(r.buffer.Bytes())
+
+	r.compressedBuffer.Reset()
+	r.compressedBuffer.Write(r.buffer.Bytes()[compressedFrameHeaderSize:])
+
+	for next := index + 1; r.compressedBuffer.Len() < compressedLen; next++ {
+		if next >= uint(r.header.BlockCount) {
+			return nil, fmt.Errorf("compressed block %d in %s runs past the last block", index, r.filePath)
+		}
+
+		err = r.blocks.readBlock(r.storage, r.filePath, next, r.buffer)
+		if err != nil {
+			return nil, err
+		}
+
+		r.compressedBuffer.Write(r.buffer.Bytes())
+	}
+
+	r.blockDataIndex = -1
+	r.blockData.Reset()
+
+	err = r.compressor.Decompress(r.blockData, r.compressedBuffer.Bytes()[0:compressedLen])
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing block %d in %s: %v", index, r.filePath, err)
+	}
+
+	if r.blockData.Len() != uncompressedLen {
+		return nil, fmt.Errorf(
+			"block %d in %s decompressed to %d bytes, expected %d",
+			index, r.filePath, r.blockData.Len(), uncompressedLen,
+		)
+	}
+
+	r.blockDataIndex = int(index)
+	return r.blockData.Bytes(), nil
 }
 
 // random access read
 func (r *sstableDataReader) ReadValueAt(position blockstore.Position) (types.ValueType, error) {
-	r.buffer.Reset()
-	n, err := r.storage.ReadBlock(uint(position.Block), r.buffer)
+	if r.compressor != nil {
+		return r.readCompressedValueAt(position)
+	}
+
+	err := r.blocks.readBlock(r.storage, r.filePath, uint(position.Block), r.buffer)
 	if err != nil {
 		return nil, err
 	}
 
-	if n <= position.Offset {
+	if r.buffer.Len() <= position.Offset {
 		return nil, io.EOF
 	}
 
@@ -776,37 +1337,237 @@ func (r *sstableDataReader) ReadValueAt(position blockstore.Position) (types.Val
 	return record.Value, nil
 }
 
+func (r *sstableDataReader) readCompressedValueAt(position blockstore.Position) (types.ValueType, error) {
+	block, err := r.readCompressedBlock(uint(position.Block))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(block) <= position.Offset {
+		return nil, io.EOF
+	}
+
+	record := &SSTableDataRecord{}
+	err = record.UnMarshall(bytes.NewReader(block[position.Offset:]))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return record.Value, nil
+}
+
 type sstableReaderStruct struct {
 	indexReader sstableIndexReader
 	dataReader  sstableDataReader
+	filter      *bloom.Filter
+
+	// cursor of ReadNext and ReadPrev, independent of point lookups.
+	// It sits before scanEntries[scanPos] of index block scanBlock,
+	// block 0 means no block is loaded yet.
+	scanBlock   uint
+	scanEntries []SSTableIndexEntry
+	scanPos     int
 }
 
-func (r *sstableReaderStruct) ReadNext() (key types.KeyType, value types.ValueType, deleted bool, err error) {
-	return
+// KeyMayExist is false only when the bloom filter rules the key out.
+func (r *sstableReaderStruct) KeyMayExist(key types.KeyType) bool {
+	return r.filter == nil || r.filter.MayContain(key)
 }
 
-func (r *sstableReaderStruct) FindRecord(key types.KeyType) (value types.ValueType, deleted bool, ok bool, err error) {
-	entry, ok, err := r.indexReader.FindIndexForKey(key)
-	// error
+func (r *sstableReaderStruct) loadScanBlock(block uint) error {
+	entries, err := r.indexReader.readEntries(block)
 	if err != nil {
-		return nil, false, false, err
+		return err
 	}
-	// not found
-	if ! ok || entry == nil {
-		return nil, false, false, nil
+
+	r.scanBlock = block
+	r.scanEntries = entries
+	return nil
+}
+
+// ReadNext returns records in key order, newest version of a key first,
+// and io.EOF after the last one.
+func (r *sstableReaderStruct) ReadNext() (Record, error) {
+	for r.scanPos >= len(r.scanEntries) {
+		if r.scanBlock >= uint(r.indexReader.header.BlockCount) {
+			return Record{}, io.EOF
+		}
+
+		err := r.loadScanBlock(r.scanBlock + 1)
+		if err != nil {
+			return Record{}, err
+		}
+
+		r.scanPos = 0
+	}
+
+	entry := &r.scanEntries[r.scanPos]
+	r.scanPos++
+
+	return r.readRecord(entry)
+}
+
+// ReadPrev returns records in reverse order of ReadNext and io.EOF before the first one.
+func (r *sstableReaderStruct) ReadPrev() (Record, error) {
+	for r.scanPos == 0 {
+		if r.scanBlock <= 1 {
+			return Record{}, io.EOF
+		}
+
+		err := r.loadScanBlock(r.scanBlock - 1)
+		if err != nil {
+			return Record{}, err
+		}
+
+		r.scanPos = len(r.scanEntries)
+	}
+
+	r.scanPos--
+	entry := &r.scanEntries[r.scanPos]
+
+	return r.readRecord(entry)
+}
+
+// Seek positions ReadNext at the first record with key >= key,
+// a nil key positions it at the first record.
+func (r *sstableReaderStruct) Seek(key types.KeyType) error {
+	return r.seek(key, false, func(entry []byte) bool {
+		return bytes.Compare(entry, key) >= 0
+	})
+}
+
+// SeekForPrev positions ReadPrev at the last record with key <= key,
+// a nil key positions it at the last record.
+func (r *sstableReaderStruct) SeekForPrev(key types.KeyType) error {
+	if key == nil {
+		return r.seekToEnd()
+	}
+
+	return r.seek(key, true, func(entry []byte) bool {
+		return bytes.Compare(entry, key) > 0
+	})
+}
+
+// seek places the cursor before the first entry of key's block for which after is true.
+func (r *sstableReaderStruct) seek(key types.KeyType, inclusive bool, after func(entry []byte) bool) error {
+	r.scanBlock, r.scanEntries, r.scanPos = 0, nil, 0
+
+	if key == nil || r.indexReader.header.BlockCount < 1 {
+		return nil
+	}
+
+	block, err := r.indexReader.blockForKey(key, inclusive)
+	if err != nil {
+		return err
 	}
 
-	if entry.Flags == SSTableIndexKeyDelete {
-		return nil, true, true, nil
+	err = r.loadScanBlock(block)
+	if err != nil {
+		return err
+	}
+
+	r.scanPos = sort.Search(len(r.scanEntries), func(i int) bool {
+		return after(r.scanEntries[i].LargeKey)
+	})
+
+	return nil
+}
+
+func (r *sstableReaderStruct) seekToEnd() error {
+	r.scanBlock, r.scanEntries, r.scanPos = 0, nil, 0
+
+	blockCount := uint(r.indexReader.header.BlockCount)
+	if blockCount < 1 {
+		return nil
+	}
+
+	err := r.loadScanBlock(blockCount)
+	if err != nil {
+		return err
+	}
+
+	r.scanPos = len(r.scanEntries)
+	return nil
+}
+
+func (r *sstableReaderStruct) readRecord(entry *SSTableIndexEntry) (Record, error) {
+	record := Record{
+		Key:          entry.LargeKey,
+		Seq:          entry.Seq,
+		Deleted:      entry.Deleted(),
+		MergeOperand: entry.MergeOperand(),
+		ExpiresAt:    entry.ExpiresAt,
+	}
+
+	if record.Deleted {
+		return record, nil
 	}
 
 	pos := blockstore.Position{}
 	pos.DecodeUint64(entry.DataFileOffSet)
 
-	value, err = r.dataReader.ReadValueAt(pos)
+	value, err := r.dataReader.ReadValueAt(pos)
+	if err != nil {
+		return Record{}, err
+	}
+
+	record.Value = value
+	return record, nil
+}
+
+// KeyRange returns the first and last key of the table, ok is false for an empty table.
+func (r *sstableReaderStruct) KeyRange() (first types.KeyType, last types.KeyType, ok bool, err error) {
+	blockCount := uint(r.indexReader.header.BlockCount)
+	if blockCount < 1 {
+		return nil, nil, false, nil
+	}
+
+	firstEntry, err := r.indexReader.getFirstEntryOfBlock(1)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	indexBlock, err := r.indexReader.readBlock(blockCount)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	lastEntry := &SSTableIndexEntry{}
+	for i := uint32(0); i < indexBlock.KeyCount; i++ {
+		err = r.indexReader.readEntry(lastEntry, lastEntry.LargeKey)
+		if err != nil {
+			return nil, nil, false, err
+		}
+	}
+
+	return firstEntry.LargeKey, lastEntry.LargeKey, true, nil
+}
+
+func (r *sstableReaderStruct) FindRecord(key types.KeyType) (value types.ValueType, deleted bool, ok bool, err error) {
+	record, ok, err := r.FindVersion(key, types.MaxSequenceNumber)
+	return record.Value, record.Deleted, ok, err
+}
+
+func (r *sstableReaderStruct) FindVersion(key types.KeyType, seq uint64) (record Record, ok bool, err error) {
+	if !r.KeyMayExist(key) {
+		return Record{}, false, nil
+	}
+
+	entry, ok, err := r.indexReader.FindIndexForKey(key, seq)
+	// error
+	if err != nil {
+		return Record{}, false, err
+	}
+	// not found
+	if ! ok || entry == nil {
+		return Record{}, false, nil
+	}
+
+	record, err = r.readRecord(entry)
 	if err != nil {
-		return nil, false, true, err
+		return Record{}, true, err
 	}
 
-	return value, false, true, nil
+	return record, true, nil
 }
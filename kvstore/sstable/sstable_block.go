@@ -0,0 +1,86 @@
+package sstable
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/zl14917/MastersProject/kvstore/blockstore"
+	"github.com/zl14917/MastersProject/kvstore/crc"
+)
+
+// Every block of index and data files ends with a CRC-32C
+// of the bytes before it.
+//
+// | payload, blockSize - 4 bytes | crc32c of payload, 4 bytes |
+const blockTrailerSize = 4
+
+func blockPayloadSize(blockSize int) int {
+	return blockSize - blockTrailerSize
+}
+
+// writeChecksummedBlock pads payload to the block payload size,
+// appends the checksum trailer and writes it as block index.
+func writeChecksummedBlock(storage blockstore.BlockStorage, index uint, payload []byte, scratch *bytes.Buffer) error {
+	var (
+		blockSize   = storage.BlockSize()
+		payloadSize = blockPayloadSize(blockSize)
+		trailer     [blockTrailerSize]byte
+	)
+
+	if len(payload) > payloadSize {
+		return fmt.Errorf("block payload of %d bytes exceeds %d bytes", len(payload), payloadSize)
+	}
+
+	_, err := storage.Allocate(int(index + 1))
+	if err != nil {
+		return err
+	}
+
+	scratch.Reset()
+	scratch.Write(payload)
+	for scratch.Len() < payloadSize {
+		scratch.WriteByte(0)
+	}
+
+	binary.BigEndian.PutUint32(trailer[:], crc.Checksum(scratch.Bytes()))
+	scratch.Write(trailer[:])
+
+	_, err = storage.WriteBlock(index, scratch)
+	return err
+}
+
+// readChecksummedBlock reads block index into buffer and verifies its trailer,
+// buffer is left holding only the block payload.
+func readChecksummedBlock(storage blockstore.BlockStorage, filePath string, index uint, buffer *bytes.Buffer) error {
+	blockSize := storage.BlockSize()
+
+	buffer.Reset()
+	n, err := storage.ReadBlock(index, buffer)
+
+	if err != nil {
+		return err
+	}
+
+	if n < blockSize {
+		return fmt.Errorf("short read of block %d in %s: %d of %d bytes", index, filePath, n, blockSize)
+	}
+
+	block := buffer.Bytes()[0:blockSize]
+	payloadSize := blockPayloadSize(blockSize)
+
+	stored := binary.BigEndian.Uint32(block[payloadSize:])
+	computed := crc.Checksum(block[0:payloadSize])
+
+	if stored != computed {
+		return &crc.CorruptionError{
+			FilePath: filePath,
+			Block:    int(index),
+			Offset:   int64(index) * int64(blockSize),
+			Stored:   stored,
+			Computed: computed,
+		}
+	}
+
+	buffer.Truncate(payloadSize)
+	return nil
+}
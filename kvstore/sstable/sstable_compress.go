@@ -0,0 +1,127 @@
+package sstable
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// BlockCodec compresses whole data blocks, stored in the data file header.
+// Files written before codecs read back as CodecNone.
+type BlockCodec uint32
+
+const (
+	CodecNone BlockCodec = iota
+	// DEFLATE at its fastest level
+	CodecFlate
+)
+
+const (
+	DataFileCompressed DataFileFlags = 1 << iota
+)
+
+// A compressed data block collects this many storage blocks worth of values
+// before it is compressed, so that it can be stored in fewer storage blocks.
+const compressedBlockFactor = 4
+
+// Compressed blocks start at a storage block and continue over as many
+// storage blocks as they need.
+//
+// | compressed length, 4 bytes | uncompressed length, 4 bytes | compressed bytes |
+const compressedFrameHeaderSize = 8
+
+type blockCompressor interface {
+	Compress(dst *bytes.Buffer, src []byte) error
+	Decompress(dst *bytes.Buffer, src []byte) error
+}
+
+// compressors keep state between blocks, every writer and reader gets its own
+var blockCompressors = map[BlockCodec]func() blockCompressor{
+	CodecFlate: func() blockCompressor { return &flateCompressor{} },
+}
+
+var blockCodecNames = map[BlockCodec]string{
+	CodecNone:  "none",
+	CodecFlate: "flate",
+}
+
+func (c BlockCodec) String() string {
+	name, ok := blockCodecNames[c]
+	if !ok {
+		return fmt.Sprintf("codec(%d)", uint32(c))
+	}
+	return name
+}
+
+func ParseBlockCodec(name string) (BlockCodec, error) {
+	if name == "" {
+		return CodecNone, nil
+	}
+
+	for codec, codecName := range blockCodecNames {
+		if codecName == name {
+			return codec, nil
+		}
+	}
+
+	return CodecNone, fmt.Errorf("unknown block codec %q", name)
+}
+
+// newCompressor returns nil for CodecNone.
+func newCompressor(codec BlockCodec) (blockCompressor, error) {
+	if codec == CodecNone {
+		return nil, nil
+	}
+
+	newCompressor, ok := blockCompressors[codec]
+	if !ok {
+		return nil, fmt.Errorf("unsupported block codec %v", codec)
+	}
+	return newCompressor(), nil
+}
+
+type flateCompressor struct {
+	writer *flate.Writer
+}
+
+func (c *flateCompressor) Compress(dst *bytes.Buffer, src []byte) error {
+	var err error
+
+	if c.writer == nil {
+		c.writer, err = flate.NewWriter(dst, flate.BestSpeed)
+		if err != nil {
+			return err
+		}
+	} else {
+		c.writer.Reset(dst)
+	}
+
+	_, err = c.writer.Write(src)
+	if err != nil {
+		return err
+	}
+
+	return c.writer.Close()
+}
+
+func (c *flateCompressor) Decompress(dst *bytes.Buffer, src []byte) error {
+	reader := flate.NewReader(bytes.NewReader(src))
+	_, err := io.Copy(dst, reader)
+
+	closeErr := reader.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+func putCompressedFrameHeader(frame []byte, compressedLen int, uncompressedLen int) {
+	binary.BigEndian.PutUint32(frame[0:4], uint32(compressedLen))
+	binary.BigEndian.PutUint32(frame[4:8], uint32(uncompressedLen))
+}
+
+func compressedFrameHeader(frame []byte) (compressedLen int, uncompressedLen int) {
+	return int(binary.BigEndian.Uint32(frame[0:4])), int(binary.BigEndian.Uint32(frame[4:8]))
+}
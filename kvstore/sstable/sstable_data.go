@@ -21,6 +21,7 @@ type SSTableDataFileHeader struct {
 	BlockSize   uint32
 	BlockCount  uint32
 	ValuesCount uint32
+	Codec       BlockCodec
 }
 
 var UnitializedSSTableDataFileHeader = SSTableDataFileHeader{
@@ -29,6 +30,7 @@ var UnitializedSSTableDataFileHeader = SSTableDataFileHeader{
 	BlockSize:   HeaderUninitialized,
 	BlockCount:  HeaderUninitialized,
 	ValuesCount: HeaderUninitialized,
+	Codec:       CodecNone,
 }
 
 func (h *SSTableDataFileHeader) Marshall(w io.Writer) error {
@@ -68,6 +70,12 @@ func (h *SSTableDataFileHeader) Marshall(w io.Writer) error {
 		return err
 	}
 
+	binary.BigEndian.PutUint32(uint32buffer, uint32(h.Codec))
+	_, err = w.Write(uint32buffer)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -84,6 +92,9 @@ func (h *SSTableDataFileHeader) UnMarshall(r io.Reader) error {
 	}
 
 	h.Magic = binary.BigEndian.Uint32(uint32buffer)
+	if h.Magic != DataFileMagic {
+		return InvalidHeaderMagicErr
+	}
 
 	_, err = r.Read(uint32buffer)
 	if err != nil {
@@ -113,6 +124,13 @@ func (h *SSTableDataFileHeader) UnMarshall(r io.Reader) error {
 
 	h.ValuesCount = binary.BigEndian.Uint32(uint32buffer)
 
+	_, err = r.Read(uint32buffer)
+	if err != nil {
+		return err
+	}
+
+	h.Codec = BlockCodec(binary.BigEndian.Uint32(uint32buffer))
+
 	return nil
 }
 
@@ -144,12 +162,17 @@ func (d *SSTableDataRecord) Marshall(w io.Writer) error {
 		return err
 	}
 
+	_, err = w.Write(d.Value)
+	if err != nil {
+		return err
+	}
+
 	if gap > 0 {
 		binary.BigEndian.PutUint32(uint32buf, 0)
 		_, err = w.Write(uint32buf[0:gap])
 	}
 
-	return nil
+	return err
 }
 
 func (d *SSTableDataRecord) UnMarshall(r io.Reader) error {
@@ -1,7 +1,7 @@
 package sstable
 
 import (
-	"github.com/zl14917/MastersProject/pkg/kvstore/blockstore"
+	"github.com/zl14917/MastersProject/kvstore/blockstore"
 	"testing"
 )
 
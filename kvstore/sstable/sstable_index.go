@@ -17,12 +17,42 @@ const (
 	indexEntryHeaderSize = unsafe.Sizeof(SSTableIndexEntry{}.Flags) +
 		unsafe.Sizeof(SSTableIndexEntry{}.KeyLen) +
 		unsafe.Sizeof(SSTableIndexEntry{}.DataFileOffSet)
-	IndexFileMagic uint32 = 0x32323232
+	indexEntrySeqSize           = unsafe.Sizeof(SSTableIndexEntry{}.Seq)
+	indexEntryExpirySize        = unsafe.Sizeof(SSTableIndexEntry{}.ExpiresAt)
+	IndexFileMagic       uint32 = 0x32323232
+	// restart count, offset of the first restart and shared key length of its entry
+	prefixedBlockOverhead = 4 + 4 + 4
 )
 
+const (
+	// index blocks of full keys, files written before the format
+	// version was added read back as it
+	IndexFormatFullKeys uint32 = iota
+	// entries store the length of the prefix their key shares with the key
+	// before and the rest of the key, every restart interval entries a key
+	// is stored in full. Blocks start with the offsets of their restart entries.
+	//
+	// | key count 4 | restart count 4 | restart offsets 4 * restart count | entries |
+	IndexFormatPrefixCompressed
+)
+
+const DefaultIndexRestartInterval = 16
+
 const (
 	SSTableIndexKeyInsert IndexKeyFlags = 1 << iota
 	SSTableIndexKeyDelete
+	// the sequence number follows the data file offset,
+	// entries without it were written at sequence number 0
+	SSTableIndexKeyHasSeq
+	// the expiry time follows the sequence number
+	SSTableIndexKeyHasExpiry
+	// the value is a merge operand
+	SSTableIndexKeyMerge
+)
+
+const (
+	// bloom filter blocks follow the index blocks
+	IndexFileHasFilter IndexFileFlags = 1 << iota
 )
 
 var InvalidHeaderMagicErr = errors.New("first 32-bit magic of file is wrong")
@@ -38,15 +68,24 @@ type SSTableIndexFileHeader struct {
 	BlockSize  uint32
 	BlockCount uint32
 	MaxKeySize uint32
+	// files written before filters read back as zero
+	FilterBlockCount uint32
+	// files written before versions read back as IndexFormatFullKeys
+	Version uint32
+	// entries between restart points of prefix compressed blocks
+	RestartInterval uint32
 }
 
 var UnitialzedSSTableIndexFileHeader = SSTableIndexFileHeader{
-	Magic:      IndexFileMagic,
-	Flags:      IndexFileFlags(HeaderUninitialized),
-	MaxKeySize: HeaderUninitialized,
-	BlockSize:  HeaderUninitialized,
-	KeyCount:   HeaderUninitialized,
-	BlockCount: HeaderUninitialized,
+	Magic:            IndexFileMagic,
+	Flags:            IndexFileFlags(HeaderUninitialized),
+	MaxKeySize:       HeaderUninitialized,
+	BlockSize:        HeaderUninitialized,
+	KeyCount:         HeaderUninitialized,
+	BlockCount:       HeaderUninitialized,
+	FilterBlockCount: HeaderUninitialized,
+	Version:          HeaderUninitialized,
+	RestartInterval:  HeaderUninitialized,
 }
 
 type SSTableIndexFile struct {
@@ -78,11 +117,23 @@ func (b *SSTableIndexBlock) UnMarshall(r io.Reader) error {
 	return nil
 }
 
+// Versions of a key are stored newest first, ordered by descending Seq.
 type SSTableIndexEntry struct {
 	Flags          IndexKeyFlags
 	KeyLen         uint32
 	DataFileOffSet uint64
-	LargeKey       []byte
+	Seq            uint64
+	// unix nanoseconds, 0 never expires
+	ExpiresAt int64
+	LargeKey  []byte
+}
+
+func (e *SSTableIndexEntry) Deleted() bool {
+	return e.Flags&SSTableIndexKeyDelete != 0
+}
+
+func (e *SSTableIndexEntry) MergeOperand() bool {
+	return e.Flags&SSTableIndexKeyMerge != 0
 }
 
 func (header *SSTableIndexFileHeader) Marshall(writer io.Writer) error {
@@ -130,6 +181,27 @@ func (header *SSTableIndexFileHeader) Marshall(writer io.Writer) error {
 		return err
 	}
 
+	binary.BigEndian.PutUint32(uint32buffer, header.FilterBlockCount)
+
+	_, err = writer.Write(uint32buffer)
+	if err != nil {
+		return err
+	}
+
+	binary.BigEndian.PutUint32(uint32buffer, header.Version)
+
+	_, err = writer.Write(uint32buffer)
+	if err != nil {
+		return err
+	}
+
+	binary.BigEndian.PutUint32(uint32buffer, header.RestartInterval)
+
+	_, err = writer.Write(uint32buffer)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -179,6 +251,24 @@ func (header *SSTableIndexFileHeader) UnMarshall(reader io.Reader) error {
 	}
 	header.MaxKeySize = binary.BigEndian.Uint32(uint32buf)
 
+	_, err = reader.Read(uint32buf)
+	if err != nil {
+		return err
+	}
+	header.FilterBlockCount = binary.BigEndian.Uint32(uint32buf)
+
+	_, err = reader.Read(uint32buf)
+	if err != nil {
+		return err
+	}
+	header.Version = binary.BigEndian.Uint32(uint32buf)
+
+	_, err = reader.Read(uint32buf)
+	if err != nil {
+		return err
+	}
+	header.RestartInterval = binary.BigEndian.Uint32(uint32buf)
+
 	return nil
 }
 
@@ -221,6 +311,28 @@ func (e *SSTableIndexEntry) Marshall(buffer io.Writer) (nbytes int, err error) {
 
 	nbytes += int(indexEntryHeaderSize)
 
+	if e.Flags&SSTableIndexKeyHasSeq != 0 {
+		binary.BigEndian.PutUint64(uint64buffer, e.Seq)
+		_, err = buffer.Write(uint64buffer)
+
+		if err != nil {
+			return
+		}
+
+		nbytes += int(indexEntrySeqSize)
+	}
+
+	if e.Flags&SSTableIndexKeyHasExpiry != 0 {
+		binary.BigEndian.PutUint64(uint64buffer, uint64(e.ExpiresAt))
+		_, err = buffer.Write(uint64buffer)
+
+		if err != nil {
+			return
+		}
+
+		nbytes += int(indexEntryExpirySize)
+	}
+
 	if len(e.LargeKey) < 1 {
 		return
 	}
@@ -268,6 +380,27 @@ func (e *SSTableIndexEntry) UnMarshall(buffer *bytes.Buffer) error {
 	}
 
 	e.DataFileOffSet = binary.BigEndian.Uint64(uint64buffer)
+	e.Seq = 0
+
+	if e.Flags&SSTableIndexKeyHasSeq != 0 {
+		_, err = buffer.Read(uint64buffer)
+		if err != nil {
+			return err
+		}
+
+		e.Seq = binary.BigEndian.Uint64(uint64buffer)
+	}
+
+	e.ExpiresAt = 0
+
+	if e.Flags&SSTableIndexKeyHasExpiry != 0 {
+		_, err = buffer.Read(uint64buffer)
+		if err != nil {
+			return err
+		}
+
+		e.ExpiresAt = int64(binary.BigEndian.Uint64(uint64buffer))
+	}
 
 	paddedKeySize := NextMultipleOf4Uint(uint(e.KeyLen))
 	e.LargeKey = make([]byte, paddedKeySize, paddedKeySize)
@@ -284,6 +417,169 @@ func (e *SSTableIndexEntry) UnMarshall(buffer *bytes.Buffer) error {
 	return nil
 }
 
+// MarshallPrefixed writes the entry of a prefix compressed block,
+// without the first shared bytes of its key.
+//
+// | flags 4 | shared key len 4 | unshared key len 4 | data offset 8 | seq 8 | expires at 8 | unshared key |
+func (e *SSTableIndexEntry) MarshallPrefixed(buffer io.Writer, shared int) (nbytes int, err error) {
+	if e.KeyLen != uint32(len(e.LargeKey)) || shared > len(e.LargeKey) {
+		return 0, fmt.Errorf(
+			"Entry KeyLen is %d, key length %d, shared %d",
+			e.KeyLen,
+			len(e.LargeKey),
+			shared,
+		)
+	}
+
+	var (
+		smallBytesBuffer [8]byte
+		uint32buffer     = smallBytesBuffer[0:4]
+		uint64buffer     = smallBytesBuffer[0:8]
+		unshared         = e.LargeKey[shared:]
+	)
+
+	for _, field := range []uint32{uint32(e.Flags), uint32(shared), uint32(len(unshared))} {
+		binary.BigEndian.PutUint32(uint32buffer, field)
+		_, err = buffer.Write(uint32buffer)
+		if err != nil {
+			return
+		}
+	}
+
+	binary.BigEndian.PutUint64(uint64buffer, e.DataFileOffSet)
+	_, err = buffer.Write(uint64buffer)
+
+	if err != nil {
+		return
+	}
+
+	nbytes = int(indexEntryHeaderSize) + 4
+
+	if e.Flags&SSTableIndexKeyHasSeq != 0 {
+		binary.BigEndian.PutUint64(uint64buffer, e.Seq)
+		_, err = buffer.Write(uint64buffer)
+
+		if err != nil {
+			return
+		}
+
+		nbytes += int(indexEntrySeqSize)
+	}
+
+	if e.Flags&SSTableIndexKeyHasExpiry != 0 {
+		binary.BigEndian.PutUint64(uint64buffer, uint64(e.ExpiresAt))
+		_, err = buffer.Write(uint64buffer)
+
+		if err != nil {
+			return
+		}
+
+		nbytes += int(indexEntryExpirySize)
+	}
+
+	_, err = buffer.Write(unshared)
+	if err != nil {
+		return
+	}
+
+	return nbytes + len(unshared), nil
+}
+
+// UnMarshallPrefixed reads the entry of a prefix compressed block,
+// prevKey is the key of the entry before it in the block, nil at restart points.
+func (e *SSTableIndexEntry) UnMarshallPrefixed(buffer *bytes.Buffer, prevKey []byte) error {
+	var (
+		smallBytesBuffer [8]byte
+		uint32buffer     = smallBytesBuffer[0:4]
+		uint64buffer     = smallBytesBuffer[0:8]
+		fields           [3]uint32
+	)
+
+	for i := range fields {
+		_, err := buffer.Read(uint32buffer)
+		if err != nil {
+			return err
+		}
+		fields[i] = binary.BigEndian.Uint32(uint32buffer)
+	}
+
+	e.Flags = IndexKeyFlags(fields[0])
+	shared, unshared := fields[1], fields[2]
+
+	if int(shared) > len(prevKey) {
+		return fmt.Errorf("entry shares %d bytes of a %d byte key", shared, len(prevKey))
+	}
+
+	_, err := buffer.Read(uint64buffer)
+	if err != nil {
+		return err
+	}
+
+	e.DataFileOffSet = binary.BigEndian.Uint64(uint64buffer)
+	e.Seq = 0
+
+	if e.Flags&SSTableIndexKeyHasSeq != 0 {
+		_, err = buffer.Read(uint64buffer)
+		if err != nil {
+			return err
+		}
+
+		e.Seq = binary.BigEndian.Uint64(uint64buffer)
+	}
+
+	e.ExpiresAt = 0
+
+	if e.Flags&SSTableIndexKeyHasExpiry != 0 {
+		_, err = buffer.Read(uint64buffer)
+		if err != nil {
+			return err
+		}
+
+		e.ExpiresAt = int64(binary.BigEndian.Uint64(uint64buffer))
+	}
+
+	if uint32(buffer.Len()) < unshared {
+		return fmt.Errorf("expect %d unshared key bytes but only %d left", unshared, buffer.Len())
+	}
+
+	e.KeyLen = shared + unshared
+	e.LargeKey = make([]byte, e.KeyLen)
+	copy(e.LargeKey, prevKey[0:shared])
+	copy(e.LargeKey[shared:], buffer.Next(int(unshared)))
+
+	return nil
+}
+
+// readRestarts reads the restart offsets that follow the key count of a prefix compressed block.
+func readRestarts(buffer *bytes.Buffer, restarts []uint32) ([]uint32, error) {
+	var uint32buffer [4]byte
+
+	_, err := buffer.Read(uint32buffer[:])
+	if err != nil {
+		return nil, err
+	}
+
+	count := binary.BigEndian.Uint32(uint32buffer[:])
+	if int(count)*4 > buffer.Len() {
+		return nil, fmt.Errorf("index block restart count %d overruns the block", count)
+	}
+
+	restarts = restarts[:0]
+	for i := uint32(0); i < count; i++ {
+		restarts = append(restarts, binary.BigEndian.Uint32(buffer.Next(4)))
+	}
+
+	return restarts, nil
+}
+
+func sharedPrefixLen(a []byte, b []byte) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
 func validateBlockSize(blockSize uint32) bool {
 	return blockSize%BaseBlockSize == 0
 }
@@ -332,15 +628,28 @@ func (index *SSTableIndexFile) CreateFile() error {
 
 func MaxKeySizeFitInBlocK(blockSize int) int {
 	availableBlockBytes := blockSize - int(blockHeaderSize)
-	availableEntryBytes := availableBlockBytes - int(indexEntryHeaderSize)
+	availableEntryBytes := availableBlockBytes - int(indexEntryHeaderSize) -
+		int(indexEntrySeqSize) - int(indexEntryExpirySize) - prefixedBlockOverhead
 
 	return availableEntryBytes
 }
 
+// sizeOfEntryFields is the size of the optional sequence number and expiry time.
+func sizeOfEntryFields(entry *SSTableIndexEntry) int {
+	size := 0
+	if entry.Flags&SSTableIndexKeyHasSeq != 0 {
+		size += int(indexEntrySeqSize)
+	}
+	if entry.Flags&SSTableIndexKeyHasExpiry != 0 {
+		size += int(indexEntryExpirySize)
+	}
+	return size
+}
+
 func SizeOfIndexEntry(entry *SSTableIndexEntry) int {
-	return int(indexEntryHeaderSize) + int(entry.KeyLen)
+	return int(indexEntryHeaderSize) + sizeOfEntryFields(entry) + int(entry.KeyLen)
 }
 
 func MarshalledSizeOfIndexEntry(entry *SSTableIndexEntry) int {
-	return int(indexEntryHeaderSize) + int(NextMultipleOf4Uint(uint(entry.KeyLen)))
+	return int(indexEntryHeaderSize) + sizeOfEntryFields(entry) + int(NextMultipleOf4Uint(uint(entry.KeyLen)))
 }
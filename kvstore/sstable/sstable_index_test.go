@@ -2,7 +2,8 @@ package sstable
 
 import (
 	"bytes"
-	"github.com/zl14917/MastersProject/pkg/kvstore/blockstore"
+	"github.com/zl14917/MastersProject/kvstore/blockstore"
+	"github.com/zl14917/MastersProject/kvstore/types"
 	"reflect"
 	"testing"
 )
@@ -69,11 +70,11 @@ func TestSSTableBlockIndexWriter(t *testing.T) {
 
 	indexWriter := newSSTableIndexWriter(storage)
 
-	err := indexWriter.WriteIndex(key, false, position)
+	err := indexWriter.WriteIndex(key, 0, 0, false, false, position)
 	if err != nil {
 		t.Errorf("error writing index key: %s", string(key))
 	}
-	err = indexWriter.WriteIndex(key2, true, position)
+	err = indexWriter.WriteIndex(key2, 0, 0, true, false, position)
 
 	if err != nil {
 		t.Error(err)
@@ -95,7 +96,7 @@ func TestSSTableBlockIndexWriter(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	entry, ok, err := indexReader.FindIndexForKey(key)
+	entry, ok, err := indexReader.FindIndexForKey(key, types.MaxSequenceNumber)
 
 	if err != nil {
 		t.Error(err)
@@ -120,10 +121,49 @@ func TestSSTableBlockIndexWriter(t *testing.T) {
 		t.Errorf("key not the same, written %s, read %s", string(key), string(entry.LargeKey))
 	}
 
-	entry, ok, err = indexReader.FindIndexForKey(key2)
+	entry, ok, err = indexReader.FindIndexForKey(key2, types.MaxSequenceNumber)
 
 	if entry == nil {
 		t.Error("key 2 should exist:", key2)
 	}
 
 }
+
+func TestSSTableIndexEntry_MarshallPrefixed(t *testing.T) {
+	prevKey := []byte("tenant/123/user/alice")
+	entry := SSTableIndexEntry{
+		Flags:          SSTableIndexKeyInsert | SSTableIndexKeyHasSeq | SSTableIndexKeyHasExpiry,
+		KeyLen:         uint32(len("tenant/123/user/bob")),
+		DataFileOffSet: 123456789,
+		Seq:            42,
+		ExpiresAt:      1000,
+		LargeKey:       []byte("tenant/123/user/bob"),
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	shared := sharedPrefixLen(prevKey, entry.LargeKey)
+
+	n, err := entry.MarshallPrefixed(buffer, shared)
+	if err != nil {
+		t.Fatalf("error marshalling entry: %v", err)
+	}
+
+	if n != buffer.Len() || n != SizeOfIndexEntry(&entry)+4-shared {
+		t.Errorf("marshalled entry should store only the unshared key, got %d bytes", n)
+	}
+
+	var unmarshalledEntry SSTableIndexEntry
+	err = unmarshalledEntry.UnMarshallPrefixed(buffer, prevKey)
+	if err != nil {
+		t.Fatal("error unmarshalling entry:", err)
+	}
+
+	if !reflect.DeepEqual(unmarshalledEntry, entry) {
+		t.Errorf("unmarshalled entry %+v does not equal %+v", unmarshalledEntry, entry)
+	}
+
+	err = unmarshalledEntry.UnMarshallPrefixed(bytes.NewBuffer(buffer.Bytes()), nil)
+	if err == nil {
+		t.Error("entry sharing a prefix should not read without the key before it")
+	}
+}
@@ -4,18 +4,48 @@ import (
 	"github.com/zl14917/MastersProject/kvstore/types"
 )
 
-// SSTableWriter assumes that the keys are sorted.
+// Record is one version of a key.
+type Record struct {
+	Key     types.KeyType
+	Value   types.ValueType
+	Seq     uint64
+	Deleted bool
+	// the value is an operand of the store's merge operator,
+	// folded into the older versions of the key when read
+	MergeOperand bool
+	// unix nanoseconds, the record reads as deleted from then on, 0 never expires
+	ExpiresAt int64
+}
+
+// Expired is true once now reached the expiry time of the record.
+func (r *Record) Expired(now int64) bool {
+	return r.ExpiresAt != 0 && r.ExpiresAt <= now
+}
+
+// SSTableWriter assumes that the keys are sorted, and versions of a key
+// are written newest first. Write stores a version at sequence number 0.
 // key and values exceeding maximum size will be rejected.
 type SSTableWriter interface {
 	Write(key types.KeyType, value types.ValueType, deleted bool) error
+	WriteVersion(key types.KeyType, value types.ValueType, seq uint64, deleted bool) error
+	WriteRecord(record Record) error
 	MaxKeySize() int
 	MaxValueSize() int
 	Commit() error
 }
 
+// ReadNext and ReadPrev move one cursor in either direction over all versions,
+// Seek and SeekForPrev place it by key. FindRecord returns the newest version
+// of a key, FindVersion the newest one at or before sequence number seq.
 type SSTableReader interface {
-	ReadNext() (key types.KeyType, value types.ValueType, deleted bool, err error)
+	ReadNext() (Record, error)
+	ReadPrev() (Record, error)
+	Seek(key types.KeyType) error
+	SeekForPrev(key types.KeyType) error
 	FindRecord(key types.KeyType) (value types.ValueType, deleted bool, ok bool, err error)
+	FindVersion(key types.KeyType, seq uint64) (record Record, ok bool, err error)
+	KeyMayExist(key types.KeyType) bool
+	KeyRange() (first types.KeyType, last types.KeyType, ok bool, err error)
 }
 
 
@@ -1,6 +1,11 @@
 package sstable
 
 import (
+	"bytes"
+	"fmt"
+	"github.com/zl14917/MastersProject/kvstore/crc"
+	"github.com/zl14917/MastersProject/kvstore/types"
+	"io"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -54,3 +59,508 @@ func TestNewSSTable(t *testing.T) {
 		}
 	})
 }
+
+func TestSSTable_FindRecord(t *testing.T) {
+	var options = defaultSSTableOpenOptions
+	options.InMemStore = true
+	options.IndexBlockSize = 256
+	options.DataBlockSize = 256
+
+	sstable := NewSSTable("", &options)
+
+	writer, err := sstable.NewWriter()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key_%03d", i)
+		err = writer.Write([]byte(key), []byte("value_"+key), i%10 == 9)
+		if err != nil {
+			t.Errorf("error writing key %s: %v", key, err)
+			return
+		}
+	}
+
+	err = writer.Commit()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	reader, err := sstable.NewReader()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key_%03d", i)
+		value, deleted, ok, err := reader.FindRecord([]byte(key))
+
+		if err != nil || !ok {
+			t.Errorf("key %s should be found, ok: %v, err: %v", key, ok, err)
+			continue
+		}
+
+		if deleted != (i%10 == 9) {
+			t.Errorf("key %s deleted flag wrong", key)
+		}
+
+		if !deleted && string(value) != "value_"+key {
+			t.Errorf("key %s expect value %s, got %s", key, "value_"+key, string(value))
+		}
+	}
+
+	_, _, ok, err := reader.FindRecord([]byte("key_0505"))
+	if ok || err != nil {
+		t.Errorf("key_0505 should not be found, ok: %v, err: %v", ok, err)
+	}
+}
+
+func TestSSTable_BlockChecksum(t *testing.T) {
+	var options = defaultSSTableOpenOptions
+	options.InMemStore = true
+
+	sstable := NewSSTable("", &options)
+
+	writer, err := sstable.NewWriter()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_ = writer.Write([]byte("hello"), []byte("world"), false)
+	_ = writer.Commit()
+
+	buffer := bytes.NewBuffer(nil)
+	_, _ = sstable.dataStorage.ReadBlock(1, buffer)
+	buffer.Bytes()[5] ^= 0xff
+	_, _ = sstable.dataStorage.WriteBlock(1, buffer)
+
+	reader, err := sstable.NewReader()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, _, _, err = reader.FindRecord([]byte("hello"))
+	corruption, ok := err.(*crc.CorruptionError)
+
+	if !ok {
+		t.Errorf("expect corruption error, got %v", err)
+		return
+	}
+
+	if corruption.Block != 1 || corruption.FilePath != sstable.DataFilePath {
+		t.Errorf("corruption should be reported at data block 1, got %v", corruption)
+	}
+}
+
+func TestSSTable_BloomFilter(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		var options = defaultSSTableOpenOptions
+		options.Timestamp = 1
+		options.IndexBlockSize = 256
+		options.DataBlockSize = 256
+
+		table := NewSSTable(dirPath, &options)
+		defer table.PermanentlyRemove()
+
+		writer, err := table.NewWriter()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		for i := 0; i < 500; i++ {
+			key := fmt.Sprintf("key_%04d", i)
+			err = writer.Write([]byte(key), []byte("value_"+key), false)
+			if err != nil {
+				t.Errorf("error writing key %s: %v", key, err)
+				return
+			}
+		}
+
+		err = writer.Commit()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		_ = table.Close()
+
+		table = LoadSSTableFrom(dirPath, &options)
+		reader, err := table.NewReader()
+		if err != nil {
+			t.Error("error opening committed table", err)
+			return
+		}
+
+		for i := 0; i < 500; i++ {
+			key := fmt.Sprintf("key_%04d", i)
+			value, _, ok, err := reader.FindRecord([]byte(key))
+
+			if err != nil || !ok || string(value) != "value_"+key {
+				t.Errorf("key %s should be found, ok: %v, err: %v", key, ok, err)
+				return
+			}
+		}
+
+		ruledOut := 0
+		for i := 0; i < 500; i++ {
+			if !reader.KeyMayExist([]byte(fmt.Sprintf("missing_%04d", i))) {
+				ruledOut++
+			}
+		}
+
+		if ruledOut < 450 {
+			t.Errorf("filter should rule out most missing keys, ruled out %d of 500", ruledOut)
+		}
+	})
+}
+
+func writeCompressibleTable(t *testing.T, codec BlockCodec) *SSTable {
+	var options = defaultSSTableOpenOptions
+	options.InMemStore = true
+	options.IndexBlockSize = 1024
+	options.DataBlockSize = 1024
+	options.DataBlockCodec = codec
+
+	table := NewSSTable("", &options)
+
+	writer, err := table.NewWriter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key_%04d", i)
+		value := bytes.Repeat([]byte(fmt.Sprintf("value_%d_", i%7)), 10)
+
+		err = writer.Write([]byte(key), value, i%50 == 0)
+		if err != nil {
+			t.Fatalf("error writing key %s: %v", key, err)
+		}
+	}
+
+	err = writer.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return table
+}
+
+func TestSSTable_CompressedDataBlocks(t *testing.T) {
+	plain := writeCompressibleTable(t, CodecNone)
+	compressed := writeCompressibleTable(t, CodecFlate)
+
+	if compressed.dataStorage.NumBlocks()*2 > plain.dataStorage.NumBlocks() {
+		t.Errorf(
+			"compressed data file should be much smaller, %d blocks compressed and %d plain",
+			compressed.dataStorage.NumBlocks(),
+			plain.dataStorage.NumBlocks(),
+		)
+	}
+
+	// the codec in the header is used regardless of the open options
+	compressed.DataBlockCodec = CodecNone
+
+	reader, err := compressed.NewReader()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i := 999; i >= 0; i-- {
+		key := fmt.Sprintf("key_%04d", i)
+		value, deleted, ok, err := reader.FindRecord([]byte(key))
+
+		if err != nil || !ok {
+			t.Errorf("key %s should be found, ok: %v, err: %v", key, ok, err)
+			return
+		}
+
+		if deleted != (i%50 == 0) {
+			t.Errorf("key %s deleted flag wrong", key)
+		}
+
+		expect := bytes.Repeat([]byte(fmt.Sprintf("value_%d_", i%7)), 10)
+		if !deleted && !bytes.Equal(value, expect) {
+			t.Errorf("key %s expect value %s, got %s", key, expect, value)
+			return
+		}
+	}
+}
+
+func TestParseBlockCodec(t *testing.T) {
+	for _, codec := range []BlockCodec{CodecNone, CodecFlate} {
+		parsed, err := ParseBlockCodec(codec.String())
+		if err != nil || parsed != codec {
+			t.Errorf("codec %v parsed as %v: %v", codec, parsed, err)
+		}
+	}
+
+	_, err := ParseBlockCodec("unknown")
+	if err == nil {
+		t.Error("unknown codec should fail to parse")
+	}
+}
+
+func TestSSTable_SeekAndReadPrev(t *testing.T) {
+	var options = defaultSSTableOpenOptions
+	options.InMemStore = true
+	options.IndexBlockSize = 256
+	options.DataBlockSize = 256
+
+	table := NewSSTable("", &options)
+
+	writer, err := table.NewWriter()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// even keys only, so that seeks can land between keys
+	for i := 0; i < 200; i += 2 {
+		key := fmt.Sprintf("key_%04d", i)
+		_ = writer.Write([]byte(key), []byte("value_"+key), false)
+	}
+
+	err = writer.Commit()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	reader, err := table.NewReader()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expectKey := func(key []byte, err error, expect string) bool {
+		if err != nil || string(key) != expect {
+			t.Errorf("expect key %s, got %s: %v", expect, key, err)
+			return false
+		}
+		return true
+	}
+
+	_ = reader.SeekForPrev(nil)
+	for i := 198; i >= 0; i -= 2 {
+		record, err := reader.ReadPrev()
+		if !expectKey(record.Key, err, fmt.Sprintf("key_%04d", i)) {
+			return
+		}
+	}
+
+	if _, err = reader.ReadPrev(); err != io.EOF {
+		t.Errorf("expect io.EOF before the first key, got %v", err)
+	}
+
+	_ = reader.Seek([]byte("key_0101"))
+	record, err := reader.ReadNext()
+	expectKey(record.Key, err, "key_0102")
+
+	// the cursor moves back over the key just read
+	record, err = reader.ReadPrev()
+	expectKey(record.Key, err, "key_0102")
+	record, err = reader.ReadPrev()
+	expectKey(record.Key, err, "key_0100")
+
+	_ = reader.SeekForPrev([]byte("key_0101"))
+	record, err = reader.ReadPrev()
+	expectKey(record.Key, err, "key_0100")
+
+	_ = reader.SeekForPrev([]byte("key_0100"))
+	record, err = reader.ReadPrev()
+	expectKey(record.Key, err, "key_0100")
+
+	_ = reader.Seek([]byte("key_0199"))
+	if _, err = reader.ReadNext(); err != io.EOF {
+		t.Errorf("expect io.EOF after the last key, got %v", err)
+	}
+
+	_ = reader.SeekForPrev([]byte("a"))
+	if _, err = reader.ReadPrev(); err != io.EOF {
+		t.Errorf("expect io.EOF before the first key, got %v", err)
+	}
+}
+
+func TestSSTable_FindVersion(t *testing.T) {
+	var options = defaultSSTableOpenOptions
+	options.InMemStore = true
+	options.IndexBlockSize = 256
+	options.DataBlockSize = 256
+
+	sstable := NewSSTable("", &options)
+
+	writer, err := sstable.NewWriter()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// versions of a key are written newest first
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key_%03d", i)
+
+		for _, seq := range []uint64{30, 20, 10} {
+			value := fmt.Sprintf("value_%d", seq)
+			err = writer.WriteVersion([]byte(key), []byte(value), seq, seq == 20 && i%5 == 0)
+			if err != nil {
+				t.Errorf("error writing key %s: %v", key, err)
+				return
+			}
+		}
+	}
+
+	err = writer.Commit()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	reader, err := sstable.NewReader()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key_%03d", i)
+
+		for _, read := range []struct {
+			seq    uint64
+			expect string
+		}{{35, "value_30"}, {30, "value_30"}, {25, "value_20"}, {15, "value_10"}} {
+			record, ok, err := reader.FindVersion([]byte(key), read.seq)
+
+			if err != nil || !ok {
+				t.Errorf("key %s should be found at %d, ok: %v, err: %v", key, read.seq, ok, err)
+				continue
+			}
+
+			if read.expect == "value_20" && i%5 == 0 {
+				if !record.Deleted {
+					t.Errorf("key %s should be deleted at %d", key, read.seq)
+				}
+				continue
+			}
+
+			if record.Deleted || string(record.Value) != read.expect {
+				t.Errorf("key %s at %d expect %s, got %s deleted: %v", key, read.seq, read.expect, record.Value, record.Deleted)
+			}
+		}
+
+		_, ok, err := reader.FindVersion([]byte(key), 5)
+		if ok || err != nil {
+			t.Errorf("key %s should not be found at 5, ok: %v, err: %v", key, ok, err)
+		}
+	}
+
+	value, _, ok, err := reader.FindRecord([]byte("key_007"))
+	if !ok || err != nil || string(value) != "value_30" {
+		t.Errorf("FindRecord should return the newest version, got %s, ok: %v, err: %v", value, ok, err)
+	}
+}
+
+func TestSSTable_PrefixCompressedIndex(t *testing.T) {
+	write := func(restartInterval int) *SSTable {
+		var options = defaultSSTableOpenOptions
+		options.InMemStore = true
+		options.IndexBlockSize = 512
+		options.DataBlockSize = 512
+		options.IndexRestartInterval = restartInterval
+
+		sstable := NewSSTable("", &options)
+
+		writer, err := sstable.NewWriter()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 200; i++ {
+			key := fmt.Sprintf("tenant/123/user/%04d", i)
+
+			for _, seq := range []uint64{30, 20, 10} {
+				err = writer.WriteVersion([]byte(key), []byte(fmt.Sprintf("%s@%d", key, seq)), seq, false)
+				if err != nil {
+					t.Fatalf("error writing key %s: %v", key, err)
+				}
+			}
+		}
+
+		if err = writer.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		return sstable
+	}
+
+	// tables written before prefix compression are still read
+	for _, restartInterval := range []int{0, 1, 4, DefaultIndexRestartInterval} {
+		sstable := write(restartInterval)
+
+		reader, err := sstable.NewReader()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 200; i++ {
+			key := fmt.Sprintf("tenant/123/user/%04d", i)
+
+			for _, read := range []struct {
+				seq    uint64
+				expect uint64
+			}{{35, 30}, {25, 20}, {10, 10}} {
+				record, ok, err := reader.FindVersion([]byte(key), read.seq)
+				expect := fmt.Sprintf("%s@%d", key, read.expect)
+
+				if err != nil || !ok || string(record.Value) != expect {
+					t.Errorf("interval %d: key %s at %d expect %s, got %s, ok: %v, err: %v",
+						restartInterval, key, read.seq, expect, record.Value, ok, err)
+				}
+			}
+
+			_, ok, err := reader.FindVersion([]byte(key+"/missing"), types.MaxSequenceNumber)
+			if ok || err != nil {
+				t.Errorf("interval %d: key %s/missing should not be found, ok: %v, err: %v", restartInterval, key, ok, err)
+			}
+		}
+
+		count := 0
+		for {
+			record, err := reader.ReadNext()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			key := fmt.Sprintf("tenant/123/user/%04d", count/3)
+			if string(record.Key) != key {
+				t.Fatalf("interval %d: expect key %s, got %s", restartInterval, key, record.Key)
+			}
+			count++
+		}
+
+		if count != 600 {
+			t.Errorf("interval %d: expect 600 versions, got %d", restartInterval, count)
+		}
+
+		first, last, ok, err := reader.KeyRange()
+		if err != nil || !ok || string(first) != "tenant/123/user/0000" || string(last) != "tenant/123/user/0199" {
+			t.Errorf("interval %d: unexpected key range %s, %s, ok: %v, err: %v", restartInterval, first, last, ok, err)
+		}
+	}
+
+	full, compressed := write(0), write(DefaultIndexRestartInterval)
+	if compressed.indexStorage.NumBlocks() >= full.indexStorage.NumBlocks() {
+		t.Errorf("compressed index should take fewer blocks, got %d, full keys take %d",
+			compressed.indexStorage.NumBlocks(), full.indexStorage.NumBlocks())
+	}
+}
@@ -0,0 +1,50 @@
+package tables
+
+import (
+	"fmt"
+	"github.com/zl14917/MastersProject/kvstore/fileutil"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Checkpoint hard-links the files of every live table into dirPath and copies
+// the manifest referring to them, so that dirPath opens as the same set of tables.
+// Table files are never modified once committed, links stay valid after the
+// tables are compacted away here. Files are copied when they can't be linked.
+func (t *LevelFileTable) Checkpoint(dirPath string) error {
+	t.RLock()
+	defer t.RUnlock()
+
+	if t.manifest == nil {
+		return fmt.Errorf("tables in %s are not opened", t.TableRootDir)
+	}
+
+	for level := 0; level < NumLevels; level++ {
+		for _, table := range *t.levelRef(level) {
+			for _, filePath := range []string{table.IndexFilePath, table.DataFilePath} {
+				err := linkOrCopyFile(filePath, path.Join(dirPath, filepath.Base(filePath)))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// edits are appended with the lock held, the copy ends after a complete edit
+	for _, name := range []string{t.manifest.FileName(), manifestCurrentFileName} {
+		err := fileutil.CopyFile(path.Join(t.TableRootDir, name), path.Join(dirPath, name))
+		if err != nil {
+			return err
+		}
+	}
+
+	return fileutil.SyncDir(dirPath)
+}
+
+func linkOrCopyFile(source string, target string) error {
+	if err := os.Link(source, target); err == nil {
+		return nil
+	}
+	return fileutil.CopyFile(source, target)
+}
@@ -0,0 +1,24 @@
+package tables
+
+import "sync/atomic"
+
+// ExpiryClock is the time expiry is decided at, in unix nanoseconds. It only
+// moves forward with the timestamps of applied writes, never with the wall clock,
+// so that replicas applying the same log expire the same keys.
+type ExpiryClock struct {
+	now int64
+}
+
+func (c *ExpiryClock) Now() int64 {
+	return atomic.LoadInt64(&c.now)
+}
+
+// Advance moves the clock to timestamp if it is later.
+func (c *ExpiryClock) Advance(timestamp int64) {
+	for {
+		now := atomic.LoadInt64(&c.now)
+		if timestamp <= now || atomic.CompareAndSwapInt64(&c.now, now, timestamp) {
+			return
+		}
+	}
+}
@@ -1,119 +1,795 @@
 package tables
 
 import (
+	"bytes"
 	"fmt"
+	"github.com/zl14917/MastersProject/kvstore/fileutil"
 	"github.com/zl14917/MastersProject/kvstore/sstable"
+	"github.com/zl14917/MastersProject/kvstore/types"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// Level0 tables overlap, tables of deeper levels are sorted by key
+// and do not overlap. Level 2 is the bottom level.
+const NumLevels = 3
+
+// FileTableScanner returns the newest version of each key across all levels,
+// tables are pinned until it is closed.
 type FileTableScanner interface {
+	Scanner
 }
 
 type MemTableFlushCallback func(bool, error)
 
 type FileTable interface {
 	BeginFlushing(table MemTable, withCallback MemTableFlushCallback)
-	NewScanner() FileTableScanner
+	Get(key types.KeyType, seq uint64, now int64) (value types.ValueType, deleted bool, ok bool, err error)
+	NewScanner(start types.KeyType, end types.KeyType, seq uint64, now int64) (FileTableScanner, error)
 }
 
 type SStableRef struct {
 	sync.Mutex
 	sstable.SSTable
 
-	Timestamp int64
-	Level     int
+	// FileNumber names the table files, Timestamp orders level 0 tables
+	// and is the age of the newest data in the table
+	FileNumber int64
+	Timestamp  int64
+	Level      int
+
+	reader sstable.SSTableReader
+
+	// levels holding the table and lookups using it,
+	// files are removed when the last reference is released
+	refs int32
+
+	metadataLoaded bool
+	minKey         types.KeyType
+	maxKey         types.KeyType
+	sizeBytes      int64
+}
+
+// Reader opens the table on first use and keeps the reader,
+// with its bloom filter, for later lookups. Callers hold the lock.
+func (r *SStableRef) Reader() (sstable.SSTableReader, error) {
+	if r.reader != nil {
+		return r.reader, nil
+	}
+
+	reader, err := r.NewReader()
+	if err != nil {
+		return nil, err
+	}
+
+	r.reader = reader
+	return reader, nil
+}
+
+func (r *SStableRef) Acquire() {
+	atomic.AddInt32(&r.refs, 1)
+}
+
+func (r *SStableRef) Release() error {
+	if atomic.AddInt32(&r.refs, -1) > 0 {
+		return nil
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.reader = nil
+	return r.PermanentlyRemove()
+}
+
+func (r *SStableRef) loadMetadata() error {
+	if r.metadataLoaded {
+		return nil
+	}
+
+	reader, err := r.Reader()
+	if err != nil {
+		return err
+	}
+
+	r.minKey, r.maxKey, _, err = reader.KeyRange()
+	if err != nil {
+		return err
+	}
+
+	r.sizeBytes = r.SizeBytes()
+	r.metadataLoaded = true
+	return nil
+}
+
+// KeyRange is the first and last key in the table.
+func (r *SStableRef) KeyRange() (minKey types.KeyType, maxKey types.KeyType, err error) {
+	r.Lock()
+	defer r.Unlock()
+
+	err = r.loadMetadata()
+	return r.minKey, r.maxKey, err
+}
+
+// Size is the size of the table files in bytes.
+func (r *SStableRef) Size() (int64, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	err := r.loadMetadata()
+	return r.sizeBytes, err
+}
+
+// NewMergeReader returns a reader positioned at the first record,
+// independent of the one used for lookups.
+func (r *SStableRef) NewMergeReader() (sstable.SSTableReader, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.NewReader()
+}
+
+// Overlaps is true if any key in [minKey, maxKey] may be in the table.
+func (r *SStableRef) Overlaps(minKey types.KeyType, maxKey types.KeyType) (bool, error) {
+	tableMin, tableMax, err := r.KeyRange()
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Compare(tableMin, maxKey) <= 0 && bytes.Compare(minKey, tableMax) <= 0, nil
+}
+
+type TableEdit struct {
+	Level int
+	Table *SStableRef
+}
+
+// VersionEdit adds and removes tables in one step,
+// lookups see either all or none of it.
+type VersionEdit struct {
+	Added   []TableEdit
+	Removed []TableEdit
+	// wal records up to this index are in tables, 0 leaves it unchanged
+	FlushIndex uint64
+	// range tombstones of a flushed memtable
+	RangeTombstones []sstable.RangeTombstone
+	// seqs of range tombstones the merge writing Added applied to Removed,
+	// no version they delete is left in Added
+	AppliedRangeTombstones []uint64
+}
+
+func (e *VersionEdit) manifestEdit() ManifestEdit {
+	edit := ManifestEdit{FlushIndex: e.FlushIndex}
+
+	for _, added := range e.Added {
+		edit.Added = append(edit.Added, ManifestTable{
+			Level:          added.Level,
+			FileNumber:     added.Table.FileNumber,
+			Timestamp:      added.Table.Timestamp,
+			IndexBlockSize: added.Table.IndexStorageBlockSize,
+			DataBlockSize:  added.Table.DataStoreBlockSize,
+		})
+	}
+
+	for _, removed := range e.Removed {
+		edit.Removed = append(edit.Removed, ManifestTable{
+			Level:      removed.Level,
+			FileNumber: removed.Table.FileNumber,
+		})
+	}
+
+	return edit
+}
+
+type FileTableStats struct {
+	// lookups that skipped a table because its bloom filter ruled the key out
+	BloomFilterSkips uint64
+	// lookups that had to search a table
+	TableSearches uint64
 }
 
 // Leveled SStables are stored in concurrent lists.
 //
 type LevelFileTable struct {
+	sync.RWMutex
+
 	TableRootDir string
+	Options      sstable.SSTableOpenOptions
 
 	Level0 []*SStableRef
 	Level1 []*SStableRef
 	Level2 []*SStableRef
-}
 
-type SSTableFlushEvent struct {
-	Level     int
-	Timestamp int64
+	lastTimestamp int64
+	stats         FileTableStats
+
+	// sequence numbers read by live snapshots, compaction keeps the versions they see
+	Snapshots SnapshotList
+	// records expired at the clock are dropped by compaction
+	Clock ExpiryClock
+	// decides what compaction keeps of live records, nil keeps them all
+	CompactionFilter sstable.CompactionFilter
+	// folds merge operands in compactions, nil keeps them as they are
+	MergeOperator sstable.MergeOperator
+
+	// nil until the table is opened from its directory, edits are then persisted
+	manifest *Manifest
+	// replaced by installed edits that change them
+	rangeDeletions []RangeDeletion
+
+	// closed and replaced by every installed edit
+	changed chan struct{}
 }
 
 var _ FileTable = NewSStableFileTable("", "")
 
+const sstableFilePrefix = "sstable_"
+
+var DefaultFileTableOptions = sstable.SSTableOpenOptions{
+	IndexBlockSize:  int(sstable.BaseBlockSize),
+	DataBlockSize:   4 * int(sstable.BaseBlockSize),
+	BloomBitsPerKey: sstable.DefaultBloomBitsPerKey,
+
+	IndexRestartInterval: sstable.DefaultIndexRestartInterval,
+}
+
 func NewSStableFileTable(tableRootDir string, logDir string) *LevelFileTable {
 	table := &LevelFileTable{
 		TableRootDir: tableRootDir,
+		Options:      DefaultFileTableOptions,
+		changed:      make(chan struct{}),
 	}
 	return table
 }
 
-func NewSSTableRef(level int) *SStableRef {
+func NewSSTableRef(dirPath string, level int, fileNumber int64, timestamp int64, options sstable.SSTableOpenOptions) *SStableRef {
 	if level < 0 {
 		level = 0
 	}
 
-	tablet := &SStableRef{}
-	options := sstable.SSTableOpenOptions{
-		Prefix:         "level_" + strconv.Itoa(level),
-		MaxKeySize:     0,
-		MaxValueSize:   0,
-		IndexBlockSize: int(sstable.BaseBlockSize),
-		LoadExisting:   false,
-		Timestamp:      time.Now().Unix(),
+	tablet := &SStableRef{
+		FileNumber: fileNumber,
+		Timestamp:  timestamp,
+		Level:      level,
 	}
-	tablet.SSTable = *(sstable.NewSSTable("", &options))
+
+	// names do not depend on the level, tables move between levels
+	options.Prefix = sstableFilePrefix
+	options.Timestamp = fileNumber
+
+	tablet.SSTable = *(sstable.NewSSTable(dirPath, &options))
 	return tablet
 }
 
+// nextTimestamp names new tables, unique even when two are created
+// within the same nanosecond.
+func (t *LevelFileTable) nextTimestamp() int64 {
+	for {
+		last := atomic.LoadInt64(&t.lastTimestamp)
+		next := time.Now().UnixNano()
+
+		if next <= last {
+			next = last + 1
+		}
+
+		if atomic.CompareAndSwapInt64(&t.lastTimestamp, last, next) {
+			return next
+		}
+	}
+}
+
+// NewTable creates an empty table to be written and added at level.
+func (t *LevelFileTable) NewTable(level int) *SStableRef {
+	timestamp := t.nextTimestamp()
+	return NewSSTableRef(t.TableRootDir, level, timestamp, timestamp, t.Options)
+}
+
+// NewTableAt creates an empty table ordered among level 0 tables
+// as if it was created at timestamp, for merges of level 0 tables.
+func (t *LevelFileTable) NewTableAt(level int, timestamp int64) *SStableRef {
+	return NewSSTableRef(t.TableRootDir, level, t.nextTimestamp(), timestamp, t.Options)
+}
+
+// Tables of a level, level 0 oldest first, deeper levels by key.
+func (t *LevelFileTable) Tables(level int) []*SStableRef {
+	t.RLock()
+	defer t.RUnlock()
+
+	return append([]*SStableRef(nil), *t.levelRef(level)...)
+}
+
+func (t *LevelFileTable) levelRef(level int) *[]*SStableRef {
+	switch level {
+	case 0:
+		return &t.Level0
+	case 1:
+		return &t.Level1
+	default:
+		return &t.Level2
+	}
+}
+
+// AddSSTable makes a committed table visible to lookups.
+// Tables added later to level 0 shadow earlier ones.
+func (t *LevelFileTable) AddSSTable(level int, ref *SStableRef) error {
+	return t.Install(VersionEdit{
+		Added: []TableEdit{{Level: level, Table: ref}},
+	})
+}
+
+// Install records the edit in the manifest and applies it atomically.
+// Files of removed tables are deleted once no lookup uses them.
+func (t *LevelFileTable) Install(edit VersionEdit) error {
+	// deeper levels are kept sorted by their first key
+	for _, added := range edit.Added {
+		if added.Level > 0 {
+			_, _, err := added.Table.KeyRange()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	t.Lock()
+
+	manifestEdit := edit.manifestEdit()
+
+	rangeDeletions, err := t.rangeDeletionsAfter(edit, &manifestEdit)
+	if err == nil {
+		err = t.commitManifestEdit(manifestEdit)
+	}
+
+	if err != nil {
+		t.Unlock()
+		return err
+	}
+
+	t.rangeDeletions = rangeDeletions
+
+	// slices handed out by Tables and lookups are never modified
+	for level := 0; level < NumLevels; level++ {
+		tables := t.levelRef(level)
+		*tables = append([]*SStableRef(nil), *tables...)
+	}
+
+	for _, added := range edit.Added {
+		added.Table.Acquire()
+		added.Table.Level = added.Level
+
+		tables := t.levelRef(added.Level)
+		*tables = append(*tables, added.Table)
+	}
+
+	for _, removed := range edit.Removed {
+		tables := t.levelRef(removed.Level)
+		*tables = removeTable(*tables, removed.Table)
+	}
+
+	sort.SliceStable(t.Level0, func(i, j int) bool {
+		return t.Level0[i].Timestamp < t.Level0[j].Timestamp
+	})
+
+	for level := 1; level < NumLevels; level++ {
+		tables := *t.levelRef(level)
+		sort.Slice(tables, func(i, j int) bool {
+			return bytes.Compare(tables[i].minKey, tables[j].minKey) < 0
+		})
+	}
+
+	close(t.changed)
+	t.changed = make(chan struct{})
+	t.Unlock()
+
+	for _, removed := range edit.Removed {
+		err = removed.Table.Release()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Changed is closed once the next edit is installed.
+func (t *LevelFileTable) Changed() <-chan struct{} {
+	t.RLock()
+	defer t.RUnlock()
+
+	return t.changed
+}
+
+// removeTable keeps the order of the remaining tables.
+func removeTable(tables []*SStableRef, table *SStableRef) []*SStableRef {
+	remaining := make([]*SStableRef, 0, len(tables))
+
+	for _, t := range tables {
+		if t != table {
+			remaining = append(remaining, t)
+		}
+	}
+
+	return remaining
+}
+
+func (t *LevelFileTable) Stats() FileTableStats {
+	return FileTableStats{
+		BloomFilterSkips: atomic.LoadUint64(&t.stats.BloomFilterSkips),
+		TableSearches:    atomic.LoadUint64(&t.stats.TableSearches),
+	}
+}
+
+// Get searches levels from 0 down, newest table of a level first, for the newest
+// version of key at or before seq. A version expired at now or deleted by a range
+// tombstone reads as deleted. Tables whose bloom filter rules out the key are not searched.
+func (t *LevelFileTable) Get(key types.KeyType, seq uint64, now int64) (value types.ValueType, deleted bool, ok bool, err error) {
+	record, ok, err := t.GetVersion(key, seq, now)
+	return record.Value, record.Deleted, ok, err
+}
+
+// GetVersion returns the version Get reads, deleted versions as tombstones.
+// A merge operand is returned as it is.
+func (t *LevelFileTable) GetVersion(key types.KeyType, seq uint64, now int64) (record sstable.Record, ok bool, err error) {
+	levels, rangeDeletions := t.acquireLevels()
+	defer releaseLevels(levels)
+
+	for _, level := range levels {
+		for i := len(level) - 1; i >= 0; i-- {
+			record, found, err := t.findInTable(level[i], key, seq)
+
+			if err != nil {
+				return sstable.Record{}, false, err
+			}
+
+			if !found {
+				continue
+			}
+
+			if record.Deleted || record.Expired(now) {
+				return sstable.Record{Key: key, Seq: record.Seq, Deleted: true}, true, nil
+			}
+
+			// a tombstone deleting the newest version deletes the older ones too
+			for i := range rangeDeletions {
+				if rangeDeletions[i].Seq <= seq && rangeDeletions[i].Covers(key, record.Seq) {
+					return sstable.Record{Key: key, Seq: record.Seq, Deleted: true}, true, nil
+				}
+			}
+
+			return record, true, nil
+		}
+	}
+
+	return sstable.Record{}, false, nil
+}
+
+// acquireLevels pins the current tables of every level,
+// so that compaction does not remove them while they are read.
+// The range deletions returned apply to these tables.
+func (t *LevelFileTable) acquireLevels() ([][]*SStableRef, []RangeDeletion) {
+	t.RLock()
+	defer t.RUnlock()
+
+	levels := make([][]*SStableRef, NumLevels)
+
+	for level := range levels {
+		tables := *t.levelRef(level)
+		for _, table := range tables {
+			table.Acquire()
+		}
+
+		levels[level] = tables
+	}
+
+	return levels, t.rangeDeletions
+}
+
+func releaseLevels(levels [][]*SStableRef) {
+	for _, tables := range levels {
+		for _, table := range tables {
+			_ = table.Release()
+		}
+	}
+}
+
+func (t *LevelFileTable) findInTable(ref *SStableRef, key types.KeyType, seq uint64) (record sstable.Record, ok bool, err error) {
+	ref.Lock()
+	defer ref.Unlock()
+
+	reader, err := ref.Reader()
+	if err != nil {
+		return sstable.Record{}, false, err
+	}
+
+	if !reader.KeyMayExist(key) {
+		atomic.AddUint64(&t.stats.BloomFilterSkips, 1)
+		return sstable.Record{}, false, nil
+	}
+
+	atomic.AddUint64(&t.stats.TableSearches, 1)
+	return reader.FindVersion(key, seq)
+}
+
 // Flushing Memtable to File Table creates a level 0 SSTable tablet
 //
 func (t *LevelFileTable) BeginFlushing(table MemTable, withCallback MemTableFlushCallback) {
-	var err error
+	if withCallback == nil {
+		withCallback = func(bool, error) {}
+	}
+
+	err := t.Flush(table, 0)
+
+	if err != nil {
+		withCallback(false, err)
+		return
+	}
+
+	withCallback(true, nil)
+}
 
-	filename := fmt.Sprintf("level_0_%d")
-	fmt.Printf(filename)
+// Flush writes all versions of table to a new level 0 table. The table, its range
+// tombstones and flushIndex, the index of the last wal record in table, are recorded
+// in one edit. An empty table only records flushIndex.
+func (t *LevelFileTable) Flush(table MemTable, flushIndex uint64) error {
+	var (
+		iterator   = table.Iterator()
+		tombstones = table.RangeTombstones()
+	)
+
+	if !iterator.Next() {
+		if len(tombstones) > 0 {
+			return t.Install(VersionEdit{RangeTombstones: tombstones, FlushIndex: flushIndex})
+		}
 
-	iterator := table.Iterator()
+		if flushIndex == 0 {
+			return nil
+		}
+		return t.CommitChangeToLockFile(VersionEdit{FlushIndex: flushIndex})
+	}
 
-	newSStable := NewSSTableRef(0)
+	newSStable := t.NewTable(0)
 	writer, err := newSStable.NewWriter()
 
 	if err != nil {
-		withCallback(false, err)
-		return
+		return err
 	}
 
-	for iterator.Next() {
+	for ok := true; ok; ok = iterator.Next() {
 
-		key, value := iterator.Current()
-		err = writer.Write(key, []byte(value), value == nil)
+		err = writer.WriteRecord(iterator.Current())
 
 		if err != nil {
-			withCallback(false, err)
+			_ = newSStable.PermanentlyRemove()
+			return err
 		}
 	}
 
-	event := SSTableFlushEvent{
-		Timestamp: newSStable.Timestamp,
-		Level:     newSStable.Level,
+	err = writer.Commit()
+
+	if err != nil {
+		_ = newSStable.PermanentlyRemove()
+		return err
 	}
 
-	err = t.CommitChangeToLockFile(event)
+	return t.Install(VersionEdit{
+		Added:           []TableEdit{{Level: 0, Table: newSStable}},
+		FlushIndex:      flushIndex,
+		RangeTombstones: tombstones,
+	})
+}
 
+// Open rebuilds the levels from the manifest in TableRootDir,
+// and removes table files no version refers to, left by a crash
+// during a flush or compaction.
+func (t *LevelFileTable) Open() error {
+	manifest, err := OpenManifest(t.TableRootDir, DefaultMaxManifestSize)
 	if err != nil {
-		withCallback(false, err)
+		return err
 	}
 
-	withCallback(true, nil)
+	live := make(map[int64]*SStableRef)
+
+	for _, table := range manifest.Tables() {
+		options := t.Options
+		options.LoadExisting = true
+		options.IndexBlockSize = table.IndexBlockSize
+		options.DataBlockSize = table.DataBlockSize
+
+		ref := NewSSTableRef(t.TableRootDir, table.Level, table.FileNumber, table.Timestamp, options)
+
+		for _, filePath := range []string{ref.IndexFilePath, ref.DataFilePath} {
+			if _, err = os.Stat(filePath); err != nil {
+				_ = manifest.Close()
+				return fmt.Errorf("table %d at level %d is missing: %v", table.FileNumber, table.Level, err)
+			}
+		}
+
+		// deeper levels are sorted by key
+		if table.Level > 0 {
+			if _, _, err = ref.KeyRange(); err != nil {
+				_ = manifest.Close()
+				return err
+			}
+		}
+
+		ref.Acquire()
+		tables := t.levelRef(table.Level)
+		*tables = append(*tables, ref)
+
+		live[table.FileNumber] = ref
+
+		if table.FileNumber > t.lastTimestamp {
+			t.lastTimestamp = table.FileNumber
+		}
+		if table.Timestamp > t.lastTimestamp {
+			t.lastTimestamp = table.Timestamp
+		}
+	}
+
+	for level := 1; level < NumLevels; level++ {
+		tables := *t.levelRef(level)
+		sort.Slice(tables, func(i, j int) bool {
+			return bytes.Compare(tables[i].minKey, tables[j].minKey) < 0
+		})
+	}
+
+	t.rangeDeletions = openRangeDeletions(manifest, live)
+	t.Clock.Advance(manifest.ExpiryClock())
+	t.manifest = manifest
+	return t.removeOrphans(live)
 }
 
-func (t *LevelFileTable) CommitChangeToLockFile(v interface{}) error {
+func (t *LevelFileTable) removeOrphans(live map[int64]*SStableRef) error {
+	names, err := filepath.Glob(path.Join(t.TableRootDir, sstableFilePrefix+"*"))
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		number := strings.SplitN(strings.TrimPrefix(filepath.Base(name), sstableFilePrefix), "_", 2)[0]
+		fileNumber, err := strconv.ParseInt(number, 10, 64)
+
+		if err != nil || live[fileNumber] != nil {
+			continue
+		}
+
+		err = os.Remove(name)
+		if err != nil {
+			return err
+		}
+	}
+
+	manifests, err := filepath.Glob(path.Join(t.TableRootDir, manifestFilePrefix+"*"))
+	if err != nil {
+		return err
+	}
+
+	for _, name := range manifests {
+		if filepath.Base(name) != t.manifest.FileName() {
+			_ = os.Remove(name)
+		}
+	}
+
 	return nil
 }
 
-func (t *LevelFileTable) NewScanner() FileTableScanner {
-	return nil
+// FlushIndex is the last wal index recorded as persisted in tables.
+func (t *LevelFileTable) FlushIndex() uint64 {
+	t.RLock()
+	defer t.RUnlock()
+
+	if t.manifest == nil {
+		return 0
+	}
+	return t.manifest.FlushIndex()
+}
+
+// CommitChangeToLockFile appends the edit to the manifest,
+// tables it adds must be committed before.
+func (t *LevelFileTable) CommitChangeToLockFile(edit VersionEdit) error {
+	t.Lock()
+	defer t.Unlock()
+
+	return t.commitChange(edit)
+}
+
+func (t *LevelFileTable) commitChange(edit VersionEdit) error {
+	return t.commitManifestEdit(edit.manifestEdit())
+}
+
+func (t *LevelFileTable) commitManifestEdit(manifestEdit ManifestEdit) error {
+	if t.manifest == nil {
+		return nil
+	}
+
+	// new table files are durable in the directory before the manifest refers to them
+	if len(manifestEdit.Added) > 0 {
+		err := fileutil.SyncDir(t.TableRootDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	manifestEdit.ExpiryClock = t.Clock.Now()
+
+	return t.manifest.Append(manifestEdit)
+}
+
+func (t *LevelFileTable) Close() error {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.manifest == nil {
+		return nil
+	}
+
+	err := t.manifest.Close()
+	t.manifest = nil
+	return err
+}
+
+type fileTableScanner struct {
+	Scanner
+	levels [][]*SStableRef
+}
+
+func (s *fileTableScanner) Close() error {
+	err := s.Scanner.Close()
+
+	if s.levels != nil {
+		releaseLevels(s.levels)
+		s.levels = nil
+	}
+
+	return err
+}
+
+// NewScanner scans keys in [start, end) of every level as of seq, a nil end scans to the last key.
+// Keys expired at now or deleted by a range tombstone read as deleted.
+// Level 0 tables are scanned separately, newest first, deeper levels one table after another.
+func (t *LevelFileTable) NewScanner(start types.KeyType, end types.KeyType, seq uint64, now int64) (FileTableScanner, error) {
+	levels, rangeDeletions := t.acquireLevels()
+	tombstones := rangeTombstones(rangeDeletions)
+
+	var sources []Scanner
+
+	for level, tables := range levels {
+		inRange := make([]*SStableRef, 0, len(tables))
+
+		for _, table := range tables {
+			minKey, maxKey, err := table.KeyRange()
+			if err != nil {
+				releaseLevels(levels)
+				return nil, err
+			}
+
+			if pastEnd(minKey, end) || beforeStart(maxKey, start) {
+				continue
+			}
+
+			inRange = append(inRange, table)
+		}
+
+		if level > 0 {
+			if len(inRange) > 0 {
+				sources = append(sources, newTablesScanner(inRange, start, end, seq, now, tombstones))
+			}
+			continue
+		}
+
+		for i := len(inRange) - 1; i >= 0; i-- {
+			sources = append(sources, newTablesScanner(inRange[i:i+1], start, end, seq, now, tombstones))
+		}
+	}
+
+	return &fileTableScanner{
+		Scanner: NewMergedScanner(sources...),
+		levels:  levels,
+	}, nil
 }
@@ -0,0 +1,71 @@
+package tables
+
+import (
+	"fmt"
+	"github.com/zl14917/MastersProject/kvstore/types"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func writeTable(t *testing.T, fileTable *LevelFileTable, level int, prefix string, count int) {
+	ref := fileTable.NewTable(level)
+
+	writer, err := ref.NewWriter()
+	if err != nil {
+		t.Fatal("error creating sstable", err)
+	}
+
+	for i := 0; i < count; i++ {
+		key := fmt.Sprintf("%s_%04d", prefix, i)
+		err = writer.Write([]byte(key), []byte("value_"+key), false)
+		if err != nil {
+			t.Fatalf("error writing key %s: %v", key, err)
+		}
+	}
+
+	err = writer.Commit()
+	if err != nil {
+		t.Fatal("error committing sstable", err)
+	}
+
+	err = fileTable.AddSSTable(level, ref)
+	if err != nil {
+		t.Fatal("error adding sstable", err)
+	}
+}
+
+func TestLevelFileTable_GetSkipsFilteredTables(t *testing.T) {
+	dirPath, err := ioutil.TempDir("/tmp/", "cliftondbtests")
+	if err != nil {
+		t.Fatal("can't create test directory", err)
+	}
+	defer os.RemoveAll(dirPath)
+
+	fileTable := NewSStableFileTable(dirPath, dirPath)
+
+	writeTable(t, fileTable, 1, "old", 200)
+	writeTable(t, fileTable, 0, "new", 200)
+
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("old_%04d", i)
+		value, deleted, ok, err := fileTable.Get([]byte(key), types.MaxSequenceNumber, 0)
+
+		if err != nil || !ok || deleted || string(value) != "value_"+key {
+			t.Errorf("key %s should be found, ok: %v, err: %v", key, ok, err)
+			return
+		}
+	}
+
+	stats := fileTable.Stats()
+
+	// level 0 table holds none of the keys and should mostly be skipped
+	if stats.BloomFilterSkips < 190 {
+		t.Errorf("expect most level 0 lookups to be skipped, skipped %d", stats.BloomFilterSkips)
+	}
+
+	_, _, ok, err := fileTable.Get([]byte("missing"), types.MaxSequenceNumber, 0)
+	if ok || err != nil {
+		t.Errorf("missing key should not be found, ok: %v, err: %v", ok, err)
+	}
+}
@@ -0,0 +1,558 @@
+package tables
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/zl14917/MastersProject/kvstore/crc"
+	"github.com/zl14917/MastersProject/kvstore/fileutil"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The manifest is an append-only log of version edits in the sstables directory.
+// CURRENT names the manifest in use, it is replaced by rename when the manifest
+// is rotated into a new one starting with a snapshot of all live tables.
+//
+// | payload length, 4 bytes | crc32c of payload, 4 bytes | payload |
+const (
+	manifestCurrentFileName = "CURRENT"
+	manifestFilePrefix      = "MANIFEST-"
+	manifestRecordHeader    = 8
+	maxManifestRecordSize   = 64 * 1024 * 1024
+
+	DefaultMaxManifestSize = 4 * 1024 * 1024
+)
+
+var ManifestCorruptedErr = errors.New("manifest record corrupted")
+
+// ManifestTable is what the manifest knows about a live table.
+type ManifestTable struct {
+	Level          int
+	FileNumber     int64
+	Timestamp      int64
+	IndexBlockSize int
+	DataBlockSize  int
+}
+
+// ManifestRangeTombstone is a range tombstone with the file numbers
+// of the tables that may hold versions it deletes.
+type ManifestRangeTombstone struct {
+	Start  []byte
+	End    []byte
+	Seq    uint64
+	Tables []int64
+}
+
+type ManifestEdit struct {
+	Added   []ManifestTable
+	Removed []ManifestTable
+	// wal records up to this index are in tables, 0 leaves it unchanged
+	FlushIndex uint64
+	// the expiry clock, it only moves forward
+	ExpiryClock int64
+	// range tombstones added or with changed tables, they replace
+	// the ones with the same seq, and seqs of range tombstones dropped
+	RangeTombstones        []ManifestRangeTombstone
+	RemovedRangeTombstones []uint64
+}
+
+type Manifest struct {
+	DirPath string
+	MaxSize int64
+
+	number int64
+	file   *os.File
+	size   int64
+
+	tables          map[int64]ManifestTable
+	rangeTombstones map[uint64]ManifestRangeTombstone
+	flushIndex      uint64
+	expiryClock     int64
+}
+
+func manifestFileName(number int64) string {
+	return fmt.Sprintf("%s%06d", manifestFilePrefix, number)
+}
+
+// OpenManifest replays the current manifest of dirPath, or starts an empty one,
+// and rotates it so that new edits are appended after a single snapshot.
+func OpenManifest(dirPath string, maxSize int64) (*Manifest, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxManifestSize
+	}
+
+	m := &Manifest{
+		DirPath: dirPath,
+		MaxSize: maxSize,
+		tables:  make(map[int64]ManifestTable),
+
+		rangeTombstones: make(map[uint64]ManifestRangeTombstone),
+	}
+
+	current, err := ioutil.ReadFile(path.Join(dirPath, manifestCurrentFileName))
+
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err == nil {
+		name := strings.TrimSpace(string(current))
+
+		m.number, err = strconv.ParseInt(strings.TrimPrefix(name, manifestFilePrefix), 10, 64)
+		if err != nil || !strings.HasPrefix(name, manifestFilePrefix) {
+			return nil, fmt.Errorf("invalid manifest name %q in %s", name, manifestCurrentFileName)
+		}
+
+		err = m.replay(path.Join(dirPath, name))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = m.rotate()
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *Manifest) replay(filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+
+	for {
+		payload, err := readManifestRecord(reader)
+
+		if err == io.EOF {
+			return nil
+		}
+
+		// the last record may be torn by a crash while appending,
+		// the edit it held never took effect
+		if err == io.ErrUnexpectedEOF {
+			return nil
+		}
+
+		if err == ManifestCorruptedErr {
+			if _, peekErr := reader.Peek(1); peekErr == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("%s: %v", filePath, err)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		edit, err := decodeManifestEdit(payload)
+		if err != nil {
+			return fmt.Errorf("%s: %v", filePath, err)
+		}
+
+		m.apply(edit)
+	}
+}
+
+func (m *Manifest) apply(edit ManifestEdit) {
+	for _, removed := range edit.Removed {
+		delete(m.tables, removed.FileNumber)
+	}
+
+	for _, added := range edit.Added {
+		m.tables[added.FileNumber] = added
+	}
+
+	for _, seq := range edit.RemovedRangeTombstones {
+		delete(m.rangeTombstones, seq)
+	}
+
+	for _, tombstone := range edit.RangeTombstones {
+		m.rangeTombstones[tombstone.Seq] = tombstone
+	}
+
+	if edit.FlushIndex > m.flushIndex {
+		m.flushIndex = edit.FlushIndex
+	}
+
+	if edit.ExpiryClock > m.expiryClock {
+		m.expiryClock = edit.ExpiryClock
+	}
+}
+
+// Tables returns the live tables ordered by level and timestamp.
+func (m *Manifest) Tables() []ManifestTable {
+	tables := make([]ManifestTable, 0, len(m.tables))
+
+	for _, table := range m.tables {
+		tables = append(tables, table)
+	}
+
+	sort.Slice(tables, func(i, j int) bool {
+		if tables[i].Level != tables[j].Level {
+			return tables[i].Level < tables[j].Level
+		}
+		return tables[i].Timestamp < tables[j].Timestamp
+	})
+
+	return tables
+}
+
+// RangeTombstones returns the live range tombstones ordered by seq.
+func (m *Manifest) RangeTombstones() []ManifestRangeTombstone {
+	tombstones := make([]ManifestRangeTombstone, 0, len(m.rangeTombstones))
+
+	for _, tombstone := range m.rangeTombstones {
+		tombstones = append(tombstones, tombstone)
+	}
+
+	sort.Slice(tombstones, func(i, j int) bool {
+		return tombstones[i].Seq < tombstones[j].Seq
+	})
+
+	return tombstones
+}
+
+// FileName of the manifest edits are appended to.
+func (m *Manifest) FileName() string {
+	return manifestFileName(m.number)
+}
+
+func (m *Manifest) FlushIndex() uint64 {
+	return m.flushIndex
+}
+
+func (m *Manifest) ExpiryClock() int64 {
+	return m.expiryClock
+}
+
+// Append makes edit durable before it returns.
+func (m *Manifest) Append(edit ManifestEdit) error {
+	payload := encodeManifestEdit(edit)
+
+	n, err := writeManifestRecord(m.file, payload)
+	if err != nil {
+		// drop a partial record so that later edits stay readable
+		_ = m.file.Truncate(m.size)
+		_, _ = m.file.Seek(m.size, io.SeekStart)
+		return err
+	}
+
+	err = m.file.Sync()
+	if err != nil {
+		return err
+	}
+
+	m.size += int64(n)
+	m.apply(edit)
+
+	// the edit is durable whether or not the manifest rotates,
+	// a rotation that failed is retried by the next edit
+	if m.size > m.MaxSize {
+		err = m.rotate()
+		if err != nil {
+			log.Println("error rotating manifest in", m.DirPath, err)
+		}
+	}
+
+	return nil
+}
+
+// rotate writes a snapshot of the live tables into a new manifest,
+// then points CURRENT at it and removes the old one.
+func (m *Manifest) rotate() error {
+	var (
+		oldFile  = m.file
+		number   = m.number + 1
+		filePath = path.Join(m.DirPath, manifestFileName(number))
+	)
+
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	snapshot := ManifestEdit{
+		Added:           m.Tables(),
+		FlushIndex:      m.flushIndex,
+		ExpiryClock:     m.expiryClock,
+		RangeTombstones: m.RangeTombstones(),
+	}
+
+	size, err := writeManifestRecord(file, encodeManifestEdit(snapshot))
+
+	if err == nil {
+		err = file.Sync()
+	}
+
+	if err == nil {
+		err = m.setCurrent(number)
+
+		// CURRENT was replaced and only syncing the directory failed, edits go to the
+		// new manifest and the old one is kept until a later rotation succeeds
+		if err != nil && m.currentName() == manifestFileName(number) {
+			m.switchTo(number, file, size, oldFile)
+			return err
+		}
+	}
+
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(filePath)
+		return err
+	}
+
+	m.switchTo(number, file, size, oldFile)
+	m.removeManifestsBefore(number)
+	return nil
+}
+
+func (m *Manifest) switchTo(number int64, file *os.File, size int, oldFile *os.File) {
+	m.number = number
+	m.file = file
+	m.size = int64(size)
+
+	if oldFile != nil {
+		_ = oldFile.Close()
+	}
+}
+
+func (m *Manifest) currentName() string {
+	current, _ := ioutil.ReadFile(path.Join(m.DirPath, manifestCurrentFileName))
+	return strings.TrimSpace(string(current))
+}
+
+// removeManifestsBefore removes the manifests older than number.
+func (m *Manifest) removeManifestsBefore(number int64) {
+	matches, _ := filepath.Glob(path.Join(m.DirPath, manifestFilePrefix+"*"))
+
+	for _, match := range matches {
+		older, err := strconv.ParseInt(strings.TrimPrefix(filepath.Base(match), manifestFilePrefix), 10, 64)
+		if err == nil && older < number {
+			_ = os.Remove(match)
+		}
+	}
+}
+
+func (m *Manifest) setCurrent(number int64) error {
+	return fileutil.ReplaceFile(
+		path.Join(m.DirPath, manifestCurrentFileName),
+		[]byte(manifestFileName(number)+"\n"),
+	)
+}
+
+func (m *Manifest) Close() error {
+	if m.file == nil {
+		return nil
+	}
+
+	err := m.file.Close()
+	m.file = nil
+	return err
+}
+
+func writeManifestRecord(w io.Writer, payload []byte) (int, error) {
+	record := make([]byte, manifestRecordHeader+len(payload))
+
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc.Checksum(payload))
+	copy(record[manifestRecordHeader:], payload)
+
+	return w.Write(record)
+}
+
+func readManifestRecord(r io.Reader) ([]byte, error) {
+	var header [manifestRecordHeader]byte
+
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > maxManifestRecordSize {
+		return nil, ManifestCorruptedErr
+	}
+
+	payload := make([]byte, length)
+
+	_, err = io.ReadFull(r, payload)
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if crc.Checksum(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, ManifestCorruptedErr
+	}
+
+	return payload, nil
+}
+
+// | flush index, 8 bytes | added count, 4 bytes | added tables | removed count, 4 bytes | removed tables |
+// | expiry clock, 8 bytes | range tombstone count, 4 bytes | range tombstones |
+// | removed range tombstone count, 4 bytes | removed range tombstone seqs, 8 bytes each |
+//
+// added: | level, 4 | file number, 8 | timestamp, 8 | index block size, 4 | data block size, 4 |
+// removed: | level, 4 | file number, 8 |
+// range tombstone: | seq, 8 | start length, 4 | start | end length, 4 | end | table count, 4 | file numbers, 8 each |
+func encodeManifestEdit(edit ManifestEdit) []byte {
+	var (
+		buffer = bytes.NewBuffer(nil)
+		b      [8]byte
+	)
+
+	putUint32 := func(v uint32) {
+		binary.BigEndian.PutUint32(b[0:4], v)
+		buffer.Write(b[0:4])
+	}
+
+	putUint64 := func(v uint64) {
+		binary.BigEndian.PutUint64(b[0:8], v)
+		buffer.Write(b[0:8])
+	}
+
+	putUint64(edit.FlushIndex)
+
+	putUint32(uint32(len(edit.Added)))
+	for _, table := range edit.Added {
+		putUint32(uint32(table.Level))
+		putUint64(uint64(table.FileNumber))
+		putUint64(uint64(table.Timestamp))
+		putUint32(uint32(table.IndexBlockSize))
+		putUint32(uint32(table.DataBlockSize))
+	}
+
+	putUint32(uint32(len(edit.Removed)))
+	for _, table := range edit.Removed {
+		putUint32(uint32(table.Level))
+		putUint64(uint64(table.FileNumber))
+	}
+
+	putUint64(uint64(edit.ExpiryClock))
+
+	putUint32(uint32(len(edit.RangeTombstones)))
+	for _, tombstone := range edit.RangeTombstones {
+		putUint64(tombstone.Seq)
+		putUint32(uint32(len(tombstone.Start)))
+		buffer.Write(tombstone.Start)
+		putUint32(uint32(len(tombstone.End)))
+		buffer.Write(tombstone.End)
+
+		putUint32(uint32(len(tombstone.Tables)))
+		for _, fileNumber := range tombstone.Tables {
+			putUint64(uint64(fileNumber))
+		}
+	}
+
+	putUint32(uint32(len(edit.RemovedRangeTombstones)))
+	for _, seq := range edit.RemovedRangeTombstones {
+		putUint64(seq)
+	}
+
+	return buffer.Bytes()
+}
+
+func decodeManifestEdit(payload []byte) (edit ManifestEdit, err error) {
+	reader := bytes.NewReader(payload)
+
+	var b [8]byte
+
+	getUint32 := func() uint32 {
+		if err == nil {
+			_, err = io.ReadFull(reader, b[0:4])
+		}
+		return binary.BigEndian.Uint32(b[0:4])
+	}
+
+	getUint64 := func() uint64 {
+		if err == nil {
+			_, err = io.ReadFull(reader, b[0:8])
+		}
+		return binary.BigEndian.Uint64(b[0:8])
+	}
+
+	edit.FlushIndex = getUint64()
+
+	added := getUint32()
+	for i := uint32(0); i < added && err == nil; i++ {
+		edit.Added = append(edit.Added, ManifestTable{
+			Level:          int(getUint32()),
+			FileNumber:     int64(getUint64()),
+			Timestamp:      int64(getUint64()),
+			IndexBlockSize: int(getUint32()),
+			DataBlockSize:  int(getUint32()),
+		})
+	}
+
+	removed := getUint32()
+	for i := uint32(0); i < removed && err == nil; i++ {
+		edit.Removed = append(edit.Removed, ManifestTable{
+			Level:      int(getUint32()),
+			FileNumber: int64(getUint64()),
+		})
+	}
+
+	getBytes := func() []byte {
+		length := getUint32()
+		if err != nil || int64(length) > int64(reader.Len()) {
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil
+		}
+
+		data := make([]byte, length)
+		_, err = io.ReadFull(reader, data)
+		return data
+	}
+
+	edit.ExpiryClock = int64(getUint64())
+
+	tombstones := getUint32()
+	for i := uint32(0); i < tombstones && err == nil; i++ {
+		tombstone := ManifestRangeTombstone{Seq: getUint64()}
+		tombstone.Start = getBytes()
+		tombstone.End = getBytes()
+
+		tables := getUint32()
+		for j := uint32(0); j < tables && err == nil; j++ {
+			tombstone.Tables = append(tombstone.Tables, int64(getUint64()))
+		}
+
+		edit.RangeTombstones = append(edit.RangeTombstones, tombstone)
+	}
+
+	removedTombstones := getUint32()
+	for i := uint32(0); i < removedTombstones && err == nil; i++ {
+		edit.RemovedRangeTombstones = append(edit.RemovedRangeTombstones, getUint64())
+	}
+
+	if err == nil && reader.Len() > 0 {
+		err = fmt.Errorf("%d trailing bytes", reader.Len())
+	}
+
+	if err != nil {
+		return edit, fmt.Errorf("malformed manifest edit: %v", err)
+	}
+
+	return edit, nil
+}
@@ -0,0 +1,314 @@
+package tables
+
+import (
+	"fmt"
+	"github.com/zl14917/MastersProject/kvstore/sstable"
+	"github.com/zl14917/MastersProject/kvstore/types"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+func openFileTable(t *testing.T, dirPath string) *LevelFileTable {
+	fileTable := NewSStableFileTable(dirPath, dirPath)
+
+	err := fileTable.Open()
+	if err != nil {
+		t.Fatal("error opening file table", err)
+	}
+
+	return fileTable
+}
+
+func expectTableValue(t *testing.T, fileTable *LevelFileTable, key string) {
+	value, _, ok, err := fileTable.Get([]byte(key), types.MaxSequenceNumber, 0)
+
+	if err != nil || !ok || string(value) != "value_"+key {
+		t.Errorf("key %s should be found, ok: %v, err: %v", key, ok, err)
+	}
+}
+
+func TestLevelFileTable_RecoverFromManifest(t *testing.T) {
+	dirPath, err := ioutil.TempDir("/tmp/", "cliftondbtests")
+	if err != nil {
+		t.Fatal("can't create test directory", err)
+	}
+	defer os.RemoveAll(dirPath)
+
+	fileTable := openFileTable(t, dirPath)
+
+	writeTable(t, fileTable, 0, "a", 10)
+	writeTable(t, fileTable, 0, "b", 10)
+	writeTable(t, fileTable, 1, "c", 10)
+
+	// a compaction moving the oldest table down
+	moved := fileTable.Tables(0)[0]
+	err = fileTable.Install(VersionEdit{
+		Added:      []TableEdit{{Level: 2, Table: moved}},
+		Removed:    []TableEdit{{Level: 0, Table: moved}},
+		FlushIndex: 42,
+	})
+	if err != nil {
+		t.Error("error installing edit", err)
+		return
+	}
+
+	// files of a table written but never installed
+	orphan := fileTable.NewTable(0)
+	writer, _ := orphan.NewWriter()
+	_ = writer.Write([]byte("orphan"), []byte("value"), false)
+	_ = writer.Commit()
+	_ = orphan.Close()
+
+	_ = fileTable.Close()
+
+	fileTable = openFileTable(t, dirPath)
+	defer fileTable.Close()
+
+	if len(fileTable.Tables(0)) != 1 || len(fileTable.Tables(1)) != 1 || len(fileTable.Tables(2)) != 1 {
+		t.Errorf(
+			"expect one table per level, got %d, %d, %d",
+			len(fileTable.Tables(0)), len(fileTable.Tables(1)), len(fileTable.Tables(2)),
+		)
+	}
+
+	if fileTable.FlushIndex() != 42 {
+		t.Errorf("expect flush index 42, got %d", fileTable.FlushIndex())
+	}
+
+	for _, prefix := range []string{"a", "b", "c"} {
+		expectTableValue(t, fileTable, fmt.Sprintf("%s_%04d", prefix, 5))
+	}
+
+	if _, err = os.Stat(orphan.IndexFilePath); !os.IsNotExist(err) {
+		t.Error("files of tables missing from the manifest should be removed")
+	}
+}
+
+func TestManifest_TornTailAndRotation(t *testing.T) {
+	dirPath, err := ioutil.TempDir("/tmp/", "cliftondbtests")
+	if err != nil {
+		t.Fatal("can't create test directory", err)
+	}
+	defer os.RemoveAll(dirPath)
+
+	manifest, err := OpenManifest(dirPath, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := int64(1); i <= 20; i++ {
+		edit := ManifestEdit{Added: []ManifestTable{{Level: 0, FileNumber: i, Timestamp: i}}}
+		if i > 1 {
+			edit.Removed = []ManifestTable{{Level: 0, FileNumber: i - 1}}
+		}
+
+		err = manifest.Append(edit)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if manifest.FileName() == manifestFileName(1) {
+		t.Error("manifest should have been rotated")
+	}
+
+	_ = manifest.Close()
+
+	file, err := os.OpenFile(path.Join(dirPath, manifest.FileName()), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// part of a record header
+	_, _ = file.Write([]byte{0, 0, 1})
+	_ = file.Close()
+
+	manifest, err = OpenManifest(dirPath, 256)
+	if err != nil {
+		t.Error("torn manifest tail should not fail opening", err)
+		return
+	}
+	defer manifest.Close()
+
+	tables := manifest.Tables()
+	if len(tables) != 1 || tables[0].FileNumber != 20 {
+		t.Errorf("expect only table 20, got %v", tables)
+	}
+}
+
+// edits are durable before the manifest rotates, a failed rotation must not fail them
+func TestManifest_RotationFailure(t *testing.T) {
+	dirPath, err := ioutil.TempDir("/tmp/", "cliftondbtests")
+	if err != nil {
+		t.Fatal("can't create test directory", err)
+	}
+	defer os.RemoveAll(dirPath)
+
+	manifest, err := OpenManifest(dirPath, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// CURRENT can't be replaced while a directory takes the name of its temporary file
+	blocked := path.Join(dirPath, manifestCurrentFileName+".tmp")
+	if err = os.Mkdir(blocked, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	fileName := manifest.FileName()
+
+	appendEdits := func(from int64, to int64) {
+		for i := from; i <= to; i++ {
+			err = manifest.Append(ManifestEdit{Added: []ManifestTable{{Level: 0, FileNumber: i, Timestamp: i}}})
+			if err != nil {
+				t.Fatalf("edit %d should not fail when the manifest can't rotate: %v", i, err)
+			}
+		}
+	}
+
+	appendEdits(1, 20)
+
+	if manifest.FileName() != fileName {
+		t.Error("manifest should not have been rotated")
+	}
+
+	if tables := manifest.Tables(); len(tables) != 20 {
+		t.Errorf("expect 20 tables, got %d", len(tables))
+	}
+
+	_ = os.Remove(blocked)
+	appendEdits(21, 22)
+
+	if manifest.FileName() == fileName {
+		t.Error("manifest rotation should have been retried")
+	}
+	_ = manifest.Close()
+
+	manifest, err = OpenManifest(dirPath, 256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manifest.Close()
+
+	if tables := manifest.Tables(); len(tables) != 22 {
+		t.Errorf("expect 22 tables after reopening, got %d", len(tables))
+	}
+
+	matches, _ := filepath.Glob(path.Join(dirPath, manifestFilePrefix+"*"))
+	if len(matches) != 1 {
+		t.Errorf("expect older manifests to be removed, got %v", matches)
+	}
+}
+
+func TestManifest_EditLayout(t *testing.T) {
+	edit := ManifestEdit{
+		Added:       []ManifestTable{{Level: 1, FileNumber: 3, Timestamp: 4, IndexBlockSize: 5, DataBlockSize: 6}},
+		Removed:     []ManifestTable{{Level: 0, FileNumber: 2}},
+		FlushIndex:  7,
+		ExpiryClock: 8,
+		RangeTombstones: []ManifestRangeTombstone{
+			{Seq: 9, Start: []byte("a"), End: []byte("b"), Tables: []int64{3}},
+		},
+		RemovedRangeTombstones: []uint64{1},
+	}
+
+	payload := encodeManifestEdit(edit)
+
+	decoded, err := decodeManifestEdit(payload)
+	if err != nil || fmt.Sprintf("%+v", decoded) != fmt.Sprintf("%+v", edit) {
+		t.Errorf("expect %+v, got %+v: %v", edit, decoded, err)
+	}
+
+	// every field is written, edits cut short or with bytes after them are malformed
+	for _, malformed := range [][]byte{payload[:len(payload)-1], append(payload, 0)} {
+		if _, err = decodeManifestEdit(malformed); err == nil {
+			t.Errorf("edit of %d bytes out of %d should be malformed", len(malformed), len(payload))
+		}
+	}
+}
+
+func TestLevelFileTable_RangeDeletions(t *testing.T) {
+	dirPath, err := ioutil.TempDir("/tmp/", "cliftondbtests")
+	if err != nil {
+		t.Fatal("can't create test directory", err)
+	}
+	defer os.RemoveAll(dirPath)
+
+	fileTable := openFileTable(t, dirPath)
+
+	writeTable(t, fileTable, 1, "a", 10)
+	writeTable(t, fileTable, 1, "b", 10)
+
+	// a flushed memtable holding only the tombstone
+	err = fileTable.Install(VersionEdit{
+		RangeTombstones: []sstable.RangeTombstone{{Start: []byte("a"), End: []byte("a_9"), Seq: 100}},
+	})
+	if err != nil {
+		t.Error("error installing edit", err)
+		return
+	}
+
+	expectDeleted := func(key string) {
+		_, deleted, ok, err := fileTable.Get([]byte(key), types.MaxSequenceNumber, 0)
+		if err != nil || !ok || !deleted {
+			t.Errorf("key %s should be deleted, ok: %v, err: %v", key, ok, err)
+		}
+
+		// the tombstone is not seen before its seq
+		if _, deleted, _, _ = fileTable.Get([]byte(key), 99, 0); deleted {
+			t.Errorf("key %s should not be deleted at 99", key)
+		}
+	}
+
+	expectDeleted("a_0005")
+	expectTableValue(t, fileTable, "b_0005")
+
+	_ = fileTable.Close()
+	fileTable = openFileTable(t, dirPath)
+
+	deletions := fileTable.RangeDeletions()
+	if len(deletions) != 1 || len(deletions[0].Tables) != 1 || deletions[0].Tables[0] != fileTable.Tables(1)[0] {
+		t.Fatalf("expect the tombstone to wait for the first table, got %v", deletions)
+	}
+
+	expectDeleted("a_0005")
+
+	// a table moved down as it is still holds deleted versions
+	moved := fileTable.Tables(1)[0]
+	err = fileTable.Install(VersionEdit{
+		Added:   []TableEdit{{Level: 2, Table: moved}},
+		Removed: []TableEdit{{Level: 1, Table: moved}},
+	})
+	if err != nil {
+		t.Error("error installing edit", err)
+		return
+	}
+
+	if deletions = fileTable.RangeDeletions(); len(deletions) != 1 || deletions[0].Tables[0] != moved {
+		t.Errorf("expect the tombstone to wait for the moved table, got %v", deletions)
+	}
+
+	// a merge that applied the tombstone and left nothing of the table
+	err = fileTable.Install(VersionEdit{
+		Removed:                []TableEdit{{Level: 2, Table: moved}},
+		AppliedRangeTombstones: []uint64{100},
+	})
+	if err != nil {
+		t.Error("error installing edit", err)
+		return
+	}
+
+	if deletions = fileTable.RangeDeletions(); len(deletions) != 0 {
+		t.Errorf("expect the tombstone to be dropped, got %v", deletions)
+	}
+
+	_ = fileTable.Close()
+	fileTable = openFileTable(t, dirPath)
+	defer fileTable.Close()
+
+	if deletions = fileTable.RangeDeletions(); len(deletions) != 0 {
+		t.Errorf("expect no tombstone after reopening, got %v", deletions)
+	}
+}
@@ -1,15 +1,49 @@
 package tables
 
+import "github.com/zl14917/MastersProject/kvstore/sstable"
+
+// Versions are tagged with the sequence number of their write,
+// reads at seq see the newest version written at or before it.
+// A version expired at now, in unix nanoseconds, reads as deleted.
 type MemTableOps interface {
-	Put(key []byte, value []byte) error
-	Get(key []byte) (value [] byte, ok bool, err error)
-	Remove(key []byte) (ok bool, err error)
-	Exists(key []byte) (ok bool, err error)
+	Put(key []byte, value []byte, seq uint64) error
+	// PutWithExpiry writes a version that expires at expiresAt, 0 never expires.
+	PutWithExpiry(key []byte, value []byte, seq uint64, expiresAt int64) error
+	Get(key []byte, seq uint64, now int64) (value []byte, deleted bool, ok bool, err error)
+	// GetVersion returns the version Get reads, deleted versions as tombstones. A merge
+	// operand is merged into the versions older than its sequence number.
+	GetVersion(key []byte, seq uint64, now int64) (record sstable.Record, ok bool, err error)
+	// Remove writes a tombstone, ok is true if the key was live in the table.
+	Remove(key []byte, seq uint64) (ok bool, err error)
+	// DeleteRange deletes versions of keys in [start, end) written before seq,
+	// in this table and in older tables.
+	DeleteRange(start []byte, end []byte, seq uint64) error
+	// Merge writes an operand that reads fold into the older versions of key.
+	Merge(key []byte, operand []byte, seq uint64) error
+	Exists(key []byte, seq uint64, now int64) (ok bool, err error)
+	// ApplyBatch applies all entries at seq at once, readers see all or none of them.
+	ApplyBatch(entries []BatchEntry, seq uint64) error
+	// PrepareBatch writes entries at seq hidden from readers, commit shows all of them
+	// and cannot fail. Entries of a batch that is never committed stay hidden.
+	PrepareBatch(entries []BatchEntry, seq uint64) (commit func(), err error)
+}
+
+// BatchEntry is a put, a delete when Deleted is set
+// or a merge operand when MergeOperand is set.
+type BatchEntry struct {
+	Key          []byte
+	Value        []byte
+	Deleted      bool
+	MergeOperand bool
 }
 
 type MemTable interface {
 	MemTableOps
 
 	KeyCountEstimate() uint
+	// SizeBytes is the approximate memory taken by keys and values of all versions.
+	SizeBytes() int64
 	Iterator() SortedKVIterator
+	// RangeTombstones of all DeleteRange calls, they are not returned by Iterator.
+	RangeTombstones() []sstable.RangeTombstone
 }
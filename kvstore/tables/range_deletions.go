@@ -0,0 +1,182 @@
+package tables
+
+import (
+	"github.com/zl14917/MastersProject/kvstore/sstable"
+)
+
+// RangeDeletion is a range tombstone of a flushed memtable. It is kept until none
+// of Tables, the live tables that may hold versions it deletes, is left.
+// A table leaves Tables once it is removed by a merge that applied the tombstone,
+// tables written by other edits that remove it take its place.
+type RangeDeletion struct {
+	sstable.RangeTombstone
+	Tables []*SStableRef
+}
+
+// RangeDeletions of the tables, oldest first. Callers must not modify them.
+func (t *LevelFileTable) RangeDeletions() []RangeDeletion {
+	t.RLock()
+	defer t.RUnlock()
+
+	return t.rangeDeletions
+}
+
+// RangeTombstones of the tables, oldest first.
+func (t *LevelFileTable) RangeTombstones() []sstable.RangeTombstone {
+	return rangeTombstones(t.RangeDeletions())
+}
+
+func rangeTombstones(deletions []RangeDeletion) []sstable.RangeTombstone {
+	tombstones := make([]sstable.RangeTombstone, len(deletions))
+
+	for i := range deletions {
+		tombstones[i] = deletions[i].RangeTombstone
+	}
+
+	return tombstones
+}
+
+// rangeDeletionsAfter returns the range deletions once edit is installed, and records
+// the ones it changes in manifestEdit. Callers hold the lock.
+func (t *LevelFileTable) rangeDeletionsAfter(edit VersionEdit, manifestEdit *ManifestEdit) ([]RangeDeletion, error) {
+	if len(t.rangeDeletions) == 0 && len(edit.RangeTombstones) == 0 {
+		return nil, nil
+	}
+
+	var (
+		removed = make(map[*SStableRef]bool, len(edit.Removed))
+		applied = make(map[uint64]bool, len(edit.AppliedRangeTombstones))
+		result  = make([]RangeDeletion, 0, len(t.rangeDeletions)+len(edit.RangeTombstones))
+	)
+
+	for _, table := range edit.Removed {
+		removed[table.Table] = true
+	}
+
+	for _, seq := range edit.AppliedRangeTombstones {
+		applied[seq] = true
+	}
+
+	for _, deletion := range t.rangeDeletions {
+		var (
+			remaining = make([]*SStableRef, 0, len(deletion.Tables))
+			changed   = false
+		)
+
+		for _, table := range deletion.Tables {
+			if removed[table] {
+				changed = true
+				continue
+			}
+			remaining = append(remaining, table)
+		}
+
+		if !changed {
+			result = append(result, deletion)
+			continue
+		}
+
+		// the versions the tombstone deletes moved into the added tables
+		if !applied[deletion.Seq] {
+			added, err := overlappingTables(&deletion.RangeTombstone, edit.Added)
+			if err != nil {
+				return nil, err
+			}
+			remaining = append(remaining, added...)
+		}
+
+		deletion.Tables = remaining
+		result = recordRangeDeletion(result, deletion, manifestEdit)
+	}
+
+	if len(edit.RangeTombstones) == 0 {
+		return result, nil
+	}
+
+	// tables live once the edit is installed
+	var live []TableEdit
+
+	for level := 0; level < NumLevels; level++ {
+		for _, table := range *t.levelRef(level) {
+			if !removed[table] {
+				live = append(live, TableEdit{Level: level, Table: table})
+			}
+		}
+	}
+
+	live = append(live, edit.Added...)
+
+	for _, tombstone := range edit.RangeTombstones {
+		tables, err := overlappingTables(&tombstone, live)
+		if err != nil {
+			return nil, err
+		}
+
+		result = recordRangeDeletion(result, RangeDeletion{RangeTombstone: tombstone, Tables: tables}, manifestEdit)
+	}
+
+	return result, nil
+}
+
+// recordRangeDeletion appends a new or changed deletion to deletions, unless it has no tables left.
+func recordRangeDeletion(deletions []RangeDeletion, deletion RangeDeletion, manifestEdit *ManifestEdit) []RangeDeletion {
+	if len(deletion.Tables) == 0 {
+		manifestEdit.RemovedRangeTombstones = append(manifestEdit.RemovedRangeTombstones, deletion.Seq)
+		return deletions
+	}
+
+	fileNumbers := make([]int64, len(deletion.Tables))
+	for i, table := range deletion.Tables {
+		fileNumbers[i] = table.FileNumber
+	}
+
+	manifestEdit.RangeTombstones = append(manifestEdit.RangeTombstones, ManifestRangeTombstone{
+		Start:  deletion.Start,
+		End:    deletion.End,
+		Seq:    deletion.Seq,
+		Tables: fileNumbers,
+	})
+
+	return append(deletions, deletion)
+}
+
+func overlappingTables(tombstone *sstable.RangeTombstone, tables []TableEdit) ([]*SStableRef, error) {
+	var result []*SStableRef
+
+	for _, table := range tables {
+		minKey, maxKey, err := table.Table.KeyRange()
+		if err != nil {
+			return nil, err
+		}
+
+		if tombstone.Overlaps(minKey, maxKey) {
+			result = append(result, table.Table)
+		}
+	}
+
+	return result, nil
+}
+
+// openRangeDeletions finds the tables of the range tombstones in the manifest,
+// tables holds the live tables by file number.
+func openRangeDeletions(manifest *Manifest, tables map[int64]*SStableRef) []RangeDeletion {
+	var deletions []RangeDeletion
+
+	for _, tombstone := range manifest.RangeTombstones() {
+		deletion := RangeDeletion{
+			RangeTombstone: sstable.RangeTombstone{Start: tombstone.Start, End: tombstone.End, Seq: tombstone.Seq},
+		}
+
+		for _, fileNumber := range tombstone.Tables {
+			if table, ok := tables[fileNumber]; ok {
+				deletion.Tables = append(deletion.Tables, table)
+			}
+		}
+
+		if len(deletion.Tables) > 0 {
+			deletions = append(deletions, deletion)
+		}
+	}
+
+	return deletions
+}
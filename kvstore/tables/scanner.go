@@ -0,0 +1,642 @@
+package tables
+
+import (
+	"bytes"
+	"container/heap"
+	"github.com/zl14917/MastersProject/kvstore/sstable"
+	"github.com/zl14917/MastersProject/kvstore/types"
+	"io"
+)
+
+// Scanner returns the newest version of each key, as of the sequence number
+// it reads at, in key order. Deleted keys are returned as tombstones,
+// so that a merged scanner can shadow older versions with them.
+// A merge operand is returned as the value of its key, MergeOperand tells
+// that older versions of the key have to be read to fold it into them.
+//
+// Like SortedKVIterator it has a cursor between keys: Next and Prev return
+// the record after or before it, Seek positions Next at the first key >= key
+// and SeekForPrev positions Prev at the last key <= key. A nil key seeks to
+// the start of the range, or past its end for SeekForPrev.
+// Errors stop the scanner and are returned by Err.
+type Scanner interface {
+	Next() bool
+	Prev() bool
+	Seek(key types.KeyType)
+	SeekForPrev(key types.KeyType)
+	Current() (key types.KeyType, value types.ValueType, deleted bool)
+	MergeOperand() bool
+	Err() error
+	Close() error
+}
+
+// beforeStart and pastEnd bound a scan to [start, end),
+// a nil start or end leaves that side open.
+func beforeStart(key types.KeyType, start types.KeyType) bool {
+	return start != nil && bytes.Compare(key, start) < 0
+}
+
+func pastEnd(key types.KeyType, end types.KeyType) bool {
+	return end != nil && bytes.Compare(key, end) >= 0
+}
+
+// seekTarget clamps a Seek key into [start, end).
+func seekTarget(key types.KeyType, start types.KeyType) types.KeyType {
+	if key == nil || beforeStart(key, start) {
+		return start
+	}
+	return key
+}
+
+// seekForPrevTarget clamps a SeekForPrev key, records at end are skipped by Prev.
+func seekForPrevTarget(key types.KeyType, end types.KeyType) types.KeyType {
+	if end != nil && (key == nil || pastEnd(key, end)) {
+		return end
+	}
+	return key
+}
+
+// versionCursor moves over all versions in table order, newest version of a key
+// first. It has the same cursor semantics as Scanner without range bounds.
+type versionCursor interface {
+	next() (sstable.Record, bool)
+	prev() (sstable.Record, bool)
+	seek(key types.KeyType)
+	seekForPrev(key types.KeyType)
+	err() error
+	close() error
+}
+
+// versionScanner returns the newest version of each key at or before seq,
+// within [start, end). Versions expired at now or deleted by a range tombstone
+// are returned as tombstones.
+type versionScanner struct {
+	cursor          versionCursor
+	start           types.KeyType
+	end             types.KeyType
+	seq             uint64
+	now             int64
+	rangeTombstones []sstable.RangeTombstone
+
+	current sstable.Record
+	// older versions of the key returned by Next are skipped
+	lastKey types.KeyType
+}
+
+func newVersionScanner(
+	cursor versionCursor,
+	start types.KeyType,
+	end types.KeyType,
+	seq uint64,
+	now int64,
+	rangeTombstones []sstable.RangeTombstone,
+) *versionScanner {
+	scanner := &versionScanner{
+		cursor:          cursor,
+		start:           start,
+		end:             end,
+		seq:             seq,
+		now:             now,
+		rangeTombstones: rangeTombstones,
+	}
+
+	scanner.Seek(nil)
+	return scanner
+}
+
+func (s *versionScanner) Next() bool {
+	for {
+		record, ok := s.cursor.next()
+		if !ok {
+			return false
+		}
+
+		if beforeStart(record.Key, s.start) {
+			continue
+		}
+
+		if pastEnd(record.Key, s.end) {
+			s.cursor.prev()
+			return false
+		}
+
+		if record.Seq > s.seq || (s.lastKey != nil && bytes.Equal(record.Key, s.lastKey)) {
+			continue
+		}
+
+		s.setCurrent(record)
+		s.lastKey = record.Key
+		return true
+	}
+}
+
+// Prev sees the versions of a key oldest first, the last visible one
+// before the key changes is the newest.
+func (s *versionScanner) Prev() bool {
+	var (
+		found   bool
+		newest  sstable.Record
+		lastKey types.KeyType
+	)
+
+	s.lastKey = nil
+
+	for {
+		record, ok := s.cursor.prev()
+		if !ok {
+			break
+		}
+
+		if pastEnd(record.Key, s.end) {
+			continue
+		}
+
+		if beforeStart(record.Key, s.start) || (found && !bytes.Equal(record.Key, lastKey)) {
+			s.cursor.next()
+			break
+		}
+
+		lastKey = record.Key
+
+		if record.Seq <= s.seq {
+			found, newest = true, record
+		}
+	}
+
+	if found {
+		s.setCurrent(newest)
+	}
+	return found
+}
+
+func (s *versionScanner) setCurrent(record sstable.Record) {
+	if record.Expired(s.now) || sstable.RangeDeleted(s.rangeTombstones, record.Key, record.Seq, s.seq) {
+		record = sstable.Record{Key: record.Key, Seq: record.Seq, Deleted: true}
+	}
+	s.current = record
+}
+
+func (s *versionScanner) Seek(key types.KeyType) {
+	s.lastKey = nil
+	s.cursor.seek(seekTarget(key, s.start))
+}
+
+func (s *versionScanner) SeekForPrev(key types.KeyType) {
+	s.lastKey = nil
+	s.cursor.seekForPrev(seekForPrevTarget(key, s.end))
+}
+
+func (s *versionScanner) Current() (key types.KeyType, value types.ValueType, deleted bool) {
+	return s.current.Key, s.current.Value, s.current.Deleted
+}
+
+func (s *versionScanner) MergeOperand() bool {
+	return s.current.MergeOperand
+}
+
+func (s *versionScanner) Err() error {
+	return s.cursor.err()
+}
+
+func (s *versionScanner) Close() error {
+	return s.cursor.close()
+}
+
+// memTableCursor walks the iterator of a memtable.
+type memTableCursor struct {
+	iterator SortedKVIterator
+}
+
+// NewMemTableScanner scans table in [start, end) at sequence number seq,
+// keys expired at now or deleted by range tombstones of the table read as deleted.
+func NewMemTableScanner(table MemTable, start types.KeyType, end types.KeyType, seq uint64, now int64) Scanner {
+	cursor := &memTableCursor{iterator: table.Iterator()}
+	return newVersionScanner(cursor, start, end, seq, now, table.RangeTombstones())
+}
+
+func (c *memTableCursor) next() (sstable.Record, bool) {
+	if c.iterator == nil || !c.iterator.Next() {
+		return sstable.Record{}, false
+	}
+	return c.iterator.Current(), true
+}
+
+func (c *memTableCursor) prev() (sstable.Record, bool) {
+	if c.iterator == nil || !c.iterator.Prev() {
+		return sstable.Record{}, false
+	}
+	return c.iterator.Current(), true
+}
+
+func (c *memTableCursor) seek(key types.KeyType) {
+	if c.iterator != nil {
+		c.iterator.Seek(key)
+	}
+}
+
+func (c *memTableCursor) seekForPrev(key types.KeyType) {
+	if c.iterator != nil {
+		c.iterator.SeekForPrev(key)
+	}
+}
+
+func (c *memTableCursor) err() error {
+	return nil
+}
+
+func (c *memTableCursor) close() error {
+	c.iterator = nil
+	return nil
+}
+
+// tablesCursor reads tables one after another,
+// the key ranges of the tables are ordered and do not overlap.
+type tablesCursor struct {
+	tables []*SStableRef
+
+	// reader of tables[index], opened at the start of the table when nil
+	index  int
+	reader sstable.SSTableReader
+	failed error
+}
+
+func newTablesScanner(
+	tables []*SStableRef,
+	start types.KeyType,
+	end types.KeyType,
+	seq uint64,
+	now int64,
+	rangeTombstones []sstable.RangeTombstone,
+) Scanner {
+	return newVersionScanner(&tablesCursor{tables: tables}, start, end, seq, now, rangeTombstones)
+}
+
+func (c *tablesCursor) open(index int) bool {
+	reader, err := c.tables[index].NewMergeReader()
+	if err != nil {
+		c.failed = err
+		return false
+	}
+
+	c.index, c.reader = index, reader
+	return true
+}
+
+func (c *tablesCursor) next() (sstable.Record, bool) {
+	for c.failed == nil && len(c.tables) > 0 {
+		if c.reader == nil && !c.open(c.index) {
+			break
+		}
+
+		record, err := c.reader.ReadNext()
+
+		if err == io.EOF {
+			if c.index+1 >= len(c.tables) {
+				break
+			}
+
+			c.index, c.reader = c.index+1, nil
+			continue
+		}
+
+		if err != nil {
+			c.failed = err
+			break
+		}
+
+		return record, true
+	}
+
+	return sstable.Record{}, false
+}
+
+func (c *tablesCursor) prev() (sstable.Record, bool) {
+	for c.failed == nil && len(c.tables) > 0 {
+		if c.reader == nil && !c.open(c.index) {
+			break
+		}
+
+		record, err := c.reader.ReadPrev()
+
+		if err == io.EOF {
+			if c.index == 0 || !c.open(c.index-1) {
+				break
+			}
+
+			c.failed = c.reader.SeekForPrev(nil)
+			continue
+		}
+
+		if err != nil {
+			c.failed = err
+			break
+		}
+
+		return record, true
+	}
+
+	return sstable.Record{}, false
+}
+
+// seek opens the first table that may hold a key >= key.
+func (c *tablesCursor) seek(key types.KeyType) {
+	if c.failed != nil || len(c.tables) == 0 {
+		return
+	}
+
+	index := 0
+	if key != nil {
+		index = len(c.tables) - 1
+
+		for i, table := range c.tables {
+			_, maxKey, err := table.KeyRange()
+			if err != nil {
+				c.failed = err
+				return
+			}
+
+			if bytes.Compare(maxKey, key) >= 0 {
+				index = i
+				break
+			}
+		}
+	}
+
+	if c.open(index) {
+		c.failed = c.reader.Seek(key)
+	}
+}
+
+// seekForPrev opens the last table that may hold a key <= key.
+func (c *tablesCursor) seekForPrev(key types.KeyType) {
+	if c.failed != nil || len(c.tables) == 0 {
+		return
+	}
+
+	index := len(c.tables) - 1
+	if key != nil {
+		index = 0
+
+		for i := len(c.tables) - 1; i >= 0; i-- {
+			minKey, _, err := c.tables[i].KeyRange()
+			if err != nil {
+				c.failed = err
+				return
+			}
+
+			if bytes.Compare(minKey, key) <= 0 {
+				index = i
+				break
+			}
+		}
+	}
+
+	if c.open(index) {
+		c.failed = c.reader.SeekForPrev(key)
+	}
+}
+
+func (c *tablesCursor) err() error {
+	return c.failed
+}
+
+func (c *tablesCursor) close() error {
+	c.reader, c.tables = nil, nil
+	return nil
+}
+
+type mergedSource struct {
+	scanner Scanner
+	// position in the sources, lower is newer
+	age int
+}
+
+// mergedHeap has the smallest key on top, or the largest when reverse.
+// Of equal keys the newest source is on top.
+type mergedHeap struct {
+	sources []*mergedSource
+	reverse bool
+}
+
+func (h *mergedHeap) Len() int { return len(h.sources) }
+
+func (h *mergedHeap) Less(i, j int) bool {
+	keyI, _, _ := h.sources[i].scanner.Current()
+	keyJ, _, _ := h.sources[j].scanner.Current()
+
+	cmp := bytes.Compare(keyI, keyJ)
+	if cmp == 0 {
+		return h.sources[i].age < h.sources[j].age
+	}
+
+	if h.reverse {
+		return cmp > 0
+	}
+	return cmp < 0
+}
+
+func (h *mergedHeap) Swap(i, j int) { h.sources[i], h.sources[j] = h.sources[j], h.sources[i] }
+
+func (h *mergedHeap) Push(x interface{}) {
+	h.sources = append(h.sources, x.(*mergedSource))
+}
+
+func (h *mergedHeap) Pop() interface{} {
+	old := h.sources
+	n := len(old)
+	source := old[n-1]
+	h.sources = old[0 : n-1]
+	return source
+}
+
+func (h *mergedHeap) top() types.KeyType {
+	key, _, _ := h.sources[0].scanner.Current()
+	return key
+}
+
+// mergedScanner keeps the next record of every source in the heap, so the
+// cursors of the sources are one record ahead of its own cursor. Changing
+// direction seeks all sources back to the last returned key.
+type mergedScanner struct {
+	sources []*mergedSource
+	heap    mergedHeap
+	started bool
+	// key holds the last returned record
+	valid bool
+	err   error
+
+	key          types.KeyType
+	value        types.ValueType
+	deleted      bool
+	mergeOperand bool
+}
+
+// NewMergedScanner merges sources ordered newest first. Of the records
+// with the same key only the one from the newest source is returned.
+func NewMergedScanner(scanners ...Scanner) Scanner {
+	sources := make([]*mergedSource, len(scanners))
+	for age, scanner := range scanners {
+		sources[age] = &mergedSource{scanner: scanner, age: age}
+	}
+
+	return &mergedScanner{
+		sources: sources,
+		heap:    mergedHeap{sources: make([]*mergedSource, 0, len(sources))},
+	}
+}
+
+// step moves source to its next record in the heap's direction and back into the heap.
+func (s *mergedScanner) step(source *mergedSource) {
+	var ok bool
+
+	if s.heap.reverse {
+		ok = source.scanner.Prev()
+	} else {
+		ok = source.scanner.Next()
+	}
+
+	if ok {
+		heap.Push(&s.heap, source)
+		return
+	}
+
+	if err := source.scanner.Err(); err != nil && s.err == nil {
+		s.err = err
+	}
+}
+
+func (s *mergedScanner) fill(reverse bool) {
+	s.started = true
+	s.valid = false
+	s.heap.sources = s.heap.sources[:0]
+	s.heap.reverse = reverse
+
+	for _, source := range s.sources {
+		s.step(source)
+	}
+}
+
+func (s *mergedScanner) pop() bool {
+	if s.err != nil || s.heap.Len() == 0 {
+		s.valid = false
+		return false
+	}
+
+	newest := heap.Pop(&s.heap).(*mergedSource)
+	s.key, s.value, s.deleted = newest.scanner.Current()
+	s.mergeOperand = newest.scanner.MergeOperand()
+	s.step(newest)
+
+	// drop the shadowed versions of the key
+	for s.heap.Len() > 0 && bytes.Equal(s.heap.top(), s.key) {
+		s.step(heap.Pop(&s.heap).(*mergedSource))
+	}
+
+	s.valid = s.err == nil
+	return s.valid
+}
+
+func (s *mergedScanner) Next() bool {
+	if !s.started {
+		s.fill(false)
+	} else if s.heap.reverse {
+		s.Seek(s.lastKey())
+	}
+
+	return s.pop()
+}
+
+func (s *mergedScanner) Prev() bool {
+	if !s.started {
+		// the cursor starts before the first record
+		s.started, s.heap.reverse = true, true
+		return false
+	}
+
+	if !s.heap.reverse {
+		s.SeekForPrev(s.lastKey())
+	}
+
+	return s.pop()
+}
+
+// lastKey is where the cursor is after the last returned record,
+// nil at either end of the sources.
+func (s *mergedScanner) lastKey() types.KeyType {
+	if !s.valid {
+		return nil
+	}
+	return s.key
+}
+
+func (s *mergedScanner) Seek(key types.KeyType) {
+	for _, source := range s.sources {
+		source.scanner.Seek(key)
+	}
+
+	s.fill(false)
+}
+
+func (s *mergedScanner) SeekForPrev(key types.KeyType) {
+	for _, source := range s.sources {
+		source.scanner.SeekForPrev(key)
+	}
+
+	s.fill(true)
+}
+
+func (s *mergedScanner) Current() (key types.KeyType, value types.ValueType, deleted bool) {
+	return s.key, s.value, s.deleted
+}
+
+func (s *mergedScanner) MergeOperand() bool {
+	return s.mergeOperand
+}
+
+func (s *mergedScanner) Err() error {
+	return s.err
+}
+
+func (s *mergedScanner) Close() error {
+	var err error
+
+	for _, source := range s.sources {
+		if closeErr := source.scanner.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+
+	s.heap.sources = s.heap.sources[:0]
+	return err
+}
+
+// rangeDeletedScanner reads keys deleted by range tombstones of newer sources as deleted.
+type rangeDeletedScanner struct {
+	Scanner
+	seq             uint64
+	rangeTombstones []sstable.RangeTombstone
+}
+
+// NewRangeDeletedScanner wraps the scanner of a source older than every tombstone in
+// rangeTombstones, keys contained by tombstones visible at seq read as deleted.
+func NewRangeDeletedScanner(scanner Scanner, rangeTombstones []sstable.RangeTombstone, seq uint64) Scanner {
+	if len(rangeTombstones) == 0 {
+		return scanner
+	}
+
+	return &rangeDeletedScanner{Scanner: scanner, seq: seq, rangeTombstones: rangeTombstones}
+}
+
+func (s *rangeDeletedScanner) Current() (key types.KeyType, value types.ValueType, deleted bool) {
+	key, value, deleted = s.Scanner.Current()
+
+	if !deleted && sstable.RangeDeleted(s.rangeTombstones, key, 0, s.seq) {
+		return key, nil, true
+	}
+	return key, value, deleted
+}
+
+func (s *rangeDeletedScanner) MergeOperand() bool {
+	_, _, deleted := s.Current()
+	return !deleted && s.Scanner.MergeOperand()
+}
@@ -0,0 +1,293 @@
+package tables
+
+import (
+	"fmt"
+	"github.com/zl14917/MastersProject/kvstore/types"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+type testRecord struct {
+	key     string
+	value   string
+	deleted bool
+}
+
+func writeRecords(t *testing.T, fileTable *LevelFileTable, level int, records []testRecord) {
+	ref := fileTable.NewTable(level)
+
+	writer, err := ref.NewWriter()
+	if err != nil {
+		t.Fatal("error creating sstable", err)
+	}
+
+	for _, record := range records {
+		err = writer.Write([]byte(record.key), []byte(record.value), record.deleted)
+		if err != nil {
+			t.Fatalf("error writing key %s: %v", record.key, err)
+		}
+	}
+
+	err = writer.Commit()
+	if err != nil {
+		t.Fatal("error committing sstable", err)
+	}
+
+	err = fileTable.AddSSTable(level, ref)
+	if err != nil {
+		t.Fatal("error adding sstable", err)
+	}
+}
+
+func TestMergedScanner(t *testing.T) {
+	dirPath, err := ioutil.TempDir("/tmp/", "cliftondbtests")
+	if err != nil {
+		t.Fatal("can't create test directory", err)
+	}
+	defer os.RemoveAll(dirPath)
+
+	fileTable := NewSStableFileTable(dirPath, dirPath)
+
+	var oldRecords, newRecords []testRecord
+	for i := 0; i < 20; i++ {
+		oldRecords = append(oldRecords, testRecord{key: fmt.Sprintf("k_%02d", i), value: "old"})
+	}
+	for i := 5; i < 10; i++ {
+		newRecords = append(newRecords, testRecord{key: fmt.Sprintf("k_%02d", i), value: "level0"})
+	}
+	newRecords = append(newRecords, testRecord{key: "k_10", deleted: true})
+
+	writeRecords(t, fileTable, 1, oldRecords)
+	writeRecords(t, fileTable, 0, newRecords)
+
+	memtable := NewMapMemTable(100, 100)
+	_ = memtable.Put([]byte("k_06"), []byte("memtable"), 1)
+	_ = memtable.Put([]byte("k_11"), []byte("memtable"), 2)
+	_, _ = memtable.Remove([]byte("k_11"), 3)
+	_ = memtable.Put([]byte("k_30"), []byte("memtable"), 4)
+
+	fileScanner, err := fileTable.NewScanner([]byte("k_03"), []byte("k_12"), types.MaxSequenceNumber, 0)
+	if err != nil {
+		t.Error("error creating scanner", err)
+		return
+	}
+
+	scanner := NewMergedScanner(NewMemTableScanner(memtable, []byte("k_03"), []byte("k_12"), types.MaxSequenceNumber, 0), fileScanner)
+	defer scanner.Close()
+
+	expect := []testRecord{
+		{"k_03", "old", false},
+		{"k_04", "old", false},
+		{"k_05", "level0", false},
+		{"k_06", "memtable", false},
+		{"k_07", "level0", false},
+		{"k_08", "level0", false},
+		{"k_09", "level0", false},
+		{"k_10", "", true},
+		{"k_11", "", true},
+	}
+
+	for _, record := range expect {
+		if !scanner.Next() {
+			t.Errorf("scanner stopped before %s: %v", record.key, scanner.Err())
+			return
+		}
+
+		key, value, deleted := scanner.Current()
+		if string(key) != record.key || deleted != record.deleted || (!deleted && string(value) != record.value) {
+			t.Errorf("expect %v, got key %s, value %s, deleted %v", record, key, value, deleted)
+		}
+	}
+
+	if scanner.Next() {
+		key, _, _ := scanner.Current()
+		t.Errorf("scanner should stop at the end of the range, got %s", key)
+	}
+
+	if scanner.Err() != nil {
+		t.Error(scanner.Err())
+	}
+}
+
+func TestMergedScanner_SeekAndPrev(t *testing.T) {
+	dirPath, err := ioutil.TempDir("/tmp/", "cliftondbtests")
+	if err != nil {
+		t.Fatal("can't create test directory", err)
+	}
+	defer os.RemoveAll(dirPath)
+
+	fileTable := NewSStableFileTable(dirPath, dirPath)
+
+	// two level 1 tables read one after another
+	var first, second, level0 []testRecord
+	for i := 0; i < 40; i += 2 {
+		record := testRecord{key: fmt.Sprintf("k_%02d", i), value: "level1"}
+		if i < 20 {
+			first = append(first, record)
+		} else {
+			second = append(second, record)
+		}
+	}
+	for i := 1; i < 40; i += 4 {
+		level0 = append(level0, testRecord{key: fmt.Sprintf("k_%02d", i), value: "level0"})
+	}
+
+	writeRecords(t, fileTable, 1, first)
+	writeRecords(t, fileTable, 1, second)
+	writeRecords(t, fileTable, 0, level0)
+
+	memtable := NewMapMemTable(100, 100)
+	_ = memtable.Put([]byte("k_03"), []byte("memtable"), 1)
+	_ = memtable.Put([]byte("k_22"), []byte("memtable"), 2)
+
+	start, end := []byte("k_02"), []byte("k_38")
+
+	fileScanner, err := fileTable.NewScanner(start, end, types.MaxSequenceNumber, 0)
+	if err != nil {
+		t.Error("error creating scanner", err)
+		return
+	}
+
+	scanner := NewMergedScanner(NewMemTableScanner(memtable, start, end, types.MaxSequenceNumber, 0), fileScanner)
+	defer scanner.Close()
+
+	var expect []string
+	for i := 2; i < 38; i++ {
+		if i%2 == 0 || i%4 == 1 || i == 3 {
+			expect = append(expect, fmt.Sprintf("k_%02d", i))
+		}
+	}
+
+	expectNext := func(step func() bool, key string) bool {
+		if !step() {
+			t.Errorf("scanner stopped before %s: %v", key, scanner.Err())
+			return false
+		}
+
+		current, _, _ := scanner.Current()
+		if string(current) != key {
+			t.Errorf("expect key %s, got %s", key, current)
+			return false
+		}
+		return true
+	}
+
+	scanner.SeekForPrev(nil)
+	for i := len(expect) - 1; i >= 0; i-- {
+		if !expectNext(scanner.Prev, expect[i]) {
+			return
+		}
+	}
+
+	if scanner.Prev() {
+		t.Error("scanner should stop at the start of the range")
+	}
+
+	// turning around returns the records again
+	for _, key := range expect[:3] {
+		if !expectNext(scanner.Next, key) {
+			return
+		}
+	}
+	expectNext(scanner.Prev, expect[2])
+
+	scanner.Seek([]byte("k_20"))
+	expectNext(scanner.Next, "k_20")
+	expectNext(scanner.Next, "k_21")
+
+	scanner.SeekForPrev([]byte("k_23"))
+	expectNext(scanner.Prev, "k_22")
+	if _, value, _ := scanner.Current(); string(value) != "memtable" {
+		t.Errorf("k_22 should be read from the memtable, got %s", value)
+	}
+	expectNext(scanner.Prev, "k_21")
+
+	scanner.SeekForPrev([]byte("k_99"))
+	expectNext(scanner.Prev, "k_37")
+}
+
+func TestMergedScanner_SnapshotSequence(t *testing.T) {
+	dirPath, err := ioutil.TempDir("/tmp/", "cliftondbtests")
+	if err != nil {
+		t.Fatal("can't create test directory", err)
+	}
+	defer os.RemoveAll(dirPath)
+
+	fileTable := NewSStableFileTable(dirPath, dirPath)
+
+	ref := fileTable.NewTable(1)
+	writer, err := ref.NewWriter()
+	if err != nil {
+		t.Fatal("error creating sstable", err)
+	}
+
+	for _, version := range []struct {
+		key string
+		seq uint64
+	}{{"a", 2}, {"b", 3}, {"b", 1}, {"c", 4}} {
+		value := fmt.Sprintf("%s%d", version.key, version.seq)
+		if err = writer.WriteVersion([]byte(version.key), []byte(value), version.seq, false); err != nil {
+			t.Fatal("error writing sstable", err)
+		}
+	}
+
+	if err = writer.Commit(); err != nil {
+		t.Fatal("error committing sstable", err)
+	}
+	_ = fileTable.AddSSTable(1, ref)
+
+	memtable := NewMapMemTable(100, 100)
+	_ = memtable.Put([]byte("b"), []byte("b5"), 5)
+	_, _ = memtable.Remove([]byte("b"), 6)
+	_ = memtable.Put([]byte("a"), []byte("a7"), 7)
+	_ = memtable.Put([]byte("c"), []byte("c8"), 8)
+	_ = memtable.Put([]byte("c"), []byte("c9"), 9)
+	_ = memtable.Put([]byte("d"), []byte("d10"), 10)
+
+	scan := func(seq uint64, reverse bool) []string {
+		fileScanner, err := fileTable.NewScanner(nil, nil, seq, 0)
+		if err != nil {
+			t.Fatal("error creating scanner", err)
+		}
+
+		scanner := NewMergedScanner(NewMemTableScanner(memtable, nil, nil, seq, 0), fileScanner)
+		defer scanner.Close()
+
+		step := scanner.Next
+		if reverse {
+			scanner.SeekForPrev(nil)
+			step = scanner.Prev
+		}
+
+		var values []string
+		for step() {
+			if _, value, deleted := scanner.Current(); !deleted {
+				values = append(values, string(value))
+			}
+		}
+
+		if reverse {
+			for i, j := 0, len(values)-1; i < j; i, j = i+1, j-1 {
+				values[i], values[j] = values[j], values[i]
+			}
+		}
+		return values
+	}
+
+	for seq, expect := range map[uint64]string{
+		2:                       "a2,b1",
+		4:                       "a2,b3,c4",
+		5:                       "a2,b5,c4",
+		6:                       "a2,c4",
+		8:                       "a7,c8",
+		types.MaxSequenceNumber: "a7,c9,d10",
+	} {
+		for _, reverse := range []bool{false, true} {
+			if values := strings.Join(scan(seq, reverse), ","); values != expect {
+				t.Errorf("scan at %d, reverse %v: expect %s, got %s", seq, reverse, expect, values)
+			}
+		}
+	}
+}
@@ -0,0 +1,436 @@
+package tables
+
+import (
+	"bytes"
+	"github.com/zl14917/MastersProject/kvstore/sstable"
+	"github.com/zl14917/MastersProject/kvstore/types"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	memTableMaxHeight = 12
+	memTableBranching = 4
+	// bytes a version takes besides its key and value
+	memTableNodeOverhead = int64(unsafe.Sizeof(skipListNode{}) + unsafe.Sizeof(skipListVersion{}))
+)
+
+// SkipListMemTable keeps every version in one skiplist ordered by key,
+// then newest first, the order versions are written to sstables in.
+// Writers are serialized by a mutex, readers and iterators follow next
+// pointers with atomic loads and never block writers.
+type SkipListMemTable struct {
+	writeLock sync.Mutex
+	head      *skipListNode
+	height    int32
+	random    *rand.Rand
+
+	keyCount  int64
+	sizeBytes int64
+
+	// []sstable.RangeTombstone, replaced by every DeleteRange
+	rangeTombstones atomic.Value
+
+	MaxKeySize   int
+	MaxValueSize int
+}
+
+type skipListNode struct {
+	key []byte
+	seq uint64
+	// *skipListVersion, replaced when the same key is written twice at one seq
+	version unsafe.Pointer
+	next    []unsafe.Pointer
+}
+
+// a nil value is a tombstone
+type skipListVersion struct {
+	value        []byte
+	expiresAt    int64
+	mergeOperand bool
+	// versions of a batch are hidden until all of them are inserted
+	batch *skipListBatch
+}
+
+type skipListBatch struct {
+	applied int32
+}
+
+func (v *skipListVersion) visible() bool {
+	return v.batch == nil || atomic.LoadInt32(&v.batch.applied) != 0
+}
+
+func (v *skipListVersion) deleted(now int64) bool {
+	return v.value == nil || (v.expiresAt != 0 && v.expiresAt <= now)
+}
+
+func (n *skipListNode) nextNode(level int) *skipListNode {
+	return (*skipListNode)(atomic.LoadPointer(&n.next[level]))
+}
+
+func (n *skipListNode) setNext(level int, next *skipListNode) {
+	atomic.StorePointer(&n.next[level], unsafe.Pointer(next))
+}
+
+func (n *skipListNode) loadVersion() *skipListVersion {
+	return (*skipListVersion)(atomic.LoadPointer(&n.version))
+}
+
+func (n *skipListNode) record() sstable.Record {
+	version := n.loadVersion()
+
+	return sstable.Record{
+		Key:          types.KeyType(n.key),
+		Value:        types.ValueType(version.value),
+		Seq:          n.seq,
+		Deleted:      version.value == nil,
+		MergeOperand: version.mergeOperand,
+		ExpiresAt:    version.expiresAt,
+	}
+}
+
+// compareVersion orders by key, then by descending seq.
+func compareVersion(key []byte, seq uint64, node *skipListNode) int {
+	if cmp := bytes.Compare(key, node.key); cmp != 0 {
+		return cmp
+	}
+
+	switch {
+	case seq > node.seq:
+		return -1
+	case seq < node.seq:
+		return 1
+	}
+	return 0
+}
+
+func NewSkipListMemTable(maxKeySize int, maxValueSize int) MemTable {
+	return &SkipListMemTable{
+		head:         &skipListNode{next: make([]unsafe.Pointer, memTableMaxHeight)},
+		height:       1,
+		random:       rand.New(rand.NewSource(0xdecafbad)),
+		MaxKeySize:   maxKeySize,
+		MaxValueSize: maxValueSize,
+	}
+}
+
+// findGreaterOrEqual returns the first node at or after (key, seq). If previous
+// is not nil it is filled with the last node before (key, seq) on every level.
+func (m *SkipListMemTable) findGreaterOrEqual(key []byte, seq uint64, previous []*skipListNode) *skipListNode {
+	var (
+		current = m.head
+		next    *skipListNode
+	)
+
+	for level := int(atomic.LoadInt32(&m.height)) - 1; level >= 0; level-- {
+		for {
+			next = current.nextNode(level)
+			if next == nil || compareVersion(key, seq, next) <= 0 {
+				break
+			}
+			current = next
+		}
+
+		if previous != nil {
+			previous[level] = current
+		}
+	}
+
+	return next
+}
+
+// findLessThan returns the last node before node, or the last node if node is nil.
+// It returns nil if there is no such node.
+func (m *SkipListMemTable) findLessThan(node *skipListNode) *skipListNode {
+	current := m.head
+
+	for level := int(atomic.LoadInt32(&m.height)) - 1; level >= 0; level-- {
+		for {
+			next := current.nextNode(level)
+			if next == nil || (node != nil && compareVersion(next.key, next.seq, node) >= 0) {
+				break
+			}
+			current = next
+		}
+	}
+
+	if current == m.head {
+		return nil
+	}
+	return current
+}
+
+func (m *SkipListMemTable) randomHeight() int {
+	height := 1
+	for height < memTableMaxHeight && m.random.Intn(memTableBranching) == 0 {
+		height++
+	}
+	return height
+}
+
+// insert links a version of key at seq, callers hold the write lock.
+func (m *SkipListMemTable) insert(key []byte, seq uint64, version *skipListVersion) {
+	var previous [memTableMaxHeight]*skipListNode
+
+	node := m.findGreaterOrEqual(key, seq, previous[:])
+
+	if node != nil && compareVersion(key, seq, node) == 0 {
+		old := node.loadVersion()
+		atomic.StorePointer(&node.version, unsafe.Pointer(version))
+		atomic.AddInt64(&m.sizeBytes, int64(len(version.value)-len(old.value)))
+		return
+	}
+
+	newKey := (node == nil || !bytes.Equal(node.key, key)) &&
+		(previous[0] == m.head || !bytes.Equal(previous[0].key, key))
+
+	height := m.randomHeight()
+	currentHeight := int(atomic.LoadInt32(&m.height))
+
+	for level := currentHeight; level < height; level++ {
+		previous[level] = m.head
+	}
+
+	node = &skipListNode{
+		key:     copyKey(key),
+		seq:     seq,
+		version: unsafe.Pointer(version),
+		next:    make([]unsafe.Pointer, height),
+	}
+
+	// link bottom up, a reader that finds the node on a level finds it below too
+	for level := 0; level < height; level++ {
+		node.setNext(level, previous[level].nextNode(level))
+		previous[level].setNext(level, node)
+	}
+
+	if height > currentHeight {
+		atomic.StoreInt32(&m.height, int32(height))
+	}
+
+	if newKey {
+		atomic.AddInt64(&m.keyCount, 1)
+	}
+	atomic.AddInt64(&m.sizeBytes, int64(len(key)+len(version.value))+memTableNodeOverhead)
+}
+
+func copyKey(key []byte) []byte {
+	c := make([]byte, len(key))
+	copy(c, key)
+	return c
+}
+
+// visibleVersion is the newest version of key at or before seq, and the seq it was written at.
+func (m *SkipListMemTable) visibleVersion(key []byte, seq uint64) (*skipListVersion, uint64, bool) {
+	for node := m.findGreaterOrEqual(key, seq, nil); node != nil && bytes.Equal(node.key, key); node = node.nextNode(0) {
+		if version := node.loadVersion(); version.visible() {
+			return version, node.seq, true
+		}
+	}
+	return nil, 0, false
+}
+
+func (m *SkipListMemTable) Get(key []byte, seq uint64, now int64) (value []byte, deleted bool, ok bool, err error) {
+	record, ok, err := m.GetVersion(key, seq, now)
+	return record.Value, record.Deleted, ok, err
+}
+
+func (m *SkipListMemTable) GetVersion(key []byte, seq uint64, now int64) (record sstable.Record, ok bool, err error) {
+	version, versionSeq, ok := m.visibleVersion(key, seq)
+
+	if sstable.RangeDeleted(m.RangeTombstones(), key, versionSeq, seq) || (ok && version.deleted(now)) {
+		return sstable.Record{Key: key, Seq: versionSeq, Deleted: true}, true, nil
+	}
+
+	if !ok {
+		return sstable.Record{}, false, nil
+	}
+
+	return sstable.Record{
+		Key:          key,
+		Value:        version.value,
+		Seq:          versionSeq,
+		MergeOperand: version.mergeOperand,
+		ExpiresAt:    version.expiresAt,
+	}, true, nil
+}
+
+func (m *SkipListMemTable) Exists(key []byte, seq uint64, now int64) (ok bool, err error) {
+	version, versionSeq, ok := m.visibleVersion(key, seq)
+	return ok && !version.deleted(now) && !sstable.RangeDeleted(m.RangeTombstones(), key, versionSeq, seq), nil
+}
+
+func (m *SkipListMemTable) Put(key []byte, value []byte, seq uint64) error {
+	return m.PutWithExpiry(key, value, seq, 0)
+}
+
+func (m *SkipListMemTable) PutWithExpiry(key []byte, value []byte, seq uint64, expiresAt int64) error {
+	m.writeLock.Lock()
+	defer m.writeLock.Unlock()
+
+	m.put(key, value, seq, expiresAt, nil)
+	return nil
+}
+
+func (m *SkipListMemTable) Merge(key []byte, operand []byte, seq uint64) error {
+	m.writeLock.Lock()
+	defer m.writeLock.Unlock()
+
+	m.merge(key, operand, seq, nil)
+	return nil
+}
+
+func (m *SkipListMemTable) put(key []byte, value []byte, seq uint64, expiresAt int64, batch *skipListBatch) {
+	if value == nil {
+		value = []byte{}
+	}
+
+	m.insert(key, seq, &skipListVersion{value: value, expiresAt: expiresAt, batch: batch})
+}
+
+func (m *SkipListMemTable) merge(key []byte, operand []byte, seq uint64, batch *skipListBatch) {
+	if operand == nil {
+		operand = []byte{}
+	}
+
+	m.insert(key, seq, &skipListVersion{value: operand, mergeOperand: true, batch: batch})
+}
+
+func (m *SkipListMemTable) Remove(key []byte, seq uint64) (ok bool, err error) {
+	m.writeLock.Lock()
+	defer m.writeLock.Unlock()
+
+	return m.remove(key, seq, nil), nil
+}
+
+// remove writes the tombstone even if the key is not in the table,
+// it hides versions of the key in older tables.
+func (m *SkipListMemTable) remove(key []byte, seq uint64, batch *skipListBatch) (ok bool) {
+	newest := m.findGreaterOrEqual(key, types.MaxSequenceNumber, nil)
+	ok = newest != nil && bytes.Equal(newest.key, key) && newest.loadVersion().value != nil
+
+	m.insert(key, seq, &skipListVersion{batch: batch})
+	return ok
+}
+
+func (m *SkipListMemTable) DeleteRange(start []byte, end []byte, seq uint64) error {
+	m.writeLock.Lock()
+	defer m.writeLock.Unlock()
+
+	current := m.RangeTombstones()
+	tombstones := make([]sstable.RangeTombstone, len(current), len(current)+1)
+	copy(tombstones, current)
+
+	m.rangeTombstones.Store(append(tombstones, sstable.RangeTombstone{Start: copyKey(start), End: copyKey(end), Seq: seq}))
+
+	atomic.AddInt64(&m.sizeBytes, int64(len(start)+len(end)))
+	return nil
+}
+
+// RangeTombstones returns a slice that is never modified.
+func (m *SkipListMemTable) RangeTombstones() []sstable.RangeTombstone {
+	tombstones, _ := m.rangeTombstones.Load().([]sstable.RangeTombstone)
+	return tombstones
+}
+
+func (m *SkipListMemTable) ApplyBatch(entries []BatchEntry, seq uint64) error {
+	commit, err := m.PrepareBatch(entries, seq)
+	if err != nil {
+		return err
+	}
+
+	commit()
+	return nil
+}
+
+func (m *SkipListMemTable) PrepareBatch(entries []BatchEntry, seq uint64) (commit func(), err error) {
+	m.writeLock.Lock()
+	defer m.writeLock.Unlock()
+
+	batch := &skipListBatch{}
+
+	for _, entry := range entries {
+		switch {
+		case entry.Deleted:
+			m.remove(entry.Key, seq, batch)
+		case entry.MergeOperand:
+			m.merge(entry.Key, entry.Value, seq, batch)
+		default:
+			m.put(entry.Key, entry.Value, seq, 0, batch)
+		}
+	}
+
+	return func() {
+		atomic.StoreInt32(&batch.applied, 1)
+	}, nil
+}
+
+func (m *SkipListMemTable) KeyCountEstimate() uint {
+	return uint(atomic.LoadInt64(&m.keyCount))
+}
+
+func (m *SkipListMemTable) SizeBytes() int64 {
+	return atomic.LoadInt64(&m.sizeBytes)
+}
+
+// Iterator walks the table in place, versions written while it is open may or
+// may not be returned. Versions of a batch still being applied are skipped.
+func (m *SkipListMemTable) Iterator() SortedKVIterator {
+	iterator := &skipListIterator{table: m}
+	iterator.Seek(nil)
+	return iterator
+}
+
+// skipListIterator has its cursor before next, nil when it is past the last node.
+type skipListIterator struct {
+	table   *SkipListMemTable
+	next    *skipListNode
+	current *skipListNode
+}
+
+func (i *skipListIterator) Next() bool {
+	for node := i.next; node != nil; node = node.nextNode(0) {
+		if node.loadVersion().visible() {
+			i.current, i.next = node, node.nextNode(0)
+			return true
+		}
+	}
+
+	i.next = nil
+	return false
+}
+
+func (i *skipListIterator) Prev() bool {
+	for node := i.table.findLessThan(i.next); node != nil; node = i.table.findLessThan(node) {
+		if node.loadVersion().visible() {
+			i.current, i.next = node, node
+			return true
+		}
+	}
+
+	i.next = i.table.head.nextNode(0)
+	return false
+}
+
+func (i *skipListIterator) Seek(key types.KeyType) {
+	i.next = i.table.findGreaterOrEqual(key, types.MaxSequenceNumber, nil)
+}
+
+func (i *skipListIterator) SeekForPrev(key types.KeyType) {
+	if key == nil {
+		i.next = nil
+		return
+	}
+
+	// the first node after every version of key
+	i.next = i.table.findGreaterOrEqual(key, 0, nil)
+	for i.next != nil && bytes.Equal(i.next.key, key) {
+		i.next = i.next.nextNode(0)
+	}
+}
+
+func (i *skipListIterator) Current() sstable.Record {
+	return i.current.record()
+}
@@ -0,0 +1,301 @@
+package tables
+
+import (
+	"fmt"
+	"github.com/zl14917/MastersProject/kvstore/sstable"
+	"github.com/zl14917/MastersProject/kvstore/types"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestSkipListMemTable_Versions(t *testing.T) {
+	table := NewSkipListMemTable(100, 100)
+
+	_ = table.Put([]byte("apple"), []byte("v1"), 1)
+	_ = table.Put([]byte("apple"), []byte("v3"), 3)
+	// writes are applied out of sequence order
+	_ = table.Put([]byte("apple"), []byte("v2"), 2)
+	_ = table.PutWithExpiry([]byte("banana"), []byte("short"), 4, 100)
+
+	for seq, expect := range map[uint64]string{1: "v1", 2: "v2", 3: "v3", types.MaxSequenceNumber: "v3"} {
+		value, deleted, ok, err := table.Get([]byte("apple"), seq, 0)
+		if err != nil || !ok || deleted || string(value) != expect {
+			t.Errorf("apple at %d expect %s, got %s, deleted: %v, ok: %v, err: %v", seq, expect, value, deleted, ok, err)
+		}
+	}
+
+	if _, _, ok, _ := table.Get([]byte("apple"), 0, 0); ok {
+		t.Error("apple should not be found before its first write")
+	}
+
+	if ok, _ := table.Exists([]byte("banana"), 4, 100); ok {
+		t.Error("banana should read as deleted once expired")
+	}
+
+	if ok, _ := table.Remove([]byte("apple"), 5); !ok {
+		t.Error("removing live apple should return ok")
+	}
+
+	if _, deleted, ok, _ := table.Get([]byte("apple"), 5, 0); !ok || !deleted {
+		t.Errorf("apple should be deleted at 5, ok: %v, deleted: %v", ok, deleted)
+	}
+
+	if table.KeyCountEstimate() != 2 {
+		t.Errorf("expect 2 keys, got %d", table.KeyCountEstimate())
+	}
+
+	if size := table.SizeBytes(); size < int64(4*len("apple")+len("v1v2v3")+len("banana")+len("short")) {
+		t.Errorf("size should count keys and values, got %d", size)
+	}
+}
+
+// the skiplist returns the same versions in the same order as the map memtable
+func TestSkipListMemTable_IteratorMatchesMapMemTable(t *testing.T) {
+	var (
+		random   = rand.New(rand.NewSource(1))
+		skipList = NewSkipListMemTable(100, 100)
+		mapTable = NewMapMemTable(100, 100)
+	)
+
+	for seq := uint64(1); seq <= 500; seq++ {
+		key := []byte(fmt.Sprintf("key_%03d", random.Intn(100)))
+		remove := random.Intn(5) == 0
+
+		for _, table := range []MemTable{skipList, mapTable} {
+			if remove {
+				_, _ = table.Remove(key, seq)
+			} else {
+				_ = table.Put(key, []byte(fmt.Sprintf("value_%d", seq)), seq)
+			}
+		}
+	}
+
+	collect := func(iterator SortedKVIterator, next func() bool) []sstable.Record {
+		var records []sstable.Record
+		for next() {
+			records = append(records, iterator.Current())
+		}
+		return records
+	}
+
+	skipIterator, mapIterator := skipList.Iterator(), mapTable.Iterator()
+
+	if expect, got := collect(mapIterator, mapIterator.Next), collect(skipIterator, skipIterator.Next); !reflect.DeepEqual(expect, got) {
+		t.Fatalf("expect %d versions in map order, got %d", len(expect), len(got))
+	}
+
+	if expect, got := collect(mapIterator, mapIterator.Prev), collect(skipIterator, skipIterator.Prev); !reflect.DeepEqual(expect, got) {
+		t.Fatalf("expect %d versions backwards, got %d", len(expect), len(got))
+	}
+
+	for _, key := range []types.KeyType{nil, []byte("key_050"), []byte("key_0505"), []byte("zzz")} {
+		mapIterator.Seek(key)
+		skipIterator.Seek(key)
+
+		if expect, got := collect(mapIterator, mapIterator.Next), collect(skipIterator, skipIterator.Next); !reflect.DeepEqual(expect, got) {
+			t.Errorf("seek %s: expect %d versions, got %d", key, len(expect), len(got))
+		}
+
+		mapIterator.SeekForPrev(key)
+		skipIterator.SeekForPrev(key)
+
+		if expect, got := collect(mapIterator, mapIterator.Prev), collect(skipIterator, skipIterator.Prev); !reflect.DeepEqual(expect, got) {
+			t.Errorf("seek for prev %s: expect %d versions, got %d", key, len(expect), len(got))
+		}
+	}
+}
+
+// tombstones hide versions of keys the table does not hold, in older tables
+func TestMemTable_Tombstones(t *testing.T) {
+	for name, table := range map[string]MemTable{
+		"skiplist": NewSkipListMemTable(100, 100),
+		"map":      NewMapMemTable(100, 100),
+	} {
+		if ok, _ := table.Remove([]byte("flushed"), 1); ok {
+			t.Errorf("%s: removing a key not in the table should not return ok", name)
+		}
+
+		_ = table.Put([]byte("b/1"), []byte("old"), 2)
+		_ = table.DeleteRange([]byte("b/"), []byte("b0"), 3)
+		_ = table.Put([]byte("b/2"), []byte("new"), 4)
+
+		for _, read := range []struct {
+			key     string
+			seq     uint64
+			deleted bool
+			ok      bool
+		}{
+			{"flushed", 1, true, true},
+			{"b/1", 2, false, true},
+			{"b/1", 3, true, true},
+			{"b/2", 4, false, true},
+			// not in the table, but deleted in older ones
+			{"b/3", 4, true, true},
+			{"b/3", 2, false, false},
+			{"c", 4, false, false},
+		} {
+			_, deleted, ok, err := table.Get([]byte(read.key), read.seq, 0)
+			if err != nil || deleted != read.deleted || ok != read.ok {
+				t.Errorf("%s: %s at %d expect deleted: %v, ok: %v, got %v, %v, err: %v",
+					name, read.key, read.seq, read.deleted, read.ok, deleted, ok, err)
+			}
+		}
+
+		if tombstones := table.RangeTombstones(); len(tombstones) != 1 || tombstones[0].Seq != 3 {
+			t.Errorf("%s: expect the range tombstone at 3, got %v", name, tombstones)
+		}
+	}
+}
+
+// operands are versions of their own, read through GetVersion and the iterator
+func TestMemTable_MergeOperands(t *testing.T) {
+	for name, table := range map[string]MemTable{
+		"skiplist": NewSkipListMemTable(100, 100),
+		"map":      NewMapMemTable(100, 100),
+	} {
+		_ = table.Put([]byte("counter"), []byte("1"), 1)
+		_ = table.Merge([]byte("counter"), []byte("2"), 2)
+		_ = table.ApplyBatch([]BatchEntry{
+			{Key: []byte("counter"), Value: []byte("3"), MergeOperand: true},
+			{Key: []byte("other"), Value: []byte("x")},
+		}, 3)
+
+		for seq, expect := range map[uint64]string{1: "1/false", 2: "2/true", 3: "3/true"} {
+			record, ok, err := table.GetVersion([]byte("counter"), seq, 0)
+			if got := fmt.Sprintf("%s/%v", record.Value, record.MergeOperand); err != nil || !ok || got != expect || record.Seq != seq {
+				t.Errorf("%s: counter at %d expect %s, got %s at %d, ok: %v, err: %v", name, seq, expect, got, record.Seq, ok, err)
+			}
+		}
+
+		iterator := table.Iterator()
+
+		var versions []string
+		for iterator.Next() {
+			record := iterator.Current()
+			versions = append(versions, fmt.Sprintf("%s@%d=%s/%v", record.Key, record.Seq, record.Value, record.MergeOperand))
+		}
+
+		expect := []string{"counter@3=3/true", "counter@2=2/true", "counter@1=1/false", "other@3=x/false"}
+		if fmt.Sprint(versions) != fmt.Sprint(expect) {
+			t.Errorf("%s: expect versions %v, got %v", name, expect, versions)
+		}
+	}
+}
+
+// a prepared batch is read once committed, never if it is not
+func TestMemTable_PrepareBatch(t *testing.T) {
+	for name, table := range map[string]MemTable{
+		"skiplist": NewSkipListMemTable(100, 100),
+		"map":      NewMapMemTable(100, 100),
+	} {
+		_ = table.Put([]byte("key"), []byte("old"), 1)
+
+		_, err := table.PrepareBatch([]BatchEntry{{Key: []byte("key"), Value: []byte("dropped")}}, 2)
+		if err != nil {
+			t.Errorf("%s: error preparing batch: %v", name, err)
+		}
+
+		commit, err := table.PrepareBatch([]BatchEntry{{Key: []byte("other"), Value: []byte("new")}}, 3)
+		if err != nil {
+			t.Errorf("%s: error preparing batch: %v", name, err)
+		}
+
+		if _, _, ok, _ := table.Get([]byte("other"), 3, 0); ok {
+			t.Errorf("%s: batch should be hidden until committed", name)
+		}
+
+		commit()
+
+		if value, _, ok, _ := table.Get([]byte("key"), 3, 0); !ok || string(value) != "old" {
+			t.Errorf("%s: uncommitted batch should stay hidden, got %s", name, value)
+		}
+
+		if value, _, ok, _ := table.Get([]byte("other"), 3, 0); !ok || string(value) != "new" {
+			t.Errorf("%s: expect committed batch, got %s", name, value)
+		}
+	}
+}
+
+func TestSkipListMemTable_ConcurrentReadersAndBatches(t *testing.T) {
+	table := NewSkipListMemTable(100, 100)
+
+	var (
+		wg   sync.WaitGroup
+		done = make(chan struct{})
+	)
+
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				// both keys of a batch are seen, or neither
+				first, _, firstOk, _ := table.Get([]byte("first"), types.MaxSequenceNumber, 0)
+				second, _, secondOk, _ := table.Get([]byte("second"), types.MaxSequenceNumber, 0)
+
+				if firstOk != secondOk || (secondOk && string(second) < string(first)) {
+					t.Errorf("partial batch: first %s %v, second %s %v", first, firstOk, second, secondOk)
+					return
+				}
+			}
+		}()
+	}
+
+	for seq := uint64(1); seq <= 2000; seq++ {
+		value := []byte(fmt.Sprintf("%06d", seq))
+		_ = table.ApplyBatch([]BatchEntry{
+			{Key: []byte("first"), Value: value},
+			{Key: []byte("second"), Value: value},
+		}, seq)
+	}
+
+	close(done)
+	wg.Wait()
+}
+
+func TestLevelFileTable_FlushSkipListMemTable(t *testing.T) {
+	dirPath, err := ioutil.TempDir("/tmp/", "cliftondbtests")
+	if err != nil {
+		t.Fatal("can't create test directory", err)
+	}
+	defer os.RemoveAll(dirPath)
+
+	fileTable := NewSStableFileTable(dirPath, dirPath)
+	table := NewSkipListMemTable(100, 100)
+
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key_%03d", i))
+		_ = table.Put(key, []byte("old"), uint64(i+1))
+		_ = table.Put(key, []byte("new"), uint64(i+101))
+	}
+
+	var flushErr error
+	fileTable.BeginFlushing(table, func(ok bool, err error) {
+		flushErr = err
+	})
+
+	if flushErr != nil {
+		t.Fatal("error flushing memtable", flushErr)
+	}
+
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key_%03d", i))
+
+		for seq, expect := range map[uint64]string{uint64(i + 1): "old", types.MaxSequenceNumber: "new"} {
+			value, deleted, ok, err := fileTable.Get(key, seq, 0)
+			if err != nil || !ok || deleted || string(value) != expect {
+				t.Errorf("key %s at %d expect %s, got %s, ok: %v, err: %v", key, seq, expect, value, ok, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package tables
+
+import (
+	"sort"
+	"sync"
+)
+
+// SnapshotList counts live snapshots by the sequence number and
+// expiry time they read at.
+type SnapshotList struct {
+	sync.Mutex
+	refs map[snapshotPoint]int
+}
+
+type snapshotPoint struct {
+	seq uint64
+	now int64
+}
+
+func (l *SnapshotList) Acquire(seq uint64, now int64) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.refs == nil {
+		l.refs = make(map[snapshotPoint]int)
+	}
+	l.refs[snapshotPoint{seq, now}]++
+}
+
+func (l *SnapshotList) Release(seq uint64, now int64) {
+	l.Lock()
+	defer l.Unlock()
+
+	point := snapshotPoint{seq, now}
+	if l.refs[point] <= 1 {
+		delete(l.refs, point)
+		return
+	}
+	l.refs[point]--
+}
+
+// Sequences are the sequence numbers of live snapshots in ascending order.
+func (l *SnapshotList) Sequences() []uint64 {
+	l.Lock()
+	defer l.Unlock()
+
+	seen := make(map[uint64]bool, len(l.refs))
+	seqs := make([]uint64, 0, len(l.refs))
+
+	for point := range l.refs {
+		if !seen[point.seq] {
+			seen[point.seq] = true
+			seqs = append(seqs, point.seq)
+		}
+	}
+
+	sort.Slice(seqs, func(i, j int) bool {
+		return seqs[i] < seqs[j]
+	})
+	return seqs
+}
+
+// ExpiryTime is the latest time, no later than now, at which a record
+// expired for every live snapshot.
+func (l *SnapshotList) ExpiryTime(now int64) int64 {
+	l.Lock()
+	defer l.Unlock()
+
+	for point := range l.refs {
+		if point.now < now {
+			now = point.now
+		}
+	}
+	return now
+}
@@ -1,11 +1,69 @@
 package tables
 
 import (
-	"github.com/zl14917/MastersProject/concurrent/maps"
+	"bytes"
+	"github.com/zl14917/MastersProject/kvstore/sstable"
 	"github.com/zl14917/MastersProject/kvstore/types"
+	"sort"
 )
 
+// SortedKVIterator has a cursor between entries, Next and Prev return the entry
+// after or before it and move past it. Versions of a key are returned newest
+// first by Next. Seek positions Next at the first entry with key >= key,
+// SeekForPrev positions Prev at the last entry with key <= key.
+// A nil key seeks to the first entry, or past the last for SeekForPrev.
 type SortedKVIterator interface {
 	Next() bool
-	Current() (key types.KeyType, value maps.Value)
+	Prev() bool
+	Seek(key types.KeyType)
+	SeekForPrev(key types.KeyType)
+	Current() sstable.Record
+}
+
+type sortedSnapshotIterator struct {
+	records []sstable.Record
+
+	cursor  int
+	current int
+}
+
+func (i *sortedSnapshotIterator) Next() bool {
+	if i.cursor >= len(i.records) {
+		return false
+	}
+
+	i.current = i.cursor
+	i.cursor++
+	return true
+}
+
+func (i *sortedSnapshotIterator) Prev() bool {
+	if i.cursor <= 0 {
+		return false
+	}
+
+	i.cursor--
+	i.current = i.cursor
+	return true
+}
+
+func (i *sortedSnapshotIterator) Seek(key types.KeyType) {
+	i.cursor = sort.Search(len(i.records), func(n int) bool {
+		return bytes.Compare(i.records[n].Key, key) >= 0
+	})
+}
+
+func (i *sortedSnapshotIterator) SeekForPrev(key types.KeyType) {
+	if key == nil {
+		i.cursor = len(i.records)
+		return
+	}
+
+	i.cursor = sort.Search(len(i.records), func(n int) bool {
+		return bytes.Compare(i.records[n].Key, key) > 0
+	})
+}
+
+func (i *sortedSnapshotIterator) Current() sstable.Record {
+	return i.records[i.current]
 }
@@ -1,39 +1,256 @@
 package tables
 
-import "github.com/zl14917/MastersProject/concurrent/maps"
+import (
+	"github.com/zl14917/MastersProject/concurrent/maps"
+	"github.com/zl14917/MastersProject/kvstore/sstable"
+	"github.com/zl14917/MastersProject/kvstore/types"
+	"sort"
+	"sync"
+)
+
+// a nil value is a tombstone
+type memTableVersion struct {
+	seq          uint64
+	value        maps.Value
+	expiresAt    int64
+	mergeOperand bool
+}
+
+func (v *memTableVersion) deleted(now int64) bool {
+	return v.value == nil || (v.expiresAt != 0 && v.expiresAt <= now)
+}
 
 type ThreadSafeMapMemTable struct {
-	maps.ThreadsafeMap
+	sync.RWMutex
+	// versions of a key, newest first
+	versions        map[maps.Key][]memTableVersion
+	rangeTombstones []sstable.RangeTombstone
+	sizeBytes       int64
+
 	MaxKeySize   int
 	MaxValueSize int
 }
 
-func (m *ThreadSafeMapMemTable) Exists(key []byte) (ok bool, err error) {
-	panic("implement me")
+// visible is the newest version at or before seq.
+func (m *ThreadSafeMapMemTable) visible(key []byte, seq uint64) (version memTableVersion, ok bool) {
+	for _, version = range m.versions[maps.Key(key)] {
+		if version.seq <= seq {
+			return version, true
+		}
+	}
+	return version, false
+}
+
+// insert keeps versions ordered, writes may be applied out of sequence order.
+func (m *ThreadSafeMapMemTable) insert(key []byte, version memTableVersion) {
+	if m.versions == nil {
+		m.versions = make(map[maps.Key][]memTableVersion)
+	}
+
+	versions := m.versions[maps.Key(key)]
+	i := sort.Search(len(versions), func(i int) bool {
+		return versions[i].seq <= version.seq
+	})
+
+	if i < len(versions) && versions[i].seq == version.seq {
+		m.sizeBytes += int64(len(version.value) - len(versions[i].value))
+		versions[i] = version
+		return
+	}
+
+	m.sizeBytes += int64(len(key) + len(version.value))
+
+	versions = append(versions, memTableVersion{})
+	copy(versions[i+1:], versions[i:])
+	versions[i] = version
+
+	m.versions[maps.Key(key)] = versions
+}
+
+func (m *ThreadSafeMapMemTable) Exists(key []byte, seq uint64, now int64) (ok bool, err error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	version, ok := m.visible(key, seq)
+	return ok && !version.deleted(now) && !m.rangeDeleted(key, version.seq, seq), nil
+}
+
+// rangeDeleted is true if a range tombstone deletes the version of key at versionSeq,
+// callers hold the lock.
+func (m *ThreadSafeMapMemTable) rangeDeleted(key []byte, versionSeq uint64, seq uint64) bool {
+	return sstable.RangeDeleted(m.rangeTombstones, key, versionSeq, seq)
+}
+
+func (m *ThreadSafeMapMemTable) Put(key []byte, value []byte, seq uint64) error {
+	return m.PutWithExpiry(key, value, seq, 0)
+}
+
+func (m *ThreadSafeMapMemTable) PutWithExpiry(key []byte, value []byte, seq uint64, expiresAt int64) error {
+	m.Lock()
+	defer m.Unlock()
+
+	m.put(key, value, seq, expiresAt)
+	return nil
+}
+
+func (m *ThreadSafeMapMemTable) put(key []byte, value []byte, seq uint64, expiresAt int64) {
+	if value == nil {
+		value = []byte{}
+	}
+
+	m.insert(key, memTableVersion{seq: seq, value: value, expiresAt: expiresAt})
+}
+
+func (m *ThreadSafeMapMemTable) Get(key []byte, seq uint64, now int64) (value []byte, deleted bool, ok bool, err error) {
+	record, ok, err := m.GetVersion(key, seq, now)
+	return record.Value, record.Deleted, ok, err
+}
+
+func (m *ThreadSafeMapMemTable) GetVersion(key []byte, seq uint64, now int64) (record sstable.Record, ok bool, err error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	version, ok := m.visible(key, seq)
+	if !ok {
+		if m.rangeDeleted(key, 0, seq) {
+			return sstable.Record{Key: key, Deleted: true}, true, nil
+		}
+		return sstable.Record{}, false, nil
+	}
+
+	if version.deleted(now) || m.rangeDeleted(key, version.seq, seq) {
+		return sstable.Record{Key: key, Seq: version.seq, Deleted: true}, true, nil
+	}
+
+	return sstable.Record{
+		Key:          key,
+		Value:        types.ValueType(version.value),
+		Seq:          version.seq,
+		MergeOperand: version.mergeOperand,
+		ExpiresAt:    version.expiresAt,
+	}, true, nil
+}
+
+func (m *ThreadSafeMapMemTable) Merge(key []byte, operand []byte, seq uint64) error {
+	m.Lock()
+	defer m.Unlock()
+
+	m.merge(key, operand, seq)
+	return nil
+}
+
+func (m *ThreadSafeMapMemTable) merge(key []byte, operand []byte, seq uint64) {
+	if operand == nil {
+		operand = []byte{}
+	}
+
+	m.insert(key, memTableVersion{seq: seq, value: operand, mergeOperand: true})
+}
+
+func (m *ThreadSafeMapMemTable) Remove(key []byte, seq uint64) (ok bool, err error) {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.remove(key, seq), nil
 }
 
-func (m *ThreadSafeMapMemTable) Put(key []byte, value []byte) error {
+// remove writes the tombstone even if the key is not in the table,
+// it hides versions of the key in older tables.
+func (m *ThreadSafeMapMemTable) remove(key []byte, seq uint64) (ok bool) {
+	versions := m.versions[maps.Key(key)]
+	ok = len(versions) > 0 && versions[0].value != nil
+
+	m.insert(key, memTableVersion{seq: seq})
+	return ok
+}
+
+func (m *ThreadSafeMapMemTable) DeleteRange(start []byte, end []byte, seq uint64) error {
+	m.Lock()
+	defer m.Unlock()
+
+	m.rangeTombstones = append(m.rangeTombstones, sstable.RangeTombstone{Start: copyKey(start), End: copyKey(end), Seq: seq})
+	m.sizeBytes += int64(len(start) + len(end))
 	return nil
 }
 
-func (m *ThreadSafeMapMemTable) Get(key []byte) (value [] byte, ok bool, err error) {
-	return
+func (m *ThreadSafeMapMemTable) RangeTombstones() []sstable.RangeTombstone {
+	m.RLock()
+	defer m.RUnlock()
+
+	return append([]sstable.RangeTombstone(nil), m.rangeTombstones...)
 }
 
-func (m *ThreadSafeMapMemTable) Remove(key []byte) (ok bool, err error) {
-	return
+func (m *ThreadSafeMapMemTable) ApplyBatch(entries []BatchEntry, seq uint64) error {
+	m.Lock()
+	defer m.Unlock()
+
+	for _, entry := range entries {
+		switch {
+		case entry.Deleted:
+			m.remove(entry.Key, seq)
+		case entry.MergeOperand:
+			m.merge(entry.Key, entry.Value, seq)
+		default:
+			m.put(entry.Key, entry.Value, seq, 0)
+		}
+	}
+
+	return nil
+}
+
+// PrepareBatch writes nothing until commit, which applies the batch.
+func (m *ThreadSafeMapMemTable) PrepareBatch(entries []BatchEntry, seq uint64) (commit func(), err error) {
+	return func() {
+		_ = m.ApplyBatch(entries, seq)
+	}, nil
 }
 
 func (m *ThreadSafeMapMemTable) KeyCountEstimate() uint {
-	return uint(m.Len())
+	m.RLock()
+	defer m.RUnlock()
+
+	return uint(len(m.versions))
+}
+
+func (m *ThreadSafeMapMemTable) SizeBytes() int64 {
+	m.RLock()
+	defer m.RUnlock()
+
+	return m.sizeBytes
 }
 
+// Iterator returns a sorted snapshot of all versions in the table.
 func (m *ThreadSafeMapMemTable) Iterator() SortedKVIterator {
-	return nil
+	m.RLock()
+	defer m.RUnlock()
+
+	keys := make([]string, 0, len(m.versions))
+	for key := range m.versions {
+		keys = append(keys, string(key))
+	}
+	sort.Strings(keys)
+
+	iterator := &sortedSnapshotIterator{}
+
+	for _, key := range keys {
+		for _, version := range m.versions[maps.Key(key)] {
+			iterator.records = append(iterator.records, sstable.Record{
+				Key:          types.KeyType(key),
+				Value:        types.ValueType(version.value),
+				Seq:          version.seq,
+				Deleted:      version.value == nil,
+				MergeOperand: version.mergeOperand,
+				ExpiresAt:    version.expiresAt,
+			})
+		}
+	}
+
+	return iterator
 }
 
 func NewMapMemTable(maxKeySize int, maxValueSize int) MemTable {
 	return &ThreadSafeMapMemTable{
+		versions:     make(map[maps.Key][]memTableVersion),
 		MaxKeySize:   maxKeySize,
 		MaxValueSize: maxValueSize,
 	}
@@ -1,6 +1,11 @@
 package types
 
+import "math"
+
 type KeyType []byte
 type ValueType []byte
 
+// Writes are tagged with increasing sequence numbers, a read at sequence
+// number n sees the newest version of each key written at or before n.
+const MaxSequenceNumber uint64 = math.MaxUint64
 
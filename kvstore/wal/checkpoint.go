@@ -0,0 +1,28 @@
+package wal
+
+import (
+	"github.com/zl14917/MastersProject/kvstore/fileutil"
+	"path"
+	"path/filepath"
+)
+
+// Checkpoint copies the segments holding records at or after fromIndex into dirPath,
+// where LoadSegments picks up the log from the oldest copied segment.
+// Appends must not run until it returns, the current segment is copied as written.
+func (wal *WAL) Checkpoint(dirPath string, fromIndex uint64) error {
+	wal.segmentsLock.Lock()
+	defer wal.segmentsLock.Unlock()
+
+	for i, seg := range wal.Segments {
+		if i+1 < len(wal.Segments) && wal.Segments[i+1].StartRecordIndex <= fromIndex {
+			continue
+		}
+
+		err := fileutil.CopyFile(seg.FilePath, path.Join(dirPath, filepath.Base(seg.FilePath)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return fileutil.SyncDir(dirPath)
+}
@@ -0,0 +1,181 @@
+package wal
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var WALClosedErr = errors.New("wal is closed")
+
+type GroupCommitOptions struct {
+	// a batch is written as soon as it holds this many bytes of records
+	MaxBatchBytes int
+	// longest time the first record of a batch waits for others to join it,
+	// zero only batches records that are already queued.
+	MaxBatchDelay time.Duration
+}
+
+var DefaultGroupCommitOptions = GroupCommitOptions{
+	MaxBatchBytes: 1024 * 1024,
+	MaxBatchDelay: 2 * time.Millisecond,
+}
+
+type groupCommitOptions struct {
+	options GroupCommitOptions
+}
+
+func (o *groupCommitOptions) Apply(wal *WAL) {
+	wal.groupCommit = newGroupCommitter(wal, o.options)
+}
+
+// With group commit, concurrent appends are written together
+// and made durable by a single fsync.
+func WithGroupCommit(options GroupCommitOptions) WALOptions {
+	return &groupCommitOptions{options: options}
+}
+
+type commitRequest struct {
+	record *WALRecord
+	size   int
+	done   chan error
+}
+
+type groupCommitter struct {
+	sync.RWMutex
+	wal     *WAL
+	options GroupCommitOptions
+
+	requests chan *commitRequest
+	stopped  chan struct{}
+	closed   bool
+
+	records []*WALRecord
+}
+
+func newGroupCommitter(wal *WAL, options GroupCommitOptions) *groupCommitter {
+	if options.MaxBatchBytes <= 0 {
+		options.MaxBatchBytes = DefaultGroupCommitOptions.MaxBatchBytes
+	}
+
+	c := &groupCommitter{
+		wal:      wal,
+		options:  options,
+		requests: make(chan *commitRequest, 256),
+		stopped:  make(chan struct{}),
+	}
+
+	go c.run()
+	return c
+}
+
+// submit queues a record, the returned channel receives
+// nil once the record is durable or the error that prevented it.
+func (c *groupCommitter) submit(record *WALRecord) <-chan error {
+	done := make(chan error, 1)
+
+	c.RLock()
+	defer c.RUnlock()
+
+	if c.closed {
+		done <- WALClosedErr
+		return done
+	}
+
+	c.requests <- &commitRequest{
+		record: record,
+		size:   marshalledRecordHeaderSize + len(record.EventData),
+		done:   done,
+	}
+
+	return done
+}
+
+func (c *groupCommitter) run() {
+	defer close(c.stopped)
+
+	for {
+		first, ok := <-c.requests
+		if !ok {
+			return
+		}
+
+		batch := c.collect(first)
+		err := c.commit(batch)
+
+		for _, req := range batch {
+			req.done <- err
+		}
+	}
+}
+
+// collect gathers requests into a batch until it is large enough,
+// the delay of the first request ran out, or no more are queued.
+func (c *groupCommitter) collect(first *commitRequest) []*commitRequest {
+	batch := []*commitRequest{first}
+	size := first.size
+
+	var timeout <-chan time.Time
+
+	if c.options.MaxBatchDelay > 0 {
+		timer := time.NewTimer(c.options.MaxBatchDelay)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for size < c.options.MaxBatchBytes {
+		if timeout == nil {
+			select {
+			case req, ok := <-c.requests:
+				if !ok {
+					return batch
+				}
+				batch = append(batch, req)
+				size += req.size
+			default:
+				return batch
+			}
+			continue
+		}
+
+		select {
+		case req, ok := <-c.requests:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, req)
+			size += req.size
+		case <-timeout:
+			return batch
+		}
+	}
+
+	return batch
+}
+
+func (c *groupCommitter) commit(batch []*commitRequest) error {
+	records := c.records[:0]
+
+	for _, req := range batch {
+		records = append(records, req.record)
+	}
+
+	c.records = records
+	return c.wal.writeRecords(records, true)
+}
+
+// close rejects new records and waits for queued ones to be committed.
+func (c *groupCommitter) close() {
+	c.Lock()
+
+	if c.closed {
+		c.Unlock()
+		return
+	}
+
+	c.closed = true
+	close(c.requests)
+	c.Unlock()
+
+	<-c.stopped
+}
@@ -0,0 +1,119 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWAL_GroupCommit(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		const writers = 50
+
+		wal := NewWAL(dirPath, WithGroupCommit(GroupCommitOptions{
+			MaxBatchBytes: 4096,
+			MaxBatchDelay: time.Millisecond,
+		}))
+		wal.Index = 1
+
+		var wg sync.WaitGroup
+		errs := make(chan error, writers)
+
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				record := &WALRecord{}
+				record.SetPayload(PutKey, []byte(fmt.Sprintf("key_%d", i)), []byte("value"))
+				errs <- wal.Append(record)
+			}(i)
+		}
+
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			if err != nil {
+				t.Error("error committing record", err)
+			}
+		}
+
+		if wal.Index != writers+1 {
+			t.Errorf("expect next index %d, got %d", writers+1, wal.Index)
+		}
+
+		err := wal.Close()
+		if err != nil {
+			t.Error(err)
+		}
+
+		record := &WALRecord{}
+		record.SetPayload(PutKey, []byte("closed"), nil)
+		err = wal.Append(record)
+
+		if err != WALClosedErr {
+			t.Error("appending to closed wal should fail, got", err)
+		}
+
+		wal = NewWAL(dirPath)
+		_ = wal.LoadSegments()
+		defer wal.Close()
+
+		reader := wal.NewReader()
+		defer reader.Close()
+
+		seen := make(map[string]bool)
+		for reader.ReadNext(record) == nil {
+			key, _, _ := record.Payload()
+			seen[string(key)] = true
+		}
+
+		if len(seen) != writers {
+			t.Errorf("expect %d durable records, got %d", writers, len(seen))
+		}
+	})
+}
+
+// a record whose sync failed used up its index, later appends must fail
+// instead of taking indexes after the gap
+func TestWAL_SyncFailure(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		wal := NewWAL(dirPath, WithGroupCommit(DefaultGroupCommitOptions))
+		wal.Index = 1
+		defer wal.Close()
+
+		appendRecords(t, wal, 0, 1)
+
+		syncErr := errors.New("injected sync failure")
+		syncFile = func(*os.File) error {
+			return syncErr
+		}
+		defer func() {
+			syncFile = (*os.File).Sync
+		}()
+
+		record := &WALRecord{}
+		record.SetPayload(PutKey, []byte("unsynced"), []byte("value"))
+		err := wal.Append(record)
+
+		if err != syncErr {
+			t.Error("expect sync failure, got", err)
+		}
+
+		syncFile = (*os.File).Sync
+
+		record.SetPayload(PutKey, []byte("after"), []byte("value"))
+		err = wal.Append(record)
+
+		if err != WALSyncFailedErr {
+			t.Error("expect appends after a failed sync to fail, got", err)
+		}
+
+		if wal.Index != 3 {
+			t.Errorf("expect next index 3, got %d", wal.Index)
+		}
+	})
+}
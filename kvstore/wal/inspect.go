@@ -0,0 +1,156 @@
+package wal
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// InspectedRecord is the header of a record as found in a segment,
+// with the checksum computed over the record read.
+type InspectedRecord struct {
+	WALRecordHeader
+	// file offset of the record, counted in uncompressed bytes for compressed segments
+	Offset      int64
+	ComputedCRC uint32
+}
+
+func (r *InspectedRecord) Valid() bool {
+	return r.CRC == r.ComputedCRC
+}
+
+// SegmentInfo describes a segment file as it is on disk.
+type SegmentInfo struct {
+	FilePath string
+	WALSegHeader
+
+	Records int
+	// index after the last complete record
+	NextIndex uint64
+
+	// torn or corrupted records, gaps in record indexes and breaks in the chain of segments
+	Problems []string
+}
+
+func (info *SegmentInfo) problemf(format string, args ...interface{}) {
+	info.Problems = append(info.Problems, fmt.Sprintf(format, args...))
+}
+
+// InspectSegment reads every record of a segment file without modifying it,
+// onRecord is called with each record header. Records failing their checksum
+// are reported and skipped, reading stops at the first incomplete record.
+func InspectSegment(filePath string, onRecord func(record InspectedRecord)) (info SegmentInfo, err error) {
+	info.FilePath = filePath
+
+	reader, err := newWALSegRecordReader(filePath)
+	if err != nil {
+		return info, err
+	}
+	defer reader.Close()
+
+	info.WALSegHeader = reader.fileHeader
+	info.NextIndex = info.StartRecordIndex
+
+	if info.Flags&WalSegCompressedFlag != 0 && info.Flags&WALSegArchivedFlag == 0 {
+		info.problemf("compressed segment is not archived, flags 0x%x", info.Flags)
+	}
+
+	record := &WALRecord{}
+
+	for {
+		offset := reader.offset
+		err = reader.readRecord(record)
+
+		if err == io.EOF {
+			return info, nil
+		}
+
+		if err == TornRecordErr {
+			info.problemf("incomplete record at offset %d", offset)
+			return info, nil
+		}
+
+		if err != nil && err != CorruptedRecordErr {
+			return info, err
+		}
+
+		inspected := InspectedRecord{
+			WALRecordHeader: record.WALRecordHeader,
+			Offset:          offset,
+			ComputedCRC:     ComputeWALRecordCRC(&record.WALRecordHeader, record.EventData),
+		}
+
+		if !inspected.Valid() {
+			info.problemf("record %d at offset %d: stored crc 0x%08x, computed 0x%08x",
+				record.Index, offset, inspected.CRC, inspected.ComputedCRC)
+		} else {
+			if record.Index != info.NextIndex {
+				info.problemf("record %d at offset %d, expected record %d", record.Index, offset, info.NextIndex)
+			}
+
+			if record.EventType > ColumnFamilyBatch {
+				info.problemf("record %d at offset %d has unknown event type %d", record.Index, offset, record.EventType)
+			}
+
+			info.NextIndex = record.Index + 1
+		}
+
+		if onRecord != nil {
+			onRecord(inspected)
+		}
+
+		info.Records++
+		reader.offset += int64(marshalledRecordHeaderSize) + int64(record.DataLen)
+	}
+}
+
+// InspectSegments inspects every segment in dirPath, ordered by their first record,
+// and checks that each segment continues the one before it.
+func InspectSegments(dirPath string, onRecord func(filePath string, record InspectedRecord)) ([]SegmentInfo, error) {
+	matches, err := filepath.Glob(path.Join(dirPath, segmentFilePrefix+"*"))
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make([]SegmentInfo, 0, len(matches))
+
+	for _, filePath := range matches {
+		filePath := filePath
+
+		info, err := InspectSegment(filePath, func(record InspectedRecord) {
+			if onRecord != nil {
+				onRecord(filePath, record)
+			}
+		})
+
+		if err != nil {
+			return segments, fmt.Errorf("error inspecting wal segment %s: %v", filePath, err)
+		}
+
+		segments = append(segments, info)
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].StartRecordIndex < segments[j].StartRecordIndex
+	})
+
+	for i := 1; i < len(segments); i++ {
+		prev, seg := &segments[i-1], &segments[i]
+
+		if seg.PrevSegId != prev.SegId {
+			seg.problemf("follows segment %d, expected previous segment %d", seg.PrevSegId, prev.SegId)
+		}
+
+		if seg.StartRecordIndex != prev.NextIndex {
+			seg.problemf("starts at record %d, previous segment ends before record %d", seg.StartRecordIndex, prev.NextIndex)
+		}
+
+		if prev.Flags&WALSegArchivedFlag == 0 {
+			prev.problemf("segment %d is followed by segment %d but not archived", prev.SegId, seg.SegId)
+		}
+	}
+
+	return segments, nil
+}
@@ -0,0 +1,68 @@
+package wal
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestInspectSegments(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		wal := NewWAL(dirPath, WithSegmentSize(256))
+		wal.Index = 1
+		appendRecords(t, wal, 0, 40)
+		_ = wal.Close()
+
+		records := 0
+		segments, err := InspectSegments(dirPath, func(filePath string, record InspectedRecord) {
+			if !record.Valid() {
+				t.Errorf("record %d in %s should pass its checksum", record.Index, filePath)
+			}
+			records++
+		})
+
+		if err != nil {
+			t.Fatal("error inspecting segments", err)
+		}
+
+		if records != 40 || len(segments) < 2 {
+			t.Errorf("expect 40 records over several segments, got %d records in %d segments", records, len(segments))
+		}
+
+		for _, seg := range segments {
+			if len(seg.Problems) > 0 {
+				t.Errorf("segment %s should have no problems, got %v", seg.FilePath, seg.Problems)
+			}
+		}
+
+		// a flipped byte in the first record of the last segment and a torn record after the last one
+		last := segments[len(segments)-1]
+		file, err := os.OpenFile(last.FilePath, os.O_RDWR, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stat, _ := file.Stat()
+		_, _ = file.WriteAt([]byte{0xff}, int64(unsafe.Sizeof(WALSegHeader{}))+marshalledRecordHeaderSize)
+		_, _ = file.WriteAt([]byte{0, 0, 0}, stat.Size())
+		_ = file.Close()
+
+		info, err := InspectSegment(last.FilePath, nil)
+		if err != nil {
+			t.Fatal("error inspecting damaged segment", err)
+		}
+
+		problems := strings.Join(info.Problems, "\n")
+
+		for _, expect := range []string{"stored crc", "incomplete record"} {
+			if !strings.Contains(problems, expect) {
+				t.Errorf("expect a problem with %q, got:\n%s", expect, problems)
+			}
+		}
+
+		if info.Records != last.Records {
+			t.Errorf("records after a corrupted one should still be read, expect %d, got %d", last.Records, info.Records)
+		}
+	})
+}
@@ -2,6 +2,9 @@ package wal
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/zl14917/MastersProject/kvstore/crc"
 	"io"
 )
 
@@ -10,8 +13,33 @@ type WALEventType uint32
 const (
 	PutKey WALEventType = iota
 	DeleteKey
+	// puts and deletes committed together, see SetBatchPayload
+	WriteBatch
+	// a put with the time of the write and an expiry time, see SetExpiringPayload
+	PutKeyWithExpiry
+	// deletes keys in [key, value) of a SetPayload payload
+	DeleteRange
+	// a merge operand of a key, folded into its older versions when read
+	MergeKey
+	// a WriteBatch writing to column families other than the default one
+	ColumnFamilyBatch
 )
 
+// size of WALRecordHeader once marshalled, without struct padding
+const marshalledRecordHeaderSize = 8 + 4 + 4 + 4
+
+const batchEntryHeaderSize = 4 + 4 + 4
+
+const columnFamilyBatchEntryHeaderSize = 4 + batchEntryHeaderSize
+
+const expiringPayloadHeaderSize = 8 + 8 + 4
+
+// largest allocation made for a payload before its bytes are read
+const payloadReadStep = 1024 * 1024
+
+var TornRecordErr = errors.New("wal record is incomplete, log tail is torn")
+var CorruptedRecordErr = errors.New("wal record does not match its checksum")
+
 // Redo logging
 type WALEvent struct {
 	EventData []byte
@@ -64,72 +92,305 @@ func (header *WALRecordHeader) Marshall(writer io.Writer) error {
 	return nil
 }
 
+// UnMarshall reads the whole header at once, io.EOF is returned if no byte of it
+// could be read and io.ErrUnexpectedEOF if only part of it was written.
 func (h *WALRecordHeader) UnMarshall(r io.Reader) error {
-	var (
-		err         error
-		buffer8Byte [8]byte
-		uint32buf   = buffer8Byte[0:4]
-		uint64buf   = buffer8Byte[0:8]
-	)
+	var buffer [marshalledRecordHeaderSize]byte
 
-	_, err = r.Read(uint64buf)
+	_, err := io.ReadFull(r, buffer[:])
 	if err != nil {
 		return err
 	}
 
-	h.Index = binary.BigEndian.Uint64(uint64buf)
+	h.Index = binary.BigEndian.Uint64(buffer[0:8])
+	h.CRC = binary.BigEndian.Uint32(buffer[8:12])
+	h.DataLen = binary.BigEndian.Uint32(buffer[12:16])
+	h.EventType = WALEventType(binary.BigEndian.Uint32(buffer[16:20]))
+	return nil
+}
+
+func (r *WALRecord) Marshall(writer io.Writer) error {
+	var err error
 
-	_, err = r.Read(uint32buf)
+	err = r.WALRecordHeader.Marshall(writer)
 	if err != nil {
 		return err
 	}
 
-	h.CRC = binary.BigEndian.Uint32(uint32buf)
-
-	_, err = r.Read(uint32buf)
+	_, err = writer.Write(r.EventData)
 	if err != nil {
 		return err
 	}
-	h.DataLen = binary.BigEndian.Uint32(uint32buf)
 
-	_, err = r.Read(uint32buf)
+	return nil
+}
+
+// UnMarshall reads one record written by Marshall.
+// io.EOF is returned only when no byte of the record could be read,
+// a partially written record is reported as TornRecordErr.
+func (r *WALRecord) UnMarshall(reader io.Reader) error {
+	err := r.unMarshallHeader(reader)
 	if err != nil {
 		return err
 	}
+	return r.unMarshallData(reader)
+}
 
-	h.EventType = WALEventType(binary.BigEndian.Uint32(uint32buf))
-	return nil
+func (r *WALRecord) unMarshallHeader(reader io.Reader) error {
+	err := r.WALRecordHeader.UnMarshall(reader)
+
+	if err == io.ErrUnexpectedEOF {
+		return TornRecordErr
+	}
+	return err
 }
 
-func (r *WALRecord) Marshall(writer io.Writer) error {
-	var err error
+// unMarshallData reads the DataLen bytes of payload following the header. The length
+// is not verified yet, a payload larger than the buffer is read in steps so a corrupt
+// length takes no more memory than the bytes that follow it.
+func (r *WALRecord) unMarshallData(reader io.Reader) error {
+	var (
+		err     error
+		dataLen = int(r.DataLen)
+		data    = r.EventData[:0]
+	)
 
-	err = r.WALRecordHeader.Marshall(writer)
-	if err != nil {
-		return err
+	for len(data) < dataLen && err == nil {
+		step := dataLen - len(data)
+		if step > payloadReadStep && cap(data) < dataLen {
+			step = payloadReadStep
+		}
+
+		if cap(data) < len(data)+step {
+			size := 2 * cap(data)
+			if size < len(data)+step {
+				size = len(data) + step
+			}
+			if size > dataLen {
+				size = dataLen
+			}
+
+			grown := make([]byte, len(data), size)
+			copy(grown, data)
+			data = grown
+		}
+
+		data = data[:len(data)+step]
+		_, err = io.ReadFull(reader, data[len(data)-step:])
+	}
+
+	r.EventData = data
+
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return TornRecordErr
 	}
 
-	_, err = writer.Write(r.EventData)
 	if err != nil {
 		return err
 	}
 
+	if !r.VerifyCRC() {
+		return CorruptedRecordErr
+	}
+
 	return nil
 }
 
-func ComputeWALRecordCRC(data []byte) uint32 {
-	return 0
+// Payload of PutKey, DeleteKey, DeleteRange and MergeKey events is encoded as
+// | key length, 4 bytes | key | value |
+func (r *WALRecord) SetPayload(eventType WALEventType, key []byte, value []byte) {
+	data := make([]byte, 4+len(key)+len(value))
+	binary.BigEndian.PutUint32(data[0:4], uint32(len(key)))
+	copy(data[4:], key)
+	copy(data[4+len(key):], value)
+
+	r.EventType = eventType
+	r.EventData = data
+	r.DataLen = uint32(len(data))
+}
+
+func (r *WALRecord) Payload() (key []byte, value []byte, err error) {
+	if len(r.EventData) < 4 {
+		return nil, nil, fmt.Errorf("record %d payload too short: %d bytes", r.Index, len(r.EventData))
+	}
+
+	keyLen := int(binary.BigEndian.Uint32(r.EventData[0:4]))
+
+	if 4+keyLen > len(r.EventData) {
+		return nil, nil, fmt.Errorf("record %d key length %d exceeds payload", r.Index, keyLen)
+	}
+
+	key = r.EventData[4 : 4+keyLen]
+	value = r.EventData[4+keyLen:]
+	return key, value, nil
+}
+
+// Payload of PutKeyWithExpiry events is encoded as
+// | timestamp, 8 bytes | expires at, 8 bytes | key length, 4 bytes | key | value |
+// both times are unix nanoseconds, an expiry of 0 never expires.
+func (r *WALRecord) SetExpiringPayload(key []byte, value []byte, timestamp int64, expiresAt int64) {
+	data := make([]byte, expiringPayloadHeaderSize+len(key)+len(value))
+	binary.BigEndian.PutUint64(data[0:8], uint64(timestamp))
+	binary.BigEndian.PutUint64(data[8:16], uint64(expiresAt))
+	binary.BigEndian.PutUint32(data[16:20], uint32(len(key)))
+	copy(data[expiringPayloadHeaderSize:], key)
+	copy(data[expiringPayloadHeaderSize+len(key):], value)
+
+	r.EventType = PutKeyWithExpiry
+	r.EventData = data
+	r.DataLen = uint32(len(data))
+}
+
+func (r *WALRecord) ExpiringPayload() (key []byte, value []byte, timestamp int64, expiresAt int64, err error) {
+	if len(r.EventData) < expiringPayloadHeaderSize {
+		return nil, nil, 0, 0, fmt.Errorf("record %d payload too short: %d bytes", r.Index, len(r.EventData))
+	}
+
+	timestamp = int64(binary.BigEndian.Uint64(r.EventData[0:8]))
+	expiresAt = int64(binary.BigEndian.Uint64(r.EventData[8:16]))
+	keyLen := int(binary.BigEndian.Uint32(r.EventData[16:20]))
+
+	data := r.EventData[expiringPayloadHeaderSize:]
+	if keyLen > len(data) {
+		return nil, nil, 0, 0, fmt.Errorf("record %d key length %d exceeds payload", r.Index, keyLen)
+	}
+
+	return data[:keyLen], data[keyLen:], timestamp, expiresAt, nil
+}
+
+// BatchEntry is a put, delete or merge of a WriteBatch record.
+type BatchEntry struct {
+	// 0 is the default column family
+	ColumnFamily uint32
+	EventType    WALEventType
+	Key          []byte
+	Value        []byte
+}
+
+// Payload of WriteBatch events is the entry count followed by the entries,
+// | entry count, 4 bytes | entries |
+// each entry is encoded as
+// | event type, 4 bytes | key length, 4 bytes | value length, 4 bytes | key | value |
+// Batches with entries of other column families are ColumnFamilyBatch events,
+// each of their entries starts with | column family, 4 bytes |.
+func (r *WALRecord) SetBatchPayload(entries []BatchEntry) {
+	r.EventType = WriteBatch
+	headerSize := batchEntryHeaderSize
+
+	for _, entry := range entries {
+		if entry.ColumnFamily != 0 {
+			r.EventType = ColumnFamilyBatch
+			headerSize = columnFamilyBatchEntryHeaderSize
+			break
+		}
+	}
+
+	size := 4
+	for _, entry := range entries {
+		size += headerSize + len(entry.Key) + len(entry.Value)
+	}
+
+	data := make([]byte, size)
+	binary.BigEndian.PutUint32(data[0:4], uint32(len(entries)))
+
+	offset := 4
+	for _, entry := range entries {
+		if r.EventType == ColumnFamilyBatch {
+			binary.BigEndian.PutUint32(data[offset:offset+4], entry.ColumnFamily)
+			offset += 4
+		}
+
+		binary.BigEndian.PutUint32(data[offset:offset+4], uint32(entry.EventType))
+		binary.BigEndian.PutUint32(data[offset+4:offset+8], uint32(len(entry.Key)))
+		binary.BigEndian.PutUint32(data[offset+8:offset+12], uint32(len(entry.Value)))
+		offset += batchEntryHeaderSize
+
+		offset += copy(data[offset:], entry.Key)
+		offset += copy(data[offset:], entry.Value)
+	}
+
+	r.EventData = data
+	r.DataLen = uint32(len(data))
+}
+
+// BatchPayload returns the entries of a WriteBatch or ColumnFamilyBatch record,
+// keys and values refer to the record data.
+func (r *WALRecord) BatchPayload() ([]BatchEntry, error) {
+	if len(r.EventData) < 4 {
+		return nil, fmt.Errorf("record %d batch payload too short: %d bytes", r.Index, len(r.EventData))
+	}
+
+	headerSize := batchEntryHeaderSize
+	if r.EventType == ColumnFamilyBatch {
+		headerSize = columnFamilyBatchEntryHeaderSize
+	}
+
+	count := int(binary.BigEndian.Uint32(r.EventData[0:4]))
+	data := r.EventData[4:]
+
+	// every entry takes at least its header
+	if count > len(data)/headerSize {
+		return nil, fmt.Errorf("record %d batch of %d entries exceeds payload", r.Index, count)
+	}
+
+	entries := make([]BatchEntry, 0, count)
+
+	for i := 0; i < count; i++ {
+		if len(data) < headerSize {
+			return nil, fmt.Errorf("record %d batch entry %d header exceeds payload", r.Index, i)
+		}
+
+		var columnFamily uint32
+		if r.EventType == ColumnFamilyBatch {
+			columnFamily = binary.BigEndian.Uint32(data[0:4])
+			data = data[4:]
+		}
+
+		eventType := WALEventType(binary.BigEndian.Uint32(data[0:4]))
+		keyLen := int(binary.BigEndian.Uint32(data[4:8]))
+		valueLen := int(binary.BigEndian.Uint32(data[8:12]))
+		data = data[batchEntryHeaderSize:]
+
+		if keyLen > len(data) || valueLen > len(data)-keyLen {
+			return nil, fmt.Errorf("record %d batch entry %d exceeds payload", r.Index, i)
+		}
+
+		entries = append(entries, BatchEntry{
+			ColumnFamily: columnFamily,
+			EventType:    eventType,
+			Key:          data[:keyLen],
+			Value:        data[keyLen : keyLen+valueLen],
+		})
+		data = data[keyLen+valueLen:]
+	}
+
+	if len(data) != 0 {
+		return nil, fmt.Errorf("record %d batch has %d trailing bytes", r.Index, len(data))
+	}
+
+	return entries, nil
+}
+
+// ComputeWALRecordCRC covers the record index, event type and payload,
+// the CRC field itself is excluded.
+func ComputeWALRecordCRC(header *WALRecordHeader, data []byte) uint32 {
+	var buffer [16]byte
+
+	binary.BigEndian.PutUint64(buffer[0:8], header.Index)
+	binary.BigEndian.PutUint32(buffer[8:12], header.DataLen)
+	binary.BigEndian.PutUint32(buffer[12:16], uint32(header.EventType))
+
+	c := crc.NewCRC()
+	c.Update(buffer[:])
+	c.Update(data)
+	return c.GetValue()
 }
 
 func (w *WALRecord) VerifyCRC() bool {
-	crc := ComputeWALRecordCRC(w.EventData)
+	crc := ComputeWALRecordCRC(&w.WALRecordHeader, w.EventData)
 	return w.CRC == crc
 }
 
 func (w *WALRecord) ComputeCRC() {
-	if len(w.EventData) == 0 {
-		w.CRC = 0
-		return
-	}
-	w.CRC = ComputeWALRecordCRC(w.EventData)
+	w.CRC = ComputeWALRecordCRC(&w.WALRecordHeader, w.EventData)
 }
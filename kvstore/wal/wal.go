@@ -1,15 +1,26 @@
 package wal
 
 import (
+	"errors"
+	"fmt"
 	"gopkg.in/yaml.v2"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
+	"sync"
 )
 
 const WALLockFileName = "wal_lock_file"
 
+var WALSyncFailedErr = errors.New("wal stopped after a failed sync")
+
+const (
+	segmentFilePrefix = "segment_"
+	segmentFileFormat = segmentFilePrefix + "%08d"
+)
+
 type WALCloser interface {
 	Close() error
 }
@@ -31,14 +42,27 @@ type WALWriter interface {
 	Sync() error
 }
 
+const DefaultSegmentSize int64 = 16 * 1024 * 1024
+
 type WAL struct {
-	DirPath  string
-	Segments []WALSeg
-	Current  *WALSeg
-	AutoSync bool
+	DirPath     string
+	Segments    []*WALSeg
+	Current     *WALSeg
+	AutoSync    bool
+	SegmentSize int64
+	// rolled over segments are compressed when archived
+	CompressArchived bool
 
 	CommitIndex uint64
 	Index       uint64
+
+	nextSegId   uint32
+	groupCommit *groupCommitter
+	// records written before a failed sync may be lost and their indexes
+	// are used up, no record is accepted after it
+	syncFailed bool
+	// guards Segments, segments are added by the writer and removed once flushed
+	segmentsLock sync.Mutex
 }
 
 type walLockFileContent struct {
@@ -61,6 +85,31 @@ func (*cleanUpOptions) Apply(wal *WAL) {
 
 }
 
+type segmentSizeOptions struct {
+	size int64
+}
+
+func (o *segmentSizeOptions) Apply(wal *WAL) {
+	if o.size > 0 {
+		wal.SegmentSize = o.size
+	}
+}
+
+// Segments are rolled over once they grow beyond size bytes.
+func WithSegmentSize(size int64) WALOptions {
+	return &segmentSizeOptions{size: size}
+}
+
+type compressArchivedOptions struct{}
+
+func (*compressArchivedOptions) Apply(wal *WAL) {
+	wal.CompressArchived = true
+}
+
+func WithCompressedArchive() WALOptions {
+	return &compressArchivedOptions{}
+}
+
 func WithAutoSync() WALOptions {
 	return &autoSyncOptions{}
 }
@@ -74,9 +123,10 @@ func NewWAL(dirPath string, options ...WALOptions) *WAL {
 		DirPath:     dirPath,
 		CommitIndex: 0,
 
-		AutoSync: false,
-		Segments: make([]WALSeg, 0, 8),
-		Current:  nil,
+		AutoSync:    false,
+		SegmentSize: DefaultSegmentSize,
+		Segments:    make([]*WALSeg, 0, 8),
+		Current:     nil,
 	}
 
 	for _, opt := range options {
@@ -158,10 +208,11 @@ func RestoreFrom(dirPath string, options ...WALOptions) *WAL {
 	wal := &WAL{
 		CommitIndex: 0,
 
-		DirPath:  dirPath,
-		AutoSync: false,
-		Segments: make([]WALSeg, 16),
-		Current:  nil,
+		DirPath:     dirPath,
+		AutoSync:    false,
+		SegmentSize: DefaultSegmentSize,
+		Segments:    make([]*WALSeg, 0, 16),
+		Current:     nil,
 	}
 
 	for _, opts := range options {
@@ -172,59 +223,286 @@ func RestoreFrom(dirPath string, options ...WALOptions) *WAL {
 }
 
 func (wal *WAL) Sync() error {
+	if wal.Current == nil {
+		return nil
+	}
 	return wal.Current.Sync()
 }
 
+// NewReader reads records across all segments known to the log,
+// starting from the first record of the oldest segment.
 func (wal *WAL) NewReader() WALReader {
-	return nil
+	wal.segmentsLock.Lock()
+	segments := make([]*WALSeg, len(wal.Segments))
+	copy(segments, wal.Segments)
+	wal.segmentsLock.Unlock()
+
+	return newWALReader(segments)
+}
+
+// RemoveSegmentsBefore deletes archived segments that only hold records
+// before index, once those records are persisted elsewhere.
+// Segments are removed oldest first, the last segment is always kept,
+// every segment before it is archived when the next one is started.
+func (wal *WAL) RemoveSegmentsBefore(index uint64) (removed int, err error) {
+	wal.segmentsLock.Lock()
+	defer wal.segmentsLock.Unlock()
+
+	for removed+1 < len(wal.Segments) {
+		seg, next := wal.Segments[removed], wal.Segments[removed+1]
+
+		if next.StartRecordIndex > index {
+			break
+		}
+
+		err = seg.Close()
+		if err != nil {
+			break
+		}
+
+		err = os.Remove(seg.FilePath)
+		if err != nil && !os.IsNotExist(err) {
+			break
+		}
+
+		err = nil
+		removed++
+	}
+
+	wal.Segments = append(wal.Segments[:0:0], wal.Segments[removed:]...)
+	return removed, err
 }
 
+func SegmentFilePath(dirPath string, segId uint32) string {
+	return path.Join(dirPath, fmt.Sprintf(segmentFileFormat, segId))
+}
+
+// FindSegments opens all segment files in dirPath,
+// ordered by the index of the first record they hold.
+func FindSegments(dirPath string) ([]*WALSeg, error) {
+	// compressing_segment_* files are leftovers of an interrupted compression,
+	// the pattern does not match them.
+	matches, err := filepath.Glob(path.Join(dirPath, segmentFilePrefix+"*"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	segments := make([]*WALSeg, 0, len(matches))
+
+	for _, segPath := range matches {
+		seg, err := OpenWALSegment(segPath, false)
+		if err != nil {
+			return nil, fmt.Errorf("error opening wal segment %s: %v", segPath, err)
+		}
+		segments = append(segments, seg)
+	}
+
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].StartRecordIndex < segments[j].StartRecordIndex
+	})
+
+	return segments, nil
+}
+
+// NewSegment archives the current segment and
+// starts a new one chained to it, beginning at the next record index.
 func (wal *WAL) NewSegment() error {
-	newSeg := WALSeg{
-		WALSegHeader: WALSegHeader{
-			SegId:     0,
-			PrevSegId: 0,
+	var (
+		prevSegId uint32 = 0
+		err       error
+	)
 
-			Magic:            WALSegHeaderMagic,
-			Flags:            WALSegOngoingFlag,
-			StartRecordIndex: wal.Index,
-		},
-		file: nil,
+	if wal.Current != nil {
+		prevSegId = wal.Current.SegId
 	}
 
-	if wal.Current == nil {
+	segId := wal.nextSegId
+
+	newSeg, err := NewWALSegment(
+		SegmentFilePath(wal.DirPath, segId),
+		segId,
+		prevSegId,
+		wal.Index,
+		false,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	newSeg.CompressOnArchive = wal.CompressArchived
+	err = newSeg.PrepareForLogging()
+
+	if err != nil {
+		_ = newSeg.Close()
+		return err
+	}
+
+	if wal.Current != nil {
+		err = wal.archiveCurrent()
+
+		if err != nil {
+			_ = newSeg.Close()
+			return err
+		}
+	}
 
-		wal.Segments = append(wal.Segments, newSeg)
+	wal.nextSegId = segId + 1
+	wal.segmentsLock.Lock()
+	wal.Segments = append(wal.Segments, newSeg)
+	wal.segmentsLock.Unlock()
+	wal.Current = newSeg
+
+	return nil
+}
+
+func (wal *WAL) archiveCurrent() error {
+	err := wal.Current.Sync()
+	if err != nil {
+		return err
 	}
 
+	err = wal.Current.Archive()
+	if err != nil {
+		return err
+	}
+
+	err = wal.Current.Close()
+	if err != nil {
+		log.Println("error closing wal segment", wal.Current.FilePath, err)
+	}
+
+	wal.Current = nil
 	return nil
 }
 
+// LoadSegments discovers the segments in the log directory and checks that
+// they form one chain of records. A torn record at the end of the last segment
+// is cut off, and appending resumes right after the last complete record.
 func (wal *WAL) LoadSegments() error {
-	handleFile := func(path string, in os.FileInfo, err error) error {
+	segments, err := FindSegments(wal.DirPath)
+
+	if err != nil {
+		return err
+	}
+
+	if len(segments) == 0 {
 		return nil
 	}
-	err := filepath.Walk(wal.DirPath, handleFile)
-	return err
+
+	for i := 1; i < len(segments); i++ {
+		prev, seg := segments[i-1], segments[i]
+
+		if seg.PrevSegId != prev.SegId {
+			return fmt.Errorf(
+				"wal segment %s follows segment %d, expected previous segment %d",
+				seg.FilePath, seg.PrevSegId, prev.SegId,
+			)
+		}
+	}
+
+	last := segments[len(segments)-1]
+	nextIndex, err := last.recoverTail()
+
+	if err != nil {
+		return err
+	}
+
+	for _, seg := range segments {
+		if seg.SegId >= wal.nextSegId {
+			wal.nextSegId = seg.SegId + 1
+		}
+		seg.CompressOnArchive = wal.CompressArchived
+	}
+
+	wal.Segments = segments
+	wal.Index = nextIndex
+
+	if last.Flags&WALSegOngoingFlag != 0 {
+		err = last.PrepareForLogging()
+		if err != nil {
+			return err
+		}
+		wal.Current = last
+	}
+
+	return nil
 }
 
+// Append returns once the record is written, with group commit enabled
+// it returns once the record is durable. Once a sync failed, appends fail
+// with WALSyncFailedErr.
 func (wal *WAL) Append(record *WALRecord) error {
+	if wal.groupCommit != nil {
+		return <-wal.groupCommit.submit(record)
+	}
 
-	if wal.Current == nil {
-		seg := WALSeg{}
-		wal.Segments = append(wal.Segments, seg)
-		wal.Current = &seg
+	return wal.writeRecords([]*WALRecord{record}, wal.AutoSync)
+}
+
+// AppendAsync queues a record for group commit, the channel
+// receives the result once the record is durable.
+// Without group commit the record is appended before returning.
+func (wal *WAL) AppendAsync(record *WALRecord) <-chan error {
+	if wal.groupCommit != nil {
+		return wal.groupCommit.submit(record)
 	}
 
-	err := wal.Current.Append(record)
+	done := make(chan error, 1)
+	done <- wal.Append(record)
+	return done
+}
 
-	if err != nil {
+func (wal *WAL) writeRecords(records []*WALRecord, sync bool) error {
+	var err error
+
+	if len(records) == 0 {
 		return nil
 	}
 
-	if wal.AutoSync {
+	if wal.syncFailed {
+		return WALSyncFailedErr
+	}
+
+	if wal.Current == nil || wal.Current.LogSize >= wal.SegmentSize {
+		err = wal.NewSegment()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = wal.Current.AppendBatch(records)
+
+	if err != nil {
+		return err
+	}
+
+	wal.Index = records[len(records)-1].Index + 1
+
+	if sync {
 		err = wal.Current.Sync()
+		wal.syncFailed = err != nil
+	}
+
+	return err
+}
+
+func (wal *WAL) Close() error {
+	var err error
+
+	if wal.groupCommit != nil {
+		wal.groupCommit.close()
+	}
+
+	for _, seg := range wal.Segments {
+		segErr := seg.Close()
+		if segErr != nil {
+			err = segErr
+		}
 	}
 
+	wal.Current = nil
 	return err
 }
@@ -0,0 +1,165 @@
+package wal
+
+import (
+	"fmt"
+	"io"
+)
+
+// walReader reads records in index order,
+// moving on to the next segment when one is exhausted.
+type walReader struct {
+	segments []*WALSeg
+
+	segPos    int
+	segReader *WALSegRecordReader
+	nextIndex uint64
+	skipped   WALRecord
+}
+
+func newWALReader(segments []*WALSeg) *walReader {
+	reader := &walReader{
+		segments: segments,
+		segPos:   -1,
+	}
+
+	if len(segments) > 0 {
+		reader.nextIndex = segments[0].StartRecordIndex
+	}
+
+	return reader
+}
+
+func (r *walReader) ReadIndex() int {
+	return int(r.nextIndex)
+}
+
+// SetIndex positions the reader so that the next record read has the given index.
+// Seeking past the last record leaves the reader at the end of the log.
+func (r *walReader) SetIndex(index uint64) error {
+	if len(r.segments) == 0 {
+		r.nextIndex = index
+		return nil
+	}
+
+	if index < r.segments[0].StartRecordIndex {
+		return fmt.Errorf(
+			"cannot seek to index %d, oldest wal segment starts at %d",
+			index,
+			r.segments[0].StartRecordIndex,
+		)
+	}
+
+	segPos := 0
+	for i, seg := range r.segments {
+		if seg.StartRecordIndex <= index {
+			segPos = i
+		}
+	}
+
+	err := r.openSegment(segPos)
+	if err != nil {
+		return err
+	}
+
+	for r.nextIndex < index {
+		err = r.ReadNext(&r.skipped)
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *walReader) openSegment(segPos int) error {
+	err := r.closeSegment()
+	if err != nil {
+		return err
+	}
+
+	seg := r.segments[segPos]
+	segReader, err := seg.NewReader()
+
+	if err != nil {
+		return err
+	}
+
+	r.segPos = segPos
+	r.segReader = segReader
+	r.nextIndex = seg.StartRecordIndex
+	return nil
+}
+
+func (r *walReader) closeSegment() error {
+	if r.segReader == nil {
+		return nil
+	}
+
+	err := r.segReader.Close()
+	r.segReader = nil
+	return err
+}
+
+// ReadNext returns io.EOF after the last record of the newest segment.
+// A torn record is only tolerated at the end of a segment that the next
+// segment continues from.
+func (r *walReader) ReadNext(record *WALRecord) error {
+	for {
+		if r.segReader == nil {
+			if r.segPos+1 >= len(r.segments) {
+				return io.EOF
+			}
+
+			err := r.openSegment(r.segPos + 1)
+			if err != nil {
+				return err
+			}
+		}
+
+		err := r.segReader.Read(record)
+
+		if err == nil {
+			if record.Index != r.nextIndex {
+				return fmt.Errorf(
+					"wal segment %s has record %d, expected %d",
+					r.segReader.FilePath, record.Index, r.nextIndex,
+				)
+			}
+
+			r.nextIndex = record.Index + 1
+			return nil
+		}
+
+		lastSegment := r.segPos+1 >= len(r.segments)
+
+		if err == io.EOF || (err == TornRecordErr && !lastSegment) {
+			if !lastSegment && r.segments[r.segPos+1].StartRecordIndex != r.nextIndex {
+				return fmt.Errorf(
+					"wal segment %s ends before index %d, next segment starts at %d",
+					r.segReader.FilePath, r.nextIndex, r.segments[r.segPos+1].StartRecordIndex,
+				)
+			}
+
+			if lastSegment {
+				return io.EOF
+			}
+
+			err = r.closeSegment()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		return err
+	}
+}
+
+func (r *walReader) Close() error {
+	return r.closeSegment()
+}
@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"github.com/zl14917/MastersProject/kvstore/crc"
 	"io"
 	"log"
 	"os"
@@ -66,21 +67,27 @@ type WALSeg struct {
 	FilePath    string
 	LogSize     int64
 
+	// archived segments are rewritten in compressed form
+	CompressOnArchive bool
+
 	nextRecordIndex uint64
 }
 
 type WALRecordReader interface {
 	// passes result to WALRecord pointer, avoids allocation
-	Read() (record *WALRecord, err error)
+	Read(record *WALRecord) error
 	Close() error
 }
 
+// syncFile is replaced by tests to fail syncs
+var syncFile = (*os.File).Sync
+
 func (s *WALSeg) Sync() error {
 	if s.logFile == nil {
 		return nil
 	}
 
-	return s.logFile.Sync()
+	return syncFile(s.logFile)
 }
 
 // appending nil record is a no-op
@@ -89,27 +96,44 @@ func (s *WALSeg) Append(record *WALRecord) error {
 		return nil
 	}
 
+	return s.AppendBatch([]*WALRecord{record})
+}
+
+// AppendBatch assigns consecutive indexes to records and writes them with one write call.
+// On failure, the segment is cut back so a partial batch is not left behind.
+func (s *WALSeg) AppendBatch(records []*WALRecord) error {
 	var (
-		err error
+		err       error
+		nextIndex = s.NextRecordIndex()
 	)
 
-	record.Index = s.NextRecordIndex()
 	s.writeBuffer.Reset()
-	err = record.Marshall(s.writeBuffer)
 
-	if err != nil {
-		return err
+	for _, record := range records {
+		record.Index = nextIndex
+		record.ComputeCRC()
+
+		err = record.Marshall(s.writeBuffer)
+		if err != nil {
+			return err
+		}
+		nextIndex++
 	}
 
-	recordBytes := s.writeBuffer.Bytes()
+	batchBytes := s.writeBuffer.Bytes()
 
-	_, err = s.logFile.Write(recordBytes)
+	_, err = s.logFile.Write(batchBytes)
 
 	if err != nil {
+		truncateErr := s.logFile.Truncate(s.LogSize)
+		if truncateErr != nil {
+			log.Println("error removing partial batch from wal segment", s.FilePath, truncateErr)
+		}
 		return err
 	}
 
-	s.nextRecordIndex = record.Index + 1
+	s.nextRecordIndex = nextIndex
+	s.LogSize += int64(len(batchBytes))
 
 	return nil
 }
@@ -144,8 +168,10 @@ func (s *WALSeg) CreateFileOrLoad(mustNotExist bool) error {
 
 	if stat.Size() > 0 {
 		err = s.ReadHeader()
+		s.LogSize = stat.Size()
 	} else {
 		err = s.WriteHeader()
+		s.LogSize = int64(unsafe.Sizeof(s.WALSegHeader))
 	}
 
 	if err != nil {
@@ -193,6 +219,7 @@ func NewWALSegment(path string, id uint32, prevId uint32, startIndex uint64, syn
 			StartRecordIndex: startIndex,
 		},
 		nextRecordIndex: startIndex,
+		SyncIO:          syncIO,
 		FilePath:        path,
 		writeBuffer:     bytes.NewBuffer(nil),
 	}
@@ -230,6 +257,10 @@ func OpenWALSegment(path string, syncIO bool) (*WALSeg, error) {
 }
 
 func (s *WALSeg) Archive() error {
+	if s.CompressOnArchive && s.Flags&WalSegCompressedFlag == 0 {
+		return s.compress()
+	}
+
 	var err error
 	if s.file == nil {
 		err = s.openForHeaderWriting()
@@ -253,10 +284,17 @@ func (s *WALSeg) Archive() error {
 
 func (s *WALSeg) PrepareForLogging() error {
 	var err error
+	var fileFlags = os.O_RDWR | os.O_APPEND
+
+	// without sync io, durability comes from explicit Sync calls
+	if s.SyncIO {
+		fileFlags |= os.O_SYNC
+	}
+
 	if s.logFile == nil {
 		s.logFile, err = os.OpenFile(
 			s.FilePath,
-			os.O_RDWR|os.O_SYNC|os.O_APPEND,
+			fileFlags,
 			0644,
 		)
 	}
@@ -320,13 +358,63 @@ func (s *WALSeg) WriteHeader() error {
 	return nil
 }
 
+// Truncate cuts the segment file at size, used to drop a torn tail.
+func (s *WALSeg) Truncate(size int64) error {
+	err := os.Truncate(s.FilePath, size)
+	if err != nil {
+		return err
+	}
+
+	s.LogSize = size
+	return nil
+}
+
+// recoverTail reads the segment up to its last complete record,
+// truncating a torn record after it, and returns the next record index.
+func (s *WALSeg) recoverTail() (nextIndex uint64, err error) {
+	reader, err := s.NewReader()
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer reader.Close()
+
+	record := &WALRecord{}
+
+	for {
+		err = reader.Read(record)
+		if err != nil {
+			break
+		}
+	}
+
+	if err == TornRecordErr && s.Flags&WalSegCompressedFlag != 0 {
+		return 0, fmt.Errorf("compressed wal segment %s is incomplete", s.FilePath)
+	}
+
+	if err == TornRecordErr {
+		log.Println("truncating torn record at end of wal segment", s.FilePath, reader.Offset())
+		err = s.Truncate(reader.Offset())
+	}
+
+	if err != nil && err != io.EOF {
+		return 0, err
+	}
+
+	s.nextRecordIndex = reader.currentIndex
+	return s.nextRecordIndex, nil
+}
+
 func (s *WALSeg) Close() error {
 	var err1, err2 error
 	if s.file != nil {
 		err1 = s.file.Close()
+		s.file = nil
 	}
 	if s.logFile != nil {
 		err2 = s.logFile.Close()
+		s.logFile = nil
 	}
 
 	if err1 != nil || err2 != nil {
@@ -345,18 +433,18 @@ type WALSegRecordReader struct {
 	file     *os.File
 
 	bufReader    *bufio.Reader
+	decompressor io.ReadCloser
 	currentIndex uint64
-
-	recordTemp WALRecord
+	offset       int64
 
 	fileHeader WALSegHeader
 }
 
-func (s *WALSeg) NewReader() (WALRecordReader, error) {
+func (s *WALSeg) NewReader() (*WALSegRecordReader, error) {
 	return newWALSegRecordReader(s.FilePath)
 }
 
-func newWALSegRecordReader(filePath string) (WALRecordReader, error) {
+func newWALSegRecordReader(filePath string) (*WALSegRecordReader, error) {
 	reader := &WALSegRecordReader{
 		FilePath: filePath,
 		file:     nil,
@@ -370,9 +458,32 @@ func newWALSegRecordReader(filePath string) (WALRecordReader, error) {
 }
 
 func (r *WALSegRecordReader) ReadFileHeader() error {
+	buffer := make([]byte, unsafe.Sizeof(r.fileHeader))
+
+	_, err := io.ReadFull(r.bufReader, buffer)
+	if err != nil {
+		return err
+	}
+
+	r.fileHeader.DecodeFromBytes(buffer)
+
+	if r.fileHeader.Magic != WALSegHeaderMagic {
+		return fmt.Errorf("Segment header magic does not match 0x%x", WALSegHeaderMagic)
+	}
+
+	r.currentIndex = r.fileHeader.StartRecordIndex
+	r.offset = int64(len(buffer))
+
+	// records of compressed segments are read from the decompressed stream,
+	// offsets then count uncompressed bytes.
+	if r.fileHeader.Flags&WalSegCompressedFlag != 0 {
+		r.decompressor = newSegmentDecompressor(r.bufReader)
+		r.bufReader = bufio.NewReader(r.decompressor)
+	}
 
 	return nil
 }
+
 func (r *WALSegRecordReader) openForReading() error {
 	var err error
 	r.file, err = os.OpenFile(
@@ -383,18 +494,95 @@ func (r *WALSegRecordReader) openForReading() error {
 
 	if err != nil {
 		r.file = nil
-		return nil
+		return err
 	}
 	r.bufReader = bufio.NewReader(r.file)
 
+	err = r.ReadFileHeader()
+
+	if err != nil {
+		_ = r.file.Close()
+		r.file = nil
+		return err
+	}
+
+	return nil
+}
+
+// Read returns io.EOF once all complete records of the segment have been read,
+// and TornRecordErr if the segment ends with a partially written record.
+func (r *WALSegRecordReader) Read(record *WALRecord) error {
+	if r.file == nil {
+		return io.EOF
+	}
+
+	err := r.readRecord(record)
+
+	if err == CorruptedRecordErr {
+		// a record that fails its checksum with nothing after it
+		// was cut short by a crash, same as a torn record.
+		if _, peekErr := r.bufReader.Peek(1); peekErr == io.EOF {
+			return TornRecordErr
+		}
+
+		return &crc.CorruptionError{
+			FilePath: r.FilePath,
+			Block:    -1,
+			Offset:   r.offset,
+			Stored:   record.CRC,
+			Computed: ComputeWALRecordCRC(&record.WALRecordHeader, record.EventData),
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	r.currentIndex = record.Index + 1
+	r.offset += int64(marshalledRecordHeaderSize) + int64(record.DataLen)
 	return nil
 }
 
-func (r *WALSegRecordReader) Read() (record *WALRecord, err error) {
+// readRecord reads the record at the offset. A header whose payload would run past
+// the end of the segment is torn or corrupt, TornRecordErr is returned before
+// the payload is read.
+func (r *WALSegRecordReader) readRecord(record *WALRecord) error {
+	err := record.unMarshallHeader(r.bufReader)
+	if err != nil {
+		return err
+	}
+
+	// records of compressed segments are read in steps, their uncompressed size is unknown
+	if r.decompressor == nil && int(record.DataLen) > r.bufReader.Buffered() {
+		stat, err := r.file.Stat()
+		if err != nil {
+			return err
+		}
 
-	return &r.recordTemp, nil
+		if int64(record.DataLen) > stat.Size()-r.offset-marshalledRecordHeaderSize {
+			return TornRecordErr
+		}
+	}
+
+	return record.unMarshallData(r.bufReader)
+}
+
+// Offset is the file offset right after the last record read successfully.
+func (r *WALSegRecordReader) Offset() int64 {
+	return r.offset
 }
 
 func (r *WALSegRecordReader) Close() error {
-	return nil
+	if r.file == nil {
+		return nil
+	}
+
+	if r.decompressor != nil {
+		_ = r.decompressor.Close()
+		r.decompressor = nil
+	}
+
+	err := r.file.Close()
+	r.file = nil
+	return err
 }
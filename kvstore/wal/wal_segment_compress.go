@@ -0,0 +1,111 @@
+package wal
+
+import (
+	"bufio"
+	"compress/flate"
+	"github.com/zl14917/MastersProject/kvstore/fileutil"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"unsafe"
+)
+
+// Compressed segments keep the segment header uncompressed,
+// with WalSegCompressedFlag set. Records after it form one DEFLATE stream.
+const compressedSegmentTempPrefix = "compressing_"
+
+func newSegmentDecompressor(r io.Reader) io.ReadCloser {
+	return flate.NewReader(r)
+}
+
+// compress rewrites an archived segment into a temporary file
+// and renames it over the original once it is durable.
+func (s *WALSeg) compress() error {
+	headerSize := int64(unsafe.Sizeof(s.WALSegHeader))
+	tempPath := path.Join(filepath.Dir(s.FilePath), compressedSegmentTempPrefix+filepath.Base(s.FilePath))
+
+	source, err := os.Open(s.FilePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	_, err = source.Seek(headerSize, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	temp, err := os.OpenFile(tempPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	err = s.writeCompressed(temp, source)
+
+	if err == nil {
+		err = temp.Sync()
+	}
+
+	closeErr := temp.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(tempPath)
+		return err
+	}
+
+	err = os.Rename(tempPath, s.FilePath)
+	if err != nil {
+		_ = os.Remove(tempPath)
+		return err
+	}
+
+	err = fileutil.SyncDir(filepath.Dir(s.FilePath))
+	if err != nil {
+		return err
+	}
+
+	stat, err := os.Stat(s.FilePath)
+	if err != nil {
+		return err
+	}
+
+	s.Flags = WALSegArchivedFlag | WalSegCompressedFlag
+	s.LogSize = stat.Size()
+	return nil
+}
+
+func (s *WALSeg) writeCompressed(temp *os.File, records io.Reader) error {
+	header := s.WALSegHeader
+	header.Flags = WALSegArchivedFlag | WalSegCompressedFlag
+
+	buffer := make([]byte, unsafe.Sizeof(header))
+	header.EncodeToBytes(buffer)
+
+	writer := bufio.NewWriter(temp)
+
+	_, err := writer.Write(buffer)
+	if err != nil {
+		return err
+	}
+
+	compressor, err := flate.NewWriter(writer, flate.BestSpeed)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(compressor, records)
+	if err != nil {
+		return err
+	}
+
+	err = compressor.Close()
+	if err != nil {
+		return err
+	}
+
+	return writer.Flush()
+}
@@ -1,10 +1,13 @@
 package wal
 
 import (
+	"bytes"
+	"github.com/zl14917/MastersProject/kvstore/crc"
 	"io/ioutil"
 	"os"
 	"path"
 	"testing"
+	"unsafe"
 )
 
 var tmpDir = "/tmp"
@@ -150,3 +153,118 @@ func TestSegmentReader(t *testing.T) {
 		}
 	})
 }
+
+func TestSegmentReader_Corruption(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		segmentPath := path.Join(dirPath, "segment_0001")
+
+		seg, err := NewWALSegment(segmentPath, 1, 0, 1, false)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		_ = seg.PrepareForLogging()
+		for _, key := range []string{"hello", "greetings"} {
+			record := &WALRecord{}
+			record.SetPayload(PutKey, []byte(key), []byte("world"))
+			_ = seg.Append(record)
+		}
+		_ = seg.Close()
+
+		file, err := os.OpenFile(segmentPath, os.O_RDWR, 0644)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		// flip a byte in the payload of the first record
+		_, _ = file.WriteAt([]byte{0xff}, int64(unsafe.Sizeof(WALSegHeader{}))+marshalledRecordHeaderSize+5)
+		_ = file.Close()
+
+		reader, err := seg.NewReader()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer reader.Close()
+
+		err = reader.Read(&WALRecord{})
+		corruption, ok := err.(*crc.CorruptionError)
+
+		if !ok {
+			t.Errorf("expect corruption error, got %v", err)
+			return
+		}
+
+		if corruption.Offset != int64(unsafe.Sizeof(WALSegHeader{})) || corruption.FilePath != segmentPath {
+			t.Errorf("corruption error should point at first record, got %v", corruption)
+		}
+	})
+}
+
+func TestWALSeg_ArchiveCompressed(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		segPath := path.Join(dirPath, "segment_00001")
+		seg, err := NewWALSegment(segPath, 1, 0, 1, false)
+		if err != nil {
+			t.Error("error creating segment", err)
+			return
+		}
+
+		_ = seg.PrepareForLogging()
+		for i := 0; i < 100; i++ {
+			record := &WALRecord{}
+			record.SetPayload(PutKey, []byte("hello"), bytes.Repeat([]byte("world"), 20))
+			_ = seg.Append(record)
+		}
+
+		uncompressedSize := seg.LogSize
+		seg.CompressOnArchive = true
+
+		err = seg.Archive()
+		if err != nil {
+			t.Error("error archiving segment", err)
+			return
+		}
+		_ = seg.Close()
+
+		if seg.Flags&WalSegCompressedFlag == 0 || seg.Flags&WALSegArchivedFlag == 0 {
+			t.Error("segment should be archived and compressed")
+		}
+
+		if seg.LogSize >= uncompressedSize {
+			t.Errorf("compressed segment should be smaller, %d >= %d", seg.LogSize, uncompressedSize)
+		}
+
+		seg, err = OpenWALSegment(segPath, false)
+		if err != nil {
+			t.Error("error reopening compressed segment", err)
+			return
+		}
+
+		if seg.Flags&WalSegCompressedFlag == 0 {
+			t.Error("compressed flag should be stored in segment header")
+		}
+
+		reader, err := seg.NewReader()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer reader.Close()
+
+		record := &WALRecord{}
+		count := 0
+		for reader.Read(record) == nil {
+			count++
+			key, _, _ := record.Payload()
+			if string(key) != "hello" || record.Index != uint64(count) {
+				t.Errorf("record %d read wrong from compressed segment", count)
+			}
+		}
+
+		if count != 100 {
+			t.Errorf("expect 100 records in compressed segment, got %d", count)
+		}
+	})
+}
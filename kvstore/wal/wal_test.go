@@ -1,8 +1,483 @@
 package wal
 
-import "testing"
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"testing"
+)
 
 func TestNewWAL(t *testing.T) {
 	_ = NewWAL("/tmp/wal/new_test", WithAutoSync(), WithCleanUp())
 
 }
+
+func appendRecords(t *testing.T, wal *WAL, from int, to int) {
+	for i := from; i < to; i++ {
+		record := &WALRecord{}
+		record.SetPayload(PutKey, []byte(fmt.Sprintf("key_%d", i)), []byte("value"))
+
+		err := wal.Append(record)
+		if err != nil {
+			t.Error("error appending record", err)
+			return
+		}
+	}
+}
+
+func TestWAL_Rollover(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		wal := NewWAL(dirPath, WithSegmentSize(256))
+		wal.Index = 1
+
+		appendRecords(t, wal, 0, 40)
+
+		if len(wal.Segments) < 2 {
+			t.Errorf("wal should roll over to new segments, got %d segments", len(wal.Segments))
+		}
+
+		for i := 1; i < len(wal.Segments); i++ {
+			prev, seg := wal.Segments[i-1], wal.Segments[i]
+			if seg.PrevSegId != prev.SegId {
+				t.Errorf("segment %d should follow segment %d", seg.SegId, prev.SegId)
+			}
+			if prev.Flags&WALSegArchivedFlag == 0 {
+				t.Errorf("rolled over segment %d should be archived", prev.SegId)
+			}
+		}
+
+		segCount := len(wal.Segments)
+		_ = wal.Close()
+
+		wal = NewWAL(dirPath, WithSegmentSize(256))
+		err := wal.LoadSegments()
+		if err != nil {
+			t.Error("error loading segments", err)
+			return
+		}
+
+		if len(wal.Segments) != segCount {
+			t.Errorf("expect %d segments after reopening, got %d", segCount, len(wal.Segments))
+		}
+
+		if wal.Index != 41 {
+			t.Errorf("next index should be 41, got %d", wal.Index)
+		}
+
+		appendRecords(t, wal, 40, 45)
+		if wal.Index != 46 {
+			t.Errorf("next index should be 46, got %d", wal.Index)
+		}
+		_ = wal.Close()
+	})
+}
+
+// a record torn at any byte of its header is truncated on load,
+// records appended after it are read back
+func TestWAL_RecoverTornHeader(t *testing.T) {
+	for torn := 1; torn <= marshalledRecordHeaderSize; torn++ {
+		WithTempDir(t, func(t *testing.T, dirPath string) {
+			wal := NewWAL(dirPath)
+			wal.Index = 1
+			appendRecords(t, wal, 0, 1)
+			filePath := wal.Segments[0].FilePath
+			_ = wal.Close()
+
+			record := &WALRecord{}
+			record.SetPayload(PutKey, []byte("torn"), []byte("value"))
+			record.Index = 2
+
+			var buffer bytes.Buffer
+			_ = record.Marshall(&buffer)
+
+			file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _ = file.Write(buffer.Bytes()[:torn])
+			_ = file.Close()
+
+			for _, next := range []int{1, 2} {
+				wal = NewWAL(dirPath)
+				if err = wal.LoadSegments(); err != nil {
+					t.Fatalf("torn at %d: error loading segments: %v", torn, err)
+				}
+
+				if wal.Index != uint64(next+1) {
+					t.Errorf("torn at %d: expect next index %d, got %d", torn, next+1, wal.Index)
+				}
+
+				appendRecords(t, wal, next, next+1)
+				_ = wal.Close()
+			}
+
+			wal = NewWAL(dirPath)
+			if err = wal.LoadSegments(); err != nil {
+				t.Fatalf("torn at %d: error reloading segments: %v", torn, err)
+			}
+			defer wal.Close()
+
+			reader := wal.NewReader()
+			defer reader.Close()
+			_ = reader.SetIndex(1)
+
+			var keys []string
+			for reader.ReadNext(record) == nil {
+				key, _, _ := record.Payload()
+				keys = append(keys, string(key))
+			}
+
+			if fmt.Sprint(keys) != "[key_0 key_1 key_2]" {
+				t.Errorf("torn at %d: expect keys [key_0 key_1 key_2], got %v", torn, keys)
+			}
+		})
+	}
+}
+
+// a corrupt header claiming a huge payload must not be allocated for
+func TestWAL_RecoverCorruptDataLen(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		wal := NewWAL(dirPath)
+		wal.Index = 1
+		appendRecords(t, wal, 0, 3)
+		filePath := wal.Segments[0].FilePath
+		_ = wal.Close()
+
+		// payloads over the read step are read whole
+		large := &WALRecord{}
+		large.SetPayload(PutKey, []byte("large"), bytes.Repeat([]byte("v"), 3*payloadReadStep+1))
+		large.ComputeCRC()
+
+		var buffer bytes.Buffer
+		_ = large.Marshall(&buffer)
+
+		read := &WALRecord{}
+		if err := read.UnMarshall(&buffer); err != nil || !bytes.Equal(read.EventData, large.EventData) {
+			t.Error("error reading large record", err)
+		}
+
+		record := &WALRecord{}
+		record.SetPayload(PutKey, []byte("corrupt"), []byte("value"))
+		record.Index = 4
+		record.DataLen = 0xfffffff0
+
+		buffer.Reset()
+		_ = record.WALRecordHeader.Marshall(&buffer)
+		buffer.Write(record.EventData)
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+
+		err := (&WALRecord{}).UnMarshall(bytes.NewReader(buffer.Bytes()))
+		if err != TornRecordErr {
+			t.Error("expect torn record, got", err)
+		}
+
+		runtime.ReadMemStats(&after)
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 16*payloadReadStep {
+			t.Errorf("expect payload to be read in steps, allocated %d bytes", allocated)
+		}
+
+		file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = file.Write(buffer.Bytes())
+		_ = file.Close()
+
+		info, err := InspectSegment(filePath, nil)
+		if err != nil || info.Records != 3 || len(info.Problems) != 1 {
+			t.Errorf("expect 3 records and the incomplete one reported, got %d records, %v: %v",
+				info.Records, info.Problems, err)
+		}
+
+		wal = NewWAL(dirPath)
+		if err = wal.LoadSegments(); err != nil {
+			t.Fatal("error loading segments", err)
+		}
+		defer wal.Close()
+
+		if wal.Index != 4 {
+			t.Errorf("expect next index 4, got %d", wal.Index)
+		}
+
+		stat, _ := os.Stat(filePath)
+		if stat.Size() != wal.Segments[0].LogSize {
+			t.Errorf("expect corrupt record to be truncated, file has %d bytes", stat.Size())
+		}
+	})
+}
+
+func TestWALReader_SetIndex(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		wal := NewWAL(dirPath, WithSegmentSize(256))
+		wal.Index = 1
+		appendRecords(t, wal, 0, 40)
+		defer wal.Close()
+
+		reader := wal.NewReader()
+		defer reader.Close()
+
+		// index of first record of a later segment and one in the middle of it
+		seekTo := []uint64{wal.Segments[1].StartRecordIndex, wal.Segments[1].StartRecordIndex + 1, 3, 40}
+		record := &WALRecord{}
+
+		for _, index := range seekTo {
+			err := reader.SetIndex(index)
+			if err != nil {
+				t.Errorf("error seeking to %d: %v", index, err)
+				continue
+			}
+
+			err = reader.ReadNext(record)
+			if err != nil {
+				t.Errorf("error reading record %d: %v", index, err)
+				continue
+			}
+
+			if record.Index != index {
+				t.Errorf("expect record %d, got %d", index, record.Index)
+			}
+
+			key, _, _ := record.Payload()
+			if string(key) != fmt.Sprintf("key_%d", index-1) {
+				t.Errorf("record %d has wrong key %s", index, string(key))
+			}
+		}
+
+		count := 0
+		_ = reader.SetIndex(1)
+		for reader.ReadNext(record) == nil {
+			count++
+		}
+
+		if count != 40 {
+			t.Errorf("reading from start should return 40 records, got %d", count)
+		}
+
+		err := reader.ReadNext(record)
+		if err != io.EOF {
+			t.Error("reading past last record should return EOF, got", err)
+		}
+	})
+}
+
+func TestWAL_RemoveSegmentsBefore(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		wal := NewWAL(dirPath, WithSegmentSize(256))
+		wal.Index = 1
+		appendRecords(t, wal, 0, 40)
+
+		if len(wal.Segments) < 3 {
+			t.Errorf("wal should roll over to at least 3 segments, got %d", len(wal.Segments))
+			return
+		}
+
+		keep := wal.Segments[1]
+		removed, err := wal.RemoveSegmentsBefore(keep.StartRecordIndex + 1)
+		if err != nil || removed != 1 {
+			t.Errorf("expect to remove the first segment, removed %d: %v", removed, err)
+		}
+
+		if wal.Segments[0] != keep {
+			t.Errorf("segment %d should be the oldest, got %d", keep.SegId, wal.Segments[0].SegId)
+		}
+
+		current := wal.Current
+		removed, err = wal.RemoveSegmentsBefore(wal.Index)
+		if err != nil || len(wal.Segments) != 1 || wal.Segments[0] != current {
+			t.Errorf("only the current segment should be kept, removed %d: %v", removed, err)
+		}
+
+		_ = wal.Close()
+
+		wal = NewWAL(dirPath, WithSegmentSize(256))
+		err = wal.LoadSegments()
+		if err != nil {
+			t.Error("error loading remaining segments", err)
+			return
+		}
+		defer wal.Close()
+
+		reader := wal.NewReader()
+		defer reader.Close()
+
+		err = reader.SetIndex(current.StartRecordIndex)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		record := &WALRecord{}
+		count := 0
+		for reader.ReadNext(record) == nil {
+			count++
+		}
+
+		if expect := int(41 - current.StartRecordIndex); count != expect {
+			t.Errorf("expect %d records in the current segment, got %d", expect, count)
+		}
+	})
+}
+
+func TestWAL_CompressedArchive(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		wal := NewWAL(dirPath, WithSegmentSize(512), WithCompressedArchive())
+		wal.Index = 1
+		appendRecords(t, wal, 0, 60)
+
+		if len(wal.Segments) < 2 {
+			t.Errorf("wal should roll over to new segments, got %d segments", len(wal.Segments))
+			return
+		}
+
+		for _, seg := range wal.Segments[:len(wal.Segments)-1] {
+			if seg.Flags&WalSegCompressedFlag == 0 {
+				t.Errorf("archived segment %d should be compressed", seg.SegId)
+			}
+		}
+
+		if wal.Current.Flags&WalSegCompressedFlag != 0 {
+			t.Error("ongoing segment should not be compressed")
+		}
+
+		_ = wal.Close()
+
+		wal = NewWAL(dirPath, WithSegmentSize(512), WithCompressedArchive())
+		err := wal.LoadSegments()
+		if err != nil {
+			t.Error("error loading compressed segments", err)
+			return
+		}
+		defer wal.Close()
+
+		reader := wal.NewReader()
+		defer reader.Close()
+
+		err = reader.SetIndex(30)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		record := &WALRecord{}
+		count := 0
+		for reader.ReadNext(record) == nil {
+			count++
+		}
+
+		if count != 31 {
+			t.Errorf("expect 31 records from index 30, got %d", count)
+		}
+	})
+}
+
+func TestWALRecord_BatchPayload(t *testing.T) {
+	WithTempDir(t, func(t *testing.T, dirPath string) {
+		wal := NewWAL(dirPath)
+		wal.Index = 1
+		defer wal.Close()
+
+		entries := []BatchEntry{
+			{EventType: PutKey, Key: []byte("apple"), Value: []byte("red")},
+			{EventType: DeleteKey, Key: []byte("banana")},
+			{EventType: PutKey, Key: []byte("cherry"), Value: []byte{}},
+		}
+
+		record := &WALRecord{}
+		record.SetBatchPayload(entries)
+
+		if err := wal.Append(record); err != nil {
+			t.Error("error appending batch", err)
+			return
+		}
+
+		reader := wal.NewReader()
+		defer reader.Close()
+
+		read := &WALRecord{}
+		_ = reader.SetIndex(1)
+
+		if err := reader.ReadNext(read); err != nil {
+			t.Error("error reading batch", err)
+			return
+		}
+
+		if read.EventType != WriteBatch {
+			t.Errorf("expect a WriteBatch record, got event type %d", read.EventType)
+		}
+
+		decoded, err := read.BatchPayload()
+		if err != nil {
+			t.Error("error decoding batch", err)
+			return
+		}
+
+		if fmt.Sprint(decoded) != fmt.Sprint(entries) {
+			t.Errorf("expect entries %v, got %v", entries, decoded)
+		}
+
+		read.EventData = read.EventData[:len(read.EventData)-1]
+		if _, err = read.BatchPayload(); err == nil {
+			t.Error("truncated batch payload should not decode")
+		}
+	})
+}
+
+// entries of column families keep their family, batches of the default family keep the old layout
+func TestWALRecord_ColumnFamilyBatchPayload(t *testing.T) {
+	entries := []BatchEntry{
+		{EventType: PutKey, Key: []byte("apple"), Value: []byte("red")},
+		{ColumnFamily: 2, EventType: MergeKey, Key: []byte("counter"), Value: []byte("1")},
+		{ColumnFamily: 1, EventType: DeleteKey, Key: []byte("banana")},
+	}
+
+	record := &WALRecord{}
+	record.SetBatchPayload(entries)
+
+	if record.EventType != ColumnFamilyBatch {
+		t.Errorf("expect a ColumnFamilyBatch record, got event type %d", record.EventType)
+	}
+
+	decoded, err := record.BatchPayload()
+	if err != nil {
+		t.Fatal("error decoding batch", err)
+	}
+
+	if fmt.Sprint(decoded) != fmt.Sprint(entries) {
+		t.Errorf("expect entries %v, got %v", entries, decoded)
+	}
+
+	record.SetBatchPayload(entries[:1])
+
+	if record.EventType != WriteBatch || record.DataLen != uint32(4+batchEntryHeaderSize+len("applered")) {
+		t.Errorf("expect a WriteBatch record of %d bytes, got event type %d of %d bytes",
+			4+batchEntryHeaderSize+len("applered"), record.EventType, record.DataLen)
+	}
+}
+
+func TestWALRecord_ExpiringPayload(t *testing.T) {
+	record := &WALRecord{}
+	record.SetExpiringPayload([]byte("session"), []byte("data"), 1000, 5000)
+
+	if record.EventType != PutKeyWithExpiry {
+		t.Errorf("expect a PutKeyWithExpiry record, got event type %d", record.EventType)
+	}
+
+	key, value, timestamp, expiresAt, err := record.ExpiringPayload()
+	if err != nil {
+		t.Error("error decoding payload", err)
+		return
+	}
+
+	if string(key) != "session" || string(value) != "data" || timestamp != 1000 || expiresAt != 5000 {
+		t.Errorf("decoded %s=%s at %d expiring at %d", key, value, timestamp, expiresAt)
+	}
+
+	record.EventData = record.EventData[:10]
+	if _, _, _, _, err = record.ExpiringPayload(); err == nil {
+		t.Error("truncated payload should not decode")
+	}
+}